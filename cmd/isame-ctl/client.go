@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// gives up on an admin API that doesn't answer
+const requestTimeout = 10 * time.Second
+
+// client talks to the load balancer's admin API
+type client struct {
+	base string
+	http *http.Client
+}
+
+func newClient(addr string) *client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &client{
+		base: strings.TrimSuffix(addr, "/"),
+		http: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// backend as listed by GET /admin/backends
+type backend struct {
+	Upstream    string `json:"upstream"`
+	Backend     string `json:"backend"`
+	Healthy     bool   `json:"healthy"`
+	Degraded    bool   `json:"degraded"`
+	Disabled    bool   `json:"disabled"`
+	Drained     bool   `json:"drained"`
+	Connections int64  `json:"connections"`
+	Breaker     string `json:"breaker"`
+}
+
+func (b backend) health() string {
+	switch {
+	case !b.Healthy:
+		return "unhealthy"
+	case b.Degraded:
+		return "degraded"
+	default:
+		return "healthy"
+	}
+}
+
+func (b backend) rotation() string {
+	switch {
+	case b.Disabled:
+		return "disabled"
+	case b.Drained:
+		return "drained"
+	default:
+		return "active"
+	}
+}
+
+// prints the status document indented
+func (c *client) status(w io.Writer) error {
+	var status json.RawMessage
+	if err := c.do(http.MethodGet, "/status", &status); err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, status, "", "  "); err != nil {
+		return fmt.Errorf("invalid status response: %w", err)
+	}
+	out.WriteByte('\n')
+	_, err := out.WriteTo(w)
+	return err
+}
+
+func (c *client) backends(w io.Writer) error {
+	var backends []backend
+	if err := c.do(http.MethodGet, "/admin/backends", &backends); err != nil {
+		return err
+	}
+	return printBackends(w, backends...)
+}
+
+func (c *client) setDrained(w io.Writer, backendURL string, drained bool) error {
+	action := "enable"
+	if drained {
+		action = "drain"
+	}
+
+	var b backend
+	if err := c.do(http.MethodPost, "/admin/backends/"+url.PathEscape(backendURL)+"/"+action, &b); err != nil {
+		return err
+	}
+	return printBackends(w, b)
+}
+
+func printBackends(w io.Writer, backends ...backend) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "UPSTREAM\tBACKEND\tHEALTH\tROTATION\tCONNECTIONS\tCIRCUIT")
+	for _, b := range backends {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", b.Upstream, b.Backend, b.health(), b.rotation(), b.Connections, b.Breaker)
+	}
+	return tw.Flush()
+}
+
+// sends a request to the admin API and decodes the JSON response into v;
+// error responses come back as errors carrying the API's message
+func (c *client) do(method, path string, v any) error {
+	req, err := http.NewRequest(method, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s (HTTP %d)", apiErr.Error, resp.StatusCode)
+		}
+		return fmt.Errorf("%s %s: HTTP %d", method, path, resp.StatusCode)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", path, err)
+	}
+	return nil
+}
@@ -1,31 +1,79 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
 )
 
+const version = "0.1.0"
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Isame Load Balancer Control Tool v0.1.0")
-		fmt.Println("Usage: isame-ctl [command]")
-		fmt.Println("Commands:")
-		fmt.Println("  version  - Show version information")
-		fmt.Println("  help     - Show this help message")
-		return
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes one command and returns the process exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("isame-ctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { usage(stderr) }
+	addr := flags.String("addr", "http://127.0.0.1:9091", "Address of the load balancer's admin API")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		usage(stdout)
+		return 0
 	}
 
-	command := os.Args[1]
+	command, rest := flags.Arg(0), flags.Args()[1:]
+	client := newClient(*addr)
+
+	var err error
 	switch command {
 	case "version":
-		fmt.Println("isame-ctl version 0.1.0")
-		fmt.Println("MVP Load Balancer")
+		fmt.Fprintf(stdout, "isame-ctl version %s\n", version)
+		return 0
 	case "help":
-		fmt.Println("Isame Load Balancer Control Tool")
-		fmt.Println("This is a basic CLI tool for the MVP Load Balancer.")
+		usage(stdout)
+		return 0
+	case "validate":
+		return validate(rest, stdout, stderr)
+	case "status":
+		err = client.status(stdout)
+	case "backends":
+		err = client.backends(stdout)
+	case "drain", "enable":
+		if len(rest) != 1 {
+			fmt.Fprintf(stderr, "Usage: isame-ctl %s <backend-url>\n", command)
+			return 2
+		}
+		err = client.setDrained(stdout, rest[0], command == "drain")
 	default:
-		fmt.Printf("Unknown command: %s\n", command)
-		fmt.Println("Use 'isame-ctl help' for available commands.")
-		os.Exit(1)
+		fmt.Fprintf(stderr, "Unknown command: %s\n", command)
+		fmt.Fprintln(stderr, "Use 'isame-ctl help' for available commands.")
+		return 1
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
 	}
+	return 0
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Isame Load Balancer Control Tool v%s\n", version)
+	fmt.Fprintln(w, "Usage: isame-ctl [--addr http://127.0.0.1:9091] [command]")
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintln(w, "  status         - Show the load balancer's status")
+	fmt.Fprintln(w, "  backends       - List backends with their health, connections and circuit state")
+	fmt.Fprintln(w, "  drain <url>    - Stop sending new requests to a backend")
+	fmt.Fprintln(w, "  enable <url>   - Put a drained backend back into rotation")
+	fmt.Fprintln(w, "  validate --config <path>")
+	fmt.Fprintln(w, "                 - Check a configuration file without starting anything")
+	fmt.Fprintln(w, "  version        - Show version information")
+	fmt.Fprintln(w, "  help           - Show this help message")
 }
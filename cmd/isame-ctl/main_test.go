@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stands in for the admin API with one upstream of two backends
+func newAdminStub(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	backends := []backend{
+		{Upstream: "web", Backend: "http://10.0.0.1:8080", Healthy: true, Connections: 3, Breaker: "closed"},
+		{Upstream: "web", Backend: "http://10.0.0.2:8080", Healthy: false, Breaker: "open"},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"service":"isame-lb","backends":{"total":2,"healthy":1}}`))
+	})
+	mux.HandleFunc("GET /admin/backends", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(backends)
+	})
+	mux.HandleFunc("POST /admin/backends/{backend}/{action}", func(w http.ResponseWriter, r *http.Request) {
+		for _, b := range backends {
+			if b.Backend == r.PathValue("backend") {
+				b.Drained = r.PathValue("action") == "drain"
+				json.NewEncoder(w).Encode(b)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"unknown backend"}`))
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func runCtl(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestStatusCommand(t *testing.T) {
+	admin := newAdminStub(t)
+
+	code, stdout, stderr := runCtl("--addr", admin.URL, "status")
+	if code != 0 {
+		t.Fatalf("Expected exit 0, got %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "\n  \"service\": \"isame-lb\",") {
+		t.Errorf("Expected indented status, got:\n%s", stdout)
+	}
+}
+
+func TestBackendsCommand(t *testing.T) {
+	admin := newAdminStub(t)
+
+	// without a scheme the address is taken as http
+	code, stdout, stderr := runCtl("--addr", strings.TrimPrefix(admin.URL, "http://"), "backends")
+	if code != 0 {
+		t.Fatalf("Expected exit 0, got %d: %s", code, stderr)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a header and 2 backends, got:\n%s", stdout)
+	}
+	for i, want := range [][]string{
+		{"UPSTREAM", "BACKEND", "HEALTH", "ROTATION", "CONNECTIONS", "CIRCUIT"},
+		{"web", "http://10.0.0.1:8080", "healthy", "active", "3", "closed"},
+		{"web", "http://10.0.0.2:8080", "unhealthy", "active", "0", "open"},
+	} {
+		if got := strings.Fields(lines[i]); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("Line %d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestDrainAndEnableCommands(t *testing.T) {
+	admin := newAdminStub(t)
+
+	code, stdout, stderr := runCtl("--addr", admin.URL, "drain", "http://10.0.0.1:8080")
+	if code != 0 {
+		t.Fatalf("Expected exit 0, got %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "drained") {
+		t.Errorf("Expected the backend reported drained, got:\n%s", stdout)
+	}
+
+	code, stdout, stderr = runCtl("--addr", admin.URL, "enable", "http://10.0.0.1:8080")
+	if code != 0 {
+		t.Fatalf("Expected exit 0, got %d: %s", code, stderr)
+	}
+	if strings.Contains(stdout, "drained") || !strings.Contains(stdout, "active") {
+		t.Errorf("Expected the backend reported active, got:\n%s", stdout)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	admin := newAdminStub(t)
+
+	tests := []struct {
+		name      string
+		args      []string
+		wantCode  int
+		wantInErr string
+	}{
+		{"unknown backend", []string{"--addr", admin.URL, "drain", "http://nope:8080"}, 1, "unknown backend (HTTP 404)"},
+		{"missing backend", []string{"--addr", admin.URL, "drain"}, 2, "Usage: isame-ctl drain"},
+		{"unknown command", []string{"frobnicate"}, 1, "Unknown command"},
+		{"admin API down", []string{"--addr", "http://127.0.0.1:1", "status"}, 1, "Error:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, stderr := runCtl(tt.args...)
+			if code != tt.wantCode {
+				t.Errorf("Expected exit %d, got %d", tt.wantCode, code)
+			}
+			if !strings.Contains(stderr, tt.wantInErr) {
+				t.Errorf("Expected %q in stderr, got %q", tt.wantInErr, stderr)
+			}
+		})
+	}
+}
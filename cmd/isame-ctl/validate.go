@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// validate loads and validates a config file the way the load balancer
+// would at startup, without starting anything
+func validate(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("isame-ctl validate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	path := flags.String("config", "", "Path to the configuration file to check")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" || flags.NArg() > 0 {
+		fmt.Fprintln(stderr, "Usage: isame-ctl validate --config <path>")
+		return 2
+	}
+
+	cfg, err := config.LoadConfig(*path)
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid config: %v\n", err)
+		return 1
+	}
+
+	backends := 0
+	for _, upstream := range cfg.Upstreams {
+		backends += len(upstream.Backends)
+	}
+	tls := "off"
+	if cfg.TLS.Enabled {
+		tls = "on"
+	}
+
+	fmt.Fprintf(stdout, "Config %s is valid\n", *path)
+	fmt.Fprintf(stdout, "  service:   %s %s\n", cfg.Service, cfg.Version)
+	fmt.Fprintf(stdout, "  upstreams: %d\n", len(cfg.Upstreams))
+	fmt.Fprintf(stdout, "  backends:  %d\n", backends)
+	fmt.Fprintf(stdout, "  tls:       %s\n", tls)
+	return 0
+}
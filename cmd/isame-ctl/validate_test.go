@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestValidateCommand(t *testing.T) {
+	valid := writeConfig(t, `
+service: "edge-lb"
+version: "2.1.0"
+server:
+  port: 8080
+upstreams:
+  - name: "web"
+    backends:
+      - url: "http://10.0.0.1:8080"
+      - url: "http://10.0.0.2:8080"
+  - name: "api"
+    backends:
+      - url: "http://10.0.1.1:8080"
+`)
+	invalid := writeConfig(t, `
+server:
+  port: 8080
+upstreams:
+  - name: "web"
+    backends:
+      - url: "ftp://10.0.0.1"
+`)
+
+	tests := []struct {
+		name      string
+		args      []string
+		wantCode  int
+		wantOut   []string
+		wantInErr string
+	}{
+		{
+			name:     "valid file",
+			args:     []string{"validate", "--config", valid},
+			wantCode: 0,
+			wantOut:  []string{"is valid", "service:   edge-lb 2.1.0", "upstreams: 2", "backends:  3", "tls:       off"},
+		},
+		{
+			name:      "invalid file",
+			args:      []string{"validate", "--config", invalid},
+			wantCode:  1,
+			wantInErr: "URL scheme must be http or https",
+		},
+		{
+			name:      "missing file",
+			args:      []string{"validate", "--config", filepath.Join(t.TempDir(), "missing.yaml")},
+			wantCode:  1,
+			wantInErr: "Invalid config",
+		},
+		{
+			name:      "no path",
+			args:      []string{"validate"},
+			wantCode:  2,
+			wantInErr: "Usage: isame-ctl validate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCtl(tt.args...)
+			if code != tt.wantCode {
+				t.Errorf("Expected exit %d, got %d (stderr %q)", tt.wantCode, code, stderr)
+			}
+			for _, want := range tt.wantOut {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("Expected %q in output:\n%s", want, stdout)
+				}
+			}
+			if !strings.Contains(stderr, tt.wantInErr) {
+				t.Errorf("Expected %q in stderr, got %q", tt.wantInErr, stderr)
+			}
+		})
+	}
+}
@@ -12,13 +12,19 @@ import (
 func main() {
 	// cli flags
 	var configFile string
-	flag.StringVar(&configFile, "config", "configs/dev.yaml", "Path to configuration file")
+	var requireConfig bool
+	flag.StringVar(&configFile, "config", "configs/dev.yaml", "Path to configuration file, - to read it from stdin, or an http(s) URL to fetch it from")
+	flag.BoolVar(&requireConfig, "require-config", false, "Exit if the configuration file does not exist instead of starting with defaults")
 	flag.Parse()
 
 	log.Println("Isame Load Balancer starting...")
 
 	// load config
-	cfg, err := config.LoadConfigWithDefaults(configFile)
+	load := config.LoadConfigWithDefaults
+	if requireConfig {
+		load = config.LoadRequiredConfig
+	}
+	cfg, err := load(configFile)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
@@ -41,6 +47,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
+	// stdin can only be read once, so such a config cannot be reloaded
+	if config.IsReloadable(configFile) {
+		srv.SetConfigPath(configFile)
+	}
 
 	// start the server (blocks until shutdown)
 	if err := srv.Start(); err != nil {
@@ -0,0 +1,79 @@
+package balancer
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// share of each new load report blended into the smoothed value
+	adaptiveSmoothing = 0.3
+	// weight multiplier floor so saturated backends still get enough
+	// traffic to report that they have recovered
+	minAdaptiveFactor = 0.05
+)
+
+// AdaptiveWeights tracks the load each backend reports about itself and
+// turns it into a multiplier for that backend's configured weight
+type AdaptiveWeights struct {
+	mu    sync.RWMutex
+	decay time.Duration
+	loads map[string]*decayedLoad
+}
+
+func NewAdaptiveWeights(decay time.Duration) *AdaptiveWeights {
+	return &AdaptiveWeights{
+		decay: decay,
+		loads: make(map[string]*decayedLoad),
+	}
+}
+
+// Record folds a reported load into the backend's smoothed value; reports
+// are clamped to [0, 1]
+func (aw *AdaptiveWeights) Record(backendURL string, load float64) {
+	if math.IsNaN(load) {
+		return
+	}
+	load = math.Max(0, math.Min(1, load))
+
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+
+	now := time.Now()
+	current := aw.loadAt(backendURL, now)
+	if _, exists := aw.loads[backendURL]; !exists {
+		current = load
+	}
+
+	aw.loads[backendURL] = &decayedLoad{
+		value:   current + (load-current)*adaptiveSmoothing,
+		updated: now,
+	}
+}
+
+// Load returns the backend's smoothed load, 0 if it never reported
+func (aw *AdaptiveWeights) Load(backendURL string) float64 {
+	aw.mu.RLock()
+	defer aw.mu.RUnlock()
+	return aw.loadAt(backendURL, time.Now())
+}
+
+// Factor returns the multiplier applied to the backend's configured weight
+func (aw *AdaptiveWeights) Factor(backendURL string) float64 {
+	return math.Max(minAdaptiveFactor, 1-aw.Load(backendURL))
+}
+
+// reports fade towards zero while a backend stays quiet so a stale high
+// reading cannot starve it forever; caller must hold aw.mu
+func (aw *AdaptiveWeights) loadAt(backendURL string, now time.Time) float64 {
+	load, exists := aw.loads[backendURL]
+	if !exists {
+		return 0
+	}
+	if aw.decay <= 0 {
+		return load.value
+	}
+
+	return load.value * math.Exp(-float64(now.Sub(load.updated))/float64(aw.decay))
+}
@@ -0,0 +1,71 @@
+package balancer
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestAdaptiveWeightsClampsReports(t *testing.T) {
+	aw := NewAdaptiveWeights(time.Minute)
+
+	aw.Record("http://backend1:8080", 5)
+	if load := aw.Load("http://backend1:8080"); math.Abs(load-1) > 0.001 {
+		t.Errorf("Expected load clamped to 1, got %f", load)
+	}
+	if factor := aw.Factor("http://backend1:8080"); factor != minAdaptiveFactor {
+		t.Errorf("Expected saturated backend to keep the minimum factor, got %f", factor)
+	}
+
+	aw.Record("http://backend2:8080", -3)
+	if load := aw.Load("http://backend2:8080"); load != 0 {
+		t.Errorf("Expected load clamped to 0, got %f", load)
+	}
+
+	if factor := aw.Factor("http://unknown:8080"); factor != 1 {
+		t.Errorf("Expected full weight for a backend with no reports, got %f", factor)
+	}
+}
+
+func TestAdaptiveWeightsDecay(t *testing.T) {
+	aw := NewAdaptiveWeights(20 * time.Millisecond)
+
+	aw.Record("http://backend1:8080", 0.9)
+	time.Sleep(100 * time.Millisecond)
+
+	if load := aw.Load("http://backend1:8080"); load > 0.1 {
+		t.Errorf("Expected stale report to decay towards 0, got %f", load)
+	}
+}
+
+func TestAdaptiveWeightedRoundRobinShiftsTraffic(t *testing.T) {
+	wrr := NewAdaptiveWeightedRoundRobin(time.Minute)
+
+	backends := []config.Backend{
+		{URL: "http://busy:8080", Weight: 1},
+		{URL: "http://idle:8080", Weight: 1},
+	}
+	healthStatus := map[string]bool{}
+
+	for i := 0; i < 20; i++ {
+		wrr.AdaptiveWeights().Record("http://busy:8080", 0.8)
+		wrr.AdaptiveWeights().Record("http://idle:8080", 0)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		backend, err := wrr.SelectBackend(nil, backends, healthStatus)
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		counts[backend.URL]++
+	}
+
+	// factors are 0.2 and 1.0, so the busy backend should get ~1/6 of traffic
+	busyShare := float64(counts["http://busy:8080"]) / 1000
+	if math.Abs(busyShare-1.0/6) > 0.02 {
+		t.Errorf("Expected busy backend to receive ~%.3f of traffic, got %.3f (%v)", 1.0/6, busyShare, counts)
+	}
+}
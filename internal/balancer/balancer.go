@@ -2,9 +2,10 @@ package balancer
 
 import (
 	"errors"
+	"math"
 	"net/http"
-	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/sanchxt/isame-lb/internal/config"
 )
@@ -12,6 +13,9 @@ import (
 var (
 	ErrNoHealthyBackends = errors.New("no healthy backends available")
 	ErrInvalidAlgorithm  = errors.New("invalid load balancing algorithm")
+
+	// every healthy backend is at its max_conns; retrying right away won't help
+	ErrAllBackendsSaturated = errors.New("all backends are at their connection limit")
 )
 
 type LoadBalancer interface {
@@ -20,6 +24,26 @@ type LoadBalancer interface {
 	Algorithm() string
 }
 
+// implemented by balancers that rank backends by in-flight requests.
+// SelectBackend counts the request it picks as in flight, under the same
+// lock as the max_conns check, so concurrent selections can't overshoot a
+// limit; the proxy reports the request's end through DecrementConnections.
+type ConnectionTracker interface {
+	IncrementConnections(backendURL string)
+	DecrementConnections(backendURL string)
+}
+
+// whether a backend may take traffic: disabled backends (weight 0) stay
+// health checked but are never selected, and backends without a health
+// status yet count as healthy
+func available(backend config.Backend, healthStatus map[string]bool) bool {
+	if backend.Disabled {
+		return false
+	}
+	healthy, exists := healthStatus[backend.URL]
+	return !exists || healthy
+}
+
 func NewLoadBalancer(algorithm string) (LoadBalancer, error) {
 	switch algorithm {
 	case "round_robin", "":
@@ -28,11 +52,55 @@ func NewLoadBalancer(algorithm string) (LoadBalancer, error) {
 		return NewWeightedRoundRobin(), nil
 	case "least_connections":
 		return NewLeastConnections(), nil
+	case "least_response_time":
+		return NewLeastResponseTime(), nil
+	case "p2c":
+		return NewPowerOfTwoChoices(), nil
+	case "ip_hash":
+		return NewIPHash(), nil
+	case "consistent_hash":
+		return NewConsistentHash(defaultHashReplicas, ""), nil
+	case "bounded_consistent_hash":
+		return NewBoundedConsistentHash(defaultHashReplicas, defaultLoadFactor), nil
 	default:
 		return nil, ErrInvalidAlgorithm
 	}
 }
 
+// builds the balancer for an upstream, applying algorithm specific options
+func NewForUpstream(upstream config.Upstream) (LoadBalancer, error) {
+	lb, err := NewLoadBalancer(upstream.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if lc, ok := lb.(*LeastConnections); ok && upstream.ConnectionDecay > 0 {
+		lc.decay = upstream.ConnectionDecay
+	}
+
+	if hash := upstream.ConsistentHash; hash != nil {
+		switch lb.(type) {
+		case *ConsistentHash:
+			lb = NewConsistentHash(hash.VirtualNodes, hash.Header)
+		case *BoundedConsistentHash:
+			bch := NewBoundedConsistentHash(hash.VirtualNodes, defaultLoadFactor)
+			bch.header = hash.Header
+			lb = bch
+		}
+	}
+
+	if wrr, ok := lb.(*WeightedRoundRobin); ok && upstream.AdaptiveWeight != nil && upstream.AdaptiveWeight.Enabled {
+		wrr.adaptive = NewAdaptiveWeights(upstream.AdaptiveWeight.Decay)
+	}
+
+	if wrr, ok := lb.(*WeightedRoundRobin); ok && upstream.ErrorWeight != nil && upstream.ErrorWeight.Enabled {
+		ew := upstream.ErrorWeight
+		wrr.errors = NewErrorWeights(ew.Decay, ew.Recovery, ew.Floor)
+	}
+
+	return lb, nil
+}
+
 type RoundRobin struct {
 	counter uint64
 }
@@ -48,7 +116,7 @@ func (rr *RoundRobin) SelectBackend(request *http.Request, backends []config.Bac
 
 	var healthyBackends []config.Backend
 	for _, backend := range backends {
-		if healthy, exists := healthStatus[backend.URL]; !exists || healthy {
+		if available(backend, healthStatus) {
 			healthyBackends = append(healthyBackends, backend)
 		}
 	}
@@ -67,17 +135,86 @@ func (rr *RoundRobin) Algorithm() string {
 	return "round_robin"
 }
 
+// share of its weight a degraded backend keeps when none is configured
+const defaultDegradedFactor = 0.5
+
 type WeightedRoundRobin struct {
-	mu      sync.Mutex
-	weights map[string]int
+	mu      timedRWMutex
+	weights map[string]float64
+
+	adaptive *AdaptiveWeights // nil unless weights follow reported backend load
+	errors   *ErrorWeights    // nil unless weights decay on backend errors
+
+	// degraded backends keep serving at a reduced share of their weight
+	isDegraded     func(backendURL string) bool
+	degradedFactor float64
+
+	// recovered backends ramp back up to their weight over slowStart
+	healthySince func(backendURL string) time.Time
+	slowStart    time.Duration
+
+	conns *LeastConnections // in-flight tracking shared with least_connections, for max_conns
+
+	counter uint64 // plain round robin position while all weights are equal
 }
 
 func NewWeightedRoundRobin() *WeightedRoundRobin {
 	return &WeightedRoundRobin{
-		weights: make(map[string]int),
+		weights: make(map[string]float64),
+		conns:   NewLeastConnections(),
 	}
 }
 
+// creates a weighted round robin balancer whose configured weights are
+// scaled by load reported through the returned tracker
+func NewAdaptiveWeightedRoundRobin(decay time.Duration) *WeightedRoundRobin {
+	wrr := NewWeightedRoundRobin()
+	wrr.adaptive = NewAdaptiveWeights(decay)
+	return wrr
+}
+
+// AdaptiveWeights returns the load tracker, or nil when adaptive weights are off
+func (wrr *WeightedRoundRobin) AdaptiveWeights() *AdaptiveWeights {
+	return wrr.adaptive
+}
+
+// ErrorWeights returns the error tracker, or nil when error weighting is off
+func (wrr *WeightedRoundRobin) ErrorWeights() *ErrorWeights {
+	return wrr.errors
+}
+
+// SetDegradedCheck scales the weight of backends reported as degraded by factor
+func (wrr *WeightedRoundRobin) SetDegradedCheck(isDegraded func(backendURL string) bool, factor float64) {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+
+	if factor <= 0 || factor > 1 {
+		factor = defaultDegradedFactor
+	}
+
+	wrr.isDegraded = isDegraded
+	wrr.degradedFactor = factor
+}
+
+// SetSlowStart ramps the weight of a backend that recently became healthy,
+// per healthySince, from a small fraction up to full over window
+func (wrr *WeightedRoundRobin) SetSlowStart(healthySince func(backendURL string) time.Time, window time.Duration) {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+
+	wrr.healthySince = healthySince
+	wrr.slowStart = window
+}
+
+// the slow start share of a backend's weight, 1 once it has ramped up;
+// caller must hold wrr.mu
+func (wrr *WeightedRoundRobin) rampFactor(backendURL string, now time.Time) float64 {
+	if wrr.healthySince == nil || wrr.slowStart <= 0 {
+		return 1
+	}
+	return slowStartFactor(wrr.healthySince(backendURL), now, wrr.slowStart)
+}
+
 func (wrr *WeightedRoundRobin) SelectBackend(request *http.Request, backends []config.Backend, healthStatus map[string]bool) (*config.Backend, error) {
 	if len(backends) == 0 {
 		return nil, ErrNoHealthyBackends
@@ -88,7 +225,7 @@ func (wrr *WeightedRoundRobin) SelectBackend(request *http.Request, backends []c
 
 	var healthyBackends []config.Backend
 	for _, backend := range backends {
-		if healthy, exists := healthStatus[backend.URL]; !exists || healthy {
+		if available(backend, healthStatus) {
 			healthyBackends = append(healthyBackends, backend)
 		}
 	}
@@ -97,20 +234,51 @@ func (wrr *WeightedRoundRobin) SelectBackend(request *http.Request, backends []c
 		return nil, ErrNoHealthyBackends
 	}
 
+	// full backends sit out the round, so their share is spread over the
+	// rest in proportion to their weights
+	wrr.conns.mu.Lock()
+	defer wrr.conns.mu.Unlock()
+	healthyBackends = wrr.conns.unsaturated(healthyBackends)
+	if len(healthyBackends) == 0 {
+		return nil, ErrAllBackendsSaturated
+	}
+
+	// equal weights make smooth weighted round robin plain round robin, so
+	// take the cheaper path while nothing scales them
+	now := time.Now()
+	if wrr.equalWeights(healthyBackends, now) {
+		wrr.counter++
+		selected := &healthyBackends[(wrr.counter-1)%uint64(len(healthyBackends))]
+		wrr.conns.reserve(selected.URL)
+		return selected, nil
+	}
+
 	for _, backend := range healthyBackends {
 		if _, exists := wrr.weights[backend.URL]; !exists {
 			wrr.weights[backend.URL] = 0
 		}
 	}
 
-	totalWeight := 0
+	nearest := nearestRTT(healthyBackends)
+	totalWeight := 0.0
 	for _, backend := range healthyBackends {
-		totalWeight += backend.Weight
-		wrr.weights[backend.URL] += backend.Weight
+		weight := float64(backend.Weight) * rttFactor(backend, nearest)
+		if wrr.adaptive != nil {
+			weight *= wrr.adaptive.Factor(backend.URL)
+		}
+		if wrr.errors != nil {
+			weight *= wrr.errors.Factor(backend.URL)
+		}
+		if wrr.isDegraded != nil && wrr.isDegraded(backend.URL) {
+			weight *= wrr.degradedFactor
+		}
+		weight *= wrr.rampFactor(backend.URL, now)
+		totalWeight += weight
+		wrr.weights[backend.URL] += weight
 	}
 
 	var selected *config.Backend
-	maxWeight := -1
+	maxWeight := math.Inf(-1)
 	for i := range healthyBackends {
 		backend := &healthyBackends[i]
 		if wrr.weights[backend.URL] > maxWeight {
@@ -124,36 +292,94 @@ func (wrr *WeightedRoundRobin) SelectBackend(request *http.Request, backends []c
 	}
 
 	wrr.weights[selected.URL] -= totalWeight
+	wrr.conns.reserve(selected.URL)
 
 	return selected, nil
 }
 
+// whether every backend's effective weight is its configured weight and
+// all are the same; caller must hold wrr.mu
+func (wrr *WeightedRoundRobin) equalWeights(backends []config.Backend, now time.Time) bool {
+	if wrr.adaptive != nil || wrr.errors != nil {
+		return false
+	}
+	for _, backend := range backends {
+		if backend.Weight != backends[0].Weight || backend.RTTHint != backends[0].RTTHint {
+			return false
+		}
+		if wrr.isDegraded != nil && wrr.isDegraded(backend.URL) {
+			return false
+		}
+		if wrr.rampFactor(backend.URL, now) < 1 {
+			return false
+		}
+	}
+	return true
+}
+
+func (wrr *WeightedRoundRobin) IncrementConnections(backendURL string) {
+	wrr.conns.IncrementConnections(backendURL)
+}
+
+func (wrr *WeightedRoundRobin) DecrementConnections(backendURL string) {
+	wrr.conns.DecrementConnections(backendURL)
+}
+
+func (wrr *WeightedRoundRobin) GetConnections(backendURL string) int64 {
+	return wrr.conns.GetConnections(backendURL)
+}
+
+// LockWait is how long selections and connection tracking have waited for locks
+func (wrr *WeightedRoundRobin) LockWait() time.Duration {
+	return wrr.mu.waitTime() + wrr.conns.LockWait()
+}
+
 func (wrr *WeightedRoundRobin) Algorithm() string {
 	return "weighted_round_robin"
 }
 
 type LeastConnections struct {
-	mu          sync.RWMutex
+	mu          timedRWMutex
 	connections map[string]int64
+
+	// when set, selection uses a time-decayed average of in-flight
+	// connections instead of the raw count, smoothing out bursts
+	decay time.Duration
+	loads map[string]*decayedLoad
+}
+
+// exponentially weighted moving average of a backend's in-flight count
+type decayedLoad struct {
+	value   float64
+	updated time.Time
 }
 
 func NewLeastConnections() *LeastConnections {
 	return &LeastConnections{
 		connections: make(map[string]int64),
+		loads:       make(map[string]*decayedLoad),
 	}
 }
 
+// creates a least-connections balancer that ranks backends by an EWMA of
+// their in-flight count with the given time constant
+func newDecayedLeastConnections(decay time.Duration) *LeastConnections {
+	lc := NewLeastConnections()
+	lc.decay = decay
+	return lc
+}
+
 func (lc *LeastConnections) SelectBackend(request *http.Request, backends []config.Backend, healthStatus map[string]bool) (*config.Backend, error) {
 	if len(backends) == 0 {
 		return nil, ErrNoHealthyBackends
 	}
 
-	lc.mu.RLock()
-	defer lc.mu.RUnlock()
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
 
 	var healthyBackends []config.Backend
 	for _, backend := range backends {
-		if healthy, exists := healthStatus[backend.URL]; !exists || healthy {
+		if available(backend, healthStatus) {
 			healthyBackends = append(healthyBackends, backend)
 		}
 	}
@@ -162,15 +388,75 @@ func (lc *LeastConnections) SelectBackend(request *http.Request, backends []conf
 		return nil, ErrNoHealthyBackends
 	}
 
-	var selected *config.Backend
-	minConnections := int64(-1)
+	healthyBackends = lc.unsaturated(healthyBackends)
+	if len(healthyBackends) == 0 {
+		return nil, ErrAllBackendsSaturated
+	}
+
+	if lc.decay > 0 {
+		selected, err := lc.selectByDecayedLoad(healthyBackends)
+		if err == nil {
+			lc.reserve(selected.URL)
+		}
+		return selected, err
+	}
 
+	// fewest connections per unit of weight, compared as
+	// connections/weight without dividing
+	var selected *config.Backend
+	var minConnections, minWeight int64
 	for i := range healthyBackends {
 		backend := &healthyBackends[i]
 		connections := lc.connections[backend.URL]
+		weight := backendWeight(*backend)
 
-		if minConnections == -1 || connections < minConnections {
+		if selected == nil || connections*minWeight < minConnections*weight {
 			minConnections = connections
+			minWeight = weight
+			selected = backend
+		}
+	}
+
+	if selected == nil {
+		return nil, ErrNoHealthyBackends
+	}
+
+	lc.reserve(selected.URL)
+	return selected, nil
+}
+
+// a backend's weight for ranking by load, at least 1
+func backendWeight(backend config.Backend) int64 {
+	if backend.Weight < 1 {
+		return 1
+	}
+	return int64(backend.Weight)
+}
+
+// drops backends already at their max_conns; caller must hold lc.mu
+func (lc *LeastConnections) unsaturated(backends []config.Backend) []config.Backend {
+	var open []config.Backend
+	for _, backend := range backends {
+		if backend.MaxConns > 0 && lc.connections[backend.URL] >= int64(backend.MaxConns) {
+			continue
+		}
+		open = append(open, backend)
+	}
+	return open
+}
+
+// caller must hold lc.mu
+func (lc *LeastConnections) selectByDecayedLoad(healthyBackends []config.Backend) (*config.Backend, error) {
+	now := time.Now()
+
+	var selected *config.Backend
+	minLoad := math.Inf(1)
+	for i := range healthyBackends {
+		backend := &healthyBackends[i]
+		load := lc.decayedLoadAt(backend.URL, now) / float64(backendWeight(*backend))
+
+		if load < minLoad {
+			minLoad = load
 			selected = backend
 		}
 	}
@@ -182,15 +468,59 @@ func (lc *LeastConnections) SelectBackend(request *http.Request, backends []conf
 	return selected, nil
 }
 
+// the in-flight count has been constant since the last update, so the EWMA
+// converges towards it; caller must hold lc.mu
+func (lc *LeastConnections) decayedLoadAt(backendURL string, now time.Time) float64 {
+	current := float64(lc.connections[backendURL])
+
+	load, exists := lc.loads[backendURL]
+	if !exists {
+		return current
+	}
+
+	weight := math.Exp(-float64(now.Sub(load.updated)) / float64(lc.decay))
+	return current + (load.value-current)*weight
+}
+
+// folds elapsed time into the EWMA before the count changes; caller must hold lc.mu for writing
+func (lc *LeastConnections) updateDecayedLoad(backendURL string) {
+	if lc.decay <= 0 {
+		return
+	}
+
+	now := time.Now()
+	value := lc.decayedLoadAt(backendURL, now)
+	lc.loads[backendURL] = &decayedLoad{value: value, updated: now}
+}
+
+// the load selection ranks backendURL by: the decayed average when decay
+// is set, the raw in-flight count otherwise
+func (lc *LeastConnections) currentLoad(backendURL string) float64 {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	if lc.decay <= 0 {
+		return float64(lc.connections[backendURL])
+	}
+	return lc.decayedLoadAt(backendURL, time.Now())
+}
+
 func (lc *LeastConnections) IncrementConnections(backendURL string) {
 	lc.mu.Lock()
 	defer lc.mu.Unlock()
+	lc.reserve(backendURL)
+}
+
+// counts a selected request as in flight; caller must hold lc.mu for writing
+func (lc *LeastConnections) reserve(backendURL string) {
+	lc.updateDecayedLoad(backendURL)
 	lc.connections[backendURL]++
 }
 
 func (lc *LeastConnections) DecrementConnections(backendURL string) {
 	lc.mu.Lock()
 	defer lc.mu.Unlock()
+	lc.updateDecayedLoad(backendURL)
 	if lc.connections[backendURL] > 0 {
 		lc.connections[backendURL]--
 	}
@@ -202,6 +532,11 @@ func (lc *LeastConnections) GetConnections(backendURL string) int64 {
 	return lc.connections[backendURL]
 }
 
+// LockWait is how long selections and connection tracking have waited for locks
+func (lc *LeastConnections) LockWait() time.Duration {
+	return lc.mu.waitTime()
+}
+
 func (lc *LeastConnections) Algorithm() string {
 	return "least_connections"
 }
@@ -2,7 +2,10 @@ package balancer
 
 import (
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/sanchxt/isame-lb/internal/config"
 )
@@ -32,6 +35,24 @@ func TestNewLoadBalancer(t *testing.T) {
 			expectErr: false,
 			expectAlg: "least_connections",
 		},
+		{
+			name:      "ip_hash",
+			algorithm: "ip_hash",
+			expectErr: false,
+			expectAlg: "ip_hash",
+		},
+		{
+			name:      "consistent_hash",
+			algorithm: "consistent_hash",
+			expectErr: false,
+			expectAlg: "consistent_hash",
+		},
+		{
+			name:      "bounded_consistent_hash",
+			algorithm: "bounded_consistent_hash",
+			expectErr: false,
+			expectAlg: "bounded_consistent_hash",
+		},
 		{
 			name:      "empty string defaults to round_robin",
 			algorithm: "",
@@ -146,6 +167,41 @@ func TestRoundRobinWithUnhealthyBackends(t *testing.T) {
 	}
 }
 
+func TestDisabledBackendsAreSkipped(t *testing.T) {
+	backends := []config.Backend{
+		{URL: "http://backend1.com", Weight: 1},
+		{URL: "http://backend2.com", Disabled: true},
+	}
+	healthStatus := map[string]bool{
+		"http://backend1.com": true,
+		"http://backend2.com": true,
+	}
+	req, _ := http.NewRequest("GET", "/test", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+
+	for _, algorithm := range []string{"round_robin", "weighted_round_robin", "least_connections", "ip_hash", "consistent_hash", "bounded_consistent_hash"} {
+		lb, err := NewLoadBalancer(algorithm)
+		if err != nil {
+			t.Fatalf("NewLoadBalancer(%s) error: %v", algorithm, err)
+		}
+
+		for i := 0; i < 10; i++ {
+			backend, err := lb.SelectBackend(req, backends, healthStatus)
+			if err != nil {
+				t.Fatalf("%s: SelectBackend() unexpected error: %v", algorithm, err)
+			}
+			if backend.Disabled {
+				t.Errorf("%s: selected disabled backend %s", algorithm, backend.URL)
+			}
+		}
+	}
+
+	onlyDisabled := backends[1:]
+	if _, err := NewRoundRobin().SelectBackend(req, onlyDisabled, healthStatus); err == nil {
+		t.Error("Expected error when every backend is disabled")
+	}
+}
+
 func TestRoundRobinNoHealthyBackends(t *testing.T) {
 	backends := []config.Backend{
 		{URL: "http://backend1.com", Weight: 1},
@@ -380,6 +436,79 @@ func TestWeightedRoundRobinSmoothDistribution(t *testing.T) {
 	}
 }
 
+func TestWeightedRoundRobinEqualWeightsMatchesRoundRobin(t *testing.T) {
+	backends := []config.Backend{
+		{URL: "http://a.com", Weight: 2},
+		{URL: "http://b.com", Weight: 2},
+		{URL: "http://c.com", Weight: 2},
+	}
+
+	wrr := NewWeightedRoundRobin()
+	rr := NewRoundRobin()
+	req, _ := http.NewRequest("GET", "/test", nil)
+	healthStatus := map[string]bool{}
+
+	for i := 0; i < 30; i++ {
+		// a backend dropping out keeps the remaining weights equal
+		if i == 10 {
+			healthStatus["http://b.com"] = false
+		}
+		if i == 20 {
+			healthStatus["http://b.com"] = true
+		}
+
+		got, err := wrr.SelectBackend(req, backends, healthStatus)
+		if err != nil {
+			t.Fatalf("SelectBackend() unexpected error: %v", err)
+		}
+		want, _ := rr.SelectBackend(req, backends, healthStatus)
+		if got.URL != want.URL {
+			t.Fatalf("Selection %d: expected %s like round robin, got %s", i, want.URL, got.URL)
+		}
+	}
+
+	// unequal weights go back to smooth weighted round robin
+	backends[0].Weight = 4
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		backend, err := wrr.SelectBackend(req, backends, healthStatus)
+		if err != nil {
+			t.Fatalf("SelectBackend() unexpected error: %v", err)
+		}
+		counts[backend.URL]++
+	}
+	if counts["http://a.com"] != 4 || counts["http://b.com"] != 2 || counts["http://c.com"] != 2 {
+		t.Errorf("Expected a 4:2:2 split once weights differ, got %v", counts)
+	}
+}
+
+func benchmarkWeightedRoundRobin(b *testing.B, weights ...int) {
+	backends := make([]config.Backend, len(weights))
+	for i, weight := range weights {
+		backends[i] = config.Backend{URL: "http://backend" + string(rune('a'+i)) + ".com", Weight: weight}
+	}
+
+	wrr := NewWeightedRoundRobin()
+	req, _ := http.NewRequest("GET", "/test", nil)
+	healthStatus := map[string]bool{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := wrr.SelectBackend(req, backends, healthStatus); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWeightedRoundRobinEqualWeights(b *testing.B) {
+	benchmarkWeightedRoundRobin(b, 1, 1, 1, 1, 1, 1, 1, 1)
+}
+
+func BenchmarkWeightedRoundRobinUnequalWeights(b *testing.B) {
+	benchmarkWeightedRoundRobin(b, 1, 2, 1, 2, 1, 2, 1, 2)
+}
+
 func TestLeastConnectionsSelectBackend(t *testing.T) {
 	backends := []config.Backend{
 		{URL: "http://backend1.com", Weight: 1},
@@ -395,13 +524,12 @@ func TestLeastConnectionsSelectBackend(t *testing.T) {
 		"http://backend3.com": true,
 	}
 
+	// each selection counts as a connection until it is released
 	backend1, err := lc.SelectBackend(req, backends, healthStatus)
 	if err != nil {
 		t.Fatalf("SelectBackend() unexpected error: %v", err)
 	}
 
-	lc.IncrementConnections(backend1.URL)
-
 	backend2, err := lc.SelectBackend(req, backends, healthStatus)
 	if err != nil {
 		t.Fatalf("SelectBackend() unexpected error: %v", err)
@@ -410,8 +538,6 @@ func TestLeastConnectionsSelectBackend(t *testing.T) {
 		t.Error("Expected different backend with fewer connections")
 	}
 
-	lc.IncrementConnections(backend2.URL)
-
 	backend3, err := lc.SelectBackend(req, backends, healthStatus)
 	if err != nil {
 		t.Fatalf("SelectBackend() unexpected error: %v", err)
@@ -420,8 +546,6 @@ func TestLeastConnectionsSelectBackend(t *testing.T) {
 		t.Error("Expected backend3 with fewest connections")
 	}
 
-	lc.IncrementConnections(backend3.URL)
-
 	lc.DecrementConnections(backend1.URL)
 
 	backend4, err := lc.SelectBackend(req, backends, healthStatus)
@@ -433,6 +557,47 @@ func TestLeastConnectionsSelectBackend(t *testing.T) {
 	}
 }
 
+func TestLeastConnectionsWeighted(t *testing.T) {
+	backends := []config.Backend{
+		{URL: "http://small.com", Weight: 1},
+		{URL: "http://large.com", Weight: 3},
+	}
+
+	lc := NewLeastConnections()
+	req, _ := http.NewRequest("GET", "/test", nil)
+	healthStatus := map[string]bool{}
+
+	// connections are held, so counts only grow
+	for i := 0; i < 40; i++ {
+		if _, err := lc.SelectBackend(req, backends, healthStatus); err != nil {
+			t.Fatalf("SelectBackend() unexpected error: %v", err)
+		}
+	}
+
+	small, large := lc.GetConnections("http://small.com"), lc.GetConnections("http://large.com")
+	if small != 10 || large != 30 {
+		t.Errorf("Expected connections split 10/30 by weight, got %d/%d", small, large)
+	}
+
+	// equal load per unit of weight, the next request breaks the tie in order
+	selected, err := lc.SelectBackend(req, backends, healthStatus)
+	if err != nil {
+		t.Fatalf("SelectBackend() unexpected error: %v", err)
+	}
+	if selected.URL != "http://small.com" {
+		t.Errorf("Expected the first backend on a tie, got %s", selected.URL)
+	}
+
+	lc.DecrementConnections("http://large.com")
+	selected, err = lc.SelectBackend(req, backends, healthStatus)
+	if err != nil {
+		t.Fatalf("SelectBackend() unexpected error: %v", err)
+	}
+	if selected.URL != "http://large.com" {
+		t.Errorf("Expected the backend below its weighted share, got %s", selected.URL)
+	}
+}
+
 func TestLeastConnectionsWithUnhealthyBackends(t *testing.T) {
 	backends := []config.Backend{
 		{URL: "http://backend1.com", Weight: 1},
@@ -514,3 +679,250 @@ func TestLeastConnectionsConnectionTracking(t *testing.T) {
 		t.Errorf("Expected 0 connections (should not go negative), got %d", count)
 	}
 }
+
+func TestDecayedLeastConnectionsSmoothsBursts(t *testing.T) {
+	backends := []config.Backend{
+		{URL: "http://backend1.com", Weight: 1},
+		{URL: "http://backend2.com", Weight: 1},
+	}
+	healthStatus := map[string]bool{
+		"http://backend1.com": true,
+		"http://backend2.com": true,
+	}
+
+	// sequential short requests: the raw count is back to zero every time a
+	// backend is picked, so raw counting keeps herding onto the first one
+	distribute := func(lc *LeastConnections) map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < 10; i++ {
+			backend, err := lc.SelectBackend(nil, backends, healthStatus)
+			if err != nil {
+				t.Fatalf("SelectBackend() error = %v", err)
+			}
+			counts[backend.URL]++
+
+			time.Sleep(10 * time.Millisecond)
+			lc.DecrementConnections(backend.URL)
+		}
+		return counts
+	}
+
+	raw := distribute(NewLeastConnections())
+	if raw["http://backend1.com"] != 10 {
+		t.Errorf("Expected raw counting to herd all requests to backend1, got %v", raw)
+	}
+
+	decayed := distribute(newDecayedLeastConnections(100 * time.Millisecond))
+	diff := decayed["http://backend1.com"] - decayed["http://backend2.com"]
+	if diff < -2 || diff > 2 {
+		t.Errorf("Expected decayed counting to spread requests evenly, got %v", decayed)
+	}
+}
+
+func TestDecayedLoadTracksInFlight(t *testing.T) {
+	lc := newDecayedLeastConnections(50 * time.Millisecond)
+	backend := "http://test.com"
+
+	lc.IncrementConnections(backend)
+	if load := lc.currentLoad(backend); load > 0.5 {
+		t.Errorf("Decayed load should rise gradually, got %f", load)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if load := lc.currentLoad(backend); load < 0.9 {
+		t.Errorf("Decayed load should converge to the in-flight count, got %f", load)
+	}
+
+	lc.DecrementConnections(backend)
+	time.Sleep(250 * time.Millisecond)
+	if load := lc.currentLoad(backend); load > 0.1 {
+		t.Errorf("Decayed load should fall back towards zero, got %f", load)
+	}
+}
+
+func TestNewForUpstream(t *testing.T) {
+	lb, err := NewForUpstream(config.Upstream{
+		Name:            "test",
+		Algorithm:       "least_connections",
+		ConnectionDecay: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewForUpstream() error = %v", err)
+	}
+
+	lc, ok := lb.(*LeastConnections)
+	if !ok {
+		t.Fatalf("Expected *LeastConnections, got %T", lb)
+	}
+	if lc.decay != time.Second {
+		t.Errorf("Expected decay 1s, got %v", lc.decay)
+	}
+
+	if _, err := NewForUpstream(config.Upstream{Algorithm: "invalid"}); err == nil {
+		t.Error("Expected error for invalid algorithm")
+	}
+}
+
+func TestWeightedRoundRobinDegradedBackend(t *testing.T) {
+	wrr := NewWeightedRoundRobin()
+
+	degraded := map[string]bool{"http://backend1:8080": true}
+	wrr.SetDegradedCheck(func(url string) bool { return degraded[url] }, 0.25)
+
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 4},
+		{URL: "http://backend2:8080", Weight: 1},
+	}
+	healthStatus := map[string]bool{}
+
+	counts := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		backend, err := wrr.SelectBackend(nil, backends, healthStatus)
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		counts[backend.URL]++
+	}
+
+	// weight 4 scaled by 0.25 matches backend2's weight of 1
+	if counts["http://backend1:8080"] != 100 || counts["http://backend2:8080"] != 100 {
+		t.Errorf("Expected degraded backend's share to be reduced to an even split, got %v", counts)
+	}
+
+	degraded["http://backend1:8080"] = false
+	counts = make(map[string]int)
+	for i := 0; i < 200; i++ {
+		backend, _ := wrr.SelectBackend(nil, backends, healthStatus)
+		counts[backend.URL]++
+	}
+
+	if counts["http://backend1:8080"] != 160 {
+		t.Errorf("Expected full weight once no longer degraded, got %v", counts)
+	}
+}
+
+func TestLeastConnectionsMaxConns(t *testing.T) {
+	backends := []config.Backend{
+		{URL: "http://backend1.com", Weight: 1, MaxConns: 2},
+		{URL: "http://backend2.com", Weight: 1, MaxConns: 3},
+	}
+
+	lc := NewLeastConnections()
+	req, _ := http.NewRequest("GET", "/test", nil)
+
+	// backend1 is full even though backend2 has more connections
+	lc.IncrementConnections("http://backend1.com")
+	lc.IncrementConnections("http://backend1.com")
+	for i := 0; i < 2; i++ {
+		lc.IncrementConnections("http://backend2.com")
+	}
+
+	backend, err := lc.SelectBackend(req, backends, map[string]bool{})
+	if err != nil {
+		t.Fatalf("SelectBackend() unexpected error: %v", err)
+	}
+	if backend.URL != "http://backend2.com" {
+		t.Errorf("Expected backend2 while backend1 is at max_conns, got %s", backend.URL)
+	}
+
+	// the selection took backend2's last slot
+	if _, err := lc.SelectBackend(req, backends, map[string]bool{}); err != ErrAllBackendsSaturated {
+		t.Errorf("Expected ErrAllBackendsSaturated, got %v", err)
+	}
+
+	lc.DecrementConnections("http://backend1.com")
+	backend, err = lc.SelectBackend(req, backends, map[string]bool{})
+	if err != nil {
+		t.Fatalf("SelectBackend() unexpected error after a connection closed: %v", err)
+	}
+	if backend.URL != "http://backend1.com" {
+		t.Errorf("Expected backend1 once below max_conns, got %s", backend.URL)
+	}
+}
+
+func TestWeightedRoundRobinMaxConns(t *testing.T) {
+	backends := []config.Backend{
+		{URL: "http://backend1.com", Weight: 2, MaxConns: 1},
+		{URL: "http://backend2.com", Weight: 3},
+		{URL: "http://backend3.com", Weight: 1},
+	}
+
+	wrr := NewWeightedRoundRobin()
+	req, _ := http.NewRequest("GET", "/test", nil)
+
+	// backend1 holds a long-lived request and is at its cap
+	wrr.IncrementConnections("http://backend1.com")
+
+	counts := make(map[string]int)
+	for i := 0; i < 40; i++ {
+		backend, err := wrr.SelectBackend(req, backends, map[string]bool{})
+		if err != nil {
+			t.Fatalf("SelectBackend() unexpected error: %v", err)
+		}
+		counts[backend.URL]++
+		wrr.DecrementConnections(backend.URL)
+	}
+
+	// its share goes to the others at their own 3:1 ratio
+	if counts["http://backend1.com"] != 0 {
+		t.Errorf("Expected no traffic for backend1 at max_conns, got %d", counts["http://backend1.com"])
+	}
+	if counts["http://backend2.com"] != 30 || counts["http://backend3.com"] != 10 {
+		t.Errorf("Expected a 30/10 split between backend2 and backend3, got %v", counts)
+	}
+
+	// with the cap lifted, backend1 gets its 2 in 6 again
+	wrr.DecrementConnections("http://backend1.com")
+	counts = make(map[string]int)
+	for i := 0; i < 60; i++ {
+		backend, err := wrr.SelectBackend(req, backends, map[string]bool{})
+		if err != nil {
+			t.Fatalf("SelectBackend() unexpected error: %v", err)
+		}
+		counts[backend.URL]++
+		wrr.DecrementConnections(backend.URL)
+	}
+	if counts["http://backend1.com"] != 20 {
+		t.Errorf("Expected backend1 to get 20 of 60 requests below max_conns, got %v", counts)
+	}
+
+	saturated := []config.Backend{{URL: "http://backend1.com", Weight: 1, MaxConns: 1}}
+	wrr.IncrementConnections("http://backend1.com")
+	if _, err := wrr.SelectBackend(req, saturated, map[string]bool{}); err != ErrAllBackendsSaturated {
+		t.Errorf("Expected ErrAllBackendsSaturated, got %v", err)
+	}
+}
+
+func TestMaxConnsHoldsUnderConcurrency(t *testing.T) {
+	const maxConns = 5
+	backends := []config.Backend{{URL: "http://backend1.com", Weight: 1, MaxConns: maxConns}}
+
+	for _, algorithm := range []string{"weighted_round_robin", "least_connections", "least_response_time", "p2c"} {
+		lb, err := NewLoadBalancer(algorithm)
+		if err != nil {
+			t.Fatalf("NewLoadBalancer(%q) error = %v", algorithm, err)
+		}
+		req, _ := http.NewRequest("GET", "/test", nil)
+
+		// none of the selections are released, so only maxConns may succeed
+		var admitted atomic.Int64
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if _, err := lb.SelectBackend(req, backends, map[string]bool{}); err == nil {
+					admitted.Add(1)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		if got := admitted.Load(); got != maxConns {
+			t.Errorf("%s: admitted %d concurrent selections, want max_conns %d", algorithm, got, maxConns)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package balancer
+
+import (
+	"context"
+	"hash/crc32"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+const (
+	defaultHashReplicas = 100
+	defaultLoadFactor   = 1.25
+)
+
+type ringPoint struct {
+	hash    uint32
+	backend int // index into the backend list the ring was built from
+}
+
+// hashRing places every configured backend on the ring several times so a
+// backend leaving only moves the keys it owned
+type hashRing struct {
+	points []ringPoint
+}
+
+func newHashRing(backends []config.Backend, replicas int) *hashRing {
+	points := make([]ringPoint, 0, len(backends)*replicas)
+	for i, backend := range backends {
+		for r := 0; r < replicas; r++ {
+			points = append(points, ringPoint{
+				hash:    hashKey(backend.URL + "#" + strconv.Itoa(r)),
+				backend: i,
+			})
+		}
+	}
+
+	sort.Slice(points, func(a, b int) bool { return points[a].hash < points[b].hash })
+
+	return &hashRing{points: points}
+}
+
+// index of the first point at or after hash, wrapping around
+func (hr *hashRing) search(hash uint32) int {
+	i := sort.Search(len(hr.points), func(i int) bool { return hr.points[i].hash >= hash })
+	if i == len(hr.points) {
+		return 0
+	}
+	return i
+}
+
+// rebuilds the ring only when the backend list changes; not safe for
+// concurrent use, owners guard it with their own lock
+type cachedRing struct {
+	replicas int
+	ring     *hashRing
+	key      string // backend URLs the ring was built from
+}
+
+func (cr *cachedRing) get(backends []config.Backend) *hashRing {
+	urls := make([]string, len(backends))
+	for i, backend := range backends {
+		urls[i] = backend.URL
+	}
+	key := strings.Join(urls, ",")
+
+	if cr.ring == nil || cr.key != key {
+		cr.ring = newHashRing(backends, cr.replicas)
+		cr.key = key
+	}
+	return cr.ring
+}
+
+func hashKey(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+type clientIPKey struct{}
+
+// WithClientIP attaches the client IP the proxy resolved, with trusted
+// proxies taken into account, for ip_hash and consistent hashing to key on
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// identifies the client for hashing: the IP attached with WithClientIP, else
+// the peer address without its port. Forwarding headers aren't read here,
+// only the proxy knows which peers may set them.
+func clientKey(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok && ip != "" {
+		return ip
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// the header value when one is configured and present, else the client address
+func requestKey(r *http.Request, header string) string {
+	if header != "" && r != nil {
+		if value := r.Header.Get(header); value != "" {
+			return value
+		}
+	}
+	return clientKey(r)
+}
+
+// ConsistentHash maps client keys onto a hash ring so adding or removing
+// one of N backends only moves about 1/N of the keys
+type ConsistentHash struct {
+	mu     sync.Mutex
+	header string // hash this request header instead of the client address
+	ring   cachedRing
+
+	// backends reported down outside of health checks, e.g. by an open
+	// circuit breaker; keys fail over past them like past unhealthy ones
+	isDown func(backendURL string) bool
+}
+
+func NewConsistentHash(replicas int, header string) *ConsistentHash {
+	if replicas <= 0 {
+		replicas = defaultHashReplicas
+	}
+
+	return &ConsistentHash{
+		header: header,
+		ring:   cachedRing{replicas: replicas},
+	}
+}
+
+func (ch *ConsistentHash) SelectBackend(request *http.Request, backends []config.Backend, healthStatus map[string]bool) (*config.Backend, error) {
+	if len(backends) == 0 {
+		return nil, ErrNoHealthyBackends
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	// unhealthy backends stay on the ring and are walked past, so a key
+	// always fails over to the same next node and returns to its primary
+	// on recovery
+	ring := ch.ring.get(backends)
+
+	start := ring.search(hashKey(requestKey(request, ch.header)))
+	for i := 0; i < len(ring.points); i++ {
+		backend := backends[ring.points[(start+i)%len(ring.points)].backend]
+		if available(backend, healthStatus) && !down(ch.isDown, backend.URL) {
+			return &backend, nil
+		}
+	}
+
+	return nil, ErrNoHealthyBackends
+}
+
+// SetDownCheck makes keys fail over past backends isDown reports, and
+// return to them once it no longer does
+func (ch *ConsistentHash) SetDownCheck(isDown func(backendURL string) bool) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.isDown = isDown
+}
+
+func down(isDown func(backendURL string) bool, backendURL string) bool {
+	return isDown != nil && isDown(backendURL)
+}
+
+func (ch *ConsistentHash) Algorithm() string {
+	return "consistent_hash"
+}
+
+// BoundedConsistentHash maps client keys onto a hash ring but skips any
+// backend whose in-flight count would exceed loadFactor times the average,
+// so a hot key cannot overload its owner
+type BoundedConsistentHash struct {
+	mu         timedRWMutex
+	loadFactor float64
+	header     string // hash this request header instead of the client address
+	ring       cachedRing
+
+	conns *LeastConnections // in-flight tracking shared with least_connections
+
+	isDown func(backendURL string) bool // see ConsistentHash.isDown
+}
+
+func NewBoundedConsistentHash(replicas int, loadFactor float64) *BoundedConsistentHash {
+	if replicas <= 0 {
+		replicas = defaultHashReplicas
+	}
+	if loadFactor < 1 {
+		loadFactor = defaultLoadFactor
+	}
+
+	return &BoundedConsistentHash{
+		loadFactor: loadFactor,
+		ring:       cachedRing{replicas: replicas},
+		conns:      NewLeastConnections(),
+	}
+}
+
+func (bch *BoundedConsistentHash) SelectBackend(request *http.Request, backends []config.Backend, healthStatus map[string]bool) (*config.Backend, error) {
+	if len(backends) == 0 {
+		return nil, ErrNoHealthyBackends
+	}
+
+	bch.mu.Lock()
+	defer bch.mu.Unlock()
+
+	healthy := make([]bool, len(backends))
+	healthyCount := 0
+	var totalLoad int64
+	for i, backend := range backends {
+		if available(backend, healthStatus) && !down(bch.isDown, backend.URL) {
+			healthy[i] = true
+			healthyCount++
+			totalLoad += bch.conns.GetConnections(backend.URL)
+		}
+	}
+
+	if healthyCount == 0 {
+		return nil, ErrNoHealthyBackends
+	}
+
+	// the ring covers every configured backend, so health changes only
+	// move the keys owned by the backend that changed
+	ring := bch.ring.get(backends)
+
+	// counting the request being placed keeps the bound at least 1
+	bound := int64(math.Ceil(bch.loadFactor * float64(totalLoad+1) / float64(healthyCount)))
+
+	start := ring.search(hashKey(requestKey(request, bch.header)))
+	for i := 0; i < len(ring.points); i++ {
+		idx := ring.points[(start+i)%len(ring.points)].backend
+		if !healthy[idx] {
+			continue
+		}
+		if bch.conns.GetConnections(backends[idx].URL) < bound {
+			selected := backends[idx]
+			bch.conns.IncrementConnections(selected.URL)
+			return &selected, nil
+		}
+	}
+
+	return nil, ErrNoHealthyBackends
+}
+
+// SetDownCheck makes keys fail over past backends isDown reports
+func (bch *BoundedConsistentHash) SetDownCheck(isDown func(backendURL string) bool) {
+	bch.mu.Lock()
+	defer bch.mu.Unlock()
+	bch.isDown = isDown
+}
+
+func (bch *BoundedConsistentHash) IncrementConnections(backendURL string) {
+	bch.conns.IncrementConnections(backendURL)
+}
+
+func (bch *BoundedConsistentHash) DecrementConnections(backendURL string) {
+	bch.conns.DecrementConnections(backendURL)
+}
+
+func (bch *BoundedConsistentHash) GetConnections(backendURL string) int64 {
+	return bch.conns.GetConnections(backendURL)
+}
+
+// LockWait is how long selections and connection tracking have waited for locks
+func (bch *BoundedConsistentHash) LockWait() time.Duration {
+	return bch.mu.waitTime() + bch.conns.LockWait()
+}
+
+func (bch *BoundedConsistentHash) Algorithm() string {
+	return "bounded_consistent_hash"
+}
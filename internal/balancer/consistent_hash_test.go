@@ -0,0 +1,302 @@
+package balancer
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func newKeyedRequest(clientIP string) *http.Request {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = clientIP + ":54321"
+	return req
+}
+
+func TestBoundedConsistentHashIsSticky(t *testing.T) {
+	bch := NewBoundedConsistentHash(100, 1.25)
+
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backend3:8080", Weight: 1},
+	}
+	healthStatus := map[string]bool{}
+
+	// with nothing in flight every key should stay on its ring owner
+	first, err := bch.SelectBackend(newKeyedRequest("10.0.0.7"), backends, healthStatus)
+	if err != nil {
+		t.Fatalf("SelectBackend() error = %v", err)
+	}
+	bch.DecrementConnections(first.URL)
+
+	for i := 0; i < 50; i++ {
+		backend, err := bch.SelectBackend(newKeyedRequest("10.0.0.7"), backends, healthStatus)
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		if backend.URL != first.URL {
+			t.Fatalf("Expected key to stay on %s, got %s", first.URL, backend.URL)
+		}
+		bch.DecrementConnections(backend.URL)
+	}
+}
+
+func TestBoundedConsistentHashRespectsLoadBound(t *testing.T) {
+	const loadFactor = 1.25
+	bch := NewBoundedConsistentHash(100, loadFactor)
+
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backend3:8080", Weight: 1},
+		{URL: "http://backend4:8080", Weight: 1},
+	}
+	healthStatus := map[string]bool{}
+
+	// heavily skewed keys: 90% of requests come from one client, and none
+	// of them finish, so in-flight counts only grow
+	const requests = 200
+	for i := 0; i < requests; i++ {
+		clientIP := "10.0.0.1"
+		if i%10 == 0 {
+			clientIP = fmt.Sprintf("10.0.1.%d", i)
+		}
+
+		if _, err := bch.SelectBackend(newKeyedRequest(clientIP), backends, healthStatus); err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+	}
+
+	bound := int64(math.Ceil(loadFactor * requests / float64(len(backends))))
+	for _, backend := range backends {
+		if load := bch.GetConnections(backend.URL); load > bound {
+			t.Errorf("Backend %s has %d in flight, exceeds bound %d", backend.URL, load, bound)
+		}
+	}
+}
+
+func TestBoundedConsistentHashSkipsUnhealthy(t *testing.T) {
+	bch := NewBoundedConsistentHash(100, 1.25)
+
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+	}
+
+	owner, _ := bch.SelectBackend(newKeyedRequest("10.0.0.9"), backends, map[string]bool{})
+	healthStatus := map[string]bool{owner.URL: false}
+
+	backend, err := bch.SelectBackend(newKeyedRequest("10.0.0.9"), backends, healthStatus)
+	if err != nil {
+		t.Fatalf("SelectBackend() error = %v", err)
+	}
+	if backend.URL == owner.URL {
+		t.Error("Expected unhealthy owner to be skipped")
+	}
+
+	healthStatus[backend.URL] = false
+	if _, err := bch.SelectBackend(newKeyedRequest("10.0.0.9"), backends, healthStatus); err != ErrNoHealthyBackends {
+		t.Errorf("Expected ErrNoHealthyBackends, got %v", err)
+	}
+}
+
+func TestConsistentHashMinimalRemapOnRemoval(t *testing.T) {
+	ch := NewConsistentHash(100, "")
+
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backend3:8080", Weight: 1},
+		{URL: "http://backend4:8080", Weight: 1},
+		{URL: "http://backend5:8080", Weight: 1},
+	}
+	healthStatus := map[string]bool{}
+
+	const keys = 2000
+	before := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		ip := fmt.Sprintf("10.%d.%d.%d", i/65536, (i/256)%256, i%256)
+		backend, err := ch.SelectBackend(newKeyedRequest(ip), backends, healthStatus)
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		before[ip] = backend.URL
+	}
+
+	removed := backends[2].URL
+	remaining := append(append([]config.Backend{}, backends[:2]...), backends[3:]...)
+
+	moved := 0
+	for ip, owner := range before {
+		backend, err := ch.SelectBackend(newKeyedRequest(ip), remaining, healthStatus)
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		if backend.URL == removed {
+			t.Fatalf("Removed backend %s still selected", removed)
+		}
+		if backend.URL != owner {
+			if owner != removed {
+				t.Errorf("Key %s moved from %s to %s though its owner was not removed", ip, owner, backend.URL)
+			}
+			moved++
+		}
+	}
+
+	// only the removed backend's share (~1/5) should move, far from all of it
+	if share := float64(moved) / keys; share > 0.35 {
+		t.Errorf("Expected about 1/%d of keys to remap, got %.2f", len(backends), share)
+	}
+}
+
+func TestConsistentHashHeaderKey(t *testing.T) {
+	ch := NewConsistentHash(100, "X-Session-ID")
+
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backend3:8080", Weight: 1},
+	}
+	healthStatus := map[string]bool{}
+
+	var first string
+	for i := 0; i < 20; i++ {
+		// same session from different addresses keeps its backend
+		req := newKeyedRequest(fmt.Sprintf("192.168.1.%d", i))
+		req.Header.Set("X-Session-ID", "session-42")
+
+		backend, err := ch.SelectBackend(req, backends, healthStatus)
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		if first == "" {
+			first = backend.URL
+		} else if backend.URL != first {
+			t.Fatalf("Expected session to stay on %s, got %s", first, backend.URL)
+		}
+	}
+
+	healthStatus[first] = false
+	req := newKeyedRequest("192.168.1.1")
+	req.Header.Set("X-Session-ID", "session-42")
+	backend, err := ch.SelectBackend(req, backends, healthStatus)
+	if err != nil {
+		t.Fatalf("SelectBackend() error = %v", err)
+	}
+	if backend.URL == first {
+		t.Errorf("Expected unhealthy backend %s to be skipped", first)
+	}
+}
+
+func TestConsistentHashFailoverReturnsOnRecovery(t *testing.T) {
+	ch := NewConsistentHash(100, "")
+
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backend3:8080", Weight: 1},
+		{URL: "http://backend4:8080", Weight: 1},
+	}
+	healthStatus := map[string]bool{}
+
+	owners := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		ip := fmt.Sprintf("10.1.%d.%d", i/256, i%256)
+		backend, err := ch.SelectBackend(newKeyedRequest(ip), backends, healthStatus)
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		owners[ip] = backend.URL
+	}
+
+	down := "http://backend2:8080"
+	healthStatus[down] = false
+
+	// each of its keys moves to the next node on the ring, the one it would
+	// have without the primary, and stays there while the primary is down
+	var survivors []config.Backend
+	for _, backend := range backends {
+		if backend.URL != down {
+			survivors = append(survivors, backend)
+		}
+	}
+	for ip, owner := range owners {
+		for round := 0; round < 3; round++ {
+			backend, _ := ch.SelectBackend(newKeyedRequest(ip), backends, healthStatus)
+			if owner != down {
+				if backend.URL != owner {
+					t.Fatalf("Key %s moved from %s to %s though its primary is up", ip, owner, backend.URL)
+				}
+				continue
+			}
+
+			next, _ := NewConsistentHash(100, "").SelectBackend(newKeyedRequest(ip), survivors, map[string]bool{})
+			if backend.URL != next.URL {
+				t.Fatalf("Key %s failed over to %s, want next ring node %s", ip, backend.URL, next.URL)
+			}
+		}
+	}
+
+	healthStatus[down] = true
+	for ip, owner := range owners {
+		backend, _ := ch.SelectBackend(newKeyedRequest(ip), backends, healthStatus)
+		if backend.URL != owner {
+			t.Errorf("Key %s should return to %s on recovery, got %s", ip, owner, backend.URL)
+		}
+	}
+}
+
+func TestConsistentHashDownCheck(t *testing.T) {
+	ch := NewConsistentHash(100, "")
+
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backend3:8080", Weight: 1},
+	}
+	req := newKeyedRequest("192.168.7.7")
+
+	primary, err := ch.SelectBackend(req, backends, map[string]bool{})
+	if err != nil {
+		t.Fatalf("SelectBackend() error = %v", err)
+	}
+
+	// e.g. the primary's circuit breaker is open while health checks still pass
+	open := map[string]bool{primary.URL: true}
+	ch.SetDownCheck(func(backendURL string) bool { return open[backendURL] })
+
+	failover, err := ch.SelectBackend(req, backends, map[string]bool{})
+	if err != nil {
+		t.Fatalf("SelectBackend() error = %v", err)
+	}
+	if failover.URL == primary.URL {
+		t.Fatalf("Expected key to fail over past %s", primary.URL)
+	}
+
+	delete(open, primary.URL)
+	back, _ := ch.SelectBackend(req, backends, map[string]bool{})
+	if back.URL != primary.URL {
+		t.Errorf("Expected key to return to %s, got %s", primary.URL, back.URL)
+	}
+}
+
+func TestNewForUpstreamConsistentHash(t *testing.T) {
+	lb, err := NewForUpstream(config.Upstream{
+		Algorithm:      "consistent_hash",
+		ConsistentHash: &config.ConsistentHashConfig{VirtualNodes: 10, Header: "X-User"},
+	})
+	if err != nil {
+		t.Fatalf("NewForUpstream() error = %v", err)
+	}
+
+	ch, ok := lb.(*ConsistentHash)
+	if !ok {
+		t.Fatalf("Expected *ConsistentHash, got %T", lb)
+	}
+	if ch.ring.replicas != 10 || ch.header != "X-User" {
+		t.Errorf("Expected ring options to be applied, got replicas=%d header=%q", ch.ring.replicas, ch.header)
+	}
+}
@@ -0,0 +1,65 @@
+package balancer
+
+import (
+	"math"
+	"sync"
+)
+
+// ErrorWeights softens the weight of backends that keep failing: every
+// error multiplies a backend's factor by decay, down to floor, and every
+// success multiplies it by recovery, up to 1. A flaky backend gets less
+// traffic without being taken out of rotation.
+type ErrorWeights struct {
+	mu       sync.RWMutex
+	decay    float64
+	recovery float64
+	floor    float64
+	factors  map[string]float64
+}
+
+func NewErrorWeights(decay, recovery, floor float64) *ErrorWeights {
+	return &ErrorWeights{
+		decay:    decay,
+		recovery: recovery,
+		floor:    floor,
+		factors:  make(map[string]float64),
+	}
+}
+
+// Record applies the outcome of a request to the backend's factor
+func (ew *ErrorWeights) Record(backendURL string, success bool) {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+
+	factor, exists := ew.factors[backendURL]
+	if !exists {
+		if success {
+			return
+		}
+		factor = 1
+	}
+
+	if success {
+		factor = math.Min(1, factor*ew.recovery)
+	} else {
+		factor = math.Max(ew.floor, factor*ew.decay)
+	}
+
+	// fully recovered backends need no entry
+	if factor >= 1 {
+		delete(ew.factors, backendURL)
+		return
+	}
+	ew.factors[backendURL] = factor
+}
+
+// Factor returns the multiplier applied to the backend's configured weight
+func (ew *ErrorWeights) Factor(backendURL string) float64 {
+	ew.mu.RLock()
+	defer ew.mu.RUnlock()
+
+	if factor, exists := ew.factors[backendURL]; exists {
+		return factor
+	}
+	return 1
+}
@@ -0,0 +1,80 @@
+package balancer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestErrorWeightsDecayAndRecovery(t *testing.T) {
+	ew := NewErrorWeights(0.5, 2, 0.1)
+	backend := "http://backend1:8080"
+
+	if factor := ew.Factor(backend); factor != 1 {
+		t.Errorf("Expected full weight for a backend with no errors, got %f", factor)
+	}
+
+	ew.Record(backend, false)
+	ew.Record(backend, false)
+	if factor := ew.Factor(backend); math.Abs(factor-0.25) > 0.001 {
+		t.Errorf("Expected two errors to leave a factor of 0.25, got %f", factor)
+	}
+
+	for i := 0; i < 10; i++ {
+		ew.Record(backend, false)
+	}
+	if factor := ew.Factor(backend); factor != 0.1 {
+		t.Errorf("Expected the factor to stop at the floor, got %f", factor)
+	}
+
+	ew.Record(backend, true)
+	if factor := ew.Factor(backend); math.Abs(factor-0.2) > 0.001 {
+		t.Errorf("Expected a success to double the factor, got %f", factor)
+	}
+
+	for i := 0; i < 5; i++ {
+		ew.Record(backend, true)
+	}
+	if factor := ew.Factor(backend); factor != 1 {
+		t.Errorf("Expected successes to restore the full weight, got %f", factor)
+	}
+}
+
+func TestWeightedRoundRobinErrorWeightsReduceFlakyShare(t *testing.T) {
+	lb, err := NewForUpstream(config.Upstream{
+		Algorithm:   "weighted_round_robin",
+		ErrorWeight: &config.ErrorWeightConfig{Enabled: true, Decay: 0.5, Recovery: 1.5, Floor: 0.1},
+	})
+	if err != nil {
+		t.Fatalf("NewForUpstream() error = %v", err)
+	}
+	wrr := lb.(*WeightedRoundRobin)
+
+	backends := []config.Backend{
+		{URL: "http://flaky:8080", Weight: 1},
+		{URL: "http://steady:8080", Weight: 1},
+	}
+	healthStatus := map[string]bool{}
+
+	// the flaky backend fails every other request it gets
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		backend, err := wrr.SelectBackend(nil, backends, healthStatus)
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		counts[backend.URL]++
+
+		success := backend.URL == "http://steady:8080" || counts[backend.URL]%2 == 0
+		wrr.ErrorWeights().Record(backend.URL, success)
+	}
+
+	share := float64(counts["http://flaky:8080"]) / 1000
+	if share >= 0.4 {
+		t.Errorf("Expected the flaky backend's share to drop below 40%%, got %.1f%%", share*100)
+	}
+	if share < 0.05 {
+		t.Errorf("Expected the flaky backend to keep receiving traffic, got %.1f%%", share*100)
+	}
+}
@@ -0,0 +1,40 @@
+package balancer
+
+import (
+	"net/http"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// IPHash pins each client to a backend by hashing its address over the
+// healthy set; when that set changes clients are rehashed over what remains
+type IPHash struct{}
+
+func NewIPHash() *IPHash {
+	return &IPHash{}
+}
+
+func (ih *IPHash) SelectBackend(request *http.Request, backends []config.Backend, healthStatus map[string]bool) (*config.Backend, error) {
+	if len(backends) == 0 {
+		return nil, ErrNoHealthyBackends
+	}
+
+	var healthyBackends []config.Backend
+	for _, backend := range backends {
+		if available(backend, healthStatus) {
+			healthyBackends = append(healthyBackends, backend)
+		}
+	}
+
+	if len(healthyBackends) == 0 {
+		return nil, ErrNoHealthyBackends
+	}
+
+	index := hashKey(clientKey(request)) % uint32(len(healthyBackends))
+
+	return &healthyBackends[index], nil
+}
+
+func (ih *IPHash) Algorithm() string {
+	return "ip_hash"
+}
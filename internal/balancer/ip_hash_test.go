@@ -0,0 +1,150 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestIPHashSameClientSameBackend(t *testing.T) {
+	ih := NewIPHash()
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backend3:8080", Weight: 1},
+	}
+	healthStatus := map[string]bool{}
+
+	for i := 0; i < 20; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = fmt.Sprintf("10.0.0.%d:%d", i, 40000+i)
+
+		first, err := ih.SelectBackend(req, backends, healthStatus)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		for j := 0; j < 100; j++ {
+			// the client port changes per connection, the backend must not
+			req.RemoteAddr = fmt.Sprintf("10.0.0.%d:%d", i, 50000+j)
+			backend, err := ih.SelectBackend(req, backends, healthStatus)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if backend.URL != first.URL {
+				t.Fatalf("Client 10.0.0.%d moved from %s to %s", i, first.URL, backend.URL)
+			}
+		}
+	}
+}
+
+func TestIPHashSpreadsClients(t *testing.T) {
+	ih := NewIPHash()
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backend3:8080", Weight: 1},
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = fmt.Sprintf("192.168.%d.%d:1234", i/256, i%256)
+		backend, err := ih.SelectBackend(req, backends, map[string]bool{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		counts[backend.URL]++
+	}
+
+	for _, backend := range backends {
+		if counts[backend.URL] == 0 {
+			t.Errorf("Expected %s to receive some clients, distribution %v", backend.URL, counts)
+		}
+	}
+}
+
+func TestIPHashUnhealthyBackend(t *testing.T) {
+	ih := NewIPHash()
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backend3:8080", Weight: 1},
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req = req.WithContext(WithClientIP(req.Context(), "203.0.113.7"))
+
+	first, err := ih.SelectBackend(req, backends, map[string]bool{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	healthStatus := map[string]bool{first.URL: false}
+	for i := 0; i < 10; i++ {
+		backend, err := ih.SelectBackend(req, backends, healthStatus)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if backend.URL == first.URL {
+			t.Fatalf("Expected unhealthy backend %s to be skipped", first.URL)
+		}
+	}
+
+	for _, backend := range backends {
+		healthStatus[backend.URL] = false
+	}
+	if _, err := ih.SelectBackend(req, backends, healthStatus); err != ErrNoHealthyBackends {
+		t.Errorf("Expected ErrNoHealthyBackends, got %v", err)
+	}
+}
+
+func TestIPHashIgnoresForwardingHeaders(t *testing.T) {
+	ih := NewIPHash()
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backend3:8080", Weight: 1},
+		{URL: "http://backend4:8080", Weight: 1},
+	}
+
+	plain := httptest.NewRequest("GET", "/", nil)
+	plain.RemoteAddr = "198.51.100.20:4000"
+	want, err := ih.SelectBackend(plain, backends, map[string]bool{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// a client can't steer itself to another backend with headers it sets
+	for i := 0; i < 20; i++ {
+		spoofed := httptest.NewRequest("GET", "/", nil)
+		spoofed.RemoteAddr = fmt.Sprintf("198.51.100.20:%d", 5000+i)
+		spoofed.Header.Set("X-Forwarded-For", fmt.Sprintf("10.0.0.%d", i))
+		spoofed.Header.Set("X-Real-IP", fmt.Sprintf("10.0.1.%d", i))
+		got, err := ih.SelectBackend(spoofed, backends, map[string]bool{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got.URL != want.URL {
+			t.Fatalf("Forwarding headers moved the client from %s to %s", want.URL, got.URL)
+		}
+	}
+
+	// the IP the proxy resolved is what counts
+	counts := make(map[string]int)
+	for i := 0; i < 50; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "198.51.100.20:4000"
+		req = req.WithContext(WithClientIP(req.Context(), fmt.Sprintf("203.0.113.%d", i)))
+		got, err := ih.SelectBackend(req, backends, map[string]bool{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		counts[got.URL]++
+	}
+	if len(counts) < 2 {
+		t.Errorf("Expected resolved client IPs to spread over backends, got %v", counts)
+	}
+}
@@ -0,0 +1,121 @@
+package balancer
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// weight of the newest sample in a backend's response time average
+const responseTimeSmoothing = 0.3
+
+// implemented by balancers that rank backends by how fast they answer; the
+// proxy reports each response's latency through it
+type ResponseTimeRecorder interface {
+	RecordResponseTime(backendURL string, d time.Duration)
+}
+
+// LeastResponseTime picks the backend with the lowest moving average of
+// response latency, breaking ties by in-flight requests. Backends without
+// a sample yet rank first so each gets measured.
+type LeastResponseTime struct {
+	mu    timedRWMutex
+	ewma  map[string]float64 // seconds
+	conns *LeastConnections  // in-flight tracking for ties and max_conns
+}
+
+func NewLeastResponseTime() *LeastResponseTime {
+	return &LeastResponseTime{
+		ewma:  make(map[string]float64),
+		conns: NewLeastConnections(),
+	}
+}
+
+func (lrt *LeastResponseTime) SelectBackend(request *http.Request, backends []config.Backend, healthStatus map[string]bool) (*config.Backend, error) {
+	if len(backends) == 0 {
+		return nil, ErrNoHealthyBackends
+	}
+
+	var healthyBackends []config.Backend
+	for _, backend := range backends {
+		if available(backend, healthStatus) {
+			healthyBackends = append(healthyBackends, backend)
+		}
+	}
+
+	if len(healthyBackends) == 0 {
+		return nil, ErrNoHealthyBackends
+	}
+
+	lrt.conns.mu.Lock()
+	defer lrt.conns.mu.Unlock()
+
+	healthyBackends = lrt.conns.unsaturated(healthyBackends)
+	if len(healthyBackends) == 0 {
+		return nil, ErrAllBackendsSaturated
+	}
+
+	lrt.mu.RLock()
+	defer lrt.mu.RUnlock()
+
+	var selected *config.Backend
+	var minLatency float64
+	var minConnections int64
+	for i := range healthyBackends {
+		backend := &healthyBackends[i]
+		latency := lrt.ewma[backend.URL]
+		connections := lrt.conns.connections[backend.URL]
+
+		if selected == nil || latency < minLatency || (latency == minLatency && connections < minConnections) {
+			selected = backend
+			minLatency = latency
+			minConnections = connections
+		}
+	}
+
+	lrt.conns.reserve(selected.URL)
+	return selected, nil
+}
+
+// RecordResponseTime folds d into backendURL's moving average
+func (lrt *LeastResponseTime) RecordResponseTime(backendURL string, d time.Duration) {
+	lrt.mu.Lock()
+	defer lrt.mu.Unlock()
+
+	sample := d.Seconds()
+	current, exists := lrt.ewma[backendURL]
+	if !exists {
+		lrt.ewma[backendURL] = sample
+		return
+	}
+	lrt.ewma[backendURL] = current + responseTimeSmoothing*(sample-current)
+}
+
+// GetResponseTime returns backendURL's average response time, 0 before the first sample
+func (lrt *LeastResponseTime) GetResponseTime(backendURL string) time.Duration {
+	lrt.mu.RLock()
+	defer lrt.mu.RUnlock()
+	return time.Duration(lrt.ewma[backendURL] * float64(time.Second))
+}
+
+func (lrt *LeastResponseTime) IncrementConnections(backendURL string) {
+	lrt.conns.IncrementConnections(backendURL)
+}
+
+func (lrt *LeastResponseTime) DecrementConnections(backendURL string) {
+	lrt.conns.DecrementConnections(backendURL)
+}
+
+func (lrt *LeastResponseTime) GetConnections(backendURL string) int64 {
+	return lrt.conns.GetConnections(backendURL)
+}
+
+// LockWait is how long selections and connection tracking have waited for locks
+func (lrt *LeastResponseTime) LockWait() time.Duration {
+	return lrt.mu.waitTime() + lrt.conns.LockWait()
+}
+
+func (lrt *LeastResponseTime) Algorithm() string {
+	return "least_response_time"
+}
@@ -0,0 +1,124 @@
+package balancer
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestLeastResponseTimePrefersFasterBackend(t *testing.T) {
+	lb, err := NewLoadBalancer("least_response_time")
+	if err != nil {
+		t.Fatalf("NewLoadBalancer() error = %v", err)
+	}
+	lrt := lb.(*LeastResponseTime)
+
+	backends := []config.Backend{
+		{URL: "http://fast:8080", Weight: 1},
+		{URL: "http://slow:8080", Weight: 1},
+	}
+	latency := map[string]time.Duration{
+		"http://fast:8080": 10 * time.Millisecond,
+		"http://slow:8080": 50 * time.Millisecond,
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		selected, err := lrt.SelectBackend(req, backends, map[string]bool{})
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		counts[selected.URL]++
+
+		// jitter so the slow backend's average sometimes dips
+		d := latency[selected.URL]
+		if i%3 == 0 {
+			d /= 2
+		}
+		lrt.RecordResponseTime(selected.URL, d)
+		lrt.DecrementConnections(selected.URL)
+	}
+
+	if counts["http://fast:8080"] <= counts["http://slow:8080"] {
+		t.Errorf("Expected the faster backend to be chosen more often, got %v", counts)
+	}
+	if counts["http://slow:8080"] == 0 {
+		t.Errorf("Expected the slow backend to be measured at least once, got %v", counts)
+	}
+}
+
+func TestLeastResponseTimeMovingAverage(t *testing.T) {
+	lrt := NewLeastResponseTime()
+	backend := "http://backend1:8080"
+
+	if got := lrt.GetResponseTime(backend); got != 0 {
+		t.Errorf("Expected no average before the first sample, got %s", got)
+	}
+
+	lrt.RecordResponseTime(backend, 100*time.Millisecond)
+	if got := lrt.GetResponseTime(backend); got != 100*time.Millisecond {
+		t.Errorf("Expected the first sample to seed the average, got %s", got)
+	}
+
+	lrt.RecordResponseTime(backend, 200*time.Millisecond)
+	if got := lrt.GetResponseTime(backend); got != 130*time.Millisecond {
+		t.Errorf("Expected the average to move 30%% towards the new sample, got %s", got)
+	}
+}
+
+func TestLeastResponseTimeTieBrokenByConnections(t *testing.T) {
+	lrt := NewLeastResponseTime()
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+	}
+	lrt.RecordResponseTime("http://backend1:8080", 20*time.Millisecond)
+	lrt.RecordResponseTime("http://backend2:8080", 20*time.Millisecond)
+	lrt.IncrementConnections("http://backend1:8080")
+
+	selected, err := lrt.SelectBackend(httptest.NewRequest("GET", "/", nil), backends, map[string]bool{})
+	if err != nil {
+		t.Fatalf("SelectBackend() error = %v", err)
+	}
+	if selected.URL != "http://backend2:8080" {
+		t.Errorf("Expected the tie to go to the backend with fewer connections, got %s", selected.URL)
+	}
+}
+
+func TestLeastResponseTimeSkipsUnavailable(t *testing.T) {
+	lrt := NewLeastResponseTime()
+	backends := []config.Backend{
+		{URL: "http://fast:8080", Weight: 1, MaxConns: 1},
+		{URL: "http://down:8080", Weight: 1},
+		{URL: "http://slow:8080", Weight: 1},
+	}
+	lrt.RecordResponseTime("http://fast:8080", time.Millisecond)
+	lrt.RecordResponseTime("http://down:8080", time.Millisecond)
+	lrt.RecordResponseTime("http://slow:8080", time.Second)
+	healthStatus := map[string]bool{"http://down:8080": false}
+	req := httptest.NewRequest("GET", "/", nil)
+
+	selected, err := lrt.SelectBackend(req, backends, healthStatus)
+	if err != nil {
+		t.Fatalf("SelectBackend() error = %v", err)
+	}
+	if selected.URL != "http://fast:8080" {
+		t.Fatalf("Expected the fastest healthy backend, got %s", selected.URL)
+	}
+
+	// the first selection holds fast's only slot
+	selected, err = lrt.SelectBackend(req, backends, healthStatus)
+	if err != nil {
+		t.Fatalf("SelectBackend() error = %v", err)
+	}
+	if selected.URL != "http://slow:8080" {
+		t.Errorf("Expected a backend at max_conns to be skipped, got %s", selected.URL)
+	}
+
+	if _, err := lrt.SelectBackend(req, backends[1:2], healthStatus); err != ErrNoHealthyBackends {
+		t.Errorf("Expected ErrNoHealthyBackends, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package balancer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// implemented by balancers that keep a running total of time spent waiting
+// for their locks, a rough measure of contention under load
+type LockWaiter interface {
+	LockWait() time.Duration
+}
+
+// timedRWMutex is a sync.RWMutex that adds up how long Lock and RLock
+// waited; an uncontended lock costs only a TryLock
+type timedRWMutex struct {
+	sync.RWMutex
+	waited atomic.Int64 // nanoseconds
+}
+
+func (m *timedRWMutex) Lock() {
+	if m.TryLock() {
+		return
+	}
+	start := time.Now()
+	m.RWMutex.Lock()
+	m.waited.Add(int64(time.Since(start)))
+}
+
+func (m *timedRWMutex) RLock() {
+	if m.TryRLock() {
+		return
+	}
+	start := time.Now()
+	m.RWMutex.RLock()
+	m.waited.Add(int64(time.Since(start)))
+}
+
+func (m *timedRWMutex) waitTime() time.Duration {
+	return time.Duration(m.waited.Load())
+}
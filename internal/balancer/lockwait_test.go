@@ -0,0 +1,48 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimedRWMutexCountsContention(t *testing.T) {
+	var mu timedRWMutex
+
+	mu.Lock()
+	mu.Unlock()
+	mu.RLock()
+	mu.RUnlock()
+	if wait := mu.waitTime(); wait != 0 {
+		t.Errorf("Expected no wait for an uncontended lock, got %s", wait)
+	}
+
+	mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		mu.RLock()
+		mu.RUnlock()
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	mu.Unlock()
+	<-done
+
+	if wait := mu.waitTime(); wait < 10*time.Millisecond {
+		t.Errorf("Expected the blocked reader's wait to be counted, got %s", wait)
+	}
+}
+
+func TestLockWaitIncludesConnectionTracking(t *testing.T) {
+	wrr := NewWeightedRoundRobin()
+	wrr.conns.mu.waited.Store(int64(time.Second))
+	wrr.mu.waited.Store(int64(time.Second))
+
+	var lb LoadBalancer = wrr
+	waiter, ok := lb.(LockWaiter)
+	if !ok {
+		t.Fatal("Expected weighted round robin to report lock wait")
+	}
+	if got := waiter.LockWait(); got != 2*time.Second {
+		t.Errorf("LockWait() = %s, want 2s", got)
+	}
+}
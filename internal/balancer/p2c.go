@@ -0,0 +1,102 @@
+package balancer
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// PowerOfTwoChoices samples two healthy backends at random and picks the one
+// with fewer in-flight requests. Unlike least_connections, concurrent
+// selections that see the same counts rarely all land on one backend.
+type PowerOfTwoChoices struct {
+	conns *LeastConnections // in-flight tracking shared with least_connections, also for max_conns
+
+	randMu sync.Mutex // rand.Rand isn't safe for concurrent selections
+	rand   *rand.Rand
+}
+
+func NewPowerOfTwoChoices() *PowerOfTwoChoices {
+	return NewPowerOfTwoChoicesWithSource(rand.NewSource(time.Now().UnixNano()))
+}
+
+// NewPowerOfTwoChoicesWithSource samples from source, so a fixed seed
+// gives a reproducible sequence of selections
+func NewPowerOfTwoChoicesWithSource(source rand.Source) *PowerOfTwoChoices {
+	return &PowerOfTwoChoices{conns: NewLeastConnections(), rand: rand.New(source)}
+}
+
+func (p *PowerOfTwoChoices) SelectBackend(request *http.Request, backends []config.Backend, healthStatus map[string]bool) (*config.Backend, error) {
+	if len(backends) == 0 {
+		return nil, ErrNoHealthyBackends
+	}
+
+	var healthyBackends []config.Backend
+	for _, backend := range backends {
+		if available(backend, healthStatus) {
+			healthyBackends = append(healthyBackends, backend)
+		}
+	}
+
+	if len(healthyBackends) == 0 {
+		return nil, ErrNoHealthyBackends
+	}
+
+	p.conns.mu.Lock()
+	defer p.conns.mu.Unlock()
+
+	healthyBackends = p.conns.unsaturated(healthyBackends)
+	var selected *config.Backend
+	switch len(healthyBackends) {
+	case 0:
+		return nil, ErrAllBackendsSaturated
+	case 1:
+		selected = &healthyBackends[0]
+	default:
+		i, j := p.pick(len(healthyBackends))
+		selected = &healthyBackends[i]
+		if second := &healthyBackends[j]; p.conns.connections[second.URL] < p.conns.connections[selected.URL] {
+			selected = second
+		}
+	}
+
+	p.conns.reserve(selected.URL)
+	return selected, nil
+}
+
+// two distinct indexes below n
+func (p *PowerOfTwoChoices) pick(n int) (int, int) {
+	p.randMu.Lock()
+	defer p.randMu.Unlock()
+
+	i := p.rand.Intn(n)
+	j := p.rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+	return i, j
+}
+
+func (p *PowerOfTwoChoices) IncrementConnections(backendURL string) {
+	p.conns.IncrementConnections(backendURL)
+}
+
+func (p *PowerOfTwoChoices) DecrementConnections(backendURL string) {
+	p.conns.DecrementConnections(backendURL)
+}
+
+func (p *PowerOfTwoChoices) GetConnections(backendURL string) int64 {
+	return p.conns.GetConnections(backendURL)
+}
+
+// LockWait is how long selections and connection tracking have waited for locks
+func (p *PowerOfTwoChoices) LockWait() time.Duration {
+	return p.conns.LockWait()
+}
+
+func (p *PowerOfTwoChoices) Algorithm() string {
+	return "p2c"
+}
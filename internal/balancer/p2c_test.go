@@ -0,0 +1,177 @@
+package balancer
+
+import (
+	"math/rand"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestPowerOfTwoChoicesPicksLessLoaded(t *testing.T) {
+	lb, err := NewLoadBalancer("p2c")
+	if err != nil {
+		t.Fatalf("NewLoadBalancer() error = %v", err)
+	}
+	p := lb.(*PowerOfTwoChoices)
+
+	backends := []config.Backend{
+		{URL: "http://busy:8080", Weight: 1},
+		{URL: "http://idle:8080", Weight: 1},
+	}
+	for i := 0; i < 5; i++ {
+		p.IncrementConnections("http://busy:8080")
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+
+	// with two backends both are always sampled
+	for i := 0; i < 20; i++ {
+		selected, err := p.SelectBackend(req, backends, map[string]bool{})
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		if selected.URL != "http://idle:8080" {
+			t.Fatalf("Expected the backend with fewer connections, got %s", selected.URL)
+		}
+		p.DecrementConnections(selected.URL)
+	}
+}
+
+func TestPowerOfTwoChoicesSkipsUnavailable(t *testing.T) {
+	p := NewPowerOfTwoChoices()
+	backends := []config.Backend{
+		{URL: "http://down:8080", Weight: 1},
+		{URL: "http://full:8080", Weight: 1, MaxConns: 1},
+		{URL: "http://open:8080", Weight: 1},
+	}
+	p.IncrementConnections("http://full:8080")
+	healthStatus := map[string]bool{"http://down:8080": false}
+	req := httptest.NewRequest("GET", "/", nil)
+
+	for i := 0; i < 20; i++ {
+		selected, err := p.SelectBackend(req, backends, healthStatus)
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		if selected.URL != "http://open:8080" {
+			t.Fatalf("Expected the only available backend, got %s", selected.URL)
+		}
+	}
+
+	if _, err := p.SelectBackend(req, backends[:2], healthStatus); err != ErrAllBackendsSaturated {
+		t.Errorf("Expected ErrAllBackendsSaturated, got %v", err)
+	}
+	if _, err := p.SelectBackend(req, backends[:1], healthStatus); err != ErrNoHealthyBackends {
+		t.Errorf("Expected ErrNoHealthyBackends, got %v", err)
+	}
+}
+
+func TestPowerOfTwoChoicesConcurrentSpread(t *testing.T) {
+	p := NewPowerOfTwoChoices()
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+		{URL: "http://backend3:8080", Weight: 1},
+		{URL: "http://backend4:8080", Weight: 1},
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+
+	const workers, perWorker = 16, 500
+	var mu sync.Mutex
+	counts := make(map[string]int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make(map[string]int)
+			for i := 0; i < perWorker; i++ {
+				selected, err := p.SelectBackend(req, backends, map[string]bool{})
+				if err != nil {
+					t.Errorf("SelectBackend() error = %v", err)
+					return
+				}
+				local[selected.URL]++
+				p.DecrementConnections(selected.URL)
+			}
+
+			mu.Lock()
+			for url, n := range local {
+				counts[url] += n
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// an even spread is 25% each
+	total := workers * perWorker
+	for _, backend := range backends {
+		share := float64(counts[backend.URL]) / float64(total)
+		if share < 0.15 || share > 0.35 {
+			t.Errorf("Expected %s to get a fair share of selections, got %.2f (%v)", backend.URL, share, counts)
+		}
+	}
+}
+
+func TestPowerOfTwoChoicesSeededSequence(t *testing.T) {
+	backends := []config.Backend{
+		{URL: "http://a:8080", Weight: 1},
+		{URL: "http://b:8080", Weight: 1},
+		{URL: "http://c:8080", Weight: 1},
+		{URL: "http://d:8080", Weight: 1},
+	}
+	load := map[string]int{"http://a:8080": 3, "http://b:8080": 0, "http://c:8080": 2, "http://d:8080": 1}
+
+	p := NewPowerOfTwoChoicesWithSource(rand.NewSource(42))
+	for url, n := range load {
+		for i := 0; i < n; i++ {
+			p.IncrementConnections(url)
+		}
+	}
+
+	// replays the same draws to work out which backend each selection must be
+	expected := rand.New(rand.NewSource(42))
+	req := httptest.NewRequest("GET", "/", nil)
+	var sequence []string
+	for n := 0; n < 50; n++ {
+		i := expected.Intn(len(backends))
+		j := expected.Intn(len(backends) - 1)
+		if j >= i {
+			j++
+		}
+		want := backends[i].URL
+		if load[backends[j].URL] < load[want] {
+			want = backends[j].URL
+		}
+
+		selected, err := p.SelectBackend(req, backends, map[string]bool{})
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		if selected.URL != want {
+			t.Fatalf("selection %d = %s, want %s", n, selected.URL, want)
+		}
+		p.DecrementConnections(selected.URL)
+		sequence = append(sequence, selected.URL)
+	}
+
+	again := NewPowerOfTwoChoicesWithSource(rand.NewSource(42))
+	for url, n := range load {
+		for i := 0; i < n; i++ {
+			again.IncrementConnections(url)
+		}
+	}
+	for n, want := range sequence {
+		selected, err := again.SelectBackend(req, backends, map[string]bool{})
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		if selected.URL != want {
+			t.Fatalf("same seed diverged at selection %d: %s, want %s", n, selected.URL, want)
+		}
+		again.DecrementConnections(selected.URL)
+	}
+}
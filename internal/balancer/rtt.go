@@ -0,0 +1,29 @@
+package balancer
+
+import (
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// lowest rtt_hint among the backends, 0 when none has one
+func nearestRTT(backends []config.Backend) time.Duration {
+	var nearest time.Duration
+	for _, backend := range backends {
+		if backend.RTTHint > 0 && (nearest == 0 || backend.RTTHint < nearest) {
+			nearest = backend.RTTHint
+		}
+	}
+	return nearest
+}
+
+// rttFactor scales a backend's weight by the nearest backend's rtt_hint over
+// its own, so traffic is split in proportion to 1/rtt: a backend twice as
+// far away gets half the share, but still some. Backends without a hint are
+// treated as being as close as the nearest.
+func rttFactor(backend config.Backend, nearest time.Duration) float64 {
+	if nearest <= 0 || backend.RTTHint <= 0 {
+		return 1
+	}
+	return float64(nearest) / float64(backend.RTTHint)
+}
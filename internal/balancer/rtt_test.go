@@ -0,0 +1,34 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestWeightedRoundRobinRTTHints(t *testing.T) {
+	backends := []config.Backend{
+		{URL: "http://us-east:8080", Weight: 1, RTTHint: 10 * time.Millisecond},
+		{URL: "http://us-west:8080", Weight: 1, RTTHint: 40 * time.Millisecond},
+		{URL: "http://eu:8080", Weight: 1, RTTHint: 80 * time.Millisecond},
+	}
+
+	wrr := NewWeightedRoundRobin()
+	counts := make(map[string]int)
+	for i := 0; i < 1100; i++ {
+		backend, err := wrr.SelectBackend(nil, backends, map[string]bool{})
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		counts[backend.URL]++
+	}
+
+	// 1/10 : 1/40 : 1/80 is 8 : 2 : 1, so the nearest gets 8 of every 11
+	want := map[string]int{"http://us-east:8080": 800, "http://us-west:8080": 200, "http://eu:8080": 100}
+	for url, n := range want {
+		if counts[url] != n {
+			t.Errorf("Expected %s to get %d requests, got %d (all: %v)", url, n, counts[url], counts)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package balancer
+
+import "time"
+
+// share of its weight a backend gets the moment it recovers
+const slowStartMinFactor = 0.1
+
+// slowStartFactor scales the weight of a backend that became healthy at
+// since: slowStartMinFactor at first, rising linearly to 1 once window has
+// passed. A zero since means the backend never recovered and gets full weight.
+func slowStartFactor(since, now time.Time, window time.Duration) float64 {
+	if since.IsZero() || window <= 0 {
+		return 1
+	}
+
+	elapsed := now.Sub(since)
+	if elapsed >= window {
+		return 1
+	}
+	if elapsed <= 0 {
+		return slowStartMinFactor
+	}
+
+	return slowStartMinFactor + (1-slowStartMinFactor)*float64(elapsed)/float64(window)
+}
@@ -0,0 +1,84 @@
+package balancer
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestSlowStartFactor(t *testing.T) {
+	since := time.Now()
+	window := 10 * time.Second
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want float64
+	}{
+		{"start of window", since, slowStartMinFactor},
+		{"middle of window", since.Add(window / 2), 0.55},
+		{"end of window", since.Add(window), 1},
+		{"after window", since.Add(2 * window), 1},
+		{"clock before recovery", since.Add(-time.Second), slowStartMinFactor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := slowStartFactor(since, tt.now, window)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("slowStartFactor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := slowStartFactor(time.Time{}, since, window); got != 1 {
+		t.Errorf("Expected full weight for a backend that never recovered, got %v", got)
+	}
+	if got := slowStartFactor(since, since, 0); got != 1 {
+		t.Errorf("Expected full weight with slow start disabled, got %v", got)
+	}
+}
+
+func TestWeightedRoundRobinSlowStart(t *testing.T) {
+	backends := []config.Backend{
+		{URL: "http://backend1:8080", Weight: 1},
+		{URL: "http://backend2:8080", Weight: 1},
+	}
+	healthStatus := map[string]bool{}
+	window := time.Hour
+
+	since := map[string]time.Time{}
+	wrr := NewWeightedRoundRobin()
+	wrr.SetSlowStart(func(url string) time.Time { return since[url] }, window)
+
+	share := func() int {
+		counts := make(map[string]int)
+		for i := 0; i < 1000; i++ {
+			backend, err := wrr.SelectBackend(nil, backends, healthStatus)
+			if err != nil {
+				t.Fatalf("SelectBackend() error = %v", err)
+			}
+			counts[backend.URL]++
+		}
+		return counts["http://backend1:8080"]
+	}
+
+	// just recovered: 0.1 against 1
+	since["http://backend1:8080"] = time.Now()
+	if got := share(); got < 85 || got > 95 {
+		t.Errorf("Expected a just-recovered backend to get about 1/11 of requests, got %d/1000", got)
+	}
+
+	// halfway: 0.55 against 1
+	since["http://backend1:8080"] = time.Now().Add(-window / 2)
+	if got := share(); got < 345 || got > 365 {
+		t.Errorf("Expected a half-ramped backend to get about 0.55/1.55 of requests, got %d/1000", got)
+	}
+
+	since["http://backend1:8080"] = time.Now().Add(-window)
+	if got := share(); got != 500 {
+		t.Errorf("Expected an even split once the window has passed, got %d/1000", got)
+	}
+}
@@ -0,0 +1,156 @@
+package canary
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+type State string
+
+const (
+	StateRamping  State = "ramping"
+	StateComplete State = "complete" // reached the target share
+	StateAborted  State = "aborted"  // rolled back to 0 after too many errors
+)
+
+// intervals with fewer canary requests than this hold the current share,
+// too few samples to judge the error rate either way
+const minSamples = 10
+
+// Ramp raises the canary's share of traffic step by step and rolls it back
+// when the canary's error rate over the last interval crosses the threshold
+type Ramp struct {
+	mu      sync.Mutex
+	backend string
+	config  config.CanaryRampConfig
+	percent float64
+	state   State
+
+	// results since the last step
+	requests int
+	failures int
+
+	isOpen func() bool // reports the canary's circuit as open, optional
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func New(cfg *config.CanaryConfig) *Ramp {
+	return &Ramp{
+		backend: cfg.Backend,
+		config:  cfg.Ramp,
+		percent: cfg.Ramp.Start,
+		state:   StateRamping,
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// SetBreakerCheck aborts the ramp as soon as the canary's circuit is open
+func (r *Ramp) SetBreakerCheck(isOpen func() bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.isOpen = isOpen
+}
+
+func (r *Ramp) Backend() string {
+	return r.backend
+}
+
+// Percent is the share of traffic the canary should get right now
+func (r *Ramp) Percent() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.percent
+}
+
+func (r *Ramp) State() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// Record counts the outcome of one request served by the canary
+func (r *Ramp) Record(success bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests++
+	if !success {
+		r.failures++
+	}
+}
+
+func (r *Ramp) Start() {
+	go func() {
+		ticker := time.NewTicker(r.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stopCh:
+				return
+			case <-ticker.C:
+				if r.step() != StateRamping {
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (r *Ramp) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+}
+
+// evaluates the interval that just ended and moves the share accordingly
+func (r *Ramp) step() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.state != StateRamping {
+		return r.state
+	}
+
+	requests, failures := r.requests, r.failures
+	r.requests, r.failures = 0, 0
+
+	if r.isOpen != nil && r.isOpen() {
+		r.abort("circuit breaker open")
+		return r.state
+	}
+
+	if requests < minSamples {
+		log.Printf("Canary %s holding at %.1f%%: %d requests in the last interval", r.backend, r.percent, requests)
+		return r.state
+	}
+
+	if rate := float64(failures) / float64(requests); rate > r.config.AbortErrorRate {
+		r.abort(fmt.Sprintf("error rate %.1f%%", rate*100))
+		return r.state
+	}
+
+	r.percent = math.Min(r.percent+r.config.Step, r.config.Target)
+	if r.percent >= r.config.Target {
+		r.state = StateComplete
+		log.Printf("Canary %s reached its target of %.1f%%", r.backend, r.percent)
+	} else {
+		log.Printf("Canary %s ramped to %.1f%%", r.backend, r.percent)
+	}
+
+	return r.state
+}
+
+// caller must hold r.mu
+func (r *Ramp) abort(reason string) {
+	r.percent = 0
+	r.state = StateAborted
+	log.Printf("Warning: canary %s rolled back (%s)", r.backend, reason)
+}
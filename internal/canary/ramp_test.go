@@ -0,0 +1,131 @@
+package canary
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func newTestRamp() *Ramp {
+	return New(&config.CanaryConfig{
+		Enabled: true,
+		Backend: "http://canary:8080",
+		Ramp: config.CanaryRampConfig{
+			Start:          10,
+			Step:           30,
+			Interval:       time.Minute,
+			Target:         50,
+			AbortErrorRate: 0.1,
+		},
+	})
+}
+
+func recordResults(r *Ramp, successes, failures int) {
+	for i := 0; i < successes; i++ {
+		r.Record(true)
+	}
+	for i := 0; i < failures; i++ {
+		r.Record(false)
+	}
+}
+
+func TestRampReachesTarget(t *testing.T) {
+	r := newTestRamp()
+
+	if r.Percent() != 10 {
+		t.Fatalf("Expected ramp to start at 10%%, got %v", r.Percent())
+	}
+
+	recordResults(r, 20, 1)
+	if state := r.step(); state != StateRamping {
+		t.Errorf("Expected ramping after first step, got %s", state)
+	}
+	if r.Percent() != 40 {
+		t.Errorf("Expected 40%% after first step, got %v", r.Percent())
+	}
+
+	// the last step is capped at the target
+	recordResults(r, 20, 0)
+	if state := r.step(); state != StateComplete {
+		t.Errorf("Expected complete at the target, got %s", state)
+	}
+	if r.Percent() != 50 {
+		t.Errorf("Expected 50%% at the target, got %v", r.Percent())
+	}
+
+	recordResults(r, 20, 0)
+	r.step()
+	if r.Percent() != 50 {
+		t.Errorf("Expected a completed ramp to stay at its target, got %v", r.Percent())
+	}
+}
+
+func TestRampAbortsOnErrorRate(t *testing.T) {
+	r := newTestRamp()
+
+	recordResults(r, 20, 0)
+	r.step()
+
+	recordResults(r, 15, 5)
+	if state := r.step(); state != StateAborted {
+		t.Fatalf("Expected abort on a 25%% error rate, got %s", state)
+	}
+	if r.Percent() != 0 {
+		t.Errorf("Expected an aborted canary to get no traffic, got %v%%", r.Percent())
+	}
+
+	recordResults(r, 20, 0)
+	r.step()
+	if r.State() != StateAborted || r.Percent() != 0 {
+		t.Errorf("Expected an aborted ramp to stay rolled back, got %s at %v%%", r.State(), r.Percent())
+	}
+}
+
+func TestRampHoldsWithFewSamples(t *testing.T) {
+	r := newTestRamp()
+
+	recordResults(r, 2, 1)
+	if state := r.step(); state != StateRamping {
+		t.Errorf("Expected ramping, got %s", state)
+	}
+	if r.Percent() != 10 {
+		t.Errorf("Expected ramp to hold at 10%% without enough samples, got %v", r.Percent())
+	}
+}
+
+func TestRampAbortsOnOpenBreaker(t *testing.T) {
+	r := newTestRamp()
+	r.SetBreakerCheck(func() bool { return true })
+
+	recordResults(r, 20, 0)
+	if state := r.step(); state != StateAborted {
+		t.Errorf("Expected abort while the canary's circuit is open, got %s", state)
+	}
+}
+
+func TestRampControllerLoop(t *testing.T) {
+	r := New(&config.CanaryConfig{
+		Enabled: true,
+		Backend: "http://canary:8080",
+		Ramp: config.CanaryRampConfig{
+			Start:          50,
+			Step:           50,
+			Interval:       20 * time.Millisecond,
+			Target:         100,
+			AbortErrorRate: 0.1,
+		},
+	})
+	recordResults(r, minSamples, 0)
+
+	r.Start()
+	defer r.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for r.State() != StateComplete {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected controller to complete the ramp, stuck at %v%%", r.Percent())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -10,20 +10,51 @@ import (
 type State string
 
 const (
-	StateClosed State = "closed"
-	StateOpen   State = "open"
+	StateClosed   State = "closed"
+	StateOpen     State = "open"
+	StateHalfOpen State = "half_open" // timeout elapsed, a few probe requests decide
+
+	// set by an operator and held until cleared, regardless of failures
+	StateForcedOpen   State = "forced_open"
+	StateForcedClosed State = "forced_closed"
 )
 
 type backendState struct {
 	state               State
+	forced              State // empty unless an operator override is active
 	consecutiveFailures int
 	lastFailureTime     time.Time
+	lastProbeTime       time.Time
+
+	// half-open bookkeeping
+	probesInFlight int
+	probeSuccesses int
+
+	window *rollingWindow // error_rate mode only, outcomes while closed
 }
 
 type CircuitBreaker struct {
 	config   config.CircuitBreakerConfig
 	mu       sync.RWMutex
 	backends map[string]*backendState
+	listener StateListener
+}
+
+// StateListener is told whenever a backend's reported state changes; it runs
+// outside the breaker's lock
+type StateListener func(backendURL string, from, to State)
+
+// a state change to report once the lock is released
+type transition struct {
+	from, to State
+}
+
+// effective reports the state GetState would return
+func (s *backendState) effective() State {
+	if s.forced != "" {
+		return s.forced
+	}
+	return s.state
 }
 
 func New(cfg config.CircuitBreakerConfig) *CircuitBreaker {
@@ -33,49 +64,179 @@ func New(cfg config.CircuitBreakerConfig) *CircuitBreaker {
 	}
 }
 
-func (cb *CircuitBreaker) CanAttempt(backendURL string) bool {
-	if !cb.config.Enabled {
-		return true
+// SetStateListener registers a listener for state changes, call before use
+func (cb *CircuitBreaker) SetStateListener(listener StateListener) {
+	cb.listener = listener
+}
+
+func (cb *CircuitBreaker) notify(backendURL string, t transition) {
+	if cb.listener != nil && t.from != t.to {
+		cb.listener(backendURL, t.from, t.to)
 	}
+}
 
+func (cb *CircuitBreaker) CanAttempt(backendURL string) bool {
 	cb.mu.RLock()
 	state, exists := cb.backends[backendURL]
+	var forced State
+	if exists {
+		forced = state.forced
+	}
 	cb.mu.RUnlock()
 
 	if !exists {
 		return true
 	}
 
+	// overrides apply even with the automatic breaker disabled
+	switch forced {
+	case StateForcedOpen:
+		return false
+	case StateForcedClosed:
+		return true
+	}
+
+	if !cb.config.Enabled {
+		return true
+	}
+
+	var t transition
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	defer func() {
+		cb.mu.Unlock()
+		cb.notify(backendURL, t)
+	}()
 
 	if state.state == StateOpen {
-		if time.Since(state.lastFailureTime) >= cb.config.Timeout {
-			state.state = StateClosed
-			state.consecutiveFailures = 0
-			return true
+		// let a single live request through every probe interval so real
+		// traffic can close the circuit before the timeout elapses; it
+		// recovers through half-open like a probe after the timeout, so the
+		// success threshold and probe cap apply to both
+		probe := cb.probeDue(state)
+		if !probe && time.Since(state.lastFailureTime) < cb.config.Timeout {
+			return false
 		}
-		return false
+
+		t.from = state.effective()
+		state.state = StateHalfOpen
+		state.probesInFlight = 1
+		state.probeSuccesses = 0
+		if probe {
+			state.lastProbeTime = time.Now()
+		}
+		t.to = state.effective()
+		return true
+	}
+
+	if state.state == StateHalfOpen {
+		if state.probesInFlight >= cb.halfOpenMaxRequests() {
+			return false
+		}
+		state.probesInFlight++
 	}
 
 	return true
 }
 
+// whether open_behavior probe lets a request through an open circuit now;
+// caller must hold cb.mu
+func (cb *CircuitBreaker) probeDue(state *backendState) bool {
+	return cb.config.OpenBehavior == "probe" &&
+		time.Since(state.lastFailureTime) >= cb.config.ProbeInterval &&
+		time.Since(state.lastProbeTime) >= cb.config.ProbeInterval
+}
+
+func (cb *CircuitBreaker) halfOpenMaxRequests() int {
+	if cb.config.HalfOpenMaxRequests <= 0 {
+		return 1
+	}
+	return cb.config.HalfOpenMaxRequests
+}
+
+func (cb *CircuitBreaker) halfOpenSuccessThreshold() int {
+	if cb.config.HalfOpenSuccessThreshold <= 0 {
+		return 1
+	}
+	return cb.config.HalfOpenSuccessThreshold
+}
+
+func (cb *CircuitBreaker) errorRateMode() bool {
+	return cb.config.Mode == config.CircuitBreakerErrorRate
+}
+
+// records an outcome while closed in error_rate mode; caller must hold cb.mu
+func (cb *CircuitBreaker) observe(state *backendState, failed bool) {
+	if !cb.errorRateMode() || state.state != StateClosed {
+		return
+	}
+	if state.window == nil {
+		state.window = newRollingWindow(cb.config.RollingWindow)
+	}
+	state.window.add(time.Now(), failed)
+}
+
+// whether a closed backend's failures should open its circuit; caller must hold cb.mu
+func (cb *CircuitBreaker) shouldTrip(state *backendState) bool {
+	if !cb.errorRateMode() {
+		return state.consecutiveFailures >= cb.config.FailureThreshold
+	}
+	if state.window == nil {
+		return false
+	}
+
+	total, failed := state.window.counts(time.Now())
+	if total == 0 || total < cb.config.MinRequests {
+		return false
+	}
+	return float64(failed)*100 > cb.config.ErrorRateThreshold*float64(total)
+}
+
 func (cb *CircuitBreaker) RecordSuccess(backendURL string) {
 	if !cb.config.Enabled {
 		return
 	}
 
+	var t transition
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	defer func() {
+		cb.mu.Unlock()
+		cb.notify(backendURL, t)
+	}()
 
 	state, exists := cb.backends[backendURL]
 	if !exists {
-		return
+		// the error rate needs successes too, not just failures
+		if !cb.errorRateMode() {
+			return
+		}
+		state = &backendState{state: StateClosed}
+		cb.backends[backendURL] = state
 	}
 
 	state.consecutiveFailures = 0
+	cb.observe(state, false)
+
+	// a recovering backend has to prove itself over several probes
+	if state.state == StateHalfOpen {
+		if state.probesInFlight > 0 {
+			state.probesInFlight--
+		}
+		state.probeSuccesses++
+		if state.probeSuccesses < cb.halfOpenSuccessThreshold() {
+			return
+		}
+	}
+
+	if state.state != StateClosed && state.window != nil {
+		// judge the recovered backend on what it does from here on
+		state.window.reset()
+	}
+
+	t.from = state.effective()
 	state.state = StateClosed
+	state.probesInFlight = 0
+	state.probeSuccesses = 0
+	t.to = state.effective()
 }
 
 func (cb *CircuitBreaker) RecordFailure(backendURL string) {
@@ -83,8 +244,12 @@ func (cb *CircuitBreaker) RecordFailure(backendURL string) {
 		return
 	}
 
+	var t transition
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	defer func() {
+		cb.mu.Unlock()
+		cb.notify(backendURL, t)
+	}()
 
 	state, exists := cb.backends[backendURL]
 	if !exists {
@@ -97,9 +262,41 @@ func (cb *CircuitBreaker) RecordFailure(backendURL string) {
 
 	state.consecutiveFailures++
 	state.lastFailureTime = time.Now()
+	cb.observe(state, true)
+
+	// forced-closed keeps counting for observability but never trips
+	if state.forced == StateForcedClosed {
+		return
+	}
 
-	if state.consecutiveFailures >= cb.config.FailureThreshold {
+	// any failed probe reopens the circuit for another full timeout
+	if state.state == StateHalfOpen || (state.state == StateClosed && cb.shouldTrip(state)) {
+		t.from = state.effective()
 		state.state = StateOpen
+		state.probesInFlight = 0
+		state.probeSuccesses = 0
+		t.to = state.effective()
+	}
+}
+
+// RecordAbandoned releases an attempt that ended without a verdict on the
+// backend, such as the client disconnecting, so a half-open probe slot is
+// not held forever
+func (cb *CircuitBreaker) RecordAbandoned(backendURL string) {
+	if !cb.config.Enabled {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	state, exists := cb.backends[backendURL]
+	if !exists {
+		return
+	}
+
+	if state.state == StateHalfOpen && state.probesInFlight > 0 {
+		state.probesInFlight--
 	}
 }
 
@@ -112,18 +309,111 @@ func (cb *CircuitBreaker) GetState(backendURL string) State {
 		return StateClosed
 	}
 
-	return state.state
+	return state.effective()
+}
+
+// IsOpen reports whether requests to the backend are being turned away:
+// forced open, open with neither its timeout passed nor a probe due, or
+// half-open with every probe slot taken. Unlike CanAttempt it never moves
+// the circuit to half-open.
+func (cb *CircuitBreaker) IsOpen(backendURL string) bool {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	state, exists := cb.backends[backendURL]
+	if !exists {
+		return false
+	}
+
+	switch state.forced {
+	case StateForcedOpen:
+		return true
+	case StateForcedClosed:
+		return false
+	}
+
+	if !cb.config.Enabled {
+		return false
+	}
+	switch state.state {
+	case StateOpen:
+		return time.Since(state.lastFailureTime) < cb.config.Timeout && !cb.probeDue(state)
+	case StateHalfOpen:
+		return state.probesInFlight >= cb.halfOpenMaxRequests()
+	}
+	return false
+}
+
+// GetFailures returns the current consecutive failure count for a backend
+func (cb *CircuitBreaker) GetFailures(backendURL string) int {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	state, exists := cb.backends[backendURL]
+	if !exists {
+		return 0
+	}
+
+	return state.consecutiveFailures
+}
+
+// ForceOpen rejects all requests to the backend until the override is cleared
+func (cb *CircuitBreaker) ForceOpen(backendURL string) {
+	cb.setForced(backendURL, StateForcedOpen)
+}
+
+// ForceClosed allows all requests to the backend until the override is cleared
+func (cb *CircuitBreaker) ForceClosed(backendURL string) {
+	cb.setForced(backendURL, StateForcedClosed)
+}
+
+// ClearForce hands the backend back to the automatic state machine
+func (cb *CircuitBreaker) ClearForce(backendURL string) {
+	cb.setForced(backendURL, "")
+}
+
+func (cb *CircuitBreaker) setForced(backendURL string, forced State) {
+	var t transition
+	cb.mu.Lock()
+	defer func() {
+		cb.mu.Unlock()
+		cb.notify(backendURL, t)
+	}()
+
+	state, exists := cb.backends[backendURL]
+	if !exists {
+		if forced == "" {
+			return
+		}
+		state = &backendState{state: StateClosed}
+		cb.backends[backendURL] = state
+	}
+
+	t.from = state.effective()
+	state.forced = forced
+	t.to = state.effective()
 }
 
 func (cb *CircuitBreaker) Reset(backendURL string) {
+	var t transition
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	defer func() {
+		cb.mu.Unlock()
+		cb.notify(backendURL, t)
+	}()
 
 	state, exists := cb.backends[backendURL]
 	if !exists {
 		return
 	}
 
+	t.from = state.effective()
 	state.state = StateClosed
 	state.consecutiveFailures = 0
+	state.probesInFlight = 0
+	state.probeSuccesses = 0
+	if state.window != nil {
+		state.window.reset()
+	}
+	t.to = state.effective()
 }
@@ -100,6 +100,101 @@ func TestCircuitBreakerClosesAfterTimeout(t *testing.T) {
 	}
 }
 
+func TestCircuitBreakerIsOpen(t *testing.T) {
+	cb := New(config.CircuitBreakerConfig{
+		Enabled:          true,
+		FailureThreshold: 1,
+		Timeout:          50 * time.Millisecond,
+	})
+	backend := "http://test.com"
+
+	if cb.IsOpen(backend) {
+		t.Error("Unknown backend should not be open")
+	}
+
+	cb.RecordFailure(backend)
+	if !cb.IsOpen(backend) {
+		t.Error("Circuit should be open after threshold failures")
+	}
+
+	// past the timeout a probe is due, so requests are no longer turned away
+	time.Sleep(75 * time.Millisecond)
+	if cb.IsOpen(backend) {
+		t.Error("Circuit should admit a probe once the timeout has passed")
+	}
+	if cb.GetState(backend) != StateOpen {
+		t.Error("IsOpen() should not move the circuit to half-open")
+	}
+
+	cb.ForceOpen(backend)
+	if !cb.IsOpen(backend) {
+		t.Error("Forced open circuit should report open")
+	}
+}
+
+func TestCircuitBreakerIsOpenProbeDue(t *testing.T) {
+	cb := New(config.CircuitBreakerConfig{
+		Enabled:          true,
+		FailureThreshold: 1,
+		Timeout:          10 * time.Second,
+		OpenBehavior:     "probe",
+		ProbeInterval:    50 * time.Millisecond,
+	})
+	backend := "http://test.com"
+
+	cb.RecordFailure(backend)
+	if !cb.IsOpen(backend) {
+		t.Error("Circuit should be open before the probe interval elapses")
+	}
+
+	// hashed balancers skip backends IsOpen reports, so a due probe must
+	// show the backend as available for it to get any traffic
+	time.Sleep(75 * time.Millisecond)
+	if cb.IsOpen(backend) {
+		t.Error("Circuit should admit a probe once the probe interval has passed")
+	}
+
+	if !cb.CanAttempt(backend) {
+		t.Fatal("Expected the probe to be let through")
+	}
+	if !cb.IsOpen(backend) {
+		t.Error("Circuit should report open while its only probe is in flight")
+	}
+}
+
+func TestCircuitBreakerIsOpenHalfOpenProbesTaken(t *testing.T) {
+	cb := New(config.CircuitBreakerConfig{
+		Enabled:             true,
+		FailureThreshold:    1,
+		Timeout:             50 * time.Millisecond,
+		HalfOpenMaxRequests: 2,
+	})
+	backend := "http://test.com"
+
+	cb.RecordFailure(backend)
+	time.Sleep(75 * time.Millisecond)
+
+	// the first attempt moves the circuit to half-open and takes a slot
+	if !cb.CanAttempt(backend) {
+		t.Fatal("Circuit should admit a probe once the timeout has passed")
+	}
+	if cb.IsOpen(backend) {
+		t.Error("Half-open circuit with a free probe slot should not report open")
+	}
+
+	if !cb.CanAttempt(backend) {
+		t.Fatal("Circuit should admit a second probe")
+	}
+	if !cb.IsOpen(backend) {
+		t.Error("Half-open circuit with every probe slot taken should report open")
+	}
+
+	cb.RecordAbandoned(backend)
+	if cb.IsOpen(backend) {
+		t.Error("Released probe slot should make the circuit available again")
+	}
+}
+
 func TestCircuitBreakerMultipleBackends(t *testing.T) {
 	cfg := config.CircuitBreakerConfig{
 		Enabled:          true,
@@ -192,3 +287,481 @@ func TestCircuitBreakerSuccessResetsFailureCount(t *testing.T) {
 		t.Error("Circuit should be open after threshold consecutive failures")
 	}
 }
+
+func TestCircuitBreakerProbeThroughOpenCircuit(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:          true,
+		FailureThreshold: 2,
+		Timeout:          10 * time.Second,
+		OpenBehavior:     "probe",
+		ProbeInterval:    100 * time.Millisecond,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	cb.RecordFailure(backend)
+	cb.RecordFailure(backend)
+
+	if cb.CanAttempt(backend) {
+		t.Error("Circuit should be open before the probe interval elapses")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if !cb.CanAttempt(backend) {
+		t.Error("A single probe should be let through after the probe interval")
+	}
+
+	if cb.CanAttempt(backend) {
+		t.Error("Only one probe should be let through per probe interval")
+	}
+
+	cb.RecordSuccess(backend)
+
+	if state := cb.GetState(backend); state != StateClosed {
+		t.Errorf("Expected state %s after successful probe, got %s", StateClosed, state)
+	}
+}
+
+func TestCircuitBreakerProbeNeedsSuccessThreshold(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:                  true,
+		FailureThreshold:         1,
+		Timeout:                  10 * time.Second,
+		OpenBehavior:             "probe",
+		ProbeInterval:            50 * time.Millisecond,
+		HalfOpenMaxRequests:      1,
+		HalfOpenSuccessThreshold: 2,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	cb.RecordFailure(backend)
+	time.Sleep(75 * time.Millisecond)
+
+	if !cb.CanAttempt(backend) {
+		t.Fatal("A probe should be let through after the probe interval")
+	}
+	if state := cb.GetState(backend); state != StateHalfOpen {
+		t.Errorf("Expected a probe to move the circuit to %s, got %s", StateHalfOpen, state)
+	}
+	if cb.CanAttempt(backend) {
+		t.Error("The probe should hold the only half-open slot")
+	}
+
+	cb.RecordSuccess(backend)
+	if state := cb.GetState(backend); state != StateHalfOpen {
+		t.Errorf("One success of two should leave the circuit %s, got %s", StateHalfOpen, state)
+	}
+
+	if !cb.CanAttempt(backend) {
+		t.Fatal("Expected a second probe once the first completed")
+	}
+	cb.RecordSuccess(backend)
+	if state := cb.GetState(backend); state != StateClosed {
+		t.Errorf("Expected state %s after two successful probes, got %s", StateClosed, state)
+	}
+}
+
+func TestCircuitBreakerFastFailDoesNotProbe(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:          true,
+		FailureThreshold: 2,
+		Timeout:          10 * time.Second,
+		OpenBehavior:     "fast_fail",
+		ProbeInterval:    50 * time.Millisecond,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	cb.RecordFailure(backend)
+	cb.RecordFailure(backend)
+
+	time.Sleep(100 * time.Millisecond)
+
+	if cb.CanAttempt(backend) {
+		t.Error("Fast fail should not let requests through an open circuit")
+	}
+}
+
+func TestCircuitBreakerForcedOpenRejectsAll(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:          true,
+		FailureThreshold: 3,
+		Timeout:          10 * time.Millisecond,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	cb.ForceOpen(backend)
+
+	if state := cb.GetState(backend); state != StateForcedOpen {
+		t.Errorf("Expected state %s, got %s", StateForcedOpen, state)
+	}
+
+	cb.RecordSuccess(backend)
+	time.Sleep(20 * time.Millisecond)
+
+	if cb.CanAttempt(backend) {
+		t.Error("Forced-open circuit should reject requests regardless of successes or timeout")
+	}
+
+	cb.ClearForce(backend)
+
+	if !cb.CanAttempt(backend) {
+		t.Error("Circuit should accept requests once the override is cleared")
+	}
+	if state := cb.GetState(backend); state != StateClosed {
+		t.Errorf("Expected state %s after clearing, got %s", StateClosed, state)
+	}
+}
+
+func TestCircuitBreakerForcedOpenWhenDisabled(t *testing.T) {
+	cb := New(config.CircuitBreakerConfig{Enabled: false})
+	backend := "http://test.com"
+
+	cb.ForceOpen(backend)
+
+	if cb.CanAttempt(backend) {
+		t.Error("Forced-open circuit should reject requests even when the breaker is disabled")
+	}
+}
+
+func TestCircuitBreakerForcedClosedIgnoresThreshold(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:          true,
+		FailureThreshold: 2,
+		Timeout:          10 * time.Second,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	cb.ForceClosed(backend)
+
+	for i := 0; i < 5; i++ {
+		cb.RecordFailure(backend)
+	}
+
+	if !cb.CanAttempt(backend) {
+		t.Error("Forced-closed circuit should never open")
+	}
+	if state := cb.GetState(backend); state != StateForcedClosed {
+		t.Errorf("Expected state %s, got %s", StateForcedClosed, state)
+	}
+	if failures := cb.GetFailures(backend); failures != 5 {
+		t.Errorf("Expected 5 failures to be counted, got %d", failures)
+	}
+
+	// once the override is cleared the next failure trips the circuit
+	cb.ClearForce(backend)
+	cb.RecordFailure(backend)
+
+	if cb.CanAttempt(backend) {
+		t.Error("Circuit should open once the override is cleared and failures continue")
+	}
+}
+
+func TestCircuitBreakerHalfOpenLimitsProbes(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:             true,
+		FailureThreshold:    1,
+		Timeout:             20 * time.Millisecond,
+		HalfOpenMaxRequests: 2,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	cb.RecordFailure(backend)
+	time.Sleep(30 * time.Millisecond)
+
+	if !cb.CanAttempt(backend) || !cb.CanAttempt(backend) {
+		t.Fatal("Expected two probes through the half-open circuit")
+	}
+	if state := cb.GetState(backend); state != StateHalfOpen {
+		t.Errorf("Expected state %s, got %s", StateHalfOpen, state)
+	}
+	if cb.CanAttempt(backend) {
+		t.Error("Expected a third request to be rejected while probes are in flight")
+	}
+
+	// a finished probe frees its slot
+	cb.RecordSuccess(backend)
+	if !cb.CanAttempt(backend) {
+		t.Error("Expected a new probe once one completed")
+	}
+}
+
+func TestCircuitBreakerHalfOpenClosesAfterSuccesses(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:                  true,
+		FailureThreshold:         1,
+		Timeout:                  20 * time.Millisecond,
+		HalfOpenMaxRequests:      1,
+		HalfOpenSuccessThreshold: 3,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	cb.RecordFailure(backend)
+	time.Sleep(30 * time.Millisecond)
+
+	for i := 1; i <= 3; i++ {
+		if !cb.CanAttempt(backend) {
+			t.Fatalf("Expected probe %d to be allowed", i)
+		}
+		cb.RecordSuccess(backend)
+
+		want := StateHalfOpen
+		if i == 3 {
+			want = StateClosed
+		}
+		if state := cb.GetState(backend); state != want {
+			t.Errorf("After %d successes expected state %s, got %s", i, want, state)
+		}
+	}
+
+	if !cb.CanAttempt(backend) || !cb.CanAttempt(backend) {
+		t.Error("Closed circuit should not limit requests")
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:                  true,
+		FailureThreshold:         3,
+		Timeout:                  20 * time.Millisecond,
+		HalfOpenSuccessThreshold: 2,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	for i := 0; i < 3; i++ {
+		cb.RecordFailure(backend)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	if !cb.CanAttempt(backend) {
+		t.Fatal("Expected a probe after the timeout")
+	}
+	cb.RecordSuccess(backend)
+	if !cb.CanAttempt(backend) {
+		t.Fatal("Expected a second probe")
+	}
+
+	// one failure is enough, regardless of the failure threshold
+	cb.RecordFailure(backend)
+
+	if state := cb.GetState(backend); state != StateOpen {
+		t.Errorf("Expected state %s after a failed probe, got %s", StateOpen, state)
+	}
+	if cb.CanAttempt(backend) {
+		t.Error("Reopened circuit should reject requests until the next timeout")
+	}
+}
+
+func TestCircuitBreakerAbandonedProbeFreesSlot(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:                  true,
+		FailureThreshold:         1,
+		Timeout:                  20 * time.Millisecond,
+		HalfOpenSuccessThreshold: 2,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	cb.RecordFailure(backend)
+	time.Sleep(30 * time.Millisecond)
+
+	if !cb.CanAttempt(backend) {
+		t.Fatal("Expected a probe after the timeout")
+	}
+	if cb.CanAttempt(backend) {
+		t.Fatal("Expected the only probe slot to be taken")
+	}
+
+	cb.RecordAbandoned(backend)
+
+	if state := cb.GetState(backend); state != StateHalfOpen {
+		t.Errorf("An abandoned probe should not change the state, got %s", state)
+	}
+	if failures := cb.GetFailures(backend); failures != 1 {
+		t.Errorf("An abandoned probe should not count as a failure, got %d failures", failures)
+	}
+	if !cb.CanAttempt(backend) {
+		t.Error("Expected the abandoned probe's slot to be free again")
+	}
+}
+
+func TestCircuitBreakerStateListener(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:          true,
+		FailureThreshold: 2,
+		Timeout:          20 * time.Millisecond,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	var changes []string
+	cb.SetStateListener(func(backendURL string, from, to State) {
+		// the lock is released, so the listener may query the breaker
+		if got := cb.GetState(backendURL); got != to {
+			t.Errorf("Listener saw state %s, expected %s", got, to)
+		}
+		changes = append(changes, string(from)+"->"+string(to))
+	})
+
+	cb.RecordFailure(backend)
+	cb.RecordFailure(backend)
+	cb.RecordFailure(backend) // already open, no change
+	time.Sleep(30 * time.Millisecond)
+	cb.CanAttempt(backend)
+	cb.RecordSuccess(backend)
+	cb.ForceOpen(backend)
+	cb.ClearForce(backend)
+
+	expected := []string{
+		"closed->open",
+		"open->half_open",
+		"half_open->closed",
+		"closed->forced_open",
+		"forced_open->closed",
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected changes %v, got %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Change %d: expected %s, got %s", i, expected[i], changes[i])
+		}
+	}
+}
+
+func TestCircuitBreakerErrorRateOpensOnRatio(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:            true,
+		FailureThreshold:   3,
+		Timeout:            time.Minute,
+		Mode:               config.CircuitBreakerErrorRate,
+		ErrorRateThreshold: 30,
+		RollingWindow:      time.Minute,
+		MinRequests:        10,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	// intermittent failures, never more than one in a row
+	for i := 0; i < 9; i++ {
+		if i%5 == 0 || i%5 == 2 {
+			cb.RecordFailure(backend)
+		} else {
+			cb.RecordSuccess(backend)
+		}
+	}
+	if !cb.CanAttempt(backend) {
+		t.Fatal("Circuit should stay closed below min_requests")
+	}
+
+	cb.RecordFailure(backend)
+	if cb.GetState(backend) != StateOpen {
+		t.Errorf("Expected circuit open at 50%% errors over 10 requests, got %s", cb.GetState(backend))
+	}
+}
+
+func TestCircuitBreakerErrorRateIgnoresConsecutiveFailures(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:            true,
+		FailureThreshold:   3,
+		Timeout:            time.Minute,
+		Mode:               config.CircuitBreakerErrorRate,
+		ErrorRateThreshold: 50,
+		RollingWindow:      time.Minute,
+		MinRequests:        10,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	for i := 0; i < 20; i++ {
+		cb.RecordSuccess(backend)
+	}
+	for i := 0; i < 5; i++ {
+		cb.RecordFailure(backend)
+	}
+
+	// 5 in a row, but only 20% of the window
+	if cb.GetState(backend) != StateClosed {
+		t.Errorf("Expected circuit closed at 20%% errors, got %s", cb.GetState(backend))
+	}
+}
+
+func TestCircuitBreakerErrorRateWindowExpires(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:            true,
+		Timeout:            time.Minute,
+		Mode:               config.CircuitBreakerErrorRate,
+		ErrorRateThreshold: 50,
+		RollingWindow:      100 * time.Millisecond,
+		MinRequests:        4,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	cb.RecordFailure(backend)
+	cb.RecordFailure(backend)
+	cb.RecordFailure(backend)
+	time.Sleep(150 * time.Millisecond)
+
+	// the old failures have left the window, so this is 1 of 1
+	cb.RecordFailure(backend)
+	if cb.GetState(backend) != StateClosed {
+		t.Errorf("Expected failures outside the window not to count, got %s", cb.GetState(backend))
+	}
+}
+
+func TestCircuitBreakerErrorRateRecovery(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Enabled:                  true,
+		Timeout:                  50 * time.Millisecond,
+		HalfOpenSuccessThreshold: 1,
+		Mode:                     config.CircuitBreakerErrorRate,
+		ErrorRateThreshold:       50,
+		RollingWindow:            time.Minute,
+		MinRequests:              2,
+	}
+
+	cb := New(cfg)
+	backend := "http://test.com"
+
+	cb.RecordFailure(backend)
+	cb.RecordFailure(backend)
+	if cb.GetState(backend) != StateOpen {
+		t.Fatalf("Expected circuit open, got %s", cb.GetState(backend))
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !cb.CanAttempt(backend) {
+		t.Fatal("Expected a probe after the timeout")
+	}
+	cb.RecordSuccess(backend)
+	if cb.GetState(backend) != StateClosed {
+		t.Fatalf("Expected circuit closed after a successful probe, got %s", cb.GetState(backend))
+	}
+
+	// the failures that opened it no longer count
+	cb.RecordFailure(backend)
+	if cb.GetState(backend) != StateClosed {
+		t.Errorf("Expected a fresh window after recovery, got %s", cb.GetState(backend))
+	}
+}
@@ -0,0 +1,56 @@
+package circuitbreaker
+
+import "time"
+
+// buckets a rolling window is split into; older requests drop out one
+// bucket at a time
+const windowBuckets = 10
+
+// request outcomes over the last span, counted in buckets of span/windowBuckets
+type rollingWindow struct {
+	width   time.Duration
+	buckets [windowBuckets]windowBucket
+}
+
+type windowBucket struct {
+	epoch  int64 // which bucket width interval since the unix epoch the counts belong to
+	total  int
+	failed int
+}
+
+func newRollingWindow(span time.Duration) *rollingWindow {
+	width := span / windowBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return &rollingWindow{width: width}
+}
+
+func (w *rollingWindow) add(now time.Time, failed bool) {
+	epoch := now.UnixNano() / int64(w.width)
+	b := &w.buckets[epoch%windowBuckets]
+	if b.epoch != epoch {
+		*b = windowBucket{epoch: epoch}
+	}
+
+	b.total++
+	if failed {
+		b.failed++
+	}
+}
+
+// requests and failures recorded within the window ending at now
+func (w *rollingWindow) counts(now time.Time) (total, failed int) {
+	epoch := now.UnixNano() / int64(w.width)
+	for _, b := range w.buckets {
+		if epoch-b.epoch < windowBuckets {
+			total += b.total
+			failed += b.failed
+		}
+	}
+	return total, failed
+}
+
+func (w *rollingWindow) reset() {
+	w.buckets = [windowBuckets]windowBucket{}
+}
@@ -1,10 +1,25 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -16,11 +31,20 @@ type Config struct {
 	Service        string               `yaml:"service" json:"service"`
 	Server         ServerConfig         `yaml:"server" json:"server"`
 	Upstreams      []Upstream           `yaml:"upstreams" json:"upstreams"`
+	Routes         []Route              `yaml:"routes,omitempty" json:"routes,omitempty"` // checked before upstream match rules
 	Health         HealthConfig         `yaml:"health" json:"health"`
 	Metrics        MetricsConfig        `yaml:"metrics" json:"metrics"`
 	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker" json:"circuit_breaker"`
 	Retry          RetryConfig          `yaml:"retry" json:"retry"`
 	TLS            TLSConfig            `yaml:"tls" json:"tls"`
+	Transport      TransportConfig      `yaml:"transport" json:"transport"`
+	Logging        LoggingConfig        `yaml:"logging" json:"logging"`
+	Admin          AdminConfig          `yaml:"admin" json:"admin"`
+	Limits         LimitsConfig         `yaml:"limits" json:"limits"`
+	ErrorPages     ErrorPagesConfig     `yaml:"error_pages" json:"error_pages"`
+	Compression    CompressionConfig    `yaml:"compression" json:"compression"`
+
+	defaults []string // settings Validate filled in, reported in the startup summary
 }
 
 // server settings
@@ -31,59 +55,436 @@ type ServerConfig struct {
 	WriteTimeout   time.Duration `yaml:"write_timeout" json:"write_timeout"`
 	IdleTimeout    time.Duration `yaml:"idle_timeout" json:"idle_timeout"`
 	MaxHeaderBytes int           `yaml:"max_header_bytes" json:"max_header_bytes"`
+	MaxHeaderCount int           `yaml:"max_header_count,omitempty" json:"max_header_count,omitempty"` // requests with more header lines get 431, 0 = unlimited
+	MaxCookieCount int           `yaml:"max_cookie_count,omitempty" json:"max_cookie_count,omitempty"` // requests with more cookies get 431, 0 = unlimited
+	AllowedHosts   []string      `yaml:"allowed_hosts,omitempty" json:"allowed_hosts,omitempty"`       // Host headers accepted, exact or "*.example.com", others get 400; empty allows all
+	MaxConnsPerIP  int           `yaml:"max_conns_per_ip,omitempty" json:"max_conns_per_ip,omitempty"` // concurrent client connections per peer IP, more are closed; 0 = unlimited
+	TrustedProxies []string      `yaml:"trusted_proxies,omitempty" json:"trusted_proxies,omitempty"`   // CIDRs or IPs whose X-Forwarded-For/X-Real-IP are believed; empty trusts none
+
+	DisableKeepAlives      bool          `yaml:"disable_keep_alives" json:"disable_keep_alives"`             // close client connections after every response
+	TCPKeepAlive           time.Duration `yaml:"tcp_keep_alive" json:"tcp_keep_alive"`                       // TCP keep-alive probe period on client connections, defaults to 30s, negative disables
+	Maintenance            bool          `yaml:"maintenance" json:"maintenance"`                             // answer every proxied request with 503 and the maintenance page
+	RequestTimeout         time.Duration `yaml:"request_timeout" json:"request_timeout"`                     // default upstream timeout for upstreams without their own, 0 disables
+	DefaultUpstream        string        `yaml:"default_upstream" json:"default_upstream"`                   // receives requests no upstream match rule accepts
+	RequireBackendsOnStart bool          `yaml:"require_backends_on_start" json:"require_backends_on_start"` // refuse to start when no backend host resolves
+	DrainGrace             time.Duration `yaml:"drain_grace,omitempty" json:"drain_grace,omitempty"`         // at shutdown, wait up to this long for requests in flight, upgrades included; 0 skips
+	ProxyProtocol          bool          `yaml:"proxy_protocol" json:"proxy_protocol"`                       // expect a PROXY protocol v1/v2 header on every client connection and take the client address from it
+
+	PathNormalization PathNormalizationConfig `yaml:"path_normalization" json:"path_normalization"`
+}
+
+// rewrites request paths before routing, so equivalent paths route and
+// cache the same way
+type PathNormalizationConfig struct {
+	Enabled       bool   `yaml:"enabled" json:"enabled"`               // collapse duplicate slashes and resolve . and .. segments
+	TrailingSlash string `yaml:"trailing_slash" json:"trailing_slash"` // "add", "strip" or empty to leave as sent
 }
 
+const (
+	TrailingSlashAdd   = "add"
+	TrailingSlashStrip = "strip"
+)
+
 // server group
 type Upstream struct {
 	Name      string           `yaml:"name" json:"name"`
 	Algorithm string           `yaml:"algorithm" json:"algorithm"`
 	Backends  []Backend        `yaml:"backends" json:"backends"`
 	RateLimit *RateLimitConfig `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty"`
+
+	// least_connections only: time constant for a decayed connection
+	// estimate, 0 keeps exact counting
+	ConnectionDecay time.Duration `yaml:"connection_decay,omitempty" json:"connection_decay,omitempty"`
+
+	ResponseRewrite *ResponseRewriteConfig `yaml:"response_rewrite,omitempty" json:"response_rewrite,omitempty"`
+	AdaptiveWeight  *AdaptiveWeightConfig  `yaml:"adaptive_weight,omitempty" json:"adaptive_weight,omitempty"`
+	ErrorWeight     *ErrorWeightConfig     `yaml:"error_weight,omitempty" json:"error_weight,omitempty"`
+	Cache           *CacheConfig           `yaml:"cache,omitempty" json:"cache,omitempty"`
+
+	// max time for a request to this upstream, falls back to server.request_timeout
+	Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+
+	// requests with larger headers get 431; only tightens server.max_header_bytes,
+	// which the server enforces before routing
+	MaxHeaderBytes int `yaml:"max_header_bytes,omitempty" json:"max_header_bytes,omitempty"`
+
+	// requests this upstream accepts, nil for a catch-all upstream
+	Match *MatchConfig `yaml:"match,omitempty" json:"match,omitempty"`
+
+	// removed from the start of the path before forwarding, e.g. "/api"
+	// sends /api/users to the backends as /users
+	StripPrefix string `yaml:"strip_prefix,omitempty" json:"strip_prefix,omitempty"`
+
+	// ring options for consistent_hash and bounded_consistent_hash
+	ConsistentHash *ConsistentHashConfig `yaml:"consistent_hash,omitempty" json:"consistent_hash,omitempty"`
+
+	// gradually shift traffic onto one backend, rolling back on errors
+	Canary *CanaryConfig `yaml:"canary,omitempty" json:"canary,omitempty"`
+
+	// send slow idempotent requests to a second backend as well
+	Hedge *HedgeConfig `yaml:"hedge,omitempty" json:"hedge,omitempty"`
+
+	// copy requests to a shadow backend, optionally diffing its responses
+	Mirror *MirrorConfig `yaml:"mirror,omitempty" json:"mirror,omitempty"`
+
+	// follow the backends' redirects and return the final response instead
+	// of passing the redirect to the client
+	FollowBackendRedirects *FollowRedirectsConfig `yaml:"follow_backend_redirects,omitempty" json:"follow_backend_redirects,omitempty"`
+
+	// headers set on requests to this upstream's backends and on their
+	// responses; a value replaces the header, RemoveHeader deletes it
+	RequestHeaders  map[string]string `yaml:"request_headers,omitempty" json:"request_headers,omitempty"`
+	ResponseHeaders map[string]string `yaml:"response_headers,omitempty" json:"response_headers,omitempty"`
+}
+
+// following backend redirects: only redirects to the same scheme and host
+// as the request are followed, others reach the client unchanged
+type FollowRedirectsConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	MaxHops int  `yaml:"max_hops" json:"max_hops"` // redirects followed per request, the last one is passed through; defaults to 5
+}
+
+// header rule value that deletes the header instead of setting it
+const RemoveHeader = "-"
+
+// request hedging: when the chosen backend has not answered within Budget,
+// the request also goes to another backend and the first response wins
+type HedgeConfig struct {
+	Enabled       bool          `yaml:"enabled" json:"enabled"`
+	Budget        time.Duration `yaml:"budget" json:"budget"`                 // wait for response headers this long before hedging
+	MaxConcurrent int           `yaml:"max_concurrent" json:"max_concurrent"` // hedges in flight per upstream, defaults to 10
+}
+
+// request mirroring: each request also goes to URL in the background and
+// the shadow's response never reaches the client
+type MirrorConfig struct {
+	Enabled       bool                 `yaml:"enabled" json:"enabled"`
+	URL           string               `yaml:"url" json:"url"`                                           // shadow backend
+	Timeout       time.Duration        `yaml:"timeout,omitempty" json:"timeout,omitempty"`               // per mirrored request, defaults to 5s
+	MaxConcurrent int                  `yaml:"max_concurrent,omitempty" json:"max_concurrent,omitempty"` // mirrored requests in flight per upstream, more are not mirrored, defaults to 10
+	Compare       *ShadowCompareConfig `yaml:"compare,omitempty" json:"compare,omitempty"`
+}
+
+// shadow compare: the shadow's response is checked against the one the
+// client got and each difference is logged and counted
+type ShadowCompareConfig struct {
+	Enabled      bool     `yaml:"enabled" json:"enabled"`
+	Status       bool     `yaml:"status" json:"status"`                                     // compare status codes, the default when nothing else is compared
+	Headers      []string `yaml:"headers,omitempty" json:"headers,omitempty"`               // response headers that must match
+	Body         bool     `yaml:"body" json:"body"`                                         // compare a hash of the bodies
+	MaxBodyBytes int64    `yaml:"max_body_bytes,omitempty" json:"max_body_bytes,omitempty"` // only this much of each body is hashed, defaults to 1MB
+}
+
+// automated canary: Backend gets Ramp.Start percent of the upstream's
+// traffic, raised by Step every Interval until Target
+type CanaryConfig struct {
+	Enabled bool             `yaml:"enabled" json:"enabled"`
+	Backend string           `yaml:"backend" json:"backend"` // URL of the canary, must be one of the upstream's backends
+	Ramp    CanaryRampConfig `yaml:"ramp" json:"ramp"`
+}
+
+type CanaryRampConfig struct {
+	Start          float64       `yaml:"start" json:"start"`                       // initial share in percent
+	Step           float64       `yaml:"step" json:"step"`                         // percent added per interval
+	Interval       time.Duration `yaml:"interval" json:"interval"`                 // time between steps
+	Target         float64       `yaml:"target" json:"target"`                     // share to stop at, defaults to 100
+	AbortErrorRate float64       `yaml:"abort_error_rate" json:"abort_error_rate"` // canary error rate (0-1) that rolls it back to 0, defaults to 0.05
+}
+
+// hash ring settings
+type ConsistentHashConfig struct {
+	VirtualNodes int    `yaml:"virtual_nodes" json:"virtual_nodes"` // ring points per backend, defaults to 100
+	Header       string `yaml:"header" json:"header"`               // hash this header instead of the client IP, falls back to the IP when absent
+}
+
+// request routing rules, all set fields must match
+type MatchConfig struct {
+	Host        string `yaml:"host,omitempty" json:"host,omitempty"`                 // compared case-insensitively, port ignored
+	PathPrefix  string `yaml:"path_prefix,omitempty" json:"path_prefix,omitempty"`   // matched on path segment boundaries
+	Header      string `yaml:"header,omitempty" json:"header,omitempty"`             // request header that must be present
+	HeaderValue string `yaml:"header_value,omitempty" json:"header_value,omitempty"` // exact value of header, any value when empty
+}
+
+// Route sends matching requests to the first of its upstreams that still
+// has a healthy backend
+type Route struct {
+	Match     MatchConfig `yaml:"match" json:"match"`
+	Upstreams []string    `yaml:"upstreams" json:"upstreams"` // in order of preference
 }
 
 // individual server
 type Backend struct {
-	URL    string `yaml:"url" json:"url"`
-	Weight int    `yaml:"weight" json:"weight"`
+	URL           string  `yaml:"url" json:"url"`
+	Weight        int     `yaml:"weight" json:"weight"`
+	WeightPercent float64 `yaml:"weight_percent,omitempty" json:"weight_percent,omitempty"` // alternative to weight, converted during validation
+	MaxConns      int     `yaml:"max_conns,omitempty" json:"max_conns,omitempty"`           // least_connections, least_response_time, p2c and weighted_round_robin skip the backend at this many in-flight requests, other algorithms reject it; 0 = unlimited
+
+	RTTHint time.Duration `yaml:"rtt_hint,omitempty" json:"rtt_hint,omitempty"` // expected round trip time; weighted_round_robin scales weight by the lowest hint over this one
+
+	// weight explicitly set to 0: still health checked, never selected
+	Disabled bool `yaml:"-" json:"-"`
+}
+
+// an unset weight defaults to 1, so only an explicit 0 disables the backend
+func (b *Backend) UnmarshalYAML(value *yaml.Node) error {
+	type plain Backend
+	if err := value.Decode((*plain)(b)); err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		if value.Content[i].Value == "weight" && b.Weight == 0 {
+			b.Disabled = true
+		}
+	}
+
+	return nil
 }
 
+// how far weight_percent values may drift from 100 in total
+const weightPercentTolerance = 0.5
+
 // health check config
 type HealthConfig struct {
 	Enabled            bool          `yaml:"enabled" json:"enabled"`
+	Type               string        `yaml:"type,omitempty" json:"type,omitempty"` // "http" (default) or "tcp", which only checks the backend accepts connections
 	Interval           time.Duration `yaml:"interval" json:"interval"`
+	IntervalJitter     float64       `yaml:"interval_jitter,omitempty" json:"interval_jitter,omitempty"` // each wait varies by up to this fraction of interval, 0 to 1
 	Timeout            time.Duration `yaml:"timeout" json:"timeout"`
 	Path               string        `yaml:"path" json:"path"`
 	UnhealthyThreshold int           `yaml:"unhealthy_threshold" json:"unhealthy_threshold"`
 	HealthyThreshold   int           `yaml:"healthy_threshold" json:"healthy_threshold"`
+	Method             string        `yaml:"method,omitempty" json:"method,omitempty"`             // defaults to GET
+	Body               string        `yaml:"body,omitempty" json:"body,omitempty"`                 // sent only for methods that carry a body
+	ContentType        string        `yaml:"content_type,omitempty" json:"content_type,omitempty"` // content type of body
+	MaxLatency         time.Duration `yaml:"max_latency,omitempty" json:"max_latency,omitempty"`   // slower successful probes count as failures, 0 disables
+
+	DegradedLatency time.Duration `yaml:"degraded_latency,omitempty" json:"degraded_latency,omitempty"` // slower successful probes mark the backend degraded, 0 disables
+	DegradedWeight  float64       `yaml:"degraded_weight,omitempty" json:"degraded_weight,omitempty"`   // weight multiplier for degraded backends, defaults to 0.5
+	ExpectedStatus  []string      `yaml:"expected_status,omitempty" json:"expected_status,omitempty"`   // codes ("204"), classes ("2xx") or ranges ("200-399") counted as healthy, defaults to 2xx
+	SlowStart       time.Duration `yaml:"slow_start,omitempty" json:"slow_start,omitempty"`             // a recovered backend's weight ramps up to full over this window, 0 disables
+
+	Passive PassiveHealthConfig `yaml:"passive,omitempty" json:"passive,omitempty"`
+}
+
+// passive health checks: a backend failing this many proxied requests in a
+// row is taken out of rotation for CoolDown, with or without active checks
+type PassiveHealthConfig struct {
+	Enabled          bool          `yaml:"enabled" json:"enabled"`
+	FailureThreshold int           `yaml:"failure_threshold" json:"failure_threshold"` // consecutive 5xx or transport errors, defaults to 5
+	CoolDown         time.Duration `yaml:"cool_down" json:"cool_down"`                 // how long an ejected backend stays out, defaults to 30s
+}
+
+// StatusRange is an inclusive range of HTTP status codes
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+func (sr StatusRange) Contains(code int) bool {
+	return code >= sr.Min && code <= sr.Max
+}
+
+// ParseStatusRanges parses expected_status entries: a single code like
+// "204", a class like "2xx" or an inclusive range like "200-399"
+func ParseStatusRanges(values []string) ([]StatusRange, error) {
+	ranges := make([]StatusRange, 0, len(values))
+	for _, value := range values {
+		sr, err := parseStatusRange(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, sr)
+	}
+	return ranges, nil
 }
 
+func parseStatusRange(value string) (StatusRange, error) {
+	var sr StatusRange
+
+	if len(value) == 3 && strings.EqualFold(value[1:], "xx") && value[0] >= '1' && value[0] <= '5' {
+		class := int(value[0]-'0') * 100
+		return StatusRange{Min: class, Max: class + 99}, nil
+	}
+
+	if low, high, isRange := strings.Cut(value, "-"); isRange {
+		minCode, errMin := strconv.Atoi(strings.TrimSpace(low))
+		maxCode, errMax := strconv.Atoi(strings.TrimSpace(high))
+		if errMin != nil || errMax != nil {
+			return sr, fmt.Errorf("invalid status range %q", value)
+		}
+		sr = StatusRange{Min: minCode, Max: maxCode}
+	} else {
+		code, err := strconv.Atoi(value)
+		if err != nil {
+			return sr, fmt.Errorf("invalid status code %q (use a code like 204, a class like 2xx or a range like 200-399)", value)
+		}
+		sr = StatusRange{Min: code, Max: code}
+	}
+
+	if sr.Min > sr.Max {
+		return sr, fmt.Errorf("status range %q is reversed", value)
+	}
+	if sr.Min < 100 || sr.Max > 599 {
+		return sr, fmt.Errorf("status %q must be within 100-599", value)
+	}
+	return sr, nil
+}
+
+// health check types
+const (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+)
+
+// upper bound on a configured health check body
+const maxHealthBodyBytes = 64 << 10 // 64KB
+
 // metrics config
 type MetricsConfig struct {
-	Enabled bool   `yaml:"enabled" json:"enabled"`
-	Port    int    `yaml:"port" json:"port"`
-	Path    string `yaml:"path" json:"path"`
+	Enabled   bool   `yaml:"enabled" json:"enabled"`
+	Port      int    `yaml:"port" json:"port"`
+	Path      string `yaml:"path" json:"path"`
+	Namespace string `yaml:"namespace" json:"namespace"` // metric name prefix, defaults to "isame"
+	Subsystem string `yaml:"subsystem" json:"subsystem"` // second name segment, defaults to "lb"
+
+	RouteLabel bool           `yaml:"route_label" json:"route_label"`           // add a route label to request metrics
+	Routes     []MetricsRoute `yaml:"routes,omitempty" json:"routes,omitempty"` // named path templates used for the route label
+
+	ShutdownGrace time.Duration `yaml:"shutdown_grace" json:"shutdown_grace"` // keep serving metrics this long after the proxy stops
+
+	// fraction of requests, 0 to 1, recorded in the per-backend request
+	// series; upstream totals count every request. Defaults to 1
+	SampleRate *float64 `yaml:"sample_rate,omitempty" json:"sample_rate,omitempty"`
+}
+
+// RequestSampleRate returns the configured sample rate, 1 when unset
+func (m MetricsConfig) RequestSampleRate() float64 {
+	if m.SampleRate == nil {
+		return 1
+	}
+	return *m.SampleRate
+}
+
+// named path template for the route label, e.g. "/users/{id}" or "/static/*"
+type MetricsRoute struct {
+	Name string `yaml:"name" json:"name"`
+	Path string `yaml:"path" json:"path"`
 }
 
 // rate limiting config (per upstream)
 type RateLimitConfig struct {
 	Enabled       bool          `yaml:"enabled" json:"enabled"`
-	RequestsPerIP int           `yaml:"requests_per_ip" json:"requests_per_ip"` // max requests per IP
-	WindowSize    time.Duration `yaml:"window_size" json:"window_size"`         // sliding window duration
+	Strategy      string        `yaml:"strategy,omitempty" json:"strategy,omitempty"` // "sliding_window" (default) or "token_bucket"
+	RequestsPerIP int           `yaml:"requests_per_ip" json:"requests_per_ip"`       // max requests per IP
+	WindowSize    time.Duration `yaml:"window_size" json:"window_size"`               // sliding window duration
+
+	// token_bucket only
+	Rate  float64 `yaml:"rate,omitempty" json:"rate,omitempty"`   // tokens added per second
+	Burst int     `yaml:"burst,omitempty" json:"burst,omitempty"` // bucket size, defaults to rate rounded up
+
+	// delay rejections instead of answering them right away
+	Tarpit *TarpitConfig `yaml:"tarpit,omitempty" json:"tarpit,omitempty"`
+}
+
+// tarpit for rate-limited requests: each is held for Delay before its 429,
+// up to MaxConcurrent at once; rejections past that are answered at once
+type TarpitConfig struct {
+	Enabled       bool          `yaml:"enabled" json:"enabled"`
+	Delay         time.Duration `yaml:"delay" json:"delay"`                   // defaults to 10s
+	MaxConcurrent int           `yaml:"max_concurrent" json:"max_concurrent"` // requests held at once per upstream, defaults to 100
+}
+
+const (
+	RateLimitSlidingWindow = "sliding_window"
+	RateLimitTokenBucket   = "token_bucket"
+)
+
+// response body find/replace config (per upstream)
+type ResponseRewriteConfig struct {
+	Enabled      bool          `yaml:"enabled" json:"enabled"`
+	Rules        []RewriteRule `yaml:"rules" json:"rules"`
+	ContentTypes []string      `yaml:"content_types,omitempty" json:"content_types,omitempty"`   // media types eligible for rewriting
+	MaxBodyBytes int64         `yaml:"max_body_bytes,omitempty" json:"max_body_bytes,omitempty"` // larger bodies pass through untouched
+}
+
+// weighted_round_robin only: scale weights down by the load backends report
+// in a response header (0 = idle, 1 = saturated)
+type AdaptiveWeightConfig struct {
+	Enabled bool          `yaml:"enabled" json:"enabled"`
+	Header  string        `yaml:"header" json:"header"` // e.g. "X-Backend-Load"
+	Decay   time.Duration `yaml:"decay" json:"decay"`   // time for an unrefreshed load report to fade, defaults to 30s
+}
+
+// weighted_round_robin only: each consecutive error multiplies a backend's
+// weight by Decay, each success by Recovery, between Floor and its full weight
+type ErrorWeightConfig struct {
+	Enabled  bool    `yaml:"enabled" json:"enabled"`
+	Decay    float64 `yaml:"decay" json:"decay"`       // applied per error, in (0, 1), defaults to 0.5
+	Recovery float64 `yaml:"recovery" json:"recovery"` // applied per success, above 1, defaults to 1.5
+	Floor    float64 `yaml:"floor" json:"floor"`       // lowest share of its weight a backend keeps, in (0, 1], defaults to 0.1
+}
+
+// in-memory response cache config (per upstream)
+type CacheConfig struct {
+	Enabled      bool          `yaml:"enabled" json:"enabled"`
+	TTL          time.Duration `yaml:"ttl" json:"ttl"`                                           // defaults to 60s, a shorter max-age wins
+	MaxEntries   int           `yaml:"max_entries,omitempty" json:"max_entries,omitempty"`       // least recently used entries are evicted past this, defaults to 1000
+	MaxBodyBytes int64         `yaml:"max_body_bytes,omitempty" json:"max_body_bytes,omitempty"` // larger responses are not cached, defaults to 1MB
+	Key          []string      `yaml:"key,omitempty" json:"key,omitempty"`                       // any of "method", "path", "query", defaults to all three
+	VaryHeaders  []string      `yaml:"vary_headers,omitempty" json:"vary_headers,omitempty"`     // request headers that get their own cache entry
+}
+
+// literal replacement applied to response bodies
+type RewriteRule struct {
+	Find    string `yaml:"find" json:"find"`
+	Replace string `yaml:"replace" json:"replace"`
 }
 
 // circuit breaker config
 type CircuitBreakerConfig struct {
-	Enabled          bool          `yaml:"enabled" json:"enabled"`
-	FailureThreshold int           `yaml:"failure_threshold" json:"failure_threshold"` // consecutive failures to open circuit
-	Timeout          time.Duration `yaml:"timeout" json:"timeout"`                     // time before trying again
+	Enabled                  bool          `yaml:"enabled" json:"enabled"`
+	FailureThreshold         int           `yaml:"failure_threshold" json:"failure_threshold"`                     // consecutive failures to open circuit
+	Timeout                  time.Duration `yaml:"timeout" json:"timeout"`                                         // time before trying again
+	OpenBehavior             string        `yaml:"open_behavior" json:"open_behavior"`                             // "fast_fail" or "probe"
+	ProbeInterval            time.Duration `yaml:"probe_interval" json:"probe_interval"`                           // min time between probes through an open circuit
+	HalfOpenMaxRequests      int           `yaml:"half_open_max_requests" json:"half_open_max_requests"`           // probes let through at once after the timeout, defaults to 1
+	HalfOpenSuccessThreshold int           `yaml:"half_open_success_threshold" json:"half_open_success_threshold"` // consecutive probe successes that close the circuit, defaults to 1
+
+	Mode               string        `yaml:"mode,omitempty" json:"mode,omitempty"`                                 // "consecutive" (default) trips on failure_threshold failures in a row, "error_rate" on the failure ratio
+	ErrorRateThreshold float64       `yaml:"error_rate_threshold,omitempty" json:"error_rate_threshold,omitempty"` // error_rate mode: percentage of failed requests in the window that opens the circuit, defaults to 50
+	RollingWindow      time.Duration `yaml:"rolling_window,omitempty" json:"rolling_window,omitempty"`             // error_rate mode: how far back requests are counted, defaults to 10s
+	MinRequests        int           `yaml:"min_requests,omitempty" json:"min_requests,omitempty"`                 // error_rate mode: requests in the window before the ratio is judged, defaults to 20
 }
 
+// circuit breaker modes
+const (
+	CircuitBreakerConsecutive = "consecutive"
+	CircuitBreakerErrorRate   = "error_rate"
+)
+
 // retry config
 type RetryConfig struct {
 	Enabled        bool          `yaml:"enabled" json:"enabled"`
 	MaxAttempts    int           `yaml:"max_attempts" json:"max_attempts"`       // max retry attempts
 	InitialBackoff time.Duration `yaml:"initial_backoff" json:"initial_backoff"` // initial backoff duration
 	MaxBackoff     time.Duration `yaml:"max_backoff" json:"max_backoff"`         // max backoff duration
+
+	OnHeader *RetryHeaderConfig `yaml:"on_header,omitempty" json:"on_header,omitempty"` // retry responses carrying this header, whatever their status
+
+	// retry POST and PATCH only with an Idempotency-Key header, defaults to true
+	IdempotentOnly *bool `yaml:"idempotent_only,omitempty" json:"idempotent_only,omitempty"`
+}
+
+// OnlyIdempotent reports whether non-idempotent requests are kept from retries
+func (r RetryConfig) OnlyIdempotent() bool {
+	return r.IdempotentOnly == nil || *r.IdempotentOnly
+}
+
+// backends that flag transient errors in a header rather than the status
+type RetryHeaderConfig struct {
+	Name  string `yaml:"name" json:"name"`   // e.g. X-Retryable
+	Value string `yaml:"value" json:"value"` // matched case-insensitively, defaults to "true"
 }
 
 // TLS config
@@ -91,8 +492,102 @@ type TLSConfig struct {
 	Enabled      bool     `yaml:"enabled" json:"enabled"`
 	CertFile     string   `yaml:"cert_file" json:"cert_file"`
 	KeyFile      string   `yaml:"key_file" json:"key_file"`
+	CertPEM      string   `yaml:"cert_pem,omitempty" json:"cert_pem,omitempty"`       // inline alternative to cert_file
+	KeyPEM       string   `yaml:"key_pem,omitempty" json:"key_pem,omitempty"`         // inline alternative to key_file
 	MinVersion   string   `yaml:"min_version,omitempty" json:"min_version,omitempty"` // "1.2", "1.3"
 	CipherSuites []string `yaml:"cipher_suites,omitempty" json:"cipher_suites,omitempty"`
+	OCSPStapling bool     `yaml:"ocsp_stapling" json:"ocsp_stapling"` // staple OCSP responses from the issuer's responder
+
+	DisableSessionTickets bool          `yaml:"disable_session_tickets" json:"disable_session_tickets"` // turn off ticket based session resumption
+	SessionTicketRotation time.Duration `yaml:"session_ticket_rotation" json:"session_ticket_rotation"` // rotate ticket keys on this interval, 0 keeps Go's default
+
+	ClientCertHeaders bool `yaml:"client_cert_headers" json:"client_cert_headers"` // pass verified client cert details to backends as X-Client-Cert-* headers
+	RedirectHTTP      bool `yaml:"redirect_http" json:"redirect_http"`             // answer plain HTTP requests with a 301 to HTTPS instead of proxying them
+
+	// mutual TLS: whether clients must present a certificate, and the CAs it is verified against
+	ClientAuth   string `yaml:"client_auth,omitempty" json:"client_auth,omitempty"`       // "none" (default), "request", "require", "verify_if_given", "require_and_verify"
+	ClientCAFile string `yaml:"client_ca_file,omitempty" json:"client_ca_file,omitempty"` // PEM bundle of CAs trusted to sign client certificates
+}
+
+const (
+	ClientAuthNone             = "none"
+	ClientAuthRequest          = "request"
+	ClientAuthRequire          = "require"
+	ClientAuthVerifyIfGiven    = "verify_if_given"
+	ClientAuthRequireAndVerify = "require_and_verify"
+)
+
+// upstream transport config
+type TransportConfig struct {
+	DialTimeout time.Duration     `yaml:"dial_timeout" json:"dial_timeout"`             // max time to establish a backend connection
+	Hosts       map[string]string `yaml:"hosts,omitempty" json:"hosts,omitempty"`       // static hostname -> address overrides
+	Resolver    string            `yaml:"resolver,omitempty" json:"resolver,omitempty"` // DNS server (host:port) used instead of the system resolver
+	KeepAlive   time.Duration     `yaml:"keep_alive" json:"keep_alive"`                 // TCP keep-alive probe period on backend connections, defaults to 30s, negative disables
+}
+
+// logging config
+type LoggingConfig struct {
+	SlowRequestThreshold time.Duration `yaml:"slow_request_threshold" json:"slow_request_threshold"` // log requests slower than this, 0 disables
+
+	AccessLog AccessLogConfig `yaml:"access_log" json:"access_log"`
+	Capture   CaptureConfig   `yaml:"capture" json:"capture"`
+}
+
+// one line per proxied request
+type AccessLogConfig struct {
+	Enabled bool   `yaml:"enabled" json:"enabled"`
+	Format  string `yaml:"format" json:"format"` // "text" or "json", defaults to text
+	Output  string `yaml:"output" json:"output"` // file lines are appended to, defaults to stdout
+}
+
+const (
+	AccessLogText = "text"
+	AccessLogJSON = "json"
+)
+
+// debug capture of full requests/responses to a file
+type CaptureConfig struct {
+	Enabled       bool     `yaml:"enabled" json:"enabled"`
+	File          string   `yaml:"file" json:"file"`                                         // JSON lines are appended here
+	SampleRate    float64  `yaml:"sample_rate" json:"sample_rate"`                           // fraction of requests captured, 0 to 1
+	MatchHeader   string   `yaml:"match_header,omitempty" json:"match_header,omitempty"`     // always capture requests carrying this header
+	MaxBodyBytes  int64    `yaml:"max_body_bytes,omitempty" json:"max_body_bytes,omitempty"` // bodies are truncated past this, defaults to 4KB
+	RedactHeaders []string `yaml:"redact_headers,omitempty" json:"redact_headers,omitempty"` // header values replaced in the capture
+}
+
+// admin API config
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled" json:"enabled"`
+	Address string `yaml:"address" json:"address"` // bind address, defaults to loopback only
+	Port    int    `yaml:"port" json:"port"`
+
+	DrainDelay time.Duration `yaml:"drain_delay" json:"drain_delay"` // shut down this long after POST /admin/drain, 0 waits for a signal
+}
+
+// static files served instead of the JSON error body, loaded at startup;
+// the content type is inferred from the file extension
+type ErrorPagesConfig struct {
+	BadGateway         string `yaml:"bad_gateway,omitempty" json:"bad_gateway,omitempty"`                 // 502
+	ServiceUnavailable string `yaml:"service_unavailable,omitempty" json:"service_unavailable,omitempty"` // 503
+	GatewayTimeout     string `yaml:"gateway_timeout,omitempty" json:"gateway_timeout,omitempty"`         // 504
+	Maintenance        string `yaml:"maintenance,omitempty" json:"maintenance,omitempty"`                 // 503 while server.maintenance is on
+
+	// text/template for the JSON error body of errors without a page;
+	// .Message, .Code and .RequestID render as JSON values, quotes included
+	JSONTemplate string `yaml:"json_template,omitempty" json:"json_template,omitempty"`
+}
+
+// gzips proxied responses for clients that send Accept-Encoding: gzip
+type CompressionConfig struct {
+	Enabled      bool     `yaml:"enabled" json:"enabled"`
+	MinSize      int      `yaml:"min_size,omitempty" json:"min_size,omitempty"`           // smaller bodies are sent as is, defaults to 1024 bytes
+	ContentTypes []string `yaml:"content_types,omitempty" json:"content_types,omitempty"` // media types worth compressing
+}
+
+// guards against runaway generated configs, 0 disables a limit
+type LimitsConfig struct {
+	MaxUpstreams           int `yaml:"max_upstreams" json:"max_upstreams"`
+	MaxBackendsPerUpstream int `yaml:"max_backends_per_upstream" json:"max_backends_per_upstream"`
 }
 
 // config with defaults
@@ -106,6 +601,7 @@ func NewDefaultConfig() *Config {
 			WriteTimeout:   15 * time.Second,
 			IdleTimeout:    60 * time.Second,
 			MaxHeaderBytes: 1 << 20, // 1MB
+			TCPKeepAlive:   30 * time.Second,
 		},
 		Upstreams: []Upstream{},
 		Health: HealthConfig{
@@ -115,16 +611,21 @@ func NewDefaultConfig() *Config {
 			Path:               "/health",
 			UnhealthyThreshold: 3,
 			HealthyThreshold:   2,
+			DegradedWeight:     0.5,
 		},
 		Metrics: MetricsConfig{
-			Enabled: true,
-			Port:    9090,
-			Path:    "/metrics",
+			Enabled:   true,
+			Port:      9090,
+			Path:      "/metrics",
+			Namespace: "isame",
+			Subsystem: "lb",
 		},
 		CircuitBreaker: CircuitBreakerConfig{
 			Enabled:          true,
 			FailureThreshold: 5,
 			Timeout:          60 * time.Second,
+			OpenBehavior:     "fast_fail",
+			ProbeInterval:    5 * time.Second,
 		},
 		Retry: RetryConfig{
 			Enabled:        true,
@@ -132,6 +633,15 @@ func NewDefaultConfig() *Config {
 			InitialBackoff: 100 * time.Millisecond,
 			MaxBackoff:     2 * time.Second,
 		},
+		Transport: TransportConfig{
+			DialTimeout: 5 * time.Second,
+			KeepAlive:   30 * time.Second,
+		},
+		Admin: AdminConfig{
+			Enabled: false,
+			Address: "127.0.0.1",
+			Port:    9091,
+		},
 	}
 }
 
@@ -154,6 +664,11 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("upstreams validation failed: %w", err)
 	}
 
+	// validate routes
+	if err := c.validateRoutes(); err != nil {
+		return fmt.Errorf("routes validation failed: %w", err)
+	}
+
 	// validate health config
 	if err := c.validateHealthConfig(); err != nil {
 		return fmt.Errorf("health config validation failed: %w", err)
@@ -179,9 +694,121 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("TLS config validation failed: %w", err)
 	}
 
+	// validate transport config
+	if err := c.validateTransportConfig(); err != nil {
+		return fmt.Errorf("transport config validation failed: %w", err)
+	}
+
+	// validate logging config
+	if err := c.validateLoggingConfig(); err != nil {
+		return fmt.Errorf("logging config validation failed: %w", err)
+	}
+
+	// validate error pages config
+	if err := c.validateErrorPagesConfig(); err != nil {
+		return fmt.Errorf("error pages config validation failed: %w", err)
+	}
+
+	// validate compression config
+	if err := c.validateCompressionConfig(); err != nil {
+		return fmt.Errorf("compression config validation failed: %w", err)
+	}
+
+	// validate admin config
+	if err := c.validateAdminConfig(); err != nil {
+		return fmt.Errorf("admin config validation failed: %w", err)
+	}
+
+	// settings in different sections that contradict each other; runs last
+	// so every section has its defaults
+	if err := c.validateConsistency(); err != nil {
+		return fmt.Errorf("inconsistent config: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Config) validateConsistency() error {
+	if c.TLS.RedirectHTTP && !c.TLS.Enabled {
+		return errors.New("tls.redirect_http requires tls.enabled, there is no HTTPS listener to redirect to")
+	}
+
+	// every listener needs a port of its own
+	listeners := []struct {
+		name    string
+		port    int
+		enabled bool
+	}{
+		{"server.port", c.Server.Port, true},
+		{"server.https_port", c.Server.HTTPSPort, c.TLS.Enabled},
+		{"metrics.port", c.Metrics.Port, c.Metrics.Enabled},
+		{"admin.port", c.Admin.Port, c.Admin.Enabled},
+	}
+	for i, a := range listeners {
+		for _, b := range listeners[i+1:] {
+			if a.enabled && b.enabled && a.port == b.port {
+				return fmt.Errorf("%s and %s are both %d", a.name, b.name, a.port)
+			}
+		}
+	}
+
+	// a host match outside allowed_hosts can never be reached
+	if len(c.Server.AllowedHosts) > 0 {
+		allowed := func(host string) bool {
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			return HostAllowed(host, c.Server.AllowedHosts)
+		}
+		for i, route := range c.Routes {
+			if route.Match.Host != "" && !allowed(route.Match.Host) {
+				return fmt.Errorf("routes[%d] matches host %q, which server.allowed_hosts rejects", i, route.Match.Host)
+			}
+		}
+		for _, upstream := range c.Upstreams {
+			if upstream.Match != nil && upstream.Match.Host != "" && !allowed(upstream.Match.Host) {
+				return fmt.Errorf("upstream %s matches host %q, which server.allowed_hosts rejects", upstream.Name, upstream.Match.Host)
+			}
+		}
+	}
+
 	return nil
 }
 
+// HostAllowed reports whether host, without its port, is one of
+// server.allowed_hosts: exact, or "*.example.com" for any subdomain of
+// example.com but not example.com itself. Case, a trailing dot and IPv6
+// brackets are ignored; an empty list allows every host.
+func HostAllowed(host string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	host = strings.Trim(strings.TrimSuffix(strings.ToLower(host), "."), "[]")
+	for _, pattern := range patterns {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if len(host) > len(suffix) && strings.HasSuffix(host, suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// records a setting Validate filled in; Validate is idempotent so each is noted once
+func (c *Config) noteDefault(setting string, value any) {
+	c.defaults = append(c.defaults, fmt.Sprintf("%s=%v", setting, value))
+}
+
+// AppliedDefaults lists the settings Validate filled in because the config left them unset
+func (c *Config) AppliedDefaults() []string {
+	return c.defaults
+}
+
 func (c *Config) validateServerConfig() error {
 	if c.Server.Port <= 0 || c.Server.Port > 65535 {
 		return errors.New("server port must be between 1 and 65535")
@@ -189,15 +816,66 @@ func (c *Config) validateServerConfig() error {
 
 	if c.Server.ReadTimeout <= 0 {
 		c.Server.ReadTimeout = 15 * time.Second
+		c.noteDefault("server.read_timeout", c.Server.ReadTimeout)
 	}
 	if c.Server.WriteTimeout <= 0 {
 		c.Server.WriteTimeout = 15 * time.Second
+		c.noteDefault("server.write_timeout", c.Server.WriteTimeout)
 	}
 	if c.Server.IdleTimeout <= 0 {
 		c.Server.IdleTimeout = 60 * time.Second
+		c.noteDefault("server.idle_timeout", c.Server.IdleTimeout)
+	}
+	if c.Server.TCPKeepAlive == 0 {
+		c.Server.TCPKeepAlive = 30 * time.Second
+		c.noteDefault("server.tcp_keep_alive", c.Server.TCPKeepAlive)
 	}
 	if c.Server.MaxHeaderBytes <= 0 {
 		c.Server.MaxHeaderBytes = 1 << 20 // 1MB
+		c.noteDefault("server.max_header_bytes", c.Server.MaxHeaderBytes)
+	}
+	if c.Server.MaxHeaderCount < 0 {
+		return errors.New("max_header_count must not be negative")
+	}
+	if c.Server.MaxConnsPerIP < 0 {
+		return errors.New("max_conns_per_ip must not be negative")
+	}
+	if c.Server.DrainGrace < 0 {
+		return errors.New("drain_grace must not be negative")
+	}
+	if c.Server.MaxCookieCount < 0 {
+		return errors.New("max_cookie_count must not be negative")
+	}
+	for i, host := range c.Server.AllowedHosts {
+		host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+		if host == "" {
+			return fmt.Errorf("allowed_hosts[%d] must not be empty", i)
+		}
+		if strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+			return fmt.Errorf("allowed_hosts[%d] %q: a wildcard is only allowed as a leading \"*.\"", i, host)
+		}
+		if _, _, err := net.SplitHostPort(host); err == nil {
+			return fmt.Errorf("allowed_hosts[%d] %q must not include a port", i, host)
+		}
+		c.Server.AllowedHosts[i] = strings.Trim(host, "[]")
+	}
+	for i, proxy := range c.Server.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(proxy); err != nil {
+			return fmt.Errorf("trusted_proxies[%d] %q is not a CIDR or IP address", i, proxy)
+		}
+	}
+	if c.Server.RequestTimeout < 0 {
+		return errors.New("request_timeout must be positive when set")
+	}
+
+	switch c.Server.PathNormalization.TrailingSlash {
+	case "", TrailingSlashAdd, TrailingSlashStrip:
+	default:
+		return fmt.Errorf("path_normalization trailing_slash must be %q or %q, got %q",
+			TrailingSlashAdd, TrailingSlashStrip, c.Server.PathNormalization.TrailingSlash)
 	}
 
 	return nil
@@ -208,64 +886,311 @@ func (c *Config) validateUpstreams() error {
 		return errors.New("at least one upstream must be configured")
 	}
 
+	if c.Limits.MaxUpstreams < 0 || c.Limits.MaxBackendsPerUpstream < 0 {
+		return errors.New("limits must not be negative")
+	}
+	if c.Limits.MaxUpstreams > 0 && len(c.Upstreams) > c.Limits.MaxUpstreams {
+		return fmt.Errorf("%d upstreams configured, exceeds max_upstreams %d", len(c.Upstreams), c.Limits.MaxUpstreams)
+	}
+
+	names := make(map[string]bool, len(c.Upstreams))
 	for i, upstream := range c.Upstreams {
 		if upstream.Name == "" {
 			return fmt.Errorf("upstream[%d]: name is required", i)
 		}
+		if names[upstream.Name] {
+			return fmt.Errorf("upstream[%d]: duplicate name %q", i, upstream.Name)
+		}
+		names[upstream.Name] = true
+
+		if err := validateMatchConfig(upstream.Match); err != nil {
+			return fmt.Errorf("upstream[%d] match validation failed: %w", i, err)
+		}
+
+		if upstream.StripPrefix != "" {
+			if !strings.HasPrefix(upstream.StripPrefix, "/") {
+				return fmt.Errorf("upstream[%d]: strip_prefix %q must start with /", i, upstream.StripPrefix)
+			}
+			// "/api/" strips the same as "/api" and still leaves a leading slash
+			c.Upstreams[i].StripPrefix = strings.TrimRight(upstream.StripPrefix, "/")
+			if c.Upstreams[i].StripPrefix == "" {
+				return fmt.Errorf("upstream[%d]: strip_prefix must not be /", i)
+			}
+		}
 
 		if upstream.Algorithm == "" {
 			c.Upstreams[i].Algorithm = "round_robin"
+			c.noteDefault(fmt.Sprintf("upstreams[%s].algorithm", upstream.Name), "round_robin")
 		}
 
 		if len(upstream.Backends) == 0 {
 			return fmt.Errorf("upstream[%d]: at least one backend is required", i)
 		}
 
+		if limit := c.Limits.MaxBackendsPerUpstream; limit > 0 && len(upstream.Backends) > limit {
+			return fmt.Errorf("upstream[%d]: %d backends configured, exceeds max_backends_per_upstream %d", i, len(upstream.Backends), limit)
+		}
+
+		if upstream.Timeout < 0 {
+			return fmt.Errorf("upstream[%d]: timeout must be positive when set", i)
+		}
+
+		if upstream.ConnectionDecay < 0 {
+			return fmt.Errorf("upstream[%d]: connection_decay must not be negative", i)
+		}
+
+		if upstream.MaxHeaderBytes < 0 {
+			return fmt.Errorf("upstream[%d]: max_header_bytes must not be negative", i)
+		}
+		if upstream.MaxHeaderBytes > c.Server.MaxHeaderBytes {
+			log.Printf("Warning: upstream %s max_header_bytes %d exceeds server.max_header_bytes %d, the server limit applies first",
+				upstream.Name, upstream.MaxHeaderBytes, c.Server.MaxHeaderBytes)
+		}
+
+		hinted, limited := false, false
 		for j, backend := range upstream.Backends {
 			if err := c.validateBackend(backend, i, j); err != nil {
 				return err
 			}
+			hinted = hinted || backend.RTTHint > 0
+			limited = limited || backend.MaxConns > 0
+		}
+		if hinted && c.Upstreams[i].Algorithm != "weighted_round_robin" {
+			log.Printf("Warning: upstream %s sets rtt_hint, which only weighted_round_robin uses", upstream.Name)
+		}
+		// the other balancers don't count in-flight requests per backend
+		switch c.Upstreams[i].Algorithm {
+		case "least_connections", "least_response_time", "p2c", "weighted_round_robin":
+		default:
+			if limited {
+				return fmt.Errorf("upstream[%d]: max_conns is only enforced by least_connections, least_response_time, p2c and weighted_round_robin, not %s", i, c.Upstreams[i].Algorithm)
+			}
+		}
+
+		if err := c.normalizeWeightPercents(i); err != nil {
+			return err
 		}
 
 		// validate rate limit config for this upstream
 		if err := c.validateRateLimitConfig(upstream.RateLimit); err != nil {
 			return fmt.Errorf("upstream[%d] rate limit validation failed: %w", i, err)
 		}
-	}
 
-	return nil
-}
+		// validate response rewrite config for this upstream
+		if err := c.validateResponseRewriteConfig(upstream.ResponseRewrite); err != nil {
+			return fmt.Errorf("upstream[%d] response rewrite validation failed: %w", i, err)
+		}
 
-func (c *Config) validateBackend(backend Backend, upstreamIdx, backendIdx int) error {
-	if backend.URL == "" {
-		return fmt.Errorf("upstream[%d].backend[%d]: URL is required", upstreamIdx, backendIdx)
-	}
+		// validate cache config for this upstream
+		if err := c.validateCacheConfig(upstream.Cache); err != nil {
+			return fmt.Errorf("upstream[%d] cache validation failed: %w", i, err)
+		}
 
-	parsedURL, err := url.Parse(backend.URL)
-	if err != nil {
-		return fmt.Errorf("upstream[%d].backend[%d]: invalid URL %q: %w", upstreamIdx, backendIdx, backend.URL, err)
-	}
+		// validate consistent hash config for this upstream
+		if err := c.validateConsistentHashConfig(upstream.ConsistentHash, c.Upstreams[i].Algorithm); err != nil {
+			return fmt.Errorf("upstream[%d] consistent hash validation failed: %w", i, err)
+		}
 
-	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		return fmt.Errorf("upstream[%d].backend[%d]: URL scheme must be http or https", upstreamIdx, backendIdx)
-	}
+		// validate canary config for this upstream
+		if err := c.validateCanaryConfig(upstream.Canary, upstream.Backends); err != nil {
+			return fmt.Errorf("upstream[%d] canary validation failed: %w", i, err)
+		}
 
-	if backend.Weight <= 0 {
-		c.Upstreams[upstreamIdx].Backends[backendIdx].Weight = 1
-	}
+		// validate adaptive weight config for this upstream
+		if err := c.validateAdaptiveWeightConfig(upstream.AdaptiveWeight, c.Upstreams[i].Algorithm); err != nil {
+			return fmt.Errorf("upstream[%d] adaptive weight validation failed: %w", i, err)
+		}
 
-	return nil
-}
+		// validate error weight config for this upstream
+		if err := c.validateErrorWeightConfig(upstream.ErrorWeight, c.Upstreams[i].Algorithm); err != nil {
+			return fmt.Errorf("upstream[%d] error weight validation failed: %w", i, err)
+		}
 
-func (c *Config) validateHealthConfig() error {
-	if c.Health.Interval <= 0 {
+		// validate hedge config for this upstream
+		if err := c.validateHedgeConfig(upstream.Hedge, upstream.Backends); err != nil {
+			return fmt.Errorf("upstream[%d] hedge validation failed: %w", i, err)
+		}
+
+		// validate mirror config for this upstream
+		if err := c.validateMirrorConfig(upstream.Mirror); err != nil {
+			return fmt.Errorf("upstream[%d] mirror validation failed: %w", i, err)
+		}
+
+		// validate redirect following for this upstream
+		if err := validateFollowRedirectsConfig(upstream.FollowBackendRedirects); err != nil {
+			return fmt.Errorf("upstream[%d] follow_backend_redirects validation failed: %w", i, err)
+		}
+
+		// validate header rules for this upstream
+		requestHeaders, err := validateHeaderRules(upstream.RequestHeaders)
+		if err != nil {
+			return fmt.Errorf("upstream[%d] request_headers validation failed: %w", i, err)
+		}
+		c.Upstreams[i].RequestHeaders = requestHeaders
+		responseHeaders, err := validateHeaderRules(upstream.ResponseHeaders)
+		if err != nil {
+			return fmt.Errorf("upstream[%d] response_headers validation failed: %w", i, err)
+		}
+		c.Upstreams[i].ResponseHeaders = responseHeaders
+	}
+
+	if c.Server.DefaultUpstream != "" && !names[c.Server.DefaultUpstream] {
+		return fmt.Errorf("default_upstream %q does not name an upstream", c.Server.DefaultUpstream)
+	}
+
+	return nil
+}
+
+func (c *Config) validateRoutes() error {
+	names := make(map[string]bool, len(c.Upstreams))
+	for _, upstream := range c.Upstreams {
+		names[upstream.Name] = true
+	}
+
+	for i, route := range c.Routes {
+		if err := validateMatchConfig(&route.Match); err != nil {
+			return fmt.Errorf("route[%d] match validation failed: %w", i, err)
+		}
+		if len(route.Upstreams) == 0 {
+			return fmt.Errorf("route[%d]: at least one upstream is required", i)
+		}
+		for _, name := range route.Upstreams {
+			if !names[name] {
+				return fmt.Errorf("route[%d]: upstream %q does not exist", i, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateMatchConfig(match *MatchConfig) error {
+	if match == nil {
+		return nil
+	}
+
+	if match.Host == "" && match.PathPrefix == "" && match.Header == "" {
+		return errors.New("match needs a host, path_prefix or header")
+	}
+	if match.HeaderValue != "" && match.Header == "" {
+		return errors.New("header_value requires header")
+	}
+	if match.PathPrefix != "" && !strings.HasPrefix(match.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix %q must start with /", match.PathPrefix)
+	}
+
+	return nil
+}
+
+func (c *Config) validateBackend(backend Backend, upstreamIdx, backendIdx int) error {
+	if backend.URL == "" {
+		return fmt.Errorf("upstream[%d].backend[%d]: URL is required", upstreamIdx, backendIdx)
+	}
+
+	parsedURL, err := url.Parse(backend.URL)
+	if err != nil {
+		return fmt.Errorf("upstream[%d].backend[%d]: invalid URL %q: %w", upstreamIdx, backendIdx, backend.URL, err)
+	}
+
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return fmt.Errorf("upstream[%d].backend[%d]: URL scheme must be http or https", upstreamIdx, backendIdx)
+	}
+
+	if backend.WeightPercent < 0 {
+		return fmt.Errorf("upstream[%d].backend[%d]: weight_percent must not be negative", upstreamIdx, backendIdx)
+	}
+	if backend.MaxConns < 0 {
+		return fmt.Errorf("upstream[%d].backend[%d]: max_conns must not be negative", upstreamIdx, backendIdx)
+	}
+	if backend.RTTHint < 0 {
+		return fmt.Errorf("upstream[%d].backend[%d]: rtt_hint must not be negative", upstreamIdx, backendIdx)
+	}
+
+	if backend.WeightPercent > 0 && (backend.Weight > 0 || backend.Disabled) {
+		return fmt.Errorf("upstream[%d].backend[%d]: weight and weight_percent are mutually exclusive", upstreamIdx, backendIdx)
+	}
+
+	if backend.Weight <= 0 && !backend.Disabled {
+		c.Upstreams[upstreamIdx].Backends[backendIdx].Weight = 1
+	}
+
+	return nil
+}
+
+/*
+ * converts weight_percent values into integer weights
+ * percentages are scaled to tenths and reduced by their gcd, so 20/80 becomes 1/4
+ */
+func (c *Config) normalizeWeightPercents(upstreamIdx int) error {
+	backends := c.Upstreams[upstreamIdx].Backends
+
+	usingPercent := 0
+	total := 0.0
+	for _, backend := range backends {
+		if backend.WeightPercent > 0 {
+			usingPercent++
+			total += backend.WeightPercent
+		}
+	}
+
+	if usingPercent == 0 {
+		return nil
+	}
+	if usingPercent != len(backends) {
+		return fmt.Errorf("upstream[%d]: weight_percent must be set on every backend or none", upstreamIdx)
+	}
+	if math.Abs(total-100) > weightPercentTolerance {
+		return fmt.Errorf("upstream[%d]: weight_percent values must sum to 100, got %g", upstreamIdx, total)
+	}
+
+	weights := make([]int, len(backends))
+	divisor := 0
+	for j, backend := range backends {
+		weights[j] = int(math.Round(backend.WeightPercent * 10))
+		if weights[j] <= 0 {
+			weights[j] = 1
+		}
+		divisor = gcd(divisor, weights[j])
+	}
+
+	for j := range backends {
+		backends[j].Weight = weights[j] / divisor
+		backends[j].WeightPercent = 0
+	}
+
+	return nil
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func (c *Config) validateHealthConfig() error {
+	switch c.Health.Type {
+	case "":
+		c.Health.Type = HealthCheckHTTP
+	case HealthCheckHTTP, HealthCheckTCP:
+	default:
+		return fmt.Errorf("unsupported health check type %q (must be %q or %q)", c.Health.Type, HealthCheckHTTP, HealthCheckTCP)
+	}
+
+	if c.Health.Interval <= 0 {
 		c.Health.Interval = 30 * time.Second
+		c.noteDefault("health.interval", c.Health.Interval)
+	}
+	if c.Health.IntervalJitter < 0 || c.Health.IntervalJitter > 1 {
+		return errors.New("interval_jitter must be between 0 and 1")
 	}
 	if c.Health.Timeout <= 0 {
 		c.Health.Timeout = 5 * time.Second
+		c.noteDefault("health.timeout", c.Health.Timeout)
 	}
 	if c.Health.Path == "" {
 		c.Health.Path = "/health"
+		c.noteDefault("health.path", c.Health.Path)
 	}
 	if c.Health.UnhealthyThreshold <= 0 {
 		c.Health.UnhealthyThreshold = 3
@@ -274,6 +1199,59 @@ func (c *Config) validateHealthConfig() error {
 		c.Health.HealthyThreshold = 2
 	}
 
+	c.Health.Method = strings.ToUpper(c.Health.Method)
+	switch c.Health.Method {
+	case "":
+		c.Health.Method = http.MethodGet
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return fmt.Errorf("unsupported health check method %q", c.Health.Method)
+	}
+
+	if len(c.Health.Body) > maxHealthBodyBytes {
+		return fmt.Errorf("health check body must not exceed %d bytes", maxHealthBodyBytes)
+	}
+	if c.Health.Body != "" && c.Health.ContentType == "" {
+		c.Health.ContentType = "application/json"
+	}
+
+	if c.Health.MaxLatency < 0 {
+		return errors.New("max_latency must not be negative")
+	}
+	if c.Health.DegradedLatency < 0 {
+		return errors.New("degraded_latency must not be negative")
+	}
+	if c.Health.DegradedWeight == 0 {
+		c.Health.DegradedWeight = 0.5
+	}
+	if c.Health.DegradedWeight < 0 || c.Health.DegradedWeight > 1 {
+		return errors.New("degraded_weight must be between 0 and 1")
+	}
+	if c.Health.SlowStart < 0 {
+		return errors.New("slow_start must not be negative")
+	}
+	if _, err := ParseStatusRanges(c.Health.ExpectedStatus); err != nil {
+		return fmt.Errorf("expected_status: %w", err)
+	}
+	if c.Health.MaxLatency > 0 && c.Health.MaxLatency >= c.Health.Timeout {
+		log.Printf("Warning: health max_latency %s is not below timeout %s and will never trigger", c.Health.MaxLatency, c.Health.Timeout)
+	}
+
+	if passive := &c.Health.Passive; passive.Enabled {
+		if passive.FailureThreshold < 0 {
+			return errors.New("passive failure_threshold must not be negative")
+		}
+		if passive.FailureThreshold == 0 {
+			passive.FailureThreshold = 5
+		}
+		if passive.CoolDown < 0 {
+			return errors.New("passive cool_down must not be negative")
+		}
+		if passive.CoolDown == 0 {
+			passive.CoolDown = 30 * time.Second
+		}
+	}
+
 	return nil
 }
 
@@ -285,11 +1263,43 @@ func (c *Config) validateMetricsConfig() error {
 		if c.Metrics.Path == "" {
 			c.Metrics.Path = "/metrics"
 		}
+		if c.Metrics.Namespace == "" {
+			c.Metrics.Namespace = "isame"
+		}
+		if c.Metrics.Subsystem == "" {
+			c.Metrics.Subsystem = "lb"
+		}
+		if !metricNameSegment.MatchString(c.Metrics.Namespace) || !metricNameSegment.MatchString(c.Metrics.Subsystem) {
+			return fmt.Errorf("namespace and subsystem must match %s", metricNameSegment)
+		}
+
+		if c.Metrics.RouteLabel && len(c.Metrics.Routes) == 0 {
+			return errors.New("route_label requires at least one named route")
+		}
+		for i, route := range c.Metrics.Routes {
+			if route.Name == "" {
+				return fmt.Errorf("routes[%d]: name is required", i)
+			}
+			if !strings.HasPrefix(route.Path, "/") {
+				return fmt.Errorf("routes[%d]: path must start with /", i)
+			}
+		}
+
+		if c.Metrics.ShutdownGrace < 0 {
+			return errors.New("shutdown_grace must not be negative")
+		}
+
+		if rate := c.Metrics.RequestSampleRate(); rate < 0 || rate > 1 {
+			return fmt.Errorf("sample_rate must be between 0 and 1, got %g", rate)
+		}
 	}
 
 	return nil
 }
 
+// valid prefix segment of a Prometheus metric name
+var metricNameSegment = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 func (c *Config) validateCircuitBreakerConfig() error {
 	if c.CircuitBreaker.Enabled {
 		if c.CircuitBreaker.FailureThreshold <= 0 {
@@ -298,11 +1308,63 @@ func (c *Config) validateCircuitBreakerConfig() error {
 		if c.CircuitBreaker.Timeout <= 0 {
 			c.CircuitBreaker.Timeout = 60 * time.Second
 		}
+
+		switch c.CircuitBreaker.OpenBehavior {
+		case "":
+			c.CircuitBreaker.OpenBehavior = "fast_fail"
+		case "fast_fail", "probe":
+		default:
+			return fmt.Errorf("invalid open_behavior %q (supported: fast_fail, probe)", c.CircuitBreaker.OpenBehavior)
+		}
+
+		if c.CircuitBreaker.ProbeInterval <= 0 {
+			c.CircuitBreaker.ProbeInterval = 5 * time.Second
+		}
+		if c.CircuitBreaker.HalfOpenMaxRequests <= 0 {
+			c.CircuitBreaker.HalfOpenMaxRequests = 1
+		}
+		if c.CircuitBreaker.HalfOpenSuccessThreshold <= 0 {
+			c.CircuitBreaker.HalfOpenSuccessThreshold = 1
+		}
+
+		switch c.CircuitBreaker.Mode {
+		case "":
+			c.CircuitBreaker.Mode = CircuitBreakerConsecutive
+		case CircuitBreakerConsecutive:
+		case CircuitBreakerErrorRate:
+			if err := validateErrorRateConfig(&c.CircuitBreaker); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("invalid circuit breaker mode %q (supported: %s, %s)", c.CircuitBreaker.Mode, CircuitBreakerConsecutive, CircuitBreakerErrorRate)
+		}
 	}
 
 	return nil
 }
 
+func validateErrorRateConfig(cb *CircuitBreakerConfig) error {
+	if cb.ErrorRateThreshold < 0 || cb.ErrorRateThreshold > 100 {
+		return errors.New("error_rate_threshold must be between 0 and 100")
+	}
+	if cb.ErrorRateThreshold == 0 {
+		cb.ErrorRateThreshold = 50
+	}
+	if cb.RollingWindow < 0 {
+		return errors.New("rolling_window must not be negative")
+	}
+	if cb.RollingWindow == 0 {
+		cb.RollingWindow = 10 * time.Second
+	}
+	if cb.MinRequests < 0 {
+		return errors.New("min_requests must not be negative")
+	}
+	if cb.MinRequests == 0 {
+		cb.MinRequests = 20
+	}
+	return nil
+}
+
 func (c *Config) validateRetryConfig() error {
 	if c.Retry.Enabled {
 		if c.Retry.MaxAttempts <= 0 {
@@ -319,49 +1381,540 @@ func (c *Config) validateRetryConfig() error {
 		}
 	}
 
+	if onHeader := c.Retry.OnHeader; onHeader != nil {
+		if onHeader.Name == "" {
+			return errors.New("on_header requires a header name")
+		}
+		if onHeader.Value == "" {
+			onHeader.Value = "true"
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateTransportConfig() error {
+	if c.Transport.DialTimeout <= 0 {
+		c.Transport.DialTimeout = 5 * time.Second
+	}
+	if c.Transport.KeepAlive == 0 {
+		c.Transport.KeepAlive = 30 * time.Second
+	}
+
+	for host, addr := range c.Transport.Hosts {
+		if host == "" || addr == "" {
+			return fmt.Errorf("hosts entry %q -> %q must have both a hostname and an address", host, addr)
+		}
+	}
+
+	if c.Transport.Resolver != "" {
+		if _, _, err := net.SplitHostPort(c.Transport.Resolver); err != nil {
+			return fmt.Errorf("invalid resolver %q (expected host:port): %w", c.Transport.Resolver, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateLoggingConfig() error {
+	if c.Logging.SlowRequestThreshold < 0 {
+		return errors.New("slow_request_threshold must not be negative")
+	}
+
+	if accessLog := &c.Logging.AccessLog; accessLog.Enabled {
+		switch accessLog.Format {
+		case "":
+			accessLog.Format = AccessLogText
+		case AccessLogText, AccessLogJSON:
+		default:
+			return fmt.Errorf("access_log format must be text or json, got %q", accessLog.Format)
+		}
+	}
+
+	if capture := &c.Logging.Capture; capture.Enabled {
+		if capture.File == "" {
+			return errors.New("capture file is required")
+		}
+		if capture.SampleRate < 0 || capture.SampleRate > 1 {
+			return errors.New("capture sample_rate must be between 0 and 1")
+		}
+		if capture.SampleRate == 0 && capture.MatchHeader == "" {
+			return errors.New("capture needs a sample_rate or a match_header")
+		}
+		if capture.MaxBodyBytes <= 0 {
+			capture.MaxBodyBytes = 4 << 10 // 4KB
+		}
+		if len(capture.RedactHeaders) == 0 {
+			capture.RedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateErrorPagesConfig() error {
+	pages := map[string]string{
+		"bad_gateway":         c.ErrorPages.BadGateway,
+		"service_unavailable": c.ErrorPages.ServiceUnavailable,
+		"gateway_timeout":     c.ErrorPages.GatewayTimeout,
+		"maintenance":         c.ErrorPages.Maintenance,
+	}
+
+	for name, path := range pages {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("%s page: %w", name, err)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s page %q is a directory", name, path)
+		}
+	}
+
+	if c.ErrorPages.JSONTemplate != "" {
+		tmpl, err := template.New("json_template").Option("missingkey=error").Parse(c.ErrorPages.JSONTemplate)
+		if err != nil {
+			return fmt.Errorf("json_template: %w", err)
+		}
+		sample := map[string]string{"Message": `"Bad Gateway"`, "Code": "502", "RequestID": `"abc123"`}
+		var body bytes.Buffer
+		if err := tmpl.Execute(&body, sample); err != nil {
+			return fmt.Errorf("json_template: %w", err)
+		}
+		if !json.Valid(body.Bytes()) {
+			return fmt.Errorf("json_template renders invalid JSON: %s", body.String())
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateCompressionConfig() error {
+	if !c.Compression.Enabled {
+		return nil
+	}
+
+	if c.Compression.MinSize < 0 {
+		return errors.New("min_size must not be negative")
+	}
+	if c.Compression.MinSize == 0 {
+		c.Compression.MinSize = 1024
+	}
+
+	if len(c.Compression.ContentTypes) == 0 {
+		c.Compression.ContentTypes = []string{
+			"text/html", "text/plain", "text/css", "application/json", "application/javascript", "image/svg+xml",
+		}
+	}
+	for i, ct := range c.Compression.ContentTypes {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return fmt.Errorf("content_types[%d] %q: %w", i, ct, err)
+		}
+		c.Compression.ContentTypes[i] = mediaType
+	}
+
+	return nil
+}
+
+func (c *Config) validateAdminConfig() error {
+	if c.Admin.Enabled {
+		if c.Admin.Address == "" {
+			c.Admin.Address = "127.0.0.1"
+		}
+		if c.Admin.Port <= 0 || c.Admin.Port > 65535 {
+			c.Admin.Port = 9091
+		}
+		if c.Admin.DrainDelay < 0 {
+			return errors.New("drain_delay must not be negative")
+		}
+	}
+
 	return nil
 }
 
 func (c *Config) validateRateLimitConfig(rl *RateLimitConfig) error {
-	if rl != nil && rl.Enabled {
-		if rl.RequestsPerIP <= 0 {
-			return errors.New("requests_per_ip must be greater than 0")
+	if rl == nil || !rl.Enabled {
+		return nil
+	}
+
+	switch rl.Strategy {
+	case "":
+		rl.Strategy = RateLimitSlidingWindow
+	case RateLimitSlidingWindow, RateLimitTokenBucket:
+	default:
+		return fmt.Errorf("unsupported strategy %q (must be %q or %q)", rl.Strategy, RateLimitSlidingWindow, RateLimitTokenBucket)
+	}
+
+	if err := validateTarpitConfig(rl.Tarpit); err != nil {
+		return fmt.Errorf("tarpit: %w", err)
+	}
+
+	if rl.Strategy == RateLimitTokenBucket {
+		if rl.Rate <= 0 {
+			return errors.New("rate must be greater than 0")
+		}
+		if rl.Burst < 0 {
+			return errors.New("burst must not be negative")
 		}
-		if rl.WindowSize <= 0 {
-			return errors.New("window_size must be greater than 0")
+		if rl.Burst == 0 {
+			rl.Burst = int(math.Ceil(rl.Rate))
 		}
+		return nil
+	}
+
+	if rl.RequestsPerIP <= 0 {
+		return errors.New("requests_per_ip must be greater than 0")
+	}
+	if rl.WindowSize <= 0 {
+		return errors.New("window_size must be greater than 0")
 	}
 
 	return nil
 }
 
-func (c *Config) validateTLSConfig() error {
-	if !c.TLS.Enabled {
+func validateTarpitConfig(tarpit *TarpitConfig) error {
+	if tarpit == nil || !tarpit.Enabled {
 		return nil
 	}
 
-	// cert file path
-	if c.TLS.CertFile == "" {
-		return errors.New("cert_file is required when TLS is enabled")
+	if tarpit.Delay < 0 {
+		return errors.New("delay must not be negative")
+	}
+	if tarpit.Delay == 0 {
+		tarpit.Delay = 10 * time.Second
+	}
+	if tarpit.MaxConcurrent < 0 {
+		return errors.New("max_concurrent must not be negative")
+	}
+	if tarpit.MaxConcurrent == 0 {
+		tarpit.MaxConcurrent = 100
 	}
 
-	// key file path
-	if c.TLS.KeyFile == "" {
-		return errors.New("key_file is required when TLS is enabled")
+	return nil
+}
+
+func (c *Config) validateResponseRewriteConfig(rw *ResponseRewriteConfig) error {
+	if rw == nil || !rw.Enabled {
+		return nil
 	}
 
-	if _, err := os.Stat(c.TLS.CertFile); err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("cert_file not found: %s", c.TLS.CertFile)
+	if len(rw.Rules) == 0 {
+		return errors.New("at least one rule is required")
+	}
+	for i, rule := range rw.Rules {
+		if rule.Find == "" {
+			return fmt.Errorf("rule[%d]: find must not be empty", i)
 		}
-		return fmt.Errorf("error accessing cert_file: %w", err)
 	}
 
-	if _, err := os.Stat(c.TLS.KeyFile); err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("key_file not found: %s", c.TLS.KeyFile)
+	if len(rw.ContentTypes) == 0 {
+		rw.ContentTypes = []string{"text/html", "text/plain", "application/json"}
+	}
+	if rw.MaxBodyBytes <= 0 {
+		rw.MaxBodyBytes = 1 << 20 // 1MB
+	}
+
+	return nil
+}
+
+func (c *Config) validateHedgeConfig(hedge *HedgeConfig, backends []Backend) error {
+	if hedge == nil || !hedge.Enabled {
+		return nil
+	}
+
+	if hedge.Budget <= 0 {
+		return errors.New("budget must be positive")
+	}
+	if hedge.MaxConcurrent < 0 {
+		return errors.New("max_concurrent must not be negative")
+	}
+	if hedge.MaxConcurrent == 0 {
+		hedge.MaxConcurrent = 10
+	}
+
+	if len(backends) < 2 {
+		log.Printf("Warning: hedging needs a second backend to send requests to, it has no effect with one")
+	}
+
+	return nil
+}
+
+func (c *Config) validateMirrorConfig(mirror *MirrorConfig) error {
+	if mirror == nil || !mirror.Enabled {
+		return nil
+	}
+
+	if mirror.URL == "" {
+		return errors.New("url is required")
+	}
+	parsedURL, err := url.Parse(mirror.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url %q: %w", mirror.URL, err)
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return errors.New("url scheme must be http or https")
+	}
+
+	if mirror.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if mirror.Timeout == 0 {
+		mirror.Timeout = 5 * time.Second
+	}
+	if mirror.MaxConcurrent < 0 {
+		return errors.New("max_concurrent must not be negative")
+	}
+	if mirror.MaxConcurrent == 0 {
+		mirror.MaxConcurrent = 10
+	}
+
+	compare := mirror.Compare
+	if compare == nil || !compare.Enabled {
+		return nil
+	}
+
+	if compare.MaxBodyBytes < 0 {
+		return errors.New("compare max_body_bytes must not be negative")
+	}
+	if compare.MaxBodyBytes == 0 {
+		compare.MaxBodyBytes = 1 << 20 // 1MB
+	}
+	for _, name := range compare.Headers {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("compare headers must not be empty")
+		}
+	}
+	if !compare.Status && !compare.Body && len(compare.Headers) == 0 {
+		compare.Status = true
+	}
+
+	return nil
+}
+
+func validateFollowRedirectsConfig(follow *FollowRedirectsConfig) error {
+	if follow == nil || !follow.Enabled {
+		return nil
+	}
+
+	if follow.MaxHops < 0 {
+		return errors.New("max_hops must not be negative")
+	}
+	if follow.MaxHops == 0 {
+		follow.MaxHops = 5
+	}
+
+	return nil
+}
+
+// checks header names and values and returns the rules keyed by canonical
+// header name
+func validateHeaderRules(rules map[string]string) (map[string]string, error) {
+	if len(rules) == 0 {
+		return rules, nil
+	}
+
+	canonical := make(map[string]string, len(rules))
+	for name, value := range rules {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return nil, fmt.Errorf("header %s value must not contain CR, LF or NUL", name)
+		}
+
+		key := http.CanonicalHeaderKey(name)
+		if key == "Host" || key == "Content-Length" || key == "Transfer-Encoding" {
+			return nil, fmt.Errorf("header %s is managed by the proxy and cannot be changed", key)
+		}
+		if _, ok := canonical[key]; ok {
+			return nil, fmt.Errorf("header %s is listed more than once", key)
+		}
+		canonical[key] = value
+	}
+
+	return canonical, nil
+}
+
+// a non-empty RFC 7230 token
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r >= 0x7f || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Config) validateCacheConfig(cache *CacheConfig) error {
+	if cache == nil || !cache.Enabled {
+		return nil
+	}
+
+	if cache.TTL < 0 {
+		return errors.New("ttl must not be negative")
+	}
+	if cache.TTL == 0 {
+		cache.TTL = 60 * time.Second
+	}
+	if cache.MaxEntries <= 0 {
+		cache.MaxEntries = 1000
+	}
+	if cache.MaxBodyBytes <= 0 {
+		cache.MaxBodyBytes = 1 << 20 // 1MB
+	}
+
+	if len(cache.Key) == 0 {
+		cache.Key = []string{"method", "path", "query"}
+	}
+	for _, part := range cache.Key {
+		switch part {
+		case "method", "path", "query":
+		default:
+			return fmt.Errorf("invalid cache key part %q (supported: method, path, query)", part)
+		}
+	}
+
+	for _, header := range cache.VaryHeaders {
+		if header == "" {
+			return errors.New("vary_headers must not contain empty names")
 		}
-		return fmt.Errorf("error accessing key_file: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Config) validateCanaryConfig(canary *CanaryConfig, backends []Backend) error {
+	if canary == nil || !canary.Enabled {
+		return nil
+	}
+
+	found := false
+	for _, backend := range backends {
+		if backend.URL == canary.Backend {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("canary backend %q is not a backend of this upstream", canary.Backend)
+	}
+	if len(backends) < 2 {
+		return errors.New("canary needs at least one other backend")
+	}
+
+	ramp := &canary.Ramp
+	if ramp.Target == 0 {
+		ramp.Target = 100
+	}
+	if ramp.AbortErrorRate == 0 {
+		ramp.AbortErrorRate = 0.05
+	}
+
+	if ramp.Start <= 0 || ramp.Start > 100 {
+		return errors.New("ramp start must be between 0 and 100")
+	}
+	if ramp.Target < ramp.Start || ramp.Target > 100 {
+		return errors.New("ramp target must be between start and 100")
+	}
+	if ramp.Step <= 0 {
+		return errors.New("ramp step must be greater than 0")
+	}
+	if ramp.Interval <= 0 {
+		return errors.New("ramp interval must be greater than 0")
+	}
+	if ramp.AbortErrorRate < 0 || ramp.AbortErrorRate > 1 {
+		return errors.New("abort_error_rate must be between 0 and 1")
+	}
+
+	return nil
+}
+
+func (c *Config) validateConsistentHashConfig(ch *ConsistentHashConfig, algorithm string) error {
+	if ch == nil {
+		return nil
+	}
+
+	if algorithm != "consistent_hash" && algorithm != "bounded_consistent_hash" {
+		return fmt.Errorf("consistent_hash settings require a consistent hashing algorithm, got %s", algorithm)
+	}
+	if ch.VirtualNodes < 0 {
+		return errors.New("virtual_nodes must not be negative")
+	}
+	if ch.VirtualNodes == 0 {
+		ch.VirtualNodes = 100
+	}
+
+	return nil
+}
+
+func (c *Config) validateAdaptiveWeightConfig(aw *AdaptiveWeightConfig, algorithm string) error {
+	if aw == nil || !aw.Enabled {
+		return nil
+	}
+
+	if algorithm != "weighted_round_robin" {
+		return fmt.Errorf("adaptive weights require the weighted_round_robin algorithm, got %s", algorithm)
+	}
+	if aw.Header == "" {
+		return errors.New("header is required")
+	}
+	if aw.Decay <= 0 {
+		aw.Decay = 30 * time.Second
+	}
+
+	return nil
+}
+
+func (c *Config) validateErrorWeightConfig(ew *ErrorWeightConfig, algorithm string) error {
+	if ew == nil || !ew.Enabled {
+		return nil
+	}
+
+	if algorithm != "weighted_round_robin" {
+		return fmt.Errorf("error weights require the weighted_round_robin algorithm, got %s", algorithm)
+	}
+
+	if ew.Decay == 0 {
+		ew.Decay = 0.5
+	}
+	if ew.Decay <= 0 || ew.Decay >= 1 {
+		return fmt.Errorf("decay must be between 0 and 1, got %g", ew.Decay)
+	}
+	if ew.Recovery == 0 {
+		ew.Recovery = 1.5
+	}
+	if ew.Recovery <= 1 {
+		return fmt.Errorf("recovery must be above 1, got %g", ew.Recovery)
+	}
+	if ew.Floor == 0 {
+		ew.Floor = 0.1
+	}
+	if ew.Floor <= 0 || ew.Floor > 1 {
+		return fmt.Errorf("floor must be between 0 and 1, got %g", ew.Floor)
+	}
+
+	return nil
+}
+
+func (c *Config) validateTLSConfig() error {
+	if !c.TLS.Enabled {
+		return nil
+	}
+
+	// cert file path or inline PEM
+	if err := validatePEMSource("cert", c.TLS.CertFile, c.TLS.CertPEM); err != nil {
+		return err
+	}
+
+	// key file path or inline PEM
+	if err := validatePEMSource("key", c.TLS.KeyFile, c.TLS.KeyPEM); err != nil {
+		return err
 	}
 
 	if c.Server.HTTPSPort <= 0 || c.Server.HTTPSPort > 65535 {
@@ -378,20 +1931,120 @@ func (c *Config) validateTLSConfig() error {
 		}
 	}
 
+	if c.TLS.SessionTicketRotation < 0 {
+		return errors.New("session_ticket_rotation must not be negative")
+	}
+	if c.TLS.DisableSessionTickets && c.TLS.SessionTicketRotation > 0 {
+		log.Println("Warning: session_ticket_rotation has no effect when session tickets are disabled")
+	}
+
+	c.warnCipherSuiteVersionMismatch()
+
+	if err := c.validateClientAuth(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// client_auth modes that verify certificates need CAs to verify them against
+func (c *Config) validateClientAuth() error {
+	switch c.TLS.ClientAuth {
+	case "":
+		c.TLS.ClientAuth = ClientAuthNone
+	case ClientAuthNone, ClientAuthRequest, ClientAuthRequire, ClientAuthVerifyIfGiven, ClientAuthRequireAndVerify:
+	default:
+		return fmt.Errorf("invalid client_auth %q (supported: none, request, require, verify_if_given, require_and_verify)", c.TLS.ClientAuth)
+	}
+
+	verifies := c.TLS.ClientAuth == ClientAuthVerifyIfGiven || c.TLS.ClientAuth == ClientAuthRequireAndVerify
+	if c.TLS.ClientCAFile == "" {
+		if verifies {
+			return fmt.Errorf("client_ca_file is required with client_auth %q", c.TLS.ClientAuth)
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(c.TLS.ClientCAFile); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("client_ca_file not found: %s", c.TLS.ClientCAFile)
+		}
+		return fmt.Errorf("error accessing client_ca_file: %w", err)
+	}
+	if !verifies {
+		log.Printf("Warning: client_ca_file is only used with client_auth verify_if_given or require_and_verify")
+	}
+
 	return nil
 }
 
+// exactly one of <name>_file or <name>_pem must be set, and the file must exist
+func validatePEMSource(name, file, inline string) error {
+	if file == "" && inline == "" {
+		return fmt.Errorf("%s_file or %s_pem is required when TLS is enabled", name, name)
+	}
+	if file != "" && inline != "" {
+		return fmt.Errorf("%s_file and %s_pem are mutually exclusive", name, name)
+	}
+	if inline != "" {
+		return nil
+	}
+
+	if _, err := os.Stat(file); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s_file not found: %s", name, file)
+		}
+		return fmt.Errorf("error accessing %s_file: %w", name, err)
+	}
+
+	return nil
+}
+
+// TLS 1.3 suites are fixed by Go and never taken from cipher_suites
+var tls13CipherSuites = map[string]bool{
+	"TLS_AES_128_GCM_SHA256":       true,
+	"TLS_AES_256_GCM_SHA384":       true,
+	"TLS_CHACHA20_POLY1305_SHA256": true,
+}
+
 /*
- * loads config from yaml
+ * cipher_suites only applies to TLS 1.2 handshakes, so flag configs where
+ * the list silently has no effect or leaves TLS 1.2 without usable suites
+ */
+func (c *Config) warnCipherSuiteVersionMismatch() {
+	if len(c.TLS.CipherSuites) == 0 {
+		return
+	}
+
+	if c.TLS.MinVersion == "1.3" {
+		log.Println("Warning: tls.cipher_suites has no effect with min_version 1.3 (TLS 1.3 suites are not configurable)")
+		return
+	}
+
+	for _, name := range c.TLS.CipherSuites {
+		if !tls13CipherSuites[name] {
+			return
+		}
+	}
+	log.Println("Warning: tls.cipher_suites lists only TLS 1.3 suites, which are ignored; TLS 1.2 clients will have no usable cipher suites")
+}
+
+/*
+ * loads config from a file, stdin ("-") or an http(s) URL
  */
 func LoadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
+	data, asJSON, err := readConfig(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file %q: %w", path, err)
+		return nil, err
+	}
+
+	data, err = expandEnv(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand config file %q: %w", path, err)
 	}
 
 	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	if err := unmarshalConfig(data, asJSON, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file %q: %w", path, err)
 	}
 
@@ -402,12 +2055,168 @@ func LoadConfig(path string) (*Config, error) {
 	return &config, nil
 }
 
+// expandEnv replaces ${VAR} with values from the environment before the
+// config is parsed. ${VAR:-default} falls back to default when VAR is unset
+// or empty, and $$ is a literal $. Any other unset variable is an error.
+// Comments are left alone and a $ not followed by { is kept as written.
+func expandEnv(data []byte) ([]byte, error) {
+	var out strings.Builder
+	var missing []string
+
+	lookup := func(name string) string {
+		name, fallback, hasDefault := strings.Cut(name, ":-")
+		value, set := os.LookupEnv(name)
+		if hasDefault && value == "" {
+			return fallback
+		}
+		if !set && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+		return value
+	}
+
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		content, comment := splitComment(line)
+		for {
+			i := strings.IndexByte(content, '$')
+			if i < 0 || i == len(content)-1 {
+				break
+			}
+			out.WriteString(content[:i])
+			rest := content[i+1:]
+
+			switch {
+			case rest[0] == '$':
+				out.WriteByte('$')
+				content = rest[1:]
+				continue
+			case rest[0] == '{':
+				if end := strings.IndexByte(rest, '}'); end > 0 {
+					out.WriteString(lookup(rest[1:end]))
+					content = rest[end+1:]
+					continue
+				}
+			}
+			out.WriteByte('$')
+			content = rest
+		}
+		out.WriteString(content)
+		out.WriteString(comment)
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("unset environment variables: %s", strings.Join(missing, ", "))
+	}
+	return []byte(out.String()), nil
+}
+
+// splits a YAML line at its comment: a # at the start or after whitespace,
+// outside quotes
+func splitComment(line string) (content, comment string) {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			// only opens a quoted scalar where one can start
+			if i == 0 || strings.IndexByte(" \t[{,", line[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#':
+			if i == 0 || line[i-1] == ' ' || line[i-1] == '\t' {
+				return line[:i], line[i:]
+			}
+		}
+	}
+	return line, ""
+}
+
+// parses JSON or YAML. JSON is checked strictly, then decoded through YAML
+// like the rest so durations ("30s") and an explicit weight: 0 mean the
+// same in both formats.
+func unmarshalConfig(data []byte, asJSON bool, config *Config) error {
+	if asJSON {
+		var doc any
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
+		}
+		if _, err := decoder.Token(); err != io.EOF {
+			return errors.New("invalid JSON: unexpected data after the top-level value")
+		}
+
+		var err error
+		if data, err = yaml.Marshal(jsonNumbers(doc)); err != nil {
+			return err
+		}
+	}
+
+	return yaml.Unmarshal(data, config)
+}
+
+// turns json.Number values back into numbers, integers staying exact, so
+// YAML does not write them out as strings
+func jsonNumbers(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = jsonNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = jsonNumbers(item)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
 /*
- * loads config from file
- * if it doesnt exist, return default config
+ * loads config from file, stdin or URL
+ * if the file doesnt exist, warn and return default config
  */
 func LoadConfigWithDefaults(path string) (*Config, error) {
+	return loadConfigFile(path, false)
+}
+
+// ErrConfigNotFound is returned by LoadRequiredConfig for a missing config file
+var ErrConfigNotFound = errors.New("config file not found")
+
+// LoadRequiredConfig is LoadConfigWithDefaults without the fallback: a
+// missing config file is an error rather than an empty default config
+func LoadRequiredConfig(path string) (*Config, error) {
+	return loadConfigFile(path, true)
+}
+
+func loadConfigFile(path string, required bool) (*Config, error) {
+	if path == StdinSource || isURL(path) {
+		return LoadConfig(path)
+	}
+
 	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// a relative path is easy to get wrong from another working directory
+		resolved := path
+		if abs, err := filepath.Abs(path); err == nil {
+			resolved = abs
+		}
+
+		if required {
+			return nil, fmt.Errorf("%w: %s", ErrConfigNotFound, resolved)
+		}
+		log.Printf("WARNING: config file %s not found, starting with the default config and no upstreams; every request will get a 503", resolved)
 		return NewDefaultConfig(), nil
 	}
 
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StdinSource is the config path that reads the config from standard input
+const StdinSource = "-"
+
+// largest config accepted from stdin or a URL
+const maxConfigBytes = 10 << 20 // 10MB
+
+var (
+	stdin io.Reader = os.Stdin
+
+	// how long fetching a config URL may take, body included
+	configFetchTimeout = 10 * time.Second
+)
+
+// isURL reports whether path names a config served over HTTP(S)
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// IsReloadable reports whether the config at path can be read again, as a
+// file or URL can but stdin cannot
+func IsReloadable(path string) bool {
+	return path != "" && path != StdinSource
+}
+
+// reads the raw config from a file, stdin ("-") or an http(s) URL, along
+// with whether it is JSON
+func readConfig(path string) ([]byte, bool, error) {
+	switch {
+	case path == StdinSource:
+		data, err := readLimited(stdin)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read config from stdin: %w", err)
+		}
+		return data, false, nil
+
+	case isURL(path):
+		return fetchConfig(path)
+
+	default:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read config file %q: %w", path, err)
+		}
+		return data, strings.EqualFold(filepath.Ext(path), ".json"), nil
+	}
+}
+
+// fetches a config over HTTP(S); a JSON content type or a .json path marks
+// it as JSON
+func fetchConfig(rawURL string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), configFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid config URL %q: %w", rawURL, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch config from %s: %w", req.URL.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("failed to fetch config from %s: status %d", req.URL.Redacted(), resp.StatusCode)
+	}
+
+	data, err := readLimited(resp.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch config from %s: %w", req.URL.Redacted(), err)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	asJSON := mediaType == "application/json" || strings.EqualFold(filepath.Ext(req.URL.Path), ".json")
+	return data, asJSON, nil
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxConfigBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxConfigBytes {
+		return nil, fmt.Errorf("config is larger than %d bytes", maxConfigBytes)
+	}
+	return data, nil
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sourceTestConfig = `
+service: "test-lb"
+server:
+  port: 8085
+upstreams:
+  - name: "api"
+    backends:
+      - url: "http://localhost:3000"
+`
+
+func TestLoadConfigFromStdin(t *testing.T) {
+	original := stdin
+	t.Cleanup(func() { stdin = original })
+	stdin = strings.NewReader(sourceTestConfig)
+
+	cfg, err := LoadConfigWithDefaults(StdinSource)
+	if err != nil {
+		t.Fatalf("LoadConfigWithDefaults(-) error = %v", err)
+	}
+	if cfg.Service != "test-lb" || cfg.Server.Port != 8085 {
+		t.Errorf("Expected config read from stdin, got service %q port %d", cfg.Service, cfg.Server.Port)
+	}
+	if cfg.Upstreams[0].Backends[0].Weight != 1 {
+		t.Error("Expected validation defaults to be applied to a config from stdin")
+	}
+
+	stdin = strings.NewReader("upstreams: [")
+	if _, err := LoadConfig(StdinSource); err == nil {
+		t.Error("Expected invalid YAML on stdin to fail")
+	}
+}
+
+func TestLoadConfigFromURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.yaml":
+			w.Write([]byte(sourceTestConfig))
+		case "/config":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write([]byte(`{"service": "json-lb", "server": {"port": 8085}, "upstreams": [{"name": "api", "backends": [{"url": "http:\/\/localhost:3000"}]}]}`))
+		case "/invalid":
+			w.Write([]byte("upstreams: []"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		hasErr  bool
+		service string
+	}{
+		{name: "yaml", path: "/config.yaml", service: "test-lb"},
+		{name: "json content type", path: "/config", service: "json-lb"},
+		{name: "not found", path: "/missing", hasErr: true},
+		{name: "fails validation", path: "/invalid", hasErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfigWithDefaults(server.URL + tt.path)
+			if (err != nil) != tt.hasErr {
+				t.Fatalf("LoadConfigWithDefaults() error = %v, hasErr %v", err, tt.hasErr)
+			}
+			if !tt.hasErr && cfg.Service != tt.service {
+				t.Errorf("Expected service %q, got %q", tt.service, cfg.Service)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFromURLTimeout(t *testing.T) {
+	original := configFetchTimeout
+	t.Cleanup(func() { configFetchTimeout = original })
+	configFetchTimeout = 50 * time.Millisecond
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	start := time.Now()
+	if _, err := LoadConfig(server.URL + "/config.yaml"); err == nil {
+		t.Fatal("Expected a slow config server to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the fetch to give up after the timeout, took %s", elapsed)
+	}
+}
+
+func TestIsReloadable(t *testing.T) {
+	for path, expected := range map[string]bool{
+		"configs/example.yaml":            true,
+		"https://config.internal/lb.yaml": true,
+		StdinSource:                       false,
+		"":                                false,
+	} {
+		if got := IsReloadable(path); got != expected {
+			t.Errorf("IsReloadable(%q) = %v, want %v", path, got, expected)
+		}
+	}
+}
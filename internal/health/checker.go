@@ -2,17 +2,26 @@ package health
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/metrics"
 )
 
 type Status struct {
 	Healthy              bool
+	Degraded             bool // healthy but slow or failing below the threshold
 	LastCheck            time.Time
+	HealthySince         time.Time // when it last recovered from unhealthy, zero if it never failed
 	ConsecutiveSuccesses int
 	ConsecutiveFailures  int
 	mu                   sync.RWMutex
@@ -20,55 +29,129 @@ type Status struct {
 
 type Checker struct {
 	config      config.HealthConfig
+	expected    []config.StatusRange // status codes that count as healthy
 	statuses    map[string]*Status
 	statusMutex sync.RWMutex
 	client      *http.Client
+	metrics     *metrics.Collector
+	upstreams   map[string]string             // backend URL -> upstream name, for metric labels
+	stops       map[string]context.CancelFunc // per-backend check loops, keyed by URL
 	ctx         context.Context
 	cancel      context.CancelFunc
 	wg          sync.WaitGroup
+
+	passiveMu sync.Mutex
+	passive   map[string]*passiveState // backends with proxy failures, by URL
 }
 
 func NewChecker(cfg config.HealthConfig) *Checker {
 	ctx, cancel := context.WithCancel(context.Background())
 
+	// validated with the rest of the config
+	expected, _ := config.ParseStatusRanges(cfg.ExpectedStatus)
+	if len(expected) == 0 {
+		expected = []config.StatusRange{{Min: 200, Max: 299}}
+	}
+
+	client := &http.Client{
+		Timeout: cfg.Timeout,
+	}
+	// a backend expected to answer with a redirect is judged on the redirect itself
+	if expectsRedirect(expected) {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
 	return &Checker{
-		config:   cfg,
-		statuses: make(map[string]*Status),
-		client: &http.Client{
-			Timeout: cfg.Timeout,
-		},
-		ctx:    ctx,
-		cancel: cancel,
+		config:    cfg,
+		expected:  expected,
+		statuses:  make(map[string]*Status),
+		client:    client,
+		upstreams: make(map[string]string),
+		stops:     make(map[string]context.CancelFunc),
+		passive:   make(map[string]*passiveState),
+		ctx:       ctx,
+		cancel:    cancel,
 	}
 }
 
+// routes health checks through the same transport as proxied traffic, call before Start
+func (hc *Checker) SetTransport(transport http.RoundTripper) {
+	hc.client.Transport = transport
+}
+
+// publishes health and degraded state as metrics, call before Start
+func (hc *Checker) SetMetrics(collector *metrics.Collector) {
+	hc.metrics = collector
+}
+
 func (hc *Checker) Start(upstreams []config.Upstream) {
 	if !hc.config.Enabled {
 		log.Println("Health checker disabled")
 		return
 	}
 
-	hc.statusMutex.Lock()
+	hc.Update(upstreams)
+
+	hc.statusMutex.RLock()
+	count := len(hc.statuses)
+	hc.statusMutex.RUnlock()
+
+	log.Printf("Health checker started with %d backends", count)
+}
+
+// Update checks exactly the backends of upstreams: loops for removed backends
+// stop and their status is dropped, new backends start out healthy
+func (hc *Checker) Update(upstreams []config.Upstream) {
+	wanted := make(map[string]string)
 	for _, upstream := range upstreams {
 		for _, backend := range upstream.Backends {
-			if _, exists := hc.statuses[backend.URL]; !exists {
-				hc.statuses[backend.URL] = &Status{
-					Healthy:   true,
-					LastCheck: time.Now(),
-				}
-			}
+			wanted[backend.URL] = upstream.Name
 		}
 	}
-	hc.statusMutex.Unlock()
+	hc.prunePassive(wanted)
 
-	for _, upstream := range upstreams {
-		for _, backend := range upstream.Backends {
+	if !hc.config.Enabled {
+		return
+	}
+
+	hc.statusMutex.Lock()
+	defer hc.statusMutex.Unlock()
+
+	for url, stop := range hc.stops {
+		if _, keep := wanted[url]; !keep {
+			stop()
+			delete(hc.stops, url)
+			delete(hc.statuses, url)
+			delete(hc.upstreams, url)
+			log.Printf("Stopped health checks for %s", url)
+		}
+	}
+
+	for url, upstream := range wanted {
+		hc.upstreams[url] = upstream
+		if _, exists := hc.statuses[url]; !exists {
+			hc.statuses[url] = &Status{
+				Healthy:   true,
+				LastCheck: time.Now(),
+			}
+		}
+
+		if _, running := hc.stops[url]; !running {
+			ctx, stop := context.WithCancel(hc.ctx)
+			hc.stops[url] = stop
 			hc.wg.Add(1)
-			go hc.checkBackend(backend.URL)
+			go hc.checkBackend(ctx, url)
 		}
 	}
+}
 
-	log.Printf("Health checker started with %d backends", len(hc.statuses))
+// ActiveChecks is the number of backends with a running check loop
+func (hc *Checker) ActiveChecks() int {
+	hc.statusMutex.RLock()
+	defer hc.statusMutex.RUnlock()
+	return len(hc.stops)
 }
 
 func (hc *Checker) Stop() {
@@ -79,6 +162,10 @@ func (hc *Checker) Stop() {
 }
 
 func (hc *Checker) IsHealthy(backendURL string) bool {
+	if hc.ejected(backendURL) {
+		return false
+	}
+
 	hc.statusMutex.RLock()
 	defer hc.statusMutex.RUnlock()
 
@@ -92,20 +179,70 @@ func (hc *Checker) IsHealthy(backendURL string) bool {
 	return status.Healthy
 }
 
+// IsDegraded reports whether a healthy backend should get reduced traffic
+func (hc *Checker) IsDegraded(backendURL string) bool {
+	hc.statusMutex.RLock()
+	defer hc.statusMutex.RUnlock()
+
+	status, exists := hc.statuses[backendURL]
+	if !exists {
+		return false
+	}
+
+	status.mu.RLock()
+	defer status.mu.RUnlock()
+	return status.Degraded
+}
+
+// HealthySince reports when the backend last came back into rotation after
+// failing active checks or being ejected by passive ones; zero if it is out
+// of rotation or has never been
+func (hc *Checker) HealthySince(backendURL string) time.Time {
+	if hc.ejected(backendURL) {
+		return time.Time{}
+	}
+
+	since, healthy := hc.activeHealthySince(backendURL)
+	if !healthy {
+		return time.Time{}
+	}
+	if readmitted := hc.readmittedAt(backendURL); readmitted.After(since) {
+		since = readmitted
+	}
+	return since
+}
+
+// when active checks last marked the backend healthy, and whether it is now
+func (hc *Checker) activeHealthySince(backendURL string) (time.Time, bool) {
+	hc.statusMutex.RLock()
+	defer hc.statusMutex.RUnlock()
+
+	status, exists := hc.statuses[backendURL]
+	if !exists {
+		return time.Time{}, true
+	}
+
+	status.mu.RLock()
+	defer status.mu.RUnlock()
+	return status.HealthySince, status.Healthy
+}
+
 func (hc *Checker) GetStatus(backendURL string) *Status {
 	hc.statusMutex.RLock()
 	defer hc.statusMutex.RUnlock()
 
 	status, exists := hc.statuses[backendURL]
 	if !exists {
-		return &Status{Healthy: true, LastCheck: time.Time{}}
+		return &Status{Healthy: !hc.ejected(backendURL), LastCheck: time.Time{}}
 	}
 
 	status.mu.RLock()
 	defer status.mu.RUnlock()
 	return &Status{
-		Healthy:              status.Healthy,
+		Healthy:              status.Healthy && !hc.ejected(backendURL),
+		Degraded:             status.Degraded,
 		LastCheck:            status.LastCheck,
+		HealthySince:         status.HealthySince,
 		ConsecutiveSuccesses: status.ConsecutiveSuccesses,
 		ConsecutiveFailures:  status.ConsecutiveFailures,
 	}
@@ -113,62 +250,186 @@ func (hc *Checker) GetStatus(backendURL string) *Status {
 
 func (hc *Checker) GetAllStatuses() map[string]bool {
 	hc.statusMutex.RLock()
-	defer hc.statusMutex.RUnlock()
-
 	result := make(map[string]bool, len(hc.statuses))
 	for url, status := range hc.statuses {
 		status.mu.RLock()
 		result[url] = status.Healthy
 		status.mu.RUnlock()
 	}
+	hc.statusMutex.RUnlock()
 
+	hc.applyEjections(result)
 	return result
 }
 
-func (hc *Checker) checkBackend(backendURL string) {
+func (hc *Checker) checkBackend(ctx context.Context, backendURL string) {
 	defer hc.wg.Done()
 
-	ticker := time.NewTicker(hc.config.Interval)
-	defer ticker.Stop()
+	timer := time.NewTimer(hc.nextInterval())
+	defer timer.Stop()
 
 	log.Printf("Starting health checks for %s", backendURL)
 
 	for {
 		select {
-		case <-hc.ctx.Done():
+		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			hc.performHealthCheck(backendURL)
+		case <-timer.C:
+			hc.performHealthCheck(ctx, backendURL)
+			timer.Reset(hc.nextInterval())
 		}
 	}
 }
 
-func (hc *Checker) performHealthCheck(backendURL string) {
-	ctx, cancel := context.WithTimeout(hc.ctx, hc.config.Timeout)
+// the wait before the next probe; with interval_jitter each wait is drawn
+// afresh so backends checked on the same interval don't stay in lockstep
+func (hc *Checker) nextInterval() time.Duration {
+	jitter := hc.config.IntervalJitter
+	if jitter <= 0 {
+		return hc.config.Interval
+	}
+
+	factor := 1 + jitter*(2*rand.Float64()-1)
+	return time.Duration(float64(hc.config.Interval) * factor)
+}
+
+func (hc *Checker) performHealthCheck(ctx context.Context, backendURL string) {
+	if hc.config.Type == config.HealthCheckTCP {
+		hc.performTCPCheck(backendURL)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hc.config.Timeout)
 	defer cancel()
 
 	healthURL := backendURL + hc.config.Path
 
-	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
+	method := hc.config.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	if hc.config.Body != "" && methodHasBody(method) {
+		body = strings.NewReader(hc.config.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, healthURL, body)
 	if err != nil {
 		hc.updateBackendStatus(backendURL, false)
 		return
 	}
+	if body != nil && hc.config.ContentType != "" {
+		req.Header.Set("Content-Type", hc.config.ContentType)
+	}
 
+	start := time.Now()
 	resp, err := hc.client.Do(req)
 	if err != nil {
 		hc.updateBackendStatus(backendURL, false)
 		return
 	}
 	defer resp.Body.Close()
+	latency := time.Since(start)
+
+	healthy := hc.expectedStatus(resp.StatusCode)
+	hc.recordTimedProbe(backendURL, healthy, latency)
+}
+
+// healthy when the backend's host:port accepts a connection within the timeout
+func (hc *Checker) performTCPCheck(backendURL string) {
+	addr, err := dialAddress(backendURL)
+	if err != nil {
+		log.Printf("Health check for %s: %v", backendURL, err)
+		hc.updateBackendStatus(backendURL, false)
+		return
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, hc.config.Timeout)
+	if err != nil {
+		hc.updateBackendStatus(backendURL, false)
+		return
+	}
+	latency := time.Since(start)
+	conn.Close()
+
+	hc.recordTimedProbe(backendURL, true, latency)
+}
+
+// host:port of a backend URL, with the port implied by the scheme when omitted
+func dialAddress(backendURL string) (string, error) {
+	u, err := url.Parse(backendURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid backend URL: %w", err)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("backend URL %q has no host", backendURL)
+	}
+
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "http":
+			port = "80"
+		default:
+			return "", fmt.Errorf("backend URL %q has no port", backendURL)
+		}
+	}
 
-	healthy := resp.StatusCode >= 200 && resp.StatusCode < 300
-	hc.updateBackendStatus(backendURL, healthy)
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// applies max_latency and degraded_latency to a probe that got an answer
+func (hc *Checker) recordTimedProbe(backendURL string, healthy bool, latency time.Duration) {
+	// a backend that answers but too slowly is treated as failing
+	if healthy && hc.config.MaxLatency > 0 && latency > hc.config.MaxLatency {
+		log.Printf("Health check for %s took %s, exceeds max_latency %s", backendURL, latency, hc.config.MaxLatency)
+		healthy = false
+	}
+
+	slow := hc.config.DegradedLatency > 0 && latency > hc.config.DegradedLatency
+	hc.recordProbe(backendURL, healthy, slow)
+}
+
+func (hc *Checker) expectedStatus(code int) bool {
+	for _, sr := range hc.expected {
+		if sr.Contains(code) {
+			return true
+		}
+	}
+	return false
+}
+
+func expectsRedirect(expected []config.StatusRange) bool {
+	for _, sr := range expected {
+		if sr.Min < 400 && sr.Max >= 300 {
+			return true
+		}
+	}
+	return false
+}
+
+func methodHasBody(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	default:
+		return false
+	}
 }
 
 func (hc *Checker) updateBackendStatus(backendURL string, healthy bool) {
+	hc.recordProbe(backendURL, healthy, false)
+}
+
+// applies one probe result; slow marks a successful probe over degraded_latency
+func (hc *Checker) recordProbe(backendURL string, healthy, slow bool) {
 	hc.statusMutex.RLock()
 	status, exists := hc.statuses[backendURL]
+	upstream := hc.upstreams[backendURL]
 	hc.statusMutex.RUnlock()
 
 	if !exists {
@@ -177,6 +438,7 @@ func (hc *Checker) updateBackendStatus(backendURL string, healthy bool) {
 
 	status.mu.Lock()
 	defer status.mu.Unlock()
+	defer hc.publish(upstream, backendURL, status)
 
 	status.LastCheck = time.Now()
 	previouslyHealthy := status.Healthy
@@ -187,6 +449,7 @@ func (hc *Checker) updateBackendStatus(backendURL string, healthy bool) {
 
 		if !status.Healthy && status.ConsecutiveSuccesses >= hc.config.HealthyThreshold {
 			status.Healthy = true
+			status.HealthySince = status.LastCheck
 			log.Printf("Backend %s marked as HEALTHY (%d consecutive successes)",
 				backendURL, status.ConsecutiveSuccesses)
 		}
@@ -201,6 +464,22 @@ func (hc *Checker) updateBackendStatus(backendURL string, healthy bool) {
 		}
 	}
 
+	previouslyDegraded := status.Degraded
+	if healthy {
+		status.Degraded = status.Healthy && slow
+	} else {
+		// failing, but not yet enough to be pulled from rotation
+		status.Degraded = status.Healthy
+	}
+
+	if status.Degraded != previouslyDegraded && status.Healthy {
+		if status.Degraded {
+			log.Printf("Backend %s marked as DEGRADED", backendURL)
+		} else {
+			log.Printf("Backend %s no longer degraded", backendURL)
+		}
+	}
+
 	if previouslyHealthy != status.Healthy {
 		if status.Healthy {
 			log.Printf("✓ Backend %s recovered", backendURL)
@@ -209,3 +488,13 @@ func (hc *Checker) updateBackendStatus(backendURL string, healthy bool) {
 		}
 	}
 }
+
+// caller must hold status.mu
+func (hc *Checker) publish(upstream, backendURL string, status *Status) {
+	if hc.metrics == nil {
+		return
+	}
+
+	hc.metrics.UpdateBackendHealth(upstream, backendURL, status.Healthy)
+	hc.metrics.UpdateBackendDegraded(upstream, backendURL, status.Degraded)
+}
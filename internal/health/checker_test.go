@@ -1,6 +1,9 @@
 package health
 
 import (
+	"context"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -113,6 +116,61 @@ func TestCheckerGetAllStatuses(t *testing.T) {
 	}
 }
 
+func TestCheckerUpdate(t *testing.T) {
+	cfg := config.HealthConfig{
+		Enabled:            true,
+		Interval:           time.Minute,
+		Timeout:            1 * time.Second,
+		Path:               "/health",
+		UnhealthyThreshold: 1,
+		HealthyThreshold:   1,
+	}
+
+	checker := NewChecker(cfg)
+	defer checker.Stop()
+
+	checker.Start([]config.Upstream{{
+		Name: "test",
+		Backends: []config.Backend{
+			{URL: "http://backend1.com"},
+			{URL: "http://backend2.com"},
+		},
+	}})
+
+	// a kept backend's state must survive the update
+	checker.updateBackendStatus("http://backend1.com", false)
+
+	checker.Update([]config.Upstream{{
+		Name: "test",
+		Backends: []config.Backend{
+			{URL: "http://backend1.com"},
+			{URL: "http://backend3.com"},
+		},
+	}})
+
+	statuses := checker.GetAllStatuses()
+
+	if len(statuses) != 2 {
+		t.Errorf("Expected 2 statuses after update, got %v", statuses)
+	}
+	if _, exists := statuses["http://backend2.com"]; exists {
+		t.Error("Removed backend should no longer be tracked")
+	}
+	if healthy, exists := statuses["http://backend3.com"]; !exists || !healthy {
+		t.Error("Added backend should start out healthy")
+	}
+	if statuses["http://backend1.com"] {
+		t.Error("Kept backend should keep its unhealthy state")
+	}
+
+	checker.statusMutex.RLock()
+	running := len(checker.stops)
+	checker.statusMutex.RUnlock()
+	if running != 2 {
+		t.Errorf("Expected 2 check loops after update, got %d", running)
+	}
+}
+
 func TestHealthCheckHTTPRequests(t *testing.T) {
 	healthyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/health" {
@@ -163,6 +221,119 @@ func TestHealthCheckHTTPRequests(t *testing.T) {
 	}
 }
 
+func TestHealthCheckExpectedStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected []string
+		status   int
+		healthy  bool
+	}{
+		{name: "default accepts 2xx", status: http.StatusNoContent, healthy: true},
+		{name: "default follows redirects", status: http.StatusFound, healthy: true},
+		{name: "single code", expected: []string{"204"}, status: http.StatusNoContent, healthy: true},
+		{name: "single code rejects others", expected: []string{"204"}, status: http.StatusOK, healthy: false},
+		{name: "multiple codes", expected: []string{"200", "302"}, status: http.StatusFound, healthy: true},
+		{name: "class shorthand", expected: []string{"2xx"}, status: http.StatusAccepted, healthy: true},
+		{name: "class shorthand rejects others", expected: []string{"2xx"}, status: http.StatusServiceUnavailable, healthy: false},
+		{name: "range", expected: []string{"200-399"}, status: http.StatusMovedPermanently, healthy: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/elsewhere" {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				if tt.status >= 300 && tt.status < 400 {
+					w.Header().Set("Location", "/elsewhere")
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			checker := NewChecker(config.HealthConfig{
+				Enabled:            true,
+				Interval:           time.Hour,
+				Timeout:            time.Second,
+				Path:               "/health",
+				UnhealthyThreshold: 1,
+				HealthyThreshold:   1,
+				ExpectedStatus:     tt.expected,
+			})
+			defer checker.Stop()
+
+			checker.Start([]config.Upstream{{
+				Name:     "test",
+				Backends: []config.Backend{{URL: server.URL}},
+			}})
+
+			checker.performHealthCheck(context.Background(), server.URL)
+
+			if healthy := checker.IsHealthy(server.URL); healthy != tt.healthy {
+				t.Errorf("Expected healthy=%v for status %d, got %v", tt.healthy, tt.status, healthy)
+			}
+		})
+	}
+}
+
+func TestHealthCheckPostBody(t *testing.T) {
+	expectedBody := `{"check":"deep"}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method == http.MethodPost &&
+			r.Header.Get("Content-Type") == "application/json" &&
+			string(body) == expectedBody {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		healthy bool
+	}{
+		{name: "POST with expected body", method: http.MethodPost, body: expectedBody, healthy: true},
+		{name: "POST with wrong body", method: http.MethodPost, body: `{"check":"shallow"}`, healthy: false},
+		{name: "GET ignores body", method: http.MethodGet, body: expectedBody, healthy: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.HealthConfig{
+				Enabled:            true,
+				Interval:           50 * time.Millisecond,
+				Timeout:            1 * time.Second,
+				Path:               "/health",
+				UnhealthyThreshold: 1,
+				HealthyThreshold:   1,
+				Method:             tt.method,
+				Body:               tt.body,
+				ContentType:        "application/json",
+			}
+
+			checker := NewChecker(cfg)
+			defer checker.Stop()
+
+			checker.Start([]config.Upstream{{
+				Name:     "test",
+				Backends: []config.Backend{{URL: server.URL}},
+			}})
+
+			time.Sleep(200 * time.Millisecond)
+
+			if checker.IsHealthy(server.URL) != tt.healthy {
+				t.Errorf("Expected healthy=%v, got %v", tt.healthy, checker.IsHealthy(server.URL))
+			}
+		})
+	}
+}
+
 func TestHealthCheckThresholds(t *testing.T) {
 	requestCount := 0
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -211,6 +382,153 @@ func TestHealthCheckThresholds(t *testing.T) {
 	}
 }
 
+func TestTCPHealthCheck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	backendURL := "http://" + listener.Addr().String()
+
+	cfg := config.HealthConfig{
+		Enabled:            true,
+		Type:               config.HealthCheckTCP,
+		Interval:           time.Hour,
+		Timeout:            time.Second,
+		UnhealthyThreshold: 1,
+		HealthyThreshold:   1,
+	}
+
+	checker := NewChecker(cfg)
+	defer checker.Stop()
+
+	checker.Start([]config.Upstream{{
+		Name:     "db",
+		Backends: []config.Backend{{URL: backendURL}},
+	}})
+
+	checker.performHealthCheck(context.Background(), backendURL)
+	if status := checker.GetStatus(backendURL); !status.Healthy || status.ConsecutiveSuccesses != 1 {
+		t.Errorf("Expected a successful TCP probe, got %+v", status)
+	}
+
+	listener.Close()
+
+	checker.performHealthCheck(context.Background(), backendURL)
+	if checker.IsHealthy(backendURL) {
+		t.Error("Backend should be unhealthy once it stops accepting connections")
+	}
+}
+
+func TestDialAddress(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+		hasErr   bool
+	}{
+		{url: "http://db.internal:5432", expected: "db.internal:5432"},
+		{url: "http://db.internal", expected: "db.internal:80"},
+		{url: "https://db.internal", expected: "db.internal:443"},
+		{url: "http://[::1]:5432", expected: "[::1]:5432"},
+		{url: "tcp://db.internal", hasErr: true},
+		{url: "http://", hasErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			addr, err := dialAddress(tt.url)
+			if (err != nil) != tt.hasErr {
+				t.Errorf("dialAddress() error = %v, hasErr %v", err, tt.hasErr)
+				return
+			}
+			if addr != tt.expected {
+				t.Errorf("dialAddress() = %q, expected %q", addr, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckerIntervalJitter(t *testing.T) {
+	interval := 100 * time.Millisecond
+	checker := NewChecker(config.HealthConfig{Interval: interval, IntervalJitter: 0.2})
+
+	low, high := 80*time.Millisecond, 120*time.Millisecond
+	shortest, longest := high, low
+	for i := 0; i < 1000; i++ {
+		wait := checker.nextInterval()
+		if wait < low || wait > high {
+			t.Fatalf("Wait %s outside the ±20%% band [%s, %s]", wait, low, high)
+		}
+		shortest = min(shortest, wait)
+		longest = max(longest, wait)
+	}
+
+	// every tick draws a new wait, spread across most of the band
+	if longest-shortest < 30*time.Millisecond {
+		t.Errorf("Expected waits to vary across the band, got %s to %s", shortest, longest)
+	}
+
+	if wait := NewChecker(config.HealthConfig{Interval: interval}).nextInterval(); wait != interval {
+		t.Errorf("Expected exactly %s without jitter, got %s", interval, wait)
+	}
+}
+
+func TestCheckerJitteredProbeTiming(t *testing.T) {
+	probes := make(chan time.Time, 64)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes <- time.Now()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	checker := NewChecker(config.HealthConfig{
+		Enabled:            true,
+		Interval:           40 * time.Millisecond,
+		IntervalJitter:     0.5,
+		Timeout:            time.Second,
+		Path:               "/health",
+		UnhealthyThreshold: 1,
+		HealthyThreshold:   1,
+	})
+	defer checker.Stop()
+
+	checker.Start([]config.Upstream{{Name: "test", Backends: []config.Backend{{URL: server.URL}}}})
+
+	var gaps []time.Duration
+	last := <-probes
+	for len(gaps) < 10 {
+		select {
+		case probe := <-probes:
+			gaps = append(gaps, probe.Sub(last))
+			last = probe
+		case <-time.After(time.Second):
+			t.Fatalf("Expected regular probes, got %d gaps", len(gaps))
+		}
+	}
+
+	// 20ms to 60ms, with some slack for scheduling
+	shortest, longest := gaps[0], gaps[0]
+	for _, gap := range gaps {
+		if gap < 15*time.Millisecond || gap > 80*time.Millisecond {
+			t.Errorf("Probe gap %s outside the jitter band", gap)
+		}
+		shortest = min(shortest, gap)
+		longest = max(longest, gap)
+	}
+	if longest-shortest < 5*time.Millisecond {
+		t.Errorf("Expected probe gaps to vary, got %v", gaps)
+	}
+}
+
 func TestCheckerDisabled(t *testing.T) {
 	cfg := config.HealthConfig{
 		Enabled: false,
@@ -264,6 +582,129 @@ func TestCheckerTimeout(t *testing.T) {
 	}
 }
 
+func TestCheckerMaxLatency(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		maxLatency time.Duration
+		healthy    bool
+	}{
+		{name: "slow backend over max latency", maxLatency: 30 * time.Millisecond, healthy: false},
+		{name: "max latency disabled", maxLatency: 0, healthy: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.HealthConfig{
+				Enabled:            true,
+				Interval:           50 * time.Millisecond,
+				Timeout:            1 * time.Second,
+				Path:               "/health",
+				UnhealthyThreshold: 1,
+				HealthyThreshold:   1,
+				MaxLatency:         tt.maxLatency,
+			}
+
+			checker := NewChecker(cfg)
+			defer checker.Stop()
+
+			checker.Start([]config.Upstream{{
+				Name:     "test",
+				Backends: []config.Backend{{URL: server.URL}},
+			}})
+
+			time.Sleep(300 * time.Millisecond)
+
+			if checker.IsHealthy(server.URL) != tt.healthy {
+				t.Errorf("Expected healthy=%v, got %v", tt.healthy, checker.IsHealthy(server.URL))
+			}
+		})
+	}
+}
+
+func TestCheckerDegradedTransitions(t *testing.T) {
+	cfg := config.HealthConfig{
+		Enabled:            true,
+		Interval:           time.Hour,
+		Timeout:            1 * time.Second,
+		Path:               "/health",
+		UnhealthyThreshold: 3,
+		HealthyThreshold:   1,
+		DegradedLatency:    100 * time.Millisecond,
+	}
+
+	checker := NewChecker(cfg)
+	defer checker.Stop()
+
+	backend := "http://test.com"
+	checker.Start([]config.Upstream{{
+		Name:     "test",
+		Backends: []config.Backend{{URL: backend}},
+	}})
+
+	checker.recordProbe(backend, true, true)
+	if !checker.IsDegraded(backend) || !checker.IsHealthy(backend) {
+		t.Error("Slow successful probe should mark a healthy backend degraded")
+	}
+
+	checker.recordProbe(backend, true, false)
+	if checker.IsDegraded(backend) {
+		t.Error("Fast successful probe should clear degraded")
+	}
+
+	checker.recordProbe(backend, false, false)
+	if !checker.IsDegraded(backend) || !checker.IsHealthy(backend) {
+		t.Error("Failure below the unhealthy threshold should mark the backend degraded")
+	}
+
+	checker.recordProbe(backend, false, false)
+	checker.recordProbe(backend, false, false)
+	if checker.IsHealthy(backend) || checker.IsDegraded(backend) {
+		t.Error("Unhealthy backend should not also be reported as degraded")
+	}
+
+	if status := checker.GetStatus(backend); status.Degraded {
+		t.Error("GetStatus() should reflect the degraded flag")
+	}
+}
+
+func TestCheckerDegradedLatency(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := config.HealthConfig{
+		Enabled:            true,
+		Interval:           50 * time.Millisecond,
+		Timeout:            1 * time.Second,
+		Path:               "/health",
+		UnhealthyThreshold: 1,
+		HealthyThreshold:   1,
+		DegradedLatency:    20 * time.Millisecond,
+	}
+
+	checker := NewChecker(cfg)
+	defer checker.Stop()
+
+	checker.Start([]config.Upstream{{
+		Name:     "test",
+		Backends: []config.Backend{{URL: server.URL}},
+	}})
+
+	time.Sleep(300 * time.Millisecond)
+
+	if !checker.IsHealthy(server.URL) || !checker.IsDegraded(server.URL) {
+		t.Error("Slow backend should stay healthy but be marked degraded")
+	}
+}
+
 func TestGetStatus(t *testing.T) {
 	cfg := config.HealthConfig{
 		Enabled:            true,
@@ -299,3 +740,99 @@ func TestGetStatus(t *testing.T) {
 		t.Error("Known backend should have non-zero LastCheck time")
 	}
 }
+
+func TestCheckerPassiveEjection(t *testing.T) {
+	checker := NewChecker(config.HealthConfig{
+		Enabled: false,
+		Passive: config.PassiveHealthConfig{Enabled: true, FailureThreshold: 3, CoolDown: 50 * time.Millisecond},
+	})
+	defer checker.Stop()
+
+	backend := "http://backend1.com"
+
+	checker.UpdateFromProxy(backend, false)
+	checker.UpdateFromProxy(backend, false)
+	checker.UpdateFromProxy(backend, true)
+	checker.UpdateFromProxy(backend, false)
+	checker.UpdateFromProxy(backend, false)
+	if !checker.IsHealthy(backend) {
+		t.Fatal("Expected a success to reset the failure count")
+	}
+
+	checker.UpdateFromProxy(backend, false)
+	if checker.IsHealthy(backend) {
+		t.Fatal("Expected backend to be ejected after 3 consecutive failures")
+	}
+	if healthy, exists := checker.GetAllStatuses()[backend]; !exists || healthy {
+		t.Errorf("Expected GetAllStatuses to report the ejected backend unhealthy, got %v (exists %v)", healthy, exists)
+	}
+	if checker.GetStatus(backend).Healthy {
+		t.Error("Expected GetStatus to report the ejected backend unhealthy")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !checker.IsHealthy(backend) {
+		t.Error("Expected backend to be let back in after the cool-down")
+	}
+}
+
+func TestCheckerPassiveDisabled(t *testing.T) {
+	checker := NewChecker(config.HealthConfig{Enabled: false})
+	defer checker.Stop()
+
+	for i := 0; i < 10; i++ {
+		checker.UpdateFromProxy("http://backend1.com", false)
+	}
+	if !checker.IsHealthy("http://backend1.com") {
+		t.Error("Expected proxy failures to be ignored without passive checks")
+	}
+}
+
+func TestCheckerHealthySince(t *testing.T) {
+	checker := NewChecker(config.HealthConfig{
+		Enabled:            true,
+		Interval:           time.Hour,
+		Timeout:            1 * time.Second,
+		Path:               "/health",
+		UnhealthyThreshold: 1,
+		HealthyThreshold:   2,
+		Passive:            config.PassiveHealthConfig{Enabled: true, FailureThreshold: 1, CoolDown: 50 * time.Millisecond},
+	})
+	defer checker.Stop()
+
+	backend := "http://test.com"
+	checker.Start([]config.Upstream{{
+		Name:     "test",
+		Backends: []config.Backend{{URL: backend}},
+	}})
+
+	if since := checker.HealthySince(backend); !since.IsZero() {
+		t.Errorf("Expected zero HealthySince for a backend that never failed, got %v", since)
+	}
+
+	checker.recordProbe(backend, false, false)
+	checker.recordProbe(backend, true, false)
+	if since := checker.HealthySince(backend); !since.IsZero() {
+		t.Errorf("Expected zero HealthySince while unhealthy, got %v", since)
+	}
+
+	before := time.Now()
+	checker.recordProbe(backend, true, false)
+	recovered := checker.HealthySince(backend)
+	if recovered.Before(before) || recovered.After(time.Now()) {
+		t.Errorf("Expected HealthySince at the recovering probe, got %v", recovered)
+	}
+	if !checker.GetStatus(backend).HealthySince.Equal(recovered) {
+		t.Error("GetStatus() should reflect HealthySince")
+	}
+
+	checker.UpdateFromProxy(backend, false)
+	if since := checker.HealthySince(backend); !since.IsZero() {
+		t.Errorf("Expected zero HealthySince while ejected, got %v", since)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if since := checker.HealthySince(backend); !since.After(recovered) {
+		t.Errorf("Expected HealthySince to move to the end of the ejection, got %v", since)
+	}
+}
@@ -0,0 +1,112 @@
+package health
+
+import (
+	"log"
+	"time"
+)
+
+// consecutive proxy failures for a backend and, once ejected, when it may
+// take traffic again
+type passiveState struct {
+	failures     int
+	ejectedUntil time.Time
+}
+
+// UpdateFromProxy records the outcome of a proxied request. With passive
+// checks on, a backend that fails failure_threshold requests in a row is
+// reported unhealthy for cool_down, then let back in.
+func (hc *Checker) UpdateFromProxy(backendURL string, success bool) {
+	cfg := hc.config.Passive
+	if !cfg.Enabled {
+		return
+	}
+
+	hc.passiveMu.Lock()
+	defer hc.passiveMu.Unlock()
+
+	state, exists := hc.passive[backendURL]
+	if !exists {
+		if success {
+			return
+		}
+		state = &passiveState{}
+		hc.passive[backendURL] = state
+	}
+
+	if success {
+		state.failures = 0
+		return
+	}
+
+	now := time.Now()
+	// requests that were already in flight when it was ejected
+	if now.Before(state.ejectedUntil) {
+		return
+	}
+
+	state.failures++
+	if state.failures >= cfg.FailureThreshold {
+		state.failures = 0
+		state.ejectedUntil = now.Add(cfg.CoolDown)
+		log.Printf("Backend %s ejected for %s after %d consecutive proxy failures", backendURL, cfg.CoolDown, cfg.FailureThreshold)
+	}
+}
+
+// whether passive checks have the backend out of rotation right now
+func (hc *Checker) ejected(backendURL string) bool {
+	if !hc.config.Passive.Enabled {
+		return false
+	}
+
+	hc.passiveMu.Lock()
+	defer hc.passiveMu.Unlock()
+
+	state, exists := hc.passive[backendURL]
+	return exists && time.Now().Before(state.ejectedUntil)
+}
+
+// when the backend's last passive ejection ended, zero if it was never
+// ejected or still is
+func (hc *Checker) readmittedAt(backendURL string) time.Time {
+	if !hc.config.Passive.Enabled {
+		return time.Time{}
+	}
+
+	hc.passiveMu.Lock()
+	defer hc.passiveMu.Unlock()
+
+	state, exists := hc.passive[backendURL]
+	if !exists || time.Now().Before(state.ejectedUntil) {
+		return time.Time{}
+	}
+	return state.ejectedUntil
+}
+
+// marks currently ejected backends unhealthy in statuses
+func (hc *Checker) applyEjections(statuses map[string]bool) {
+	if !hc.config.Passive.Enabled {
+		return
+	}
+
+	hc.passiveMu.Lock()
+	defer hc.passiveMu.Unlock()
+
+	now := time.Now()
+	for url, state := range hc.passive {
+		if now.Before(state.ejectedUntil) {
+			statuses[url] = false
+		}
+	}
+}
+
+// drops passive state for backends no longer configured
+func (hc *Checker) prunePassive(wanted map[string]string) {
+	hc.passiveMu.Lock()
+	defer hc.passiveMu.Unlock()
+
+	for url := range hc.passive {
+		if _, keep := wanted[url]; !keep {
+			delete(hc.passive, url)
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -20,9 +22,26 @@ type Collector struct {
 	registry *prometheus.Registry
 
 	requestsTotal     *prometheus.CounterVec
+	upstreamRequests  *prometheus.CounterVec
 	requestDuration   *prometheus.HistogramVec
 	upstreamHealthy   *prometheus.GaugeVec
+	backendDegraded   *prometheus.GaugeVec
 	connectionsActive prometheus.Gauge
+	backendConns      *prometheus.CounterVec
+	clientDisconnects *prometheus.CounterVec
+	retriesTotal      *prometheus.CounterVec
+	rateLimited       *prometheus.CounterVec
+	breakerState      *prometheus.GaugeVec
+	breakerTrips      *prometheus.CounterVec
+	shadowDiffs       *prometheus.CounterVec
+	selectionDuration *prometheus.HistogramVec
+	rates             *rateCollector
+	internal          *internalCollector
+
+	routes *routeMatcher // nil unless the route label is enabled
+
+	// share of requests recorded in requestsTotal and requestDuration
+	sampleRate float64
 
 	mu sync.RWMutex
 }
@@ -30,53 +49,218 @@ type Collector struct {
 func NewCollector(cfg config.MetricsConfig) *Collector {
 	registry := prometheus.NewRegistry()
 
+	// empty values keep the historical isame_lb_ prefix
+	namespace := cfg.Namespace
+	if namespace == "" {
+		namespace = "isame"
+	}
+	subsystem := cfg.Subsystem
+	if subsystem == "" {
+		subsystem = "lb"
+	}
+
+	requestLabels := []string{"upstream", "backend", "method", "status"}
+	durationLabels := []string{"upstream", "backend", "method"}
+
+	var routes *routeMatcher
+	if cfg.RouteLabel && len(cfg.Routes) > 0 {
+		routes = newRouteMatcher(cfg.Routes)
+		requestLabels = append(requestLabels, "route")
+		durationLabels = append(durationLabels, "route")
+	}
+
 	requestsTotal := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "isame_lb_requests_total",
-			Help: "Total number of requests processed by the load balancer",
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "Total number of requests processed by the load balancer",
+		},
+		requestLabels,
+	)
+
+	upstreamRequests := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "upstream_requests_total",
+			Help:      "Total number of requests per upstream by status class, never sampled",
 		},
-		[]string{"upstream", "backend", "method", "status"},
+		[]string{"upstream", "code_class"},
 	)
 
 	requestDuration := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
-			Name:    "isame_lb_request_duration_seconds",
-			Help:    "Time spent processing requests in seconds",
-			Buckets: prometheus.DefBuckets,
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Time spent processing requests in seconds",
+			Buckets:   prometheus.DefBuckets,
 		},
-		[]string{"upstream", "backend", "method"},
+		durationLabels,
 	)
 
 	upstreamHealthy := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "isame_lb_upstream_healthy",
-			Help: "Whether upstream backend is healthy (1 = healthy, 0 = unhealthy)",
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "upstream_healthy",
+			Help:      "Whether upstream backend is healthy (1 = healthy, 0 = unhealthy)",
+		},
+		[]string{"upstream", "backend"},
+	)
+
+	backendDegraded := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "backend_degraded",
+			Help:      "Whether a healthy backend is degraded and receiving reduced traffic (1 = degraded)",
 		},
 		[]string{"upstream", "backend"},
 	)
 
 	connectionsActive := prometheus.NewGauge(
 		prometheus.GaugeOpts{
-			Name: "isame_lb_active_connections",
-			Help: "Current number of active connections",
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "active_connections",
+			Help:      "Current number of active connections",
+		},
+	)
+
+	backendConns := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "backend_connections_total",
+			Help:      "Backend connections used for proxied requests, by whether they were reused from the pool",
+		},
+		[]string{"upstream", "backend", "reused"},
+	)
+
+	clientDisconnects := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "client_disconnects_total",
+			Help:      "Requests abandoned by the client before the response completed",
+		},
+		[]string{"upstream"},
+	)
+
+	retriesTotal := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "retries_total",
+			Help:      "Requests retried after a failed attempt, by the backend that failed",
+		},
+		[]string{"upstream", "backend"},
+	)
+
+	rateLimited := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "rate_limited_total",
+			Help:      "Requests rejected with 429 by the upstream's rate limit",
+		},
+		[]string{"upstream"},
+	)
+
+	breakerState := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "circuit_breaker_state",
+			Help:      "Circuit breaker state per backend (0 = closed, 1 = open, 2 = half-open)",
+		},
+		[]string{"upstream", "backend"},
+	)
+
+	breakerTrips := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "circuit_breaker_trips_total",
+			Help:      "Times a backend's circuit breaker opened on failures",
+		},
+		[]string{"upstream", "backend"},
+	)
+
+	shadowDiffs := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "shadow_diffs_total",
+			Help:      "Mirrored responses that differed from the proxied response, by the field that differed",
+		},
+		[]string{"upstream", "field"},
+	)
+
+	selectionDuration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "selection_duration_seconds",
+			Help:      "Time spent picking a backend, including the health snapshot and circuit breaker check",
+			Buckets:   prometheus.ExponentialBuckets(1e-6, 4, 10), // 1µs to ~0.26s
 		},
+		[]string{"upstream"},
 	)
 
+	rates := newRateCollector(namespace, subsystem)
+	internal := newInternalCollector(namespace, subsystem)
+
 	registry.MustRegister(requestsTotal)
+	registry.MustRegister(upstreamRequests)
 	registry.MustRegister(requestDuration)
 	registry.MustRegister(upstreamHealthy)
+	registry.MustRegister(backendDegraded)
 	registry.MustRegister(connectionsActive)
+	registry.MustRegister(backendConns)
+	registry.MustRegister(clientDisconnects)
+	registry.MustRegister(retriesTotal)
+	registry.MustRegister(rateLimited)
+	registry.MustRegister(breakerState)
+	registry.MustRegister(breakerTrips)
+	registry.MustRegister(shadowDiffs)
+	registry.MustRegister(selectionDuration)
+	registry.MustRegister(rates)
+	registry.MustRegister(internal)
 
 	return &Collector{
 		config:            cfg,
 		registry:          registry,
 		requestsTotal:     requestsTotal,
+		upstreamRequests:  upstreamRequests,
 		requestDuration:   requestDuration,
 		upstreamHealthy:   upstreamHealthy,
+		backendDegraded:   backendDegraded,
 		connectionsActive: connectionsActive,
+		backendConns:      backendConns,
+		clientDisconnects: clientDisconnects,
+		retriesTotal:      retriesTotal,
+		rateLimited:       rateLimited,
+		breakerState:      breakerState,
+		breakerTrips:      breakerTrips,
+		shadowDiffs:       shadowDiffs,
+		selectionDuration: selectionDuration,
+		rates:             rates,
+		internal:          internal,
+		routes:            routes,
+		sampleRate:        cfg.RequestSampleRate(),
 	}
 }
 
+// Handler serves the collector's registry in the Prometheus exposition format
+// serves the text format by default, OpenMetrics to clients that ask for it
+// with Accept: application/openmetrics-text
+func (c *Collector) Handler() http.Handler {
+	return promhttp.HandlerFor(c.registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
 func (c *Collector) Start() error {
 	if !c.config.Enabled {
 		log.Println("Metrics collector disabled")
@@ -84,7 +268,7 @@ func (c *Collector) Start() error {
 	}
 
 	mux := http.NewServeMux()
-	mux.Handle(c.config.Path, promhttp.HandlerFor(c.registry, promhttp.HandlerOpts{}))
+	mux.Handle(c.config.Path, c.Handler())
 
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -129,6 +313,21 @@ func (c *Collector) Stop() error {
 }
 
 func (c *Collector) RecordRequest(upstream, backend, method, status string, duration time.Duration) {
+	c.RecordRouteRequest(upstream, backend, method, status, unmatchedRoute, duration)
+}
+
+// Route maps a request path to its configured route name, or "" when the
+// route label is disabled
+func (c *Collector) Route(path string) string {
+	if c.routes == nil {
+		return ""
+	}
+	return c.routes.match(path)
+}
+
+// RecordRouteRequest records a request with its route label; route is
+// ignored unless the route label is enabled
+func (c *Collector) RecordRouteRequest(upstream, backend, method, status, route string, duration time.Duration) {
 	if !c.config.Enabled {
 		return
 	}
@@ -136,8 +335,32 @@ func (c *Collector) RecordRequest(upstream, backend, method, status string, dura
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	c.requestsTotal.WithLabelValues(upstream, backend, method, status).Inc()
-	c.requestDuration.WithLabelValues(upstream, backend, method).Observe(duration.Seconds())
+	c.upstreamRequests.WithLabelValues(upstream, codeClass(status)).Inc()
+	if !c.sampled() {
+		return
+	}
+
+	if c.routes == nil {
+		c.requestsTotal.WithLabelValues(upstream, backend, method, status).Inc()
+		c.requestDuration.WithLabelValues(upstream, backend, method).Observe(duration.Seconds())
+		return
+	}
+
+	c.requestsTotal.WithLabelValues(upstream, backend, method, status, route).Inc()
+	c.requestDuration.WithLabelValues(upstream, backend, method, route).Observe(duration.Seconds())
+}
+
+// whether this request goes into the sampled series
+func (c *Collector) sampled() bool {
+	return c.sampleRate >= 1 || (c.sampleRate > 0 && rand.Float64() < c.sampleRate)
+}
+
+// "2xx" for "200"
+func codeClass(status string) string {
+	if len(status) != 3 {
+		return "unknown"
+	}
+	return status[:1] + "xx"
 }
 
 func (c *Collector) UpdateBackendHealth(upstream, backend string, healthy bool) {
@@ -155,6 +378,21 @@ func (c *Collector) UpdateBackendHealth(upstream, backend string, healthy bool)
 	c.upstreamHealthy.WithLabelValues(upstream, backend).Set(value)
 }
 
+func (c *Collector) UpdateBackendDegraded(upstream, backend string, degraded bool) {
+	if !c.config.Enabled {
+		return
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	value := 0.0
+	if degraded {
+		value = 1.0
+	}
+	c.backendDegraded.WithLabelValues(upstream, backend).Set(value)
+}
+
 func (c *Collector) SetActiveConnections(count int) {
 	if !c.config.Enabled {
 		return
@@ -187,3 +425,108 @@ func (c *Collector) DecrementActiveConnections() {
 
 	c.connectionsActive.Dec()
 }
+
+// records whether a backend connection came from the keep-alive pool; backends
+// answering with Connection: close show up as never reused
+func (c *Collector) RecordBackendConnection(upstream, backend string, reused bool) {
+	if !c.config.Enabled {
+		return
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.backendConns.WithLabelValues(upstream, backend, strconv.FormatBool(reused)).Inc()
+}
+
+// counts a request the client gave up on; these are not backend failures
+func (c *Collector) RecordClientDisconnect(upstream string) {
+	if !c.config.Enabled {
+		return
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.clientDisconnects.WithLabelValues(upstream).Inc()
+}
+
+// counts a retry caused by a failed attempt against backend
+func (c *Collector) RecordRetry(upstream, backend string) {
+	if !c.config.Enabled {
+		return
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.retriesTotal.WithLabelValues(upstream, backend).Inc()
+}
+
+// counts a request rejected by the upstream's rate limit
+func (c *Collector) RecordRateLimited(upstream string) {
+	if !c.config.Enabled {
+		return
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.rateLimited.WithLabelValues(upstream).Inc()
+}
+
+// RecordCircuitBreakerState publishes a backend's breaker state; operator
+// overrides report as the state they force
+func (c *Collector) RecordCircuitBreakerState(upstream, backend, state string) {
+	if !c.config.Enabled {
+		return
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	value := 0.0
+	switch state {
+	case "open", "forced_open":
+		value = 1
+	case "half_open":
+		value = 2
+	}
+	c.breakerState.WithLabelValues(upstream, backend).Set(value)
+}
+
+// counts a backend's circuit opening on failures
+func (c *Collector) RecordCircuitBreakerTrip(upstream, backend string) {
+	if !c.config.Enabled {
+		return
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.breakerTrips.WithLabelValues(upstream, backend).Inc()
+}
+
+// counts a shadow response differing from the proxied one in field
+func (c *Collector) RecordShadowDiff(upstream, field string) {
+	if !c.config.Enabled {
+		return
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.shadowDiffs.WithLabelValues(upstream, field).Inc()
+}
+
+// records how long picking a backend for one attempt took
+func (c *Collector) RecordSelection(upstream string, duration time.Duration) {
+	if !c.config.Enabled {
+		return
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.selectionDuration.WithLabelValues(upstream).Observe(duration.Seconds())
+}
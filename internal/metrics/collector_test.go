@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -270,3 +271,238 @@ func TestMetricsHealthEndpoint(t *testing.T) {
 		t.Errorf("Expected %s, got %s", expected, string(body))
 	}
 }
+
+func TestCollectorCustomNamespace(t *testing.T) {
+	collector := NewCollector(config.MetricsConfig{
+		Enabled:   true,
+		Path:      "/metrics",
+		Namespace: "tenant_a",
+		Subsystem: "edge",
+	})
+
+	collector.RecordRequest("web", "backend1", "GET", "200", 100*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	content := w.Body.String()
+
+	if !strings.Contains(content, `tenant_a_edge_requests_total{backend="backend1",method="GET",status="200",upstream="web"} 1`) {
+		t.Error("Expected requests_total to use the custom namespace and subsystem")
+	}
+
+	if !strings.Contains(content, "tenant_a_edge_request_duration_seconds") {
+		t.Error("Expected request_duration_seconds to use the custom namespace and subsystem")
+	}
+
+	if strings.Contains(content, "isame_lb_") {
+		t.Error("Default isame_lb_ prefix should not be used with a custom namespace")
+	}
+}
+
+func TestCollectorBackendDegraded(t *testing.T) {
+	collector := NewCollector(config.MetricsConfig{Enabled: true, Path: "/metrics"})
+
+	collector.UpdateBackendHealth("web", "backend1", true)
+	collector.UpdateBackendDegraded("web", "backend1", true)
+
+	w := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	content := w.Body.String()
+
+	if !strings.Contains(content, `isame_lb_backend_degraded{backend="backend1",upstream="web"} 1`) {
+		t.Errorf("Expected backend_degraded gauge to be set, got:\n%s", content)
+	}
+	if !strings.Contains(content, `isame_lb_upstream_healthy{backend="backend1",upstream="web"} 1`) {
+		t.Error("Expected upstream_healthy gauge to be set")
+	}
+}
+
+func TestMetricsOpenMetricsFormat(t *testing.T) {
+	collector := NewCollector(config.MetricsConfig{Enabled: true, Path: "/metrics"})
+	collector.RecordRequest("web", "backend1", "GET", "200", 100*time.Millisecond)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, req)
+
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/openmetrics-text") {
+		t.Errorf("Expected OpenMetrics content type, got %q", contentType)
+	}
+	if content := w.Body.String(); !strings.HasSuffix(content, "# EOF\n") {
+		t.Errorf("Expected OpenMetrics output to end with # EOF, got:\n%s", content)
+	}
+
+	// clients that do not ask keep getting the classic text format
+	w = httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Expected text format by default, got %q", contentType)
+	}
+	if strings.Contains(w.Body.String(), "# EOF") {
+		t.Error("Text format should not carry the OpenMetrics EOF marker")
+	}
+}
+
+func TestMetricsRequestsPerSecond(t *testing.T) {
+	collector := NewCollector(config.MetricsConfig{Enabled: true})
+	collector.SetRequestRates(func() map[string]float64 {
+		return map[string]float64{"api": 12.5}
+	})
+
+	w := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := `isame_lb_requests_per_second{upstream="api"} 12.5`
+	if !strings.Contains(w.Body.String(), expected) {
+		t.Errorf("Expected %s in metrics:\n%s", expected, w.Body.String())
+	}
+}
+
+func TestMetricsRetriesAndCircuitBreaker(t *testing.T) {
+	collector := NewCollector(config.MetricsConfig{Enabled: true})
+
+	collector.RecordRetry("web", "backend1")
+	collector.RecordRetry("web", "backend1")
+	collector.RecordCircuitBreakerState("web", "backend1", "open")
+	collector.RecordCircuitBreakerTrip("web", "backend1")
+	collector.RecordCircuitBreakerState("web", "backend2", "half_open")
+	collector.RecordCircuitBreakerState("web", "backend3", "forced_closed")
+
+	w := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	content := w.Body.String()
+
+	for _, expected := range []string{
+		`isame_lb_retries_total{backend="backend1",upstream="web"} 2`,
+		`isame_lb_circuit_breaker_state{backend="backend1",upstream="web"} 1`,
+		`isame_lb_circuit_breaker_state{backend="backend2",upstream="web"} 2`,
+		`isame_lb_circuit_breaker_state{backend="backend3",upstream="web"} 0`,
+		`isame_lb_circuit_breaker_trips_total{backend="backend1",upstream="web"} 1`,
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected %s in metrics:\n%s", expected, content)
+		}
+	}
+}
+
+func TestMetricsRateLimited(t *testing.T) {
+	collector := NewCollector(config.MetricsConfig{Enabled: true})
+	collector.RecordRateLimited("api")
+	collector.RecordRateLimited("api")
+
+	w := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := `isame_lb_rate_limited_total{upstream="api"} 2`
+	if !strings.Contains(w.Body.String(), expected) {
+		t.Errorf("Expected %s in metrics:\n%s", expected, w.Body.String())
+	}
+}
+
+func TestMetricsShadowDiffs(t *testing.T) {
+	collector := NewCollector(config.MetricsConfig{Enabled: true})
+	collector.RecordShadowDiff("api", "status")
+	collector.RecordShadowDiff("api", "status")
+	collector.RecordShadowDiff("api", "body")
+
+	w := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	for _, expected := range []string{
+		`isame_lb_shadow_diffs_total{field="status",upstream="api"} 2`,
+		`isame_lb_shadow_diffs_total{field="body",upstream="api"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("Expected %s in metrics:\n%s", expected, w.Body.String())
+		}
+	}
+}
+
+func TestMetricsSelectionDuration(t *testing.T) {
+	collector := NewCollector(config.MetricsConfig{Enabled: true})
+	collector.RecordSelection("api", 3*time.Microsecond)
+	collector.RecordSelection("api", 2*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	for _, expected := range []string{
+		`isame_lb_selection_duration_seconds_bucket{upstream="api",le="4e-06"} 1`,
+		`isame_lb_selection_duration_seconds_count{upstream="api"} 2`,
+	} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("Expected %s in metrics:\n%s", expected, w.Body.String())
+		}
+	}
+}
+
+func TestMetricsSampleRate(t *testing.T) {
+	tests := []struct {
+		name        string
+		rate        *float64
+		wantSampled bool
+	}{
+		{name: "unset records everything", wantSampled: true},
+		{name: "zero keeps only totals", rate: new(float64)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewCollector(config.MetricsConfig{Enabled: true, SampleRate: tt.rate})
+			for i := 0; i < 10; i++ {
+				collector.RecordRequest("api", "http://backend1:8080", "GET", "200", 10*time.Millisecond)
+			}
+			collector.RecordRequest("api", "http://backend1:8080", "GET", "503", 10*time.Millisecond)
+
+			w := httptest.NewRecorder()
+			collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+			content := w.Body.String()
+
+			for _, expected := range []string{
+				`isame_lb_upstream_requests_total{code_class="2xx",upstream="api"} 10`,
+				`isame_lb_upstream_requests_total{code_class="5xx",upstream="api"} 1`,
+			} {
+				if !strings.Contains(content, expected) {
+					t.Errorf("Expected %s in metrics:\n%s", expected, content)
+				}
+			}
+
+			for _, series := range []string{"isame_lb_requests_total{", "isame_lb_request_duration_seconds_count{"} {
+				if got := strings.Contains(content, series); got != tt.wantSampled {
+					t.Errorf("Expected %s present = %v in metrics:\n%s", series, tt.wantSampled, content)
+				}
+			}
+		})
+	}
+}
+
+func TestMetricsInternalStats(t *testing.T) {
+	collector := NewCollector(config.MetricsConfig{Enabled: true})
+	collector.SetInternalStats(func() InternalStats {
+		return InternalStats{
+			Goroutines:            42,
+			HealthCheckGoroutines: 3,
+			RateLimitClients:      map[string]int{"api": 7},
+			BalancerLockWait:      map[string]float64{"api": 0.5},
+			LastReload:            &ReloadOutcome{At: time.Unix(1700000000, 0), Success: true},
+		}
+	})
+
+	w := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	for _, expected := range []string{
+		"isame_lb_internal_goroutines 42",
+		"isame_lb_internal_health_check_goroutines 3",
+		`isame_lb_internal_rate_limit_clients{upstream="api"} 7`,
+		`isame_lb_internal_balancer_lock_wait_seconds_total{upstream="api"} 0.5`,
+		"isame_lb_internal_last_reload_timestamp_seconds 1.7e+09",
+		"isame_lb_internal_last_reload_success 1",
+	} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("Expected %s in metrics:\n%s", expected, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InternalStats describes the load balancer itself rather than the traffic
+// through it
+type InternalStats struct {
+	Goroutines            int                `json:"goroutines"`
+	HealthCheckGoroutines int                `json:"health_check_goroutines"`
+	RateLimitClients      map[string]int     `json:"rate_limit_clients"`         // by upstream
+	BalancerLockWait      map[string]float64 `json:"balancer_lock_wait_seconds"` // by upstream, total since the balancer was built
+	LastReload            *ReloadOutcome     `json:"last_reload,omitempty"`      // nil until the first reload
+}
+
+type ReloadOutcome struct {
+	At      time.Time `json:"at"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// internalCollector reports InternalStats at scrape time
+type internalCollector struct {
+	goroutines   *prometheus.Desc
+	healthChecks *prometheus.Desc
+	rateClients  *prometheus.Desc
+	lockWait     *prometheus.Desc
+	reloadTime   *prometheus.Desc
+	reloadOK     *prometheus.Desc
+	source       func() InternalStats
+}
+
+func newInternalCollector(namespace, subsystem string) *internalCollector {
+	name := func(n string) string {
+		return prometheus.BuildFQName(namespace, subsystem, "internal_"+n)
+	}
+
+	return &internalCollector{
+		goroutines:   prometheus.NewDesc(name("goroutines"), "Goroutines in the process", nil, nil),
+		healthChecks: prometheus.NewDesc(name("health_check_goroutines"), "Running active health check loops, one per backend", nil, nil),
+		rateClients:  prometheus.NewDesc(name("rate_limit_clients"), "Clients tracked by each upstream's rate limiter", []string{"upstream"}, nil),
+		lockWait:     prometheus.NewDesc(name("balancer_lock_wait_seconds_total"), "Time spent waiting for each upstream's balancer locks, an estimate of contention", []string{"upstream"}, nil),
+		reloadTime:   prometheus.NewDesc(name("last_reload_timestamp_seconds"), "Unix time of the last config reload attempt, 0 if there was none", nil, nil),
+		reloadOK:     prometheus.NewDesc(name("last_reload_success"), "Whether the last config reload was applied (1) or failed (0)", nil, nil),
+	}
+}
+
+func (ic *internalCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ic.goroutines
+	ch <- ic.healthChecks
+	ch <- ic.rateClients
+	ch <- ic.lockWait
+	ch <- ic.reloadTime
+	ch <- ic.reloadOK
+}
+
+func (ic *internalCollector) Collect(ch chan<- prometheus.Metric) {
+	if ic.source == nil {
+		return
+	}
+	stats := ic.source()
+
+	ch <- prometheus.MustNewConstMetric(ic.goroutines, prometheus.GaugeValue, float64(stats.Goroutines))
+	ch <- prometheus.MustNewConstMetric(ic.healthChecks, prometheus.GaugeValue, float64(stats.HealthCheckGoroutines))
+	for upstream, clients := range stats.RateLimitClients {
+		ch <- prometheus.MustNewConstMetric(ic.rateClients, prometheus.GaugeValue, float64(clients), upstream)
+	}
+	for upstream, wait := range stats.BalancerLockWait {
+		ch <- prometheus.MustNewConstMetric(ic.lockWait, prometheus.CounterValue, wait, upstream)
+	}
+
+	var reloadTime, reloadOK float64
+	if stats.LastReload != nil {
+		reloadTime = float64(stats.LastReload.At.UnixNano()) / 1e9
+		if stats.LastReload.Success {
+			reloadOK = 1
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(ic.reloadTime, prometheus.GaugeValue, reloadTime)
+	ch <- prometheus.MustNewConstMetric(ic.reloadOK, prometheus.GaugeValue, reloadOK)
+}
+
+// SetInternalStats supplies the load balancer's own state, call before Start
+func (c *Collector) SetInternalStats(source func() InternalStats) {
+	c.internal.source = source
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// rateCollector reports request rates computed at scrape time, so the gauge
+// decays while an upstream is idle instead of holding its last value
+type rateCollector struct {
+	desc   *prometheus.Desc
+	source func() map[string]float64
+}
+
+func newRateCollector(namespace, subsystem string) *rateCollector {
+	return &rateCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "requests_per_second"),
+			"Rolling average of requests per second by upstream",
+			[]string{"upstream"}, nil,
+		),
+	}
+}
+
+func (rc *rateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rc.desc
+}
+
+func (rc *rateCollector) Collect(ch chan<- prometheus.Metric) {
+	if rc.source == nil {
+		return
+	}
+	for upstream, rate := range rc.source() {
+		ch <- prometheus.MustNewConstMetric(rc.desc, prometheus.GaugeValue, rate, upstream)
+	}
+}
+
+// SetRequestRates supplies per-upstream requests per second, call before Start
+func (c *Collector) SetRequestRates(source func() map[string]float64) {
+	c.rates.source = source
+}
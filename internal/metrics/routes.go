@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"strings"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// label used for requests that match no configured route
+const unmatchedRoute = "other"
+
+type routeTemplate struct {
+	name     string
+	segments []string
+}
+
+// routeMatcher maps request paths to configured route names so the raw
+// path never ends up as a label value
+type routeMatcher struct {
+	routes []routeTemplate
+}
+
+func newRouteMatcher(routes []config.MetricsRoute) *routeMatcher {
+	templates := make([]routeTemplate, 0, len(routes))
+	for _, route := range routes {
+		templates = append(templates, routeTemplate{
+			name:     route.Name,
+			segments: splitPath(route.Path),
+		})
+	}
+
+	return &routeMatcher{routes: templates}
+}
+
+// match returns the first route whose template matches path; "{param}"
+// matches one segment and a trailing "*" matches any remainder
+func (m *routeMatcher) match(path string) string {
+	segments := splitPath(path)
+
+	for _, route := range m.routes {
+		if route.matches(segments) {
+			return route.name
+		}
+	}
+
+	return unmatchedRoute
+}
+
+func (rt routeTemplate) matches(segments []string) bool {
+	for i, want := range rt.segments {
+		if want == "*" && i == len(rt.segments)-1 {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if strings.HasPrefix(want, "{") && strings.HasSuffix(want, "}") {
+			continue
+		}
+		if want != segments[i] {
+			return false
+		}
+	}
+
+	return len(segments) == len(rt.segments)
+}
+
+func splitPath(path string) []string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return nil
+	}
+	return strings.Split(trimmed, "/")
+}
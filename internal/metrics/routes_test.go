@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestRouteMatcher(t *testing.T) {
+	matcher := newRouteMatcher([]config.MetricsRoute{
+		{Name: "user", Path: "/users/{id}"},
+		{Name: "users", Path: "/users"},
+		{Name: "static", Path: "/static/*"},
+		{Name: "root", Path: "/"},
+	})
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "/users/42", expected: "user"},
+		{path: "/users/42/", expected: "user"},
+		{path: "/users", expected: "users"},
+		{path: "/users/42/orders", expected: "other"},
+		{path: "/static/css/app.css", expected: "static"},
+		{path: "/static", expected: "static"},
+		{path: "/", expected: "root"},
+		{path: "/unknown", expected: "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := matcher.match(tt.path); got != tt.expected {
+				t.Errorf("match(%q) = %q, want %q", tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCollectorRouteLabel(t *testing.T) {
+	collector := NewCollector(config.MetricsConfig{
+		Enabled:    true,
+		Path:       "/metrics",
+		RouteLabel: true,
+		Routes:     []config.MetricsRoute{{Name: "get_user", Path: "/users/{id}"}},
+	})
+
+	route := collector.Route("/users/42")
+	if route != "get_user" {
+		t.Fatalf("Expected route get_user, got %q", route)
+	}
+
+	collector.RecordRouteRequest("web", "backend1", "GET", "200", route, 100*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	content := w.Body.String()
+
+	if !strings.Contains(content, `isame_lb_requests_total{backend="backend1",method="GET",route="get_user",status="200",upstream="web"} 1`) {
+		t.Errorf("Expected route label on requests_total, got:\n%s", content)
+	}
+	if !strings.Contains(content, `route="get_user"`) || strings.Contains(content, "/users/42") {
+		t.Error("Route label should use the route name, never the raw path")
+	}
+}
+
+func TestCollectorRouteLabelDisabled(t *testing.T) {
+	collector := NewCollector(config.MetricsConfig{Enabled: true, Path: "/metrics"})
+
+	if route := collector.Route("/users/42"); route != "" {
+		t.Errorf("Expected empty route when disabled, got %q", route)
+	}
+
+	collector.RecordRouteRequest("web", "backend1", "GET", "200", "ignored", 100*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(w.Body.String(), "route=") {
+		t.Error("Route label should not be present when disabled")
+	}
+}
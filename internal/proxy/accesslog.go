@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// AccessLog writes one line per proxied request, as text or JSON
+type AccessLog struct {
+	format string
+
+	mu     sync.Mutex
+	sink   io.Writer
+	closer io.Closer
+}
+
+// one logged request
+type accessRecord struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Upstream   string    `json:"upstream"`
+	Backend    string    `json:"backend"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	ClientIP   string    `json:"client_ip"`
+	DurationMS float64   `json:"duration_ms"`
+
+	duration time.Duration
+}
+
+// NewAccessLog opens the configured output, stdout when none is set
+func NewAccessLog(cfg config.AccessLogConfig) (*AccessLog, error) {
+	if cfg.Output == "" {
+		return newAccessLog(cfg.Format, os.Stdout), nil
+	}
+
+	file, err := os.OpenFile(cfg.Output, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %w", err)
+	}
+
+	l := newAccessLog(cfg.Format, file)
+	l.closer = file
+	return l, nil
+}
+
+func newAccessLog(format string, sink io.Writer) *AccessLog {
+	return &AccessLog{format: format, sink: sink}
+}
+
+// Close closes the access log file, if any
+func (l *AccessLog) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+func (l *AccessLog) write(record accessRecord) {
+	var line []byte
+	if l.format == config.AccessLogJSON {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return
+		}
+		line = append(data, '\n')
+	} else {
+		line = fmt.Appendf(nil, "%s method=%s path=%q upstream=%s backend=%s status=%d bytes=%d client_ip=%s duration=%s\n",
+			record.Time.Format(time.RFC3339), record.Method, record.Path, record.Upstream, record.Backend,
+			record.Status, record.Bytes, record.ClientIP, record.duration)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sink.Write(line)
+}
+
+// SetAccessLog turns on access logging for proxied requests
+func (h *Handler) SetAccessLog(l *AccessLog) {
+	h.accessLog = l
+}
+
+// where a request ended up, filled in as ServeHTTP goes
+type accessRoute struct {
+	upstream string
+	backend  string
+}
+
+func (h *Handler) logAccess(r *http.Request, rt *routing, rw *responseWriter, route *accessRoute, start time.Time) {
+	duration := time.Since(start)
+	h.accessLog.write(accessRecord{
+		Time:       start,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Upstream:   route.upstream,
+		Backend:    route.backend,
+		Status:     rw.statusCode,
+		Bytes:      rw.bytes,
+		ClientIP:   getClientIP(r, rt.trustedProxies),
+		DurationMS: float64(duration.Microseconds()) / 1000,
+		duration:   duration,
+	})
+}
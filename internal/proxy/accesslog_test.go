@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestAccessLogJSON(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:     "api",
+			Match:    &config.MatchConfig{PathPrefix: "/api"},
+			Backends: []config.Backend{{URL: backend.URL, Weight: 1}},
+		}},
+	}, nil)
+	var sink bytes.Buffer
+	handler.SetAccessLog(newAccessLog(config.AccessLogJSON, &sink))
+
+	req := httptest.NewRequest("POST", "/api/orders", strings.NewReader("{}"))
+	req.RemoteAddr = "192.0.2.10:51234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var record accessRecord
+	if err := json.Unmarshal(sink.Bytes(), &record); err != nil {
+		t.Fatalf("Access log should hold a JSON line: %v (%q)", err, sink.String())
+	}
+
+	if record.Method != "POST" || record.Path != "/api/orders" {
+		t.Errorf("Unexpected request line: %+v", record)
+	}
+	if record.Upstream != "api" || record.Backend != backend.URL {
+		t.Errorf("Expected upstream api and backend %s, got %q and %q", backend.URL, record.Upstream, record.Backend)
+	}
+	if record.Status != http.StatusCreated || record.Bytes != int64(len("created")) {
+		t.Errorf("Expected status 201 with 7 bytes, got %d with %d", record.Status, record.Bytes)
+	}
+	if record.ClientIP != "192.0.2.10" {
+		t.Errorf("Expected the client IP without its port, got %q", record.ClientIP)
+	}
+	if record.DurationMS <= 0 {
+		t.Errorf("Expected a positive duration, got %v", record.DurationMS)
+	}
+}
+
+func TestAccessLogText(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:     "api",
+			Match:    &config.MatchConfig{PathPrefix: "/api"},
+			Backends: []config.Backend{{URL: backend.URL, Weight: 1}},
+		}},
+	}, nil)
+	var sink bytes.Buffer
+	handler.SetAccessLog(newAccessLog(config.AccessLogText, &sink))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/items", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/elsewhere", nil))
+
+	lines := strings.Split(strings.TrimSuffix(sink.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected one line per request, got %q", sink.String())
+	}
+
+	for _, field := range []string{`method=GET`, `path="/api/items"`, `upstream=api`, `backend=` + backend.URL, `status=200`, `bytes=2`, `duration=`} {
+		if !strings.Contains(lines[0], field) {
+			t.Errorf("Expected %q in %q", field, lines[0])
+		}
+	}
+
+	// unmatched requests are logged too, with the status the client got
+	for _, field := range []string{`path="/elsewhere"`, `status=404`, `backend= `} {
+		if !strings.Contains(lines[1], field) {
+			t.Errorf("Expected %q in %q", field, lines[1])
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import "log"
+
+// DrainBackend takes a backend out of rotation until EnableBackend: no new
+// requests are sent to it, while requests already in flight finish. It
+// holds across reloads.
+func (h *Handler) DrainBackend(backendURL string) {
+	h.drainedMu.Lock()
+	defer h.drainedMu.Unlock()
+
+	if !h.drained[backendURL] {
+		h.drained[backendURL] = true
+		log.Printf("Backend %s drained", backendURL)
+	}
+}
+
+// EnableBackend puts a drained backend back into rotation
+func (h *Handler) EnableBackend(backendURL string) {
+	h.drainedMu.Lock()
+	defer h.drainedMu.Unlock()
+
+	if h.drained[backendURL] {
+		delete(h.drained, backendURL)
+		log.Printf("Backend %s enabled", backendURL)
+	}
+}
+
+func (h *Handler) IsDrained(backendURL string) bool {
+	h.drainedMu.RLock()
+	defer h.drainedMu.RUnlock()
+	return h.drained[backendURL]
+}
+
+// implemented by balancers that count requests in flight per backend
+type connectionCounter interface {
+	GetConnections(backendURL string) int64
+}
+
+// Connections is the number of requests in flight to a backend, 0 when its
+// upstream's balancer doesn't count them
+func (h *Handler) Connections(backendURL string) int64 {
+	rt := h.routing.Load()
+	counter, ok := rt.loadBalancers[rt.backendUpstream(backendURL)].(connectionCounter)
+	if !ok {
+		return 0
+	}
+	return counter.GetConnections(backendURL)
+}
+
+// ActiveConnections is the number of requests in flight whatever the
+// balancer, upgraded connections included until they close
+func (h *Handler) ActiveConnections() int64 {
+	return h.inFlight.Load()
+}
+
+// health of every backend as selection sees it, drained backends included
+// as unhealthy
+func (h *Handler) healthSnapshot() map[string]bool {
+	var healthStatus map[string]bool
+	if h.healthChecker != nil {
+		healthStatus = h.healthChecker.GetAllStatuses()
+	} else {
+		healthStatus = make(map[string]bool)
+	}
+
+	h.drainedMu.RLock()
+	for url := range h.drained {
+		healthStatus[url] = false
+	}
+	h.drainedMu.RUnlock()
+
+	return healthStatus
+}
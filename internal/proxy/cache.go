@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// responseCache is a small in-memory LRU of successful GET responses, also
+// served to HEAD requests
+type responseCache struct {
+	ttl          time.Duration
+	maxEntries   int
+	maxBodyBytes int64
+	keyParts     []string
+	varyHeaders  []string
+	varyAllowed  map[string]bool
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is most recently used
+}
+
+type cacheEntry struct {
+	key        string
+	statusCode int
+	header     http.Header
+	body       []byte
+	expires    time.Time
+}
+
+func newResponseCache(cfg *config.CacheConfig) *responseCache {
+	varyHeaders := make([]string, 0, len(cfg.VaryHeaders))
+	varyAllowed := make(map[string]bool, len(cfg.VaryHeaders))
+	for _, name := range cfg.VaryHeaders {
+		canonical := http.CanonicalHeaderKey(name)
+		varyHeaders = append(varyHeaders, canonical)
+		varyAllowed[canonical] = true
+	}
+
+	return &responseCache{
+		ttl:          cfg.TTL,
+		maxEntries:   cfg.MaxEntries,
+		maxBodyBytes: cfg.MaxBodyBytes,
+		keyParts:     cfg.Key,
+		varyHeaders:  varyHeaders,
+		varyAllowed:  varyAllowed,
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+	}
+}
+
+func (rc *responseCache) cacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return !strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache")
+}
+
+// key composes the configured request parts and vary header values
+func (rc *responseCache) key(r *http.Request) string {
+	var b strings.Builder
+
+	for _, part := range rc.keyParts {
+		switch part {
+		case "method":
+			b.WriteString(r.Method)
+		case "path":
+			b.WriteString(r.URL.Path)
+		case "query":
+			// re-encoding sorts parameters so their order does not matter
+			b.WriteString(r.URL.Query().Encode())
+		}
+		b.WriteByte('\n')
+	}
+
+	for _, name := range rc.varyHeaders {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+func (rc *responseCache) get(key string) (*cacheEntry, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	elem, exists := rc.entries[key]
+	if !exists {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		rc.lru.Remove(elem)
+		delete(rc.entries, key)
+		return nil, false
+	}
+
+	rc.lru.MoveToFront(elem)
+	return entry, true
+}
+
+// store caches a recorded response unless the backend marked it private or
+// it varies on headers that are not part of the key
+func (rc *responseCache) store(key string, rec *cacheRecorder) {
+	if rec.statusCode != http.StatusOK || rec.overflow {
+		return
+	}
+
+	header := rec.header
+	if header == nil {
+		header = rec.Header()
+	}
+	ttl, ok := rc.responseTTL(header)
+	if !ok {
+		return
+	}
+
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" || (name != "" && !rc.varyAllowed[http.CanonicalHeaderKey(name)]) {
+				return
+			}
+		}
+	}
+
+	entry := &cacheEntry{
+		key:        key,
+		statusCode: rec.statusCode,
+		header:     header.Clone(),
+		body:       append([]byte(nil), rec.body...),
+		expires:    time.Now().Add(ttl),
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if elem, exists := rc.entries[key]; exists {
+		elem.Value = entry
+		rc.lru.MoveToFront(elem)
+		return
+	}
+
+	rc.entries[key] = rc.lru.PushFront(entry)
+	for rc.lru.Len() > rc.maxEntries {
+		oldest := rc.lru.Back()
+		rc.lru.Remove(oldest)
+		delete(rc.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// configured TTL, shortened by the backend's max-age; false if uncacheable
+func (rc *responseCache) responseTTL(header http.Header) (time.Duration, bool) {
+	ttl := rc.ttl
+
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store", directive == "no-cache", directive == "private":
+			return 0, false
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			if maxAge := time.Duration(seconds) * time.Second; maxAge < ttl {
+				ttl = maxAge
+			}
+		}
+	}
+
+	if header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+
+	return ttl, true
+}
+
+func (e *cacheEntry) writeTo(w http.ResponseWriter, r *http.Request) {
+	for name, values := range e.header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Header().Set("X-Cache", "HIT")
+	w.WriteHeader(e.statusCode)
+
+	if r.Method != http.MethodHead {
+		w.Write(e.body)
+	}
+}
+
+// cacheRecorder passes a response through while keeping a copy for the cache
+type cacheRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	header     http.Header // as written, before compression rewrites the encoding headers
+	body       []byte
+	limit      int64
+	overflow   bool
+}
+
+func newCacheRecorder(w http.ResponseWriter, limit int64) *cacheRecorder {
+	w.Header().Set("X-Cache", "MISS")
+	return &cacheRecorder{ResponseWriter: w, statusCode: http.StatusOK, limit: limit}
+}
+
+func (cr *cacheRecorder) WriteHeader(code int) {
+	if cr.header == nil {
+		cr.header = cr.Header().Clone()
+	}
+	cr.statusCode = code
+	cr.ResponseWriter.WriteHeader(code)
+}
+
+func (cr *cacheRecorder) Write(p []byte) (int, error) {
+	if cr.header == nil {
+		cr.header = cr.Header().Clone()
+	}
+	if !cr.overflow {
+		if int64(len(cr.body)+len(p)) > cr.limit {
+			cr.overflow = true
+			cr.body = nil
+		} else {
+			cr.body = append(cr.body, p...)
+		}
+	}
+	return cr.ResponseWriter.Write(p)
+}
+
+func (cr *cacheRecorder) Unwrap() http.ResponseWriter {
+	return cr.ResponseWriter
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestResponseCacheKey(t *testing.T) {
+	cache := newResponseCache(&config.CacheConfig{
+		Key:         []string{"method", "path", "query"},
+		VaryHeaders: []string{"accept-encoding", "X-Tenant"},
+		MaxEntries:  10,
+	})
+
+	a := httptest.NewRequest("GET", "/items?b=2&a=1", nil)
+	b := httptest.NewRequest("GET", "/items?a=1&b=2", nil)
+	if cache.key(a) != cache.key(b) {
+		t.Error("Query parameter order should not change the cache key")
+	}
+
+	b.Header.Set("X-Tenant", "acme")
+	if cache.key(a) == cache.key(b) {
+		t.Error("Requests differing in a vary header should get different keys")
+	}
+
+	pathOnly := newResponseCache(&config.CacheConfig{Key: []string{"path"}, MaxEntries: 10})
+	if pathOnly.key(httptest.NewRequest("GET", "/items?a=1", nil)) != pathOnly.key(httptest.NewRequest("HEAD", "/items?a=2", nil)) {
+		t.Error("Method and query should be ignored when not part of the key")
+	}
+}
+
+func TestHandlerCacheVaryHeaders(t *testing.T) {
+	backendHits := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendHits++
+		w.Header().Set("Vary", "Accept-Encoding, X-Tenant")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("tenant=" + r.Header.Get("X-Tenant")))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:     "test-upstream",
+			Backends: []config.Backend{{URL: backend.URL, Weight: 1}},
+			Cache: &config.CacheConfig{
+				Enabled:      true,
+				TTL:          time.Minute,
+				MaxEntries:   10,
+				MaxBodyBytes: 1024,
+				Key:          []string{"method", "path", "query"},
+				VaryHeaders:  []string{"Accept-Encoding", "X-Tenant"},
+			},
+		}},
+	}, nil)
+
+	get := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/profile", nil)
+		req.Header.Set("X-Tenant", tenant)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("acme"); w.Header().Get("X-Cache") != "MISS" || w.Body.String() != "tenant=acme" {
+		t.Errorf("First request should miss, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if w := get("globex"); w.Header().Get("X-Cache") != "MISS" || w.Body.String() != "tenant=globex" {
+		t.Errorf("Different vary header value should get its own entry, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if w := get("acme"); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "tenant=acme" {
+		t.Errorf("Repeated request should be served from cache, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+
+	if backendHits != 2 {
+		t.Errorf("Expected 2 backend hits, got %d", backendHits)
+	}
+}
+
+func TestHandlerCacheSkipsUnkeyedVary(t *testing.T) {
+	backendHits := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendHits++
+		w.Header().Set("Vary", "Accept-Language")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:     "test-upstream",
+			Backends: []config.Backend{{URL: backend.URL, Weight: 1}},
+			Cache: &config.CacheConfig{
+				Enabled:      true,
+				TTL:          time.Minute,
+				MaxEntries:   10,
+				MaxBodyBytes: 1024,
+				Key:          []string{"method", "path", "query"},
+			},
+		}},
+	}, nil)
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	if backendHits != 2 {
+		t.Errorf("Responses varying on headers outside the key should not be cached, got %d backend hits", backendHits)
+	}
+}
+
+func TestHandlerCacheHeadDoesNotPoisonGet(t *testing.T) {
+	backendHits := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendHits++
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "hello")
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:     "test-upstream",
+			Backends: []config.Backend{{URL: backend.URL, Weight: 1}},
+			Cache: &config.CacheConfig{
+				Enabled:      true,
+				TTL:          time.Minute,
+				MaxEntries:   10,
+				MaxBodyBytes: 1024,
+				Key:          []string{"path"},
+			},
+		}},
+	}, nil)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("HEAD", "/", nil))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Body.String() != "hello" {
+		t.Fatalf("GET after HEAD got body %q, want %q", w.Body.String(), "hello")
+	}
+
+	head := httptest.NewRecorder()
+	handler.ServeHTTP(head, httptest.NewRequest("HEAD", "/", nil))
+	if head.Header().Get("X-Cache") != "HIT" || head.Body.Len() != 0 {
+		t.Errorf("HEAD after GET should be a bodiless hit, got X-Cache %q and %d bytes", head.Header().Get("X-Cache"), head.Body.Len())
+	}
+
+	if backendHits != 2 {
+		t.Errorf("Expected 2 backend hits, got %d", backendHits)
+	}
+}
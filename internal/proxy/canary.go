@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"maps"
+	"math/rand"
+
+	"github.com/sanchxt/isame-lb/internal/canary"
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// picks the canary or the stable set for its current share of requests and
+// returns the health statuses with the other side marked down; an unhealthy
+// canary, or one rolled back to 0, leaves all traffic on the stable set.
+// Balancers still see every backend, so hash rings and per-backend state
+// stay keyed on the full upstream.
+func canaryHealth(ramp *canary.Ramp, backends []config.Backend, healthStatus map[string]bool) map[string]bool {
+	if ramp == nil {
+		return healthStatus
+	}
+
+	found := false
+	for _, backend := range backends {
+		if backend.URL == ramp.Backend() {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return healthStatus
+	}
+
+	filtered := make(map[string]bool, len(backends))
+	maps.Copy(filtered, healthStatus)
+
+	healthy, exists := healthStatus[ramp.Backend()]
+	if (exists && !healthy) || rand.Float64()*100 >= ramp.Percent() {
+		filtered[ramp.Backend()] = false
+		return filtered
+	}
+
+	for _, backend := range backends {
+		if backend.URL != ramp.Backend() {
+			filtered[backend.URL] = false
+		}
+	}
+	return filtered
+}
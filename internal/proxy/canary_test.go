@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/balancer"
+	"github.com/sanchxt/isame-lb/internal/canary"
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestCanaryHealth(t *testing.T) {
+	backends := []config.Backend{
+		{URL: "http://stable1:8080", Weight: 1},
+		{URL: "http://stable2:8080", Weight: 1},
+		{URL: "http://canary:8080", Weight: 1},
+	}
+
+	newRamp := func(start float64) *canary.Ramp {
+		return canary.New(&config.CanaryConfig{
+			Enabled: true,
+			Backend: "http://canary:8080",
+			Ramp:    config.CanaryRampConfig{Start: start, Step: 10, Interval: time.Minute, Target: 100},
+		})
+	}
+
+	up := func(healthStatus map[string]bool, url string) bool {
+		healthy, exists := healthStatus[url]
+		return !exists || healthy
+	}
+
+	healthStatus := map[string]bool{"http://stable1:8080": true}
+	if got := canaryHealth(nil, backends, healthStatus); len(got) != 1 || !got["http://stable1:8080"] {
+		t.Errorf("Expected the health statuses unchanged without a canary, got %v", got)
+	}
+
+	canaryHits := 0
+	ramp := newRamp(20)
+	for i := 0; i < 1000; i++ {
+		got := canaryHealth(ramp, backends, healthStatus)
+		stable := up(got, "http://stable1:8080") && up(got, "http://stable2:8080")
+		noStable := !up(got, "http://stable1:8080") && !up(got, "http://stable2:8080")
+		switch {
+		case noStable && up(got, "http://canary:8080"):
+			canaryHits++
+		case !stable || up(got, "http://canary:8080"):
+			t.Fatalf("Expected either only the canary or only the stable set available, got %v", got)
+		}
+	}
+	if canaryHits < 120 || canaryHits > 280 {
+		t.Errorf("Expected about 20%% of requests on the canary, got %d/1000", canaryHits)
+	}
+	if len(healthStatus) != 1 {
+		t.Errorf("canaryHealth() modified the shared health statuses: %v", healthStatus)
+	}
+
+	full := newRamp(100)
+	got := canaryHealth(full, backends, map[string]bool{"http://canary:8080": false})
+	if up(got, "http://canary:8080") || !up(got, "http://stable1:8080") || !up(got, "http://stable2:8080") {
+		t.Errorf("Expected an unhealthy canary to get no traffic, got %v", got)
+	}
+}
+
+func TestCanaryHealthKeepsHashRing(t *testing.T) {
+	backends := []config.Backend{
+		{URL: "http://stable1:8080", Weight: 1},
+		{URL: "http://stable2:8080", Weight: 1},
+		{URL: "http://canary:8080", Weight: 1},
+	}
+	ramp := canary.New(&config.CanaryConfig{
+		Enabled: true,
+		Backend: "http://canary:8080",
+		Ramp:    config.CanaryRampConfig{Start: 0, Step: 10, Interval: time.Minute, Target: 100},
+	})
+	lb := balancer.NewConsistentHash(100, "")
+
+	// with the canary at 0%, clients the full ring sends to a stable backend
+	// must keep it
+	for i := 0; i < 50; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", i)
+
+		want, err := lb.SelectBackend(req, backends, nil)
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		if want.URL == "http://canary:8080" {
+			continue
+		}
+		got, err := lb.SelectBackend(req, backends, canaryHealth(ramp, backends, nil))
+		if err != nil {
+			t.Fatalf("SelectBackend() error = %v", err)
+		}
+		if got.URL != want.URL {
+			t.Errorf("client %s moved from %s to %s", req.RemoteAddr, want.URL, got.URL)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+const redactedValue = "[REDACTED]"
+
+// Capture is debug middleware that writes sampled requests and their
+// responses, headers and bounded bodies, to a sink as JSON lines
+type Capture struct {
+	next         http.Handler
+	sampleRate   float64
+	matchHeader  string
+	maxBodyBytes int64
+	redact       map[string]bool
+
+	mu     sync.Mutex
+	sink   io.Writer
+	closer io.Closer
+}
+
+// one captured exchange
+type captureRecord struct {
+	Time                  time.Time   `json:"time"`
+	Method                string      `json:"method"`
+	URL                   string      `json:"url"`
+	RemoteAddr            string      `json:"remote_addr"`
+	RequestHeaders        http.Header `json:"request_headers"`
+	RequestBody           string      `json:"request_body"`
+	RequestBodyTruncated  bool        `json:"request_body_truncated"`
+	Status                int         `json:"status"`
+	ResponseHeaders       http.Header `json:"response_headers"`
+	ResponseBody          string      `json:"response_body"`
+	ResponseBodyTruncated bool        `json:"response_body_truncated"`
+	DurationMS            float64     `json:"duration_ms"`
+}
+
+// NewCapture wraps next, appending captures to the configured file
+func NewCapture(cfg config.CaptureConfig, next http.Handler) (*Capture, error) {
+	file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture file: %w", err)
+	}
+
+	c := newCapture(cfg, next, file)
+	c.closer = file
+	return c, nil
+}
+
+func newCapture(cfg config.CaptureConfig, next http.Handler, sink io.Writer) *Capture {
+	redact := make(map[string]bool, len(cfg.RedactHeaders))
+	for _, name := range cfg.RedactHeaders {
+		redact[http.CanonicalHeaderKey(name)] = true
+	}
+
+	return &Capture{
+		next:         next,
+		sampleRate:   cfg.SampleRate,
+		matchHeader:  cfg.MatchHeader,
+		maxBodyBytes: cfg.MaxBodyBytes,
+		redact:       redact,
+		sink:         sink,
+	}
+}
+
+// Close closes the capture file, if any
+func (c *Capture) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+func (c *Capture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !c.shouldCapture(r) {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	start := time.Now()
+
+	reqBody := &boundedBuffer{limit: c.maxBodyBytes}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = readCloser{io.TeeReader(r.Body, reqBody), r.Body}
+	}
+
+	cw := &captureWriter{
+		ResponseWriter: w,
+		statusCode:     http.StatusOK,
+		body:           &boundedBuffer{limit: c.maxBodyBytes},
+	}
+
+	// headers are copied up front since the proxy rewrites them on the way out
+	reqHeaders := c.redactHeaders(r.Header)
+
+	c.next.ServeHTTP(cw, r)
+
+	c.write(captureRecord{
+		Time:                  start,
+		Method:                r.Method,
+		URL:                   r.URL.String(),
+		RemoteAddr:            r.RemoteAddr,
+		RequestHeaders:        reqHeaders,
+		RequestBody:           string(reqBody.data),
+		RequestBodyTruncated:  reqBody.truncated,
+		Status:                cw.statusCode,
+		ResponseHeaders:       c.redactHeaders(w.Header()),
+		ResponseBody:          string(cw.body.data),
+		ResponseBodyTruncated: cw.body.truncated,
+		DurationMS:            float64(time.Since(start).Microseconds()) / 1000,
+	})
+}
+
+func (c *Capture) shouldCapture(r *http.Request) bool {
+	if c.matchHeader != "" && r.Header.Get(c.matchHeader) != "" {
+		return true
+	}
+	return c.sampleRate > 0 && rand.Float64() < c.sampleRate
+}
+
+func (c *Capture) redactHeaders(header http.Header) http.Header {
+	out := header.Clone()
+	for name := range out {
+		if c.redact[name] {
+			out[name] = []string{redactedValue}
+		}
+	}
+	return out
+}
+
+func (c *Capture) write(record captureRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sink.Write(append(line, '\n'))
+}
+
+// boundedBuffer keeps the first limit bytes written to it and notes
+// whether anything was dropped
+type boundedBuffer struct {
+	limit     int64
+	data      []byte
+	truncated bool
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - int64(len(b.data)); room > 0 {
+		if int64(len(p)) > room {
+			b.data = append(b.data, p[:room]...)
+			b.truncated = true
+		} else {
+			b.data = append(b.data, p...)
+		}
+	} else if len(p) > 0 {
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+// captureWriter records the status and leading body bytes of a response
+type captureWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       *boundedBuffer
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	cw.statusCode = code
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	cw.body.Write(p)
+	return cw.ResponseWriter.Write(p)
+}
+
+// lets http.ResponseController reach the underlying writer for flushing
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func newTestCapture(sink io.Writer, next http.Handler) *Capture {
+	return newCapture(config.CaptureConfig{
+		Enabled:       true,
+		MatchHeader:   "X-Debug-Capture",
+		MaxBodyBytes:  8,
+		RedactHeaders: []string{"Authorization", "Set-Cookie"},
+	}, next, sink)
+}
+
+func TestCaptureRecordsMatchingRequest(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "request-body-longer-than-cap" {
+			t.Errorf("Backend should receive the full body, got %q", body)
+		}
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("response-body-longer-than-cap"))
+	})
+
+	var sink bytes.Buffer
+	capture := newTestCapture(&sink, next)
+
+	req := httptest.NewRequest("POST", "/orders?id=1", strings.NewReader("request-body-longer-than-cap"))
+	req.Header.Set("X-Debug-Capture", "1")
+	req.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	capture.ServeHTTP(w, req)
+
+	if w.Body.String() != "response-body-longer-than-cap" {
+		t.Errorf("Client should receive the full response, got %q", w.Body.String())
+	}
+
+	var record captureRecord
+	if err := json.Unmarshal(sink.Bytes(), &record); err != nil {
+		t.Fatalf("Capture sink should hold a JSON record: %v (%q)", err, sink.String())
+	}
+
+	if record.Method != "POST" || record.URL != "/orders?id=1" || record.Status != http.StatusCreated {
+		t.Errorf("Unexpected captured request line: %+v", record)
+	}
+	if record.RequestBody != "request-" || !record.RequestBodyTruncated {
+		t.Errorf("Request body should be truncated at the cap, got %q (truncated=%v)", record.RequestBody, record.RequestBodyTruncated)
+	}
+	if record.ResponseBody != "response" || !record.ResponseBodyTruncated {
+		t.Errorf("Response body should be truncated at the cap, got %q (truncated=%v)", record.ResponseBody, record.ResponseBodyTruncated)
+	}
+	if got := record.RequestHeaders.Get("Authorization"); got != redactedValue {
+		t.Errorf("Authorization should be redacted, got %q", got)
+	}
+	if got := record.ResponseHeaders.Get("Set-Cookie"); got != redactedValue {
+		t.Errorf("Set-Cookie should be redacted, got %q", got)
+	}
+}
+
+func TestCaptureSkipsUnmatchedRequest(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var sink bytes.Buffer
+	capture := newTestCapture(&sink, next)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	capture.ServeHTTP(httptest.NewRecorder(), req)
+
+	if sink.Len() != 0 {
+		t.Errorf("Requests without the match header should not be captured, got %q", sink.String())
+	}
+}
+
+func TestNewCaptureWritesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+
+	capture, err := NewCapture(config.CaptureConfig{
+		Enabled:      true,
+		File:         path,
+		SampleRate:   1,
+		MaxBodyBytes: 64,
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	if err != nil {
+		t.Fatalf("NewCapture() error = %v", err)
+	}
+
+	capture.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+	capture.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read capture file: %v", err)
+	}
+	if !strings.Contains(string(data), `"url":"/ping"`) {
+		t.Errorf("Capture file should contain the request, got %q", data)
+	}
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import "net/http"
+
+// headers describing the client certificate to backends
+const (
+	headerClientCertSubject  = "X-Client-Cert-Subject"
+	headerClientCertIssuer   = "X-Client-Cert-Issuer"
+	headerClientCertVerified = "X-Client-Cert-Verified"
+)
+
+// drops inbound X-Client-Cert-* headers, whether or not tls.client_cert_headers
+// is on, so a client can't claim a certificate it didn't present
+func removeClientCertHeaders(proxyReq *http.Request) {
+	proxyReq.Header.Del(headerClientCertSubject)
+	proxyReq.Header.Del(headerClientCertIssuer)
+	proxyReq.Header.Del(headerClientCertVerified)
+}
+
+// sets the X-Client-Cert-* headers from the client's verified chain; without
+// one the headers stay unset
+func setClientCertHeaders(proxyReq *http.Request, originalReq *http.Request) {
+	state := originalReq.TLS
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return
+	}
+
+	cert := state.VerifiedChains[0][0]
+	proxyReq.Header.Set(headerClientCertSubject, cert.Subject.String())
+	proxyReq.Header.Set(headerClientCertIssuer, cert.Issuer.String())
+	proxyReq.Header.Set(headerClientCertVerified, "true")
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/health"
+	"github.com/sanchxt/isame-lb/internal/metrics"
+)
+
+func newClientCert(t *testing.T, subject, issuer string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: subject, Organization: []string{"Example"}},
+		Issuer:       pkix.Name{CommonName: issuer},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	parent := &x509.Certificate{Subject: pkix.Name{CommonName: issuer}}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func TestHandlerClientCertHeaders(t *testing.T) {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+			},
+		},
+		TLS:            config.TLSConfig{ClientCertHeaders: true},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	cert := newClientCert(t, "client.example.com", "Example Client CA")
+
+	tests := []struct {
+		name  string
+		state *tls.ConnectionState
+		want  map[string]string
+	}{
+		{
+			name:  "verified client cert",
+			state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}},
+			want: map[string]string{
+				headerClientCertSubject:  "CN=client.example.com,O=Example",
+				headerClientCertIssuer:   "CN=Example Client CA",
+				headerClientCertVerified: "true",
+			},
+		},
+		{
+			name:  "unverified client cert",
+			state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+			want:  map[string]string{headerClientCertSubject: "", headerClientCertIssuer: "", headerClientCertVerified: ""},
+		},
+		{
+			name: "plain http",
+			want: map[string]string{headerClientCertSubject: "", headerClientCertIssuer: "", headerClientCertVerified: ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.TLS = tt.state
+			// spoofed by the client, must never reach the backend as sent
+			req.Header.Set(headerClientCertSubject, "CN=admin")
+			req.Header.Set(headerClientCertVerified, "true")
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			for header, want := range tt.want {
+				if got := received.Get(header); got != want {
+					t.Errorf("Expected %s %q, got %q", header, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerStripsClientCertHeadersWhenDisabled(t *testing.T) {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	cert := newClientCert(t, "client.example.com", "Example Client CA")
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+	req.Header.Set(headerClientCertSubject, "CN=admin")
+	req.Header.Set(headerClientCertIssuer, "CN=Forged CA")
+	req.Header.Set(headerClientCertVerified, "true")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	for _, header := range []string{headerClientCertSubject, headerClientCertIssuer, headerClientCertVerified} {
+		if got := received.Get(header); got != "" {
+			t.Errorf("Expected %s to be removed with client_cert_headers off, got %q", header, got)
+		}
+	}
+}
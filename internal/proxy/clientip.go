@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parses server.trusted_proxies, bare IPs as single-address prefixes
+func ParseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// reports whether addr falls within one of the trusted prefixes
+func IsTrustedProxy(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// whether r came from a trusted proxy, whose forwarding headers count
+func fromTrustedProxy(r *http.Request, trusted []netip.Prefix) bool {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	peer, err := netip.ParseAddr(host)
+	return err == nil && IsTrustedProxy(peer.Unmap(), trusted)
+}
+
+// the IP, without a port, of the client behind the request. X-Forwarded-For
+// and X-Real-IP only count when the peer is a trusted proxy; the chain is
+// then walked from the right, past trusted hops, to the first address a
+// trusted proxy vouches for, so a client can't spoof itself by sending its
+// own header. Rate limits key on this, so it must not vary per connection.
+func getClientIP(r *http.Request, trusted []netip.Prefix) string {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	peer, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	if !IsTrustedProxy(peer.Unmap(), trusted) {
+		return peer.Unmap().String()
+	}
+
+	if chain := forwardedChain(r); chain != "" {
+		hops := strings.Split(chain, ",")
+		client := peer.Unmap()
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, ok := parseHop(hops[i])
+			if !ok {
+				// anything left of here was written by the client
+				break
+			}
+			client = hop
+			if !IsTrustedProxy(hop, trusted) {
+				break
+			}
+		}
+		return client.String()
+	}
+
+	if realIP, ok := parseHop(r.Header.Get("X-Real-IP")); ok {
+		return realIP.String()
+	}
+
+	return peer.Unmap().String()
+}
+
+// an X-Forwarded-For entry, which some proxies write with a port
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.TrimSpace(hop)
+	if addr, err := netip.ParseAddr(hop); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
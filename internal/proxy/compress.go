@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// compressor gzips responses whose type and size make it worthwhile
+type compressor struct {
+	minSize      int
+	contentTypes map[string]bool
+}
+
+// nil when compression is off
+func newCompressor(cfg config.CompressionConfig) *compressor {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	contentTypes := make(map[string]bool, len(cfg.ContentTypes))
+	for _, ct := range cfg.ContentTypes {
+		contentTypes[strings.ToLower(ct)] = true
+	}
+	return &compressor{minSize: cfg.MinSize, contentTypes: contentTypes}
+}
+
+// wraps w when the client accepts gzip, nil otherwise; the writer's Close
+// must run once the response is complete
+func (c *compressor) wrap(w http.ResponseWriter, r *http.Request) *gzipWriter {
+	if c == nil || r.Method == http.MethodHead || !acceptsGzip(r) {
+		return nil
+	}
+	return &gzipWriter{ResponseWriter: w, compressor: c, statusCode: http.StatusOK}
+}
+
+// whether a response with this status and these headers may be compressed;
+// its size is checked separately
+func (c *compressor) eligible(statusCode int, header http.Header) bool {
+	switch {
+	case statusCode < http.StatusOK, statusCode == http.StatusNoContent,
+		statusCode == http.StatusPartialContent, statusCode == http.StatusNotModified:
+		return false
+	case header.Get("Content-Range") != "":
+		return false
+	case strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-transform"):
+		return false
+	}
+
+	// compressing twice gains nothing
+	if encoding := header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && c.contentTypes[mediaType]
+}
+
+// whether Accept-Encoding allows gzip, directly or through "*"
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			coding = strings.TrimSpace(coding)
+			if !strings.EqualFold(coding, "gzip") && coding != "*" {
+				continue
+			}
+
+			q := 1.0
+			if name, value, ok := strings.Cut(params, "="); ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					return false
+				}
+				q = parsed
+			}
+			return q > 0
+		}
+	}
+	return false
+}
+
+type gzipMode int
+
+const (
+	gzipUndecided gzipMode = iota // headers not written yet
+	gzipBuffering                 // no Content-Length, holding the body until min_size is reached
+	gzipCompressing
+	gzipPassthrough
+)
+
+// gzipWriter decides at WriteHeader whether to compress. A response without
+// a Content-Length is buffered until it reaches min_size; if it is flushed
+// or ends first it goes out as is, so streams of small writes aren't held back.
+type gzipWriter struct {
+	http.ResponseWriter
+	compressor *compressor
+	statusCode int
+	mode       gzipMode
+	buf        []byte
+	gz         *gzip.Writer
+}
+
+func (gw *gzipWriter) WriteHeader(code int) {
+	if gw.mode != gzipUndecided {
+		return
+	}
+	gw.statusCode = code
+
+	header := gw.Header()
+	if !gw.compressor.eligible(code, header) {
+		gw.passthrough()
+		return
+	}
+
+	if value := header.Get("Content-Length"); value != "" {
+		if size, err := strconv.Atoi(value); err != nil || size < gw.compressor.minSize {
+			gw.passthrough()
+			return
+		}
+		gw.compress()
+		return
+	}
+
+	gw.mode = gzipBuffering
+}
+
+func (gw *gzipWriter) Write(p []byte) (int, error) {
+	if gw.mode == gzipUndecided {
+		gw.WriteHeader(http.StatusOK)
+	}
+
+	switch gw.mode {
+	case gzipCompressing:
+		return gw.gz.Write(p)
+	case gzipBuffering:
+		gw.buf = append(gw.buf, p...)
+		if len(gw.buf) < gw.compressor.minSize {
+			return len(p), nil
+		}
+		gw.compress()
+		buffered := gw.buf
+		gw.buf = nil
+		if _, err := gw.gz.Write(buffered); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	default:
+		return gw.ResponseWriter.Write(p)
+	}
+}
+
+func (gw *gzipWriter) compress() {
+	header := gw.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", "gzip")
+	header.Add("Vary", "Accept-Encoding")
+	// the compressed bytes differ, so a strong validator no longer holds
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+
+	gw.ResponseWriter.WriteHeader(gw.statusCode)
+	gw.gz = gzipWriters.Get().(*gzip.Writer)
+	gw.gz.Reset(gw.ResponseWriter)
+	gw.mode = gzipCompressing
+}
+
+// sends the headers, and anything buffered, uncompressed
+func (gw *gzipWriter) passthrough() {
+	gw.mode = gzipPassthrough
+	gw.ResponseWriter.WriteHeader(gw.statusCode)
+	if len(gw.buf) > 0 {
+		gw.ResponseWriter.Write(gw.buf)
+		gw.buf = nil
+	}
+}
+
+func (gw *gzipWriter) Flush() {
+	switch gw.mode {
+	case gzipBuffering:
+		// the reverse proxy flushes streamed responses before their first
+		// write; deciding then would never compress them, so the headers
+		// wait for the body's first write
+		if len(gw.buf) == 0 {
+			return
+		}
+		gw.passthrough()
+	case gzipCompressing:
+		gw.gz.Flush()
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// finishes the response: writes out a body still buffered, or the gzip trailer
+func (gw *gzipWriter) Close() error {
+	switch gw.mode {
+	case gzipBuffering:
+		gw.passthrough()
+	case gzipCompressing:
+		err := gw.gz.Close()
+		gw.gz.Reset(nil)
+		gzipWriters.Put(gw.gz)
+		gw.gz = nil
+		gw.mode = gzipPassthrough
+		return err
+	}
+	return nil
+}
+
+func (gw *gzipWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestHandlerCompression(t *testing.T) {
+	largeJSON := `{"items":[` + strings.Repeat(`{"name":"widget","price":10},`, 200) + `{}]}`
+	smallJSON := `{"ok":true}`
+	binary := bytes.Repeat([]byte{0x89, 'P', 'N', 'G', 0x00}, 1000)
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		wantGzip       bool
+		wantBody       string
+	}{
+		{"large json", "/large", "gzip, deflate", true, largeJSON},
+		{"large json streamed", "/large-chunked", "gzip", true, largeJSON},
+		{"small json", "/small", "gzip", false, smallJSON},
+		{"binary", "/binary", "gzip", false, string(binary)},
+		{"already compressed", "/gzipped", "gzip", false, ""},
+		{"client without gzip", "/large", "", false, largeJSON},
+		{"gzip refused", "/large", "gzip;q=0, br", false, largeJSON},
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", strconv.Itoa(len(largeJSON)))
+			w.Header().Set("ETag", `"v1"`)
+			io.WriteString(w, largeJSON)
+		case "/large-chunked":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, largeJSON)
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, smallJSON)
+		case "/binary":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(binary)
+		case "/gzipped":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			io.WriteString(zw, largeJSON)
+			zw.Close()
+		}
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(t, &config.Config{
+		Compression: config.CompressionConfig{
+			Enabled:      true,
+			MinSize:      1024,
+			ContentTypes: []string{"application/json", "text/html"},
+		},
+		Upstreams: []config.Upstream{{
+			Name:     "test-upstream",
+			Backends: []config.Backend{{URL: backend.URL, Weight: 1}},
+		}},
+	}, nil)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			gzipped := resp.Header.Get("Content-Encoding") == "gzip"
+			if tt.path == "/gzipped" {
+				// passed through as the backend encoded it, not gzipped twice
+				zr, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatalf("Expected the backend's gzip body, got error %v", err)
+				}
+				body, _ := io.ReadAll(zr)
+				if string(body) != largeJSON {
+					t.Errorf("Expected a single gzip layer around the backend body")
+				}
+				return
+			}
+
+			if gzipped != tt.wantGzip {
+				t.Fatalf("Content-Encoding = %q, want gzip %v", resp.Header.Get("Content-Encoding"), tt.wantGzip)
+			}
+
+			body := resp.Body
+			if gzipped {
+				if cl := resp.Header.Get("Content-Length"); cl != "" {
+					t.Errorf("Expected Content-Length to be removed, got %s", cl)
+				}
+				if vary := resp.Header.Get("Vary"); vary != "Accept-Encoding" {
+					t.Errorf("Expected Vary: Accept-Encoding, got %q", vary)
+				}
+				zr, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatalf("gzip.NewReader() error = %v", err)
+				}
+				body = zr
+			}
+
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("Failed to read body: %v", err)
+			}
+			if string(got) != tt.wantBody {
+				t.Errorf("Body differs from the backend's (%d bytes, want %d)", len(got), len(tt.wantBody))
+			}
+			if gzipped && w.Body.Len() >= len(tt.wantBody) {
+				t.Errorf("Compressed body is %d bytes, not smaller than %d", w.Body.Len(), len(tt.wantBody))
+			}
+		})
+	}
+}
+
+func TestHandlerCompressionWeakensETag(t *testing.T) {
+	body := strings.Repeat("<p>hello</p>", 200)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("ETag", `"abc"`)
+		io.WriteString(w, body)
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(t, &config.Config{
+		Compression: config.CompressionConfig{
+			Enabled:      true,
+			MinSize:      1024,
+			ContentTypes: []string{"application/json", "text/html"},
+		},
+		Upstreams: []config.Upstream{{
+			Name:     "test-upstream",
+			Backends: []config.Backend{{URL: backend.URL, Weight: 1}},
+		}},
+	}, nil)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("ETag"); got != `W/"abc"` {
+		t.Errorf("ETag = %q, want the weak W/\"abc\"", got)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, GZIP", true},
+		{"br;q=1.0, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"identity", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			req.Header.Set("Accept-Encoding", tt.header)
+		}
+		if got := acceptsGzip(req); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestHandlerCompressionKeepsCacheUncompressed(t *testing.T) {
+	body := strings.Repeat(`{"id":1},`, 300)
+	backendHits := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendHits++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body)
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:     "test-upstream",
+			Backends: []config.Backend{{URL: backend.URL, Weight: 1}},
+			Cache: &config.CacheConfig{
+				Enabled:      true,
+				TTL:          time.Minute,
+				MaxEntries:   10,
+				MaxBodyBytes: 1 << 20,
+				Key:          []string{"method", "path"},
+			},
+		}},
+	}, nil)
+	handler.routing.Load().compressor = newCompressor(config.CompressionConfig{
+		Enabled:      true,
+		MinSize:      1024,
+		ContentTypes: []string{"application/json"},
+	})
+
+	get := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/items", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("gzip"); w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected the first response gzipped, got Content-Encoding %q", w.Header().Get("Content-Encoding"))
+	}
+
+	plain := get("")
+	if plain.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("Expected a cache hit, got X-Cache %q", plain.Header().Get("X-Cache"))
+	}
+	if plain.Header().Get("Content-Encoding") != "" || plain.Body.String() != body {
+		t.Errorf("Cache hit for a client without gzip should be the plain body, got Content-Encoding %q and %d bytes",
+			plain.Header().Get("Content-Encoding"), plain.Body.Len())
+	}
+
+	gzipped := get("gzip")
+	zr, err := gzip.NewReader(gzipped.Body)
+	if err != nil {
+		t.Fatalf("Cache hit for a gzip client should be compressed: %v", err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != body {
+		t.Errorf("Compressed cache hit decodes to %d bytes, want %d", len(got), len(body))
+	}
+
+	if backendHits != 1 {
+		t.Errorf("Expected 1 backend hit, got %d", backendHits)
+	}
+}
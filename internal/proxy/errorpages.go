@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// errorPage is a static body served in place of the JSON error
+type errorPage struct {
+	body        []byte
+	contentType string
+}
+
+func loadErrorPage(path string) (*errorPage, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error page: %w", err)
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+
+	return &errorPage{body: body, contentType: contentType}, nil
+}
+
+// loads the configured pages keyed by status code, plus the maintenance page
+func loadErrorPages(cfg config.ErrorPagesConfig) (map[int]*errorPage, *errorPage, error) {
+	pages := make(map[int]*errorPage)
+
+	for statusCode, path := range map[int]string{
+		http.StatusBadGateway:         cfg.BadGateway,
+		http.StatusServiceUnavailable: cfg.ServiceUnavailable,
+		http.StatusGatewayTimeout:     cfg.GatewayTimeout,
+	} {
+		if path == "" {
+			continue
+		}
+		page, err := loadErrorPage(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%d page: %w", statusCode, err)
+		}
+		pages[statusCode] = page
+	}
+
+	var maintenance *errorPage
+	if cfg.Maintenance != "" {
+		page, err := loadErrorPage(cfg.Maintenance)
+		if err != nil {
+			return nil, nil, fmt.Errorf("maintenance page: %w", err)
+		}
+		maintenance = page
+	}
+
+	return pages, maintenance, nil
+}
+
+func (p *errorPage) write(w http.ResponseWriter, statusCode int) {
+	w.Header().Set("Content-Type", p.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(p.body)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	w.Write(p.body)
+}
+
+// the JSON error body when error_pages.json_template is unset
+type errorBody struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// errorFields are the values an error template sees, each already encoded
+// as a JSON value so the template can't produce broken JSON from them
+type errorFields struct {
+	Message   string
+	Code      string
+	RequestID string
+}
+
+// parses error_pages.json_template, nil when it is unset
+func newErrorTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New("error").Option("missingkey=error").Parse(text)
+}
+
+// renders the JSON error body, falling back to the default schema if the
+// configured template fails
+func renderError(tmpl *template.Template, r *http.Request, message string, statusCode int) []byte {
+	if tmpl != nil {
+		fields := errorFields{
+			Message:   jsonString(message),
+			Code:      strconv.Itoa(statusCode),
+			RequestID: jsonString(r.Header.Get("X-Request-ID")),
+		}
+		var body bytes.Buffer
+		if err := tmpl.Execute(&body, fields); err == nil {
+			return body.Bytes()
+		}
+	}
+
+	body, _ := json.Marshal(errorBody{Error: message, Code: statusCode})
+	return body
+}
+
+func jsonString(s string) string {
+	encoded, _ := json.Marshal(s)
+	return string(encoded)
+}
@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/health"
+	"github.com/sanchxt/isame-lb/internal/metrics"
+)
+
+func writeErrorPage(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write error page: %v", err)
+	}
+	return path
+}
+
+func TestHandlerServesErrorPageWhenNoBackendsHealthy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	page := `<html><body><h1>Back soon</h1></body></html>`
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+		ErrorPages: config.ErrorPagesConfig{
+			ServiceUnavailable: writeErrorPage(t, "503.html", page),
+		},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{
+		Enabled:            true,
+		Interval:           20 * time.Millisecond,
+		Timeout:            time.Second,
+		Path:               "/health",
+		UnhealthyThreshold: 1,
+		HealthyThreshold:   1,
+	})
+	healthChecker.Start(cfg.Upstreams)
+	defer healthChecker.Stop()
+
+	handler, err := NewHandler(cfg, healthChecker, metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if healthChecker.IsHealthy(backend.URL) {
+		t.Fatal("Backend should be unhealthy before the request")
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+	if w.Body.String() != page {
+		t.Errorf("Expected configured page, got %q", w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Expected text/html content type from extension, got %q", ct)
+	}
+}
+
+func TestHandlerMaintenancePage(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb",
+		Server:  config.ServerConfig{Maintenance: true},
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: "http://backend1.invalid", Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+		ErrorPages: config.ErrorPagesConfig{
+			Maintenance: writeErrorPage(t, "maintenance.txt", "down for maintenance"),
+		},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "down for maintenance" {
+		t.Errorf("Expected maintenance page with 503, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestHandlerErrorPageFallsBackToJSON(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb",
+		Server:  config.ServerConfig{Maintenance: true},
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: "http://backend1.invalid", Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON error without configured pages, got %q", ct)
+	}
+}
+
+func TestNewHandlerMissingErrorPage(t *testing.T) {
+	cfg := &config.Config{
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: "http://backend1.invalid", Weight: 1}},
+			},
+		},
+		ErrorPages: config.ErrorPagesConfig{BadGateway: "/nonexistent/502.html"},
+	}
+
+	if _, err := NewHandler(cfg, nil, nil); err == nil {
+		t.Error("Expected error for a missing error page file")
+	}
+}
+
+func TestHandlerCustomJSONErrorTemplate(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb",
+		Server:  config.ServerConfig{Maintenance: true},
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: "http://backend1.invalid", Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+		ErrorPages: config.ErrorPagesConfig{
+			JSONTemplate: `{"message":{{.Message}},"status":{{.Code}},"request_id":{{.RequestID}}}`,
+		},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", `req-"42"`)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	var body struct {
+		Message   string `json:"message"`
+		Status    int    `json:"status"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Error body %q is not valid JSON: %v", w.Body.String(), err)
+	}
+	if body.Message != "Service under maintenance" || body.Status != http.StatusServiceUnavailable || body.RequestID != `req-"42"` {
+		t.Errorf("Unexpected error body %+v", body)
+	}
+}
+
+func TestRenderErrorEscapesValues(t *testing.T) {
+	message := "backend said \"no\"\n<b>\\</b>"
+
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"default", ""},
+		{"custom", `{"message":{{.Message}},"status":{{.Code}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := newErrorTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("newErrorTemplate() error = %v", err)
+			}
+
+			out := renderError(tmpl, httptest.NewRequest("GET", "/", nil), message, http.StatusBadGateway)
+
+			var body map[string]any
+			if err := json.Unmarshal(out, &body); err != nil {
+				t.Fatalf("renderError() = %q, not valid JSON: %v", out, err)
+			}
+			got := body["message"]
+			if tt.template == "" {
+				got = body["error"]
+			}
+			if got != message {
+				t.Errorf("message = %q, want %q", got, message)
+			}
+		})
+	}
+}
+
+func TestRenderErrorFallsBackOnTemplateFailure(t *testing.T) {
+	tmpl, err := newErrorTemplate(`{"message":{{.Missing}}}`)
+	if err != nil {
+		t.Fatalf("newErrorTemplate() error = %v", err)
+	}
+
+	out := renderError(tmpl, httptest.NewRequest("GET", "/", nil), "Bad gateway", http.StatusBadGateway)
+	if string(out) != `{"error":"Bad gateway","code":502}` {
+		t.Errorf("renderError() = %q, want the default schema", out)
+	}
+}
+
+func TestWriteErrorEscapesMessage(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: "http://backend1.invalid", Weight: 1}},
+			},
+		},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	message := `backend "http://backend1.invalid" refused: C:\path`
+	w := httptest.NewRecorder()
+	handler.writeError(w, httptest.NewRequest("GET", "/", nil), handler.routing.Load(), "test-upstream", message, http.StatusBadGateway, time.Now())
+
+	var body struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Error body %q is not valid JSON: %v", w.Body.String(), err)
+	}
+	if body.Error != message || body.Code != http.StatusBadGateway {
+		t.Errorf("Error body = %+v, want message %q and code 502", body, message)
+	}
+}
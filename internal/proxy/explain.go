@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/sanchxt/isame-lb/internal/balancer"
+)
+
+// Explanation describes where a request would be sent and why
+type Explanation struct {
+	Upstream string             `json:"upstream,omitempty"`
+	Reason   string             `json:"reason"` // which rule picked the upstream
+	Backends []BackendCandidate `json:"backends"`
+	Canary   string             `json:"canary,omitempty"` // canary backend that takes a share of requests, if any
+	Error    string             `json:"error,omitempty"`  // why no backend would be picked
+}
+
+// BackendCandidate is one of the upstream's backends and whether it could be picked
+type BackendCandidate struct {
+	URL       string `json:"url"`
+	Healthy   bool   `json:"healthy"`
+	Disabled  bool   `json:"disabled,omitempty"`
+	Drained   bool   `json:"drained,omitempty"`   // taken out of rotation through the admin API
+	Saturated bool   `json:"saturated,omitempty"` // at its max_conns
+	Circuit   string `json:"circuit"`
+	Eligible  bool   `json:"eligible"` // the balancer could pick it right now
+}
+
+// Explain runs routing for r without proxying it and lists the backends the
+// balancer could pick. Nothing is selected: that would take a round robin
+// turn or a connection slot away from real traffic.
+func (h *Handler) Explain(r *http.Request) Explanation {
+	rt := h.routing.Load()
+
+	healthStatus := h.healthSnapshot()
+
+	if rt.config.Server.Maintenance {
+		return Explanation{Reason: "server.maintenance", Backends: []BackendCandidate{}, Error: "service under maintenance"}
+	}
+
+	r, ok := normalizePath(r, rt.config.Server.PathNormalization)
+	if !ok {
+		return Explanation{Reason: "server.path_normalization", Backends: []BackendCandidate{}, Error: "invalid request path"}
+	}
+
+	upstream, reason := rt.explainMatch(r, healthStatus)
+	explanation := Explanation{Reason: reason, Backends: []BackendCandidate{}}
+	if upstream == nil {
+		explanation.Error = "no upstream matches request"
+		return explanation
+	}
+	explanation.Upstream = upstream.Name
+
+	if ramp := rt.canaries[upstream.Name]; ramp != nil {
+		explanation.Canary = ramp.Backend()
+	}
+
+	counter, _ := rt.loadBalancers[upstream.Name].(connectionCounter)
+	anyHealthy, anyEligible := false, false
+	for _, backend := range upstream.Backends {
+		healthy, exists := healthStatus[backend.URL]
+		candidate := BackendCandidate{
+			URL:      backend.URL,
+			Healthy:  !exists || healthy,
+			Disabled: backend.Disabled,
+			Drained:  h.IsDrained(backend.URL),
+			Circuit:  string(h.circuitBreaker.GetState(backend.URL)),
+		}
+		if counter != nil && backend.MaxConns > 0 {
+			candidate.Saturated = counter.GetConnections(backend.URL) >= int64(backend.MaxConns)
+		}
+
+		available := candidate.Healthy && !candidate.Disabled
+		candidate.Eligible = available && !candidate.Saturated && !h.circuitBreaker.IsOpen(backend.URL)
+		anyHealthy = anyHealthy || available
+		anyEligible = anyEligible || candidate.Eligible
+		explanation.Backends = append(explanation.Backends, candidate)
+	}
+
+	switch {
+	case !anyHealthy:
+		explanation.Error = balancer.ErrNoHealthyBackends.Error()
+	case !anyEligible:
+		explanation.Error = "every healthy backend is at max_conns or has its circuit open"
+	}
+	return explanation
+}
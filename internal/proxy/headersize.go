@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// approximates what the request took on the wire the way the server's
+// max_header_bytes counts it: the request line plus every header line
+func headerBytes(r *http.Request) int {
+	size := len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4 // two spaces and CRLF
+	if r.Host != "" {
+		size += len("Host: ") + len(r.Host) + 2
+	}
+	for name, values := range r.Header {
+		for _, value := range values {
+			size += len(name) + len(value) + 4 // ": " and CRLF
+		}
+	}
+	return size
+}
+
+// whether the request's headers exceed the upstream's max_header_bytes
+func headersTooLarge(r *http.Request, limit int) bool {
+	return limit > 0 && headerBytes(r) > limit
+}
+
+// whether the request carries more header lines or cookies than allowed;
+// cookies are counted by their separators rather than parsed
+func tooManyHeaders(r *http.Request, maxHeaders, maxCookies int) bool {
+	if maxHeaders > 0 {
+		count := 0
+		for _, values := range r.Header {
+			count += len(values)
+		}
+		if count > maxHeaders {
+			return true
+		}
+	}
+
+	if maxCookies > 0 {
+		count := 0
+		for _, value := range r.Header["Cookie"] {
+			if strings.TrimSpace(value) != "" {
+				count += strings.Count(value, ";") + 1
+			}
+		}
+		if count > maxCookies {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/health"
+	"github.com/sanchxt/isame-lb/internal/metrics"
+)
+
+func TestHandlerMaxHeaderBytesPerUpstream(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:           "strict",
+				Algorithm:      "round_robin",
+				Match:          &config.MatchConfig{PathPrefix: "/strict"},
+				Backends:       []config.Backend{{URL: backend.URL, Weight: 1}},
+				MaxHeaderBytes: 1024,
+			},
+			{
+				Name:      "auth",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	largeToken := "Bearer " + strings.Repeat("x", 2048)
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{name: "small headers on strict route", path: "/strict/items", token: "Bearer abc", status: http.StatusOK},
+		{name: "oversized header on strict route", path: "/strict/items", token: largeToken, status: http.StatusRequestHeaderFieldsTooLarge},
+		{name: "oversized header on unrestricted route", path: "/login", token: largeToken, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("Authorization", tt.token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandlerHeaderAndCookieCountLimits(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Server:  config.ServerConfig{MaxHeaderCount: 50, MaxCookieCount: 20},
+		Upstreams: []config.Upstream{
+			{Name: "api", Algorithm: "round_robin", Backends: []config.Backend{{URL: backend.URL, Weight: 1}}},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	cookies := func(n int) string {
+		pairs := make([]string, n)
+		for i := range pairs {
+			pairs[i] = fmt.Sprintf("c%d=v", i)
+		}
+		return strings.Join(pairs, "; ")
+	}
+
+	tests := []struct {
+		name    string
+		headers int
+		cookie  []string
+		status  int
+	}{
+		{name: "within limits", headers: 10, cookie: []string{cookies(5)}, status: http.StatusOK},
+		{name: "too many headers", headers: 5000, status: http.StatusRequestHeaderFieldsTooLarge},
+		{name: "too many cookies", headers: 1, cookie: []string{cookies(3000)}, status: http.StatusRequestHeaderFieldsTooLarge},
+		{name: "cookies split over headers", headers: 1, cookie: []string{cookies(15), cookies(15)}, status: http.StatusRequestHeaderFieldsTooLarge},
+		{name: "cookies at the limit", headers: 1, cookie: []string{cookies(20)}, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/items", nil)
+			for i := 0; i < tt.headers; i++ {
+				req.Header.Add(fmt.Sprintf("X-Junk-%d", i), "x")
+			}
+			for _, cookie := range tt.cookie {
+				req.Header.Add("Cookie", cookie)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
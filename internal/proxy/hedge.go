@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/balancer"
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// hedger holds an upstream's hedging budget and caps its hedges in flight
+type hedger struct {
+	budget time.Duration
+	slots  chan struct{}
+}
+
+func newHedger(cfg *config.HedgeConfig) *hedger {
+	return &hedger{
+		budget: cfg.Budget,
+		slots:  make(chan struct{}, cfg.MaxConcurrent),
+	}
+}
+
+// claims a slot without waiting; false when the upstream is at its cap
+func (hg *hedger) acquire() bool {
+	select {
+	case hg.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (hg *hedger) release() {
+	<-hg.slots
+}
+
+// hedges only go out for idempotent methods whose body can be sent twice
+func hedgeable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions, http.MethodTrace:
+	default:
+		return false
+	}
+	return r.GetBody != nil || bufferBody(r)
+}
+
+// hedgedRequest is one attempt's primary request plus the hedge it may
+// start. It is the attempt's transport: the first response to arrive is
+// the one proxied, and the other request is cancelled.
+type hedgedRequest struct {
+	next       http.RoundTripper
+	hedger     *hedger
+	primaryURL string
+	primary    *url.URL
+
+	// picks another backend and claims it for the hedge; release undoes
+	// the claim once the attempt is over
+	pickBackup func() (backendURL string, release func(), ok bool)
+
+	mu        sync.Mutex
+	backup    string // set once a hedge was sent
+	release   func()
+	backupWon bool
+}
+
+// newHedgedRequest wraps the routing's transport for one attempt at primary
+func (h *Handler) newHedgedRequest(rt *routing, hg *hedger, upstream *config.Upstream, r *http.Request, primary string, target *url.URL, healthStatus map[string]bool) *hedgedRequest {
+	lb := rt.loadBalancers[upstream.Name]
+	ramp := rt.canaries[upstream.Name]
+
+	pickBackup := func() (string, func(), bool) {
+		// hedges stay on the stable set so they don't add to a canary's share
+		var others []config.Backend
+		for _, backend := range upstream.Backends {
+			if backend.URL == primary || (ramp != nil && backend.URL == ramp.Backend()) {
+				continue
+			}
+			others = append(others, backend)
+		}
+
+		selected, err := lb.SelectBackend(r, others, healthStatus)
+		if err != nil {
+			return "", nil, false
+		}
+
+		release := func() {}
+		if tracker, ok := lb.(balancer.ConnectionTracker); ok {
+			release = func() { tracker.DecrementConnections(selected.URL) }
+		}
+		if !h.circuitBreaker.CanAttempt(selected.URL) {
+			release()
+			return "", nil, false
+		}
+		return selected.URL, release, true
+	}
+
+	return &hedgedRequest{
+		next:       rt.transport,
+		hedger:     hg,
+		primaryURL: primary,
+		primary:    target,
+		pickBackup: pickBackup,
+	}
+}
+
+// a leg's result, with the cancel func for its context
+type hedgeResult struct {
+	resp   *http.Response
+	err    error
+	cancel context.CancelFunc
+	backup bool
+}
+
+func (hr *hedgedRequest) RoundTrip(req *http.Request) (*http.Response, error) {
+	results := make(chan hedgeResult, 2)
+	cancelPrimary := hr.send(req, false, results)
+
+	timer := time.NewTimer(hr.hedger.budget)
+	defer timer.Stop()
+
+	pending := 1
+	select {
+	case result := <-results:
+		// answered within the budget, nothing to hedge
+		return hr.finishLeg(result)
+	case <-timer.C:
+	case <-req.Context().Done():
+		return hr.finishLeg(<-results)
+	}
+
+	cancelBackup := func() {}
+	if backupReq, ok := hr.startBackup(req); ok {
+		cancelBackup = hr.send(backupReq, true, results)
+		pending++
+	}
+
+	var failed *hedgeResult
+	for ; pending > 0; pending-- {
+		result := <-results
+		if result.err != nil {
+			// when both fail, the primary's error is the one reported
+			if failed == nil || !result.backup {
+				if failed != nil {
+					failed.cancel()
+				}
+				failed = &result
+			} else {
+				result.cancel()
+			}
+			continue
+		}
+
+		if pending > 1 {
+			if result.backup {
+				cancelPrimary()
+			} else {
+				cancelBackup()
+			}
+			go drainLoser(results)
+		}
+		hr.mu.Lock()
+		hr.backupWon = result.backup
+		hr.mu.Unlock()
+		return hr.finishLeg(result)
+	}
+
+	return hr.finishLeg(*failed)
+}
+
+// runs one leg in the background under its own cancellable context
+func (hr *hedgedRequest) send(req *http.Request, backup bool, results chan<- hedgeResult) context.CancelFunc {
+	ctx, cancel := context.WithCancel(req.Context())
+	go func() {
+		resp, err := hr.next.RoundTrip(req.WithContext(ctx))
+		results <- hedgeResult{resp: resp, err: err, cancel: cancel, backup: backup}
+	}()
+	return cancel
+}
+
+// copies req onto a backup backend; false when the upstream is at its hedge
+// cap or has no other backend to send it to
+func (hr *hedgedRequest) startBackup(req *http.Request) (*http.Request, bool) {
+	if !hr.hedger.acquire() {
+		return nil, false
+	}
+
+	backendURL, release, ok := hr.pickBackup()
+	if !ok {
+		hr.hedger.release()
+		return nil, false
+	}
+
+	target, err := url.Parse(backendURL)
+	if err != nil {
+		release()
+		hr.hedger.release()
+		return nil, false
+	}
+
+	hr.mu.Lock()
+	hr.backup = backendURL
+	hr.release = release
+	hr.mu.Unlock()
+
+	backupReq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		backupReq.Body, _ = req.GetBody()
+	}
+	retarget(backupReq.URL, hr.primary, target)
+	return backupReq, true
+}
+
+// the loser was cancelled; close its response should it have arrived anyway
+func drainLoser(results <-chan hedgeResult) {
+	result := <-results
+	result.cancel()
+	if result.resp != nil {
+		result.resp.Body.Close()
+	}
+}
+
+// hands back a leg's response, cancelling its context once the body is
+// done with instead of right away
+func (hr *hedgedRequest) finishLeg(result hedgeResult) (*http.Response, error) {
+	if result.err != nil {
+		result.cancel()
+		return nil, result.err
+	}
+	result.resp.Body = cancelOnClose{ReadCloser: result.resp.Body, cancel: result.cancel}
+	return result.resp, nil
+}
+
+// served reports the backend whose response was proxied and the hedge
+// backend that lost, if one was sent; the attempt's breaker accounting
+// follows the winner. It also gives back the hedge's claims.
+func (hr *hedgedRequest) served() (winner, loser string) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+
+	if hr.backup == "" {
+		return hr.primaryURL, ""
+	}
+
+	hr.release()
+	hr.hedger.release()
+	if hr.backupWon {
+		return hr.backup, hr.primaryURL
+	}
+	return hr.primaryURL, hr.backup
+}
+
+// runs the upstream's response hooks for whichever backend's response won
+func (hr *hedgedRequest) modifyResponse(hooks func(backendURL string) func(*http.Response) error) func(*http.Response) error {
+	if hooks(hr.primaryURL) == nil {
+		return nil
+	}
+
+	return func(resp *http.Response) error {
+		hr.mu.Lock()
+		backendURL := hr.primaryURL
+		if hr.backupWon {
+			backendURL = hr.backup
+		}
+		hr.mu.Unlock()
+
+		return hooks(backendURL)(resp)
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// points u, already directed at from, at to instead, keeping whatever path
+// the request added after from's base path
+func retarget(u *url.URL, from, to *url.URL) {
+	u.Scheme = to.Scheme
+	u.Host = to.Host
+	if from.Path == to.Path {
+		return
+	}
+
+	rest := strings.TrimPrefix(u.Path, strings.TrimSuffix(from.Path, "/"))
+	u.Path = strings.TrimSuffix(to.Path, "/") + rest
+	u.RawPath = ""
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// a backend that answers after delay, reporting when a client gave up on it
+func slowBackend(delay time.Duration, cancelled chan<- struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server only notices a closed connection once the body is read
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-time.After(delay):
+			w.Write([]byte("slow"))
+		case <-r.Context().Done():
+			cancelled <- struct{}{}
+		}
+	}))
+}
+
+func TestHandlerHedgeWins(t *testing.T) {
+	cancelled := make(chan struct{}, 1)
+	slow := slowBackend(2*time.Second, cancelled)
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	// round robin picks the slow backend first, so the hedge goes to the fast one
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:     "api",
+			Backends: []config.Backend{{URL: slow.URL, Weight: 1}, {URL: fast.URL, Weight: 1}},
+			Hedge:    &config.HedgeConfig{Enabled: true, Budget: 50 * time.Millisecond, MaxConcurrent: 1},
+		}},
+	}, nil)
+
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/items", nil))
+
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "fast" {
+		t.Fatalf("Expected the hedge's response, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the hedge to answer well before the slow primary, took %v", elapsed)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the losing request to the slow backend to be cancelled")
+	}
+	if got := len(handler.routing.Load().hedgers["api"].slots); got != 0 {
+		t.Errorf("Expected the hedge slot to be released, %d still held", got)
+	}
+}
+
+func TestHandlerHedgeSkipsNonIdempotent(t *testing.T) {
+	cancelled := make(chan struct{}, 1)
+	slow := slowBackend(150*time.Millisecond, cancelled)
+	defer slow.Close()
+
+	hedged := make(chan struct{}, 1)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hedged <- struct{}{}
+		w.Write([]byte("other"))
+	}))
+	defer other.Close()
+
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:     "api",
+			Backends: []config.Backend{{URL: slow.URL, Weight: 1}, {URL: other.URL, Weight: 1}},
+			Hedge:    &config.HedgeConfig{Enabled: true, Budget: 20 * time.Millisecond, MaxConcurrent: 1},
+		}},
+	}, nil)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/orders", strings.NewReader("{}")))
+
+	if recorder.Body.String() != "slow" {
+		t.Errorf("Expected POST to wait for its backend, got %q", recorder.Body.String())
+	}
+	select {
+	case <-hedged:
+		t.Error("POST should never be hedged")
+	default:
+	}
+}
+
+func TestHandlerHedgeCap(t *testing.T) {
+	cancelled := make(chan struct{}, 1)
+	slow := slowBackend(100*time.Millisecond, cancelled)
+	defer slow.Close()
+
+	hedged := make(chan struct{}, 1)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hedged <- struct{}{}
+		w.Write([]byte("other"))
+	}))
+	defer other.Close()
+
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:     "api",
+			Backends: []config.Backend{{URL: slow.URL, Weight: 1}, {URL: other.URL, Weight: 1}},
+			Hedge:    &config.HedgeConfig{Enabled: true, Budget: 20 * time.Millisecond, MaxConcurrent: 1},
+		}},
+	}, nil)
+
+	// the only slot is taken by a hedge still in flight
+	hg := handler.routing.Load().hedgers["api"]
+	if !hg.acquire() {
+		t.Fatal("Expected a free hedge slot")
+	}
+	defer hg.release()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/items", nil))
+
+	if recorder.Body.String() != "slow" {
+		t.Errorf("Expected the primary's response with no hedge slot free, got %q", recorder.Body.String())
+	}
+	select {
+	case <-hedged:
+		t.Error("No hedge should be sent while the upstream is at max_concurrent")
+	default:
+	}
+}
+
+func TestHandlerHedgeResendsBody(t *testing.T) {
+	cancelled := make(chan struct{}, 1)
+	slow := slowBackend(2*time.Second, cancelled)
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer fast.Close()
+
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:     "api",
+			Backends: []config.Backend{{URL: slow.URL, Weight: 1}, {URL: fast.URL, Weight: 1}},
+			Hedge:    &config.HedgeConfig{Enabled: true, Budget: 20 * time.Millisecond, MaxConcurrent: 1},
+		}},
+	}, nil)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("PUT", "/items/1", strings.NewReader(`{"name":"x"}`)))
+
+	if recorder.Body.String() != `{"name":"x"}` {
+		t.Errorf("Expected the hedge to carry the request body, got %q", recorder.Body.String())
+	}
+}
+
+func TestRetarget(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		from string
+		to   string
+		want string
+	}{
+		{name: "same base path", path: "/items", from: "http://a:1", to: "http://b:2", want: "http://b:2/items"},
+		{name: "different base path", path: "/v1/items", from: "http://a:1/v1", to: "http://b:2/v2", want: "http://b:2/v2/items"},
+		{name: "base path with slash", path: "/v1/items", from: "http://a:1/v1/", to: "http://b:2", want: "http://b:2/items"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, _ := url.Parse(tt.from)
+			to, _ := url.Parse(tt.to)
+			u := &url.URL{Scheme: from.Scheme, Host: from.Host, Path: tt.path}
+
+			retarget(u, from, to)
+			if u.String() != tt.want {
+				t.Errorf("retarget() = %s, want %s", u, tt.want)
+			}
+		})
+	}
+}
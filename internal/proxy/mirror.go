@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// mirror copies an upstream's requests to its shadow backend and caps the
+// copies in flight
+type mirror struct {
+	target  *url.URL
+	timeout time.Duration
+	slots   chan struct{}
+	compare *config.ShadowCompareConfig // nil unless shadow responses are compared
+}
+
+func newMirror(cfg *config.MirrorConfig) *mirror {
+	// validated with the config
+	target, _ := url.Parse(cfg.URL)
+
+	m := &mirror{
+		target:  target,
+		timeout: cfg.Timeout,
+		slots:   make(chan struct{}, cfg.MaxConcurrent),
+	}
+	if cfg.Compare != nil && cfg.Compare.Enabled {
+		m.compare = cfg.Compare
+	}
+	return m
+}
+
+// the parts of a response that shadow compare looks at
+type shadowResponse struct {
+	statusCode int
+	header     http.Header
+	bodyHash   []byte // of at most compare.max_body_bytes
+	err        error
+}
+
+// send starts a copy of r, with prefix stripped like for the backends, toward
+// the shadow backend. The returned channel gets the shadow's response; it is
+// nil when r was not mirrored because the upstream is at its cap or the body
+// is too large to send twice.
+func (m *mirror) send(r *http.Request, prefix string, next http.RoundTripper) <-chan shadowResponse {
+	if r.GetBody == nil && !bufferBody(r) {
+		return nil
+	}
+
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		return nil
+	}
+
+	// the shadow gets its own deadline, finishing the client's response
+	// must not cut it short
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), m.timeout)
+	req := r.Clone(ctx)
+	req.RequestURI = ""
+	if r.GetBody != nil {
+		req.Body, _ = r.GetBody()
+	}
+	stripPrefix(req.URL, prefix)
+	retarget(req.URL, &url.URL{}, m.target)
+
+	results := make(chan shadowResponse, 1)
+	go func() {
+		defer func() { <-m.slots }()
+		defer cancel()
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			results <- shadowResponse{err: err}
+			return
+		}
+		defer resp.Body.Close()
+
+		result := shadowResponse{statusCode: resp.StatusCode, header: resp.Header}
+		if m.compare != nil && m.compare.Body {
+			digest := sha256.New()
+			io.Copy(digest, io.LimitReader(resp.Body, m.compare.MaxBodyBytes))
+			result.bodyHash = digest.Sum(nil)
+		}
+		// lets the connection go back to the pool
+		io.Copy(io.Discard, resp.Body)
+
+		results <- result
+	}()
+
+	return results
+}
+
+// shadowRecorder passes the proxied response through while keeping what
+// shadow compare needs of it
+type shadowRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	header      http.Header // as sent to the client
+	digest      hash.Hash
+	remaining   int64
+	wroteHeader bool
+}
+
+func newShadowRecorder(w http.ResponseWriter, compare *config.ShadowCompareConfig) *shadowRecorder {
+	sr := &shadowRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+	if compare.Body {
+		sr.digest = sha256.New()
+		sr.remaining = compare.MaxBodyBytes
+	}
+	return sr
+}
+
+func (sr *shadowRecorder) WriteHeader(code int) {
+	if !sr.wroteHeader {
+		sr.wroteHeader = true
+		sr.statusCode = code
+		sr.header = sr.ResponseWriter.Header().Clone()
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *shadowRecorder) Write(p []byte) (int, error) {
+	if !sr.wroteHeader {
+		sr.WriteHeader(http.StatusOK)
+	}
+	if sr.digest != nil && sr.remaining > 0 {
+		chunk := p
+		if int64(len(chunk)) > sr.remaining {
+			chunk = chunk[:sr.remaining]
+		}
+		sr.digest.Write(chunk)
+		sr.remaining -= int64(len(chunk))
+	}
+	return sr.ResponseWriter.Write(p)
+}
+
+func (sr *shadowRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// the proxied response as shadow compare sees it
+func (sr *shadowRecorder) response() shadowResponse {
+	header := sr.header
+	if !sr.wroteHeader {
+		header = sr.ResponseWriter.Header()
+	}
+
+	result := shadowResponse{statusCode: sr.statusCode, header: header}
+	if sr.digest != nil {
+		result.bodyHash = sr.digest.Sum(nil)
+	}
+	return result
+}
+
+// a field that differed between the proxied and the shadow response
+type shadowDiff struct {
+	field  string // "status", "header" or "body", the metric label
+	detail string
+}
+
+func diffShadow(primary, shadow shadowResponse, compare *config.ShadowCompareConfig) []shadowDiff {
+	var diffs []shadowDiff
+
+	if compare.Status && primary.statusCode != shadow.statusCode {
+		diffs = append(diffs, shadowDiff{"status", fmt.Sprintf("status %d vs %d", primary.statusCode, shadow.statusCode)})
+	}
+
+	for _, name := range compare.Headers {
+		got, want := shadow.header.Get(name), primary.header.Get(name)
+		if got != want {
+			diffs = append(diffs, shadowDiff{"header", name + ": " + want + " vs " + got})
+		}
+	}
+
+	if compare.Body && !bytes.Equal(primary.bodyHash, shadow.bodyHash) {
+		diffs = append(diffs, shadowDiff{"body", "body hashes differ"})
+	}
+
+	return diffs
+}
+
+// waits for the shadow off the request path and reports how it differed
+// from what the client got
+func (h *Handler) compareShadow(upstream string, m *mirror, r *http.Request, primary shadowResponse, shadow <-chan shadowResponse) {
+	method, path := r.Method, r.URL.Path
+
+	go func() {
+		result := <-shadow
+		if result.err != nil {
+			log.Printf("Mirror request to %s failed: %v", m.target.Host, result.err)
+			return
+		}
+
+		for _, diff := range diffShadow(primary, result, m.compare) {
+			log.Printf("Shadow diff for %s %s on upstream %s: %s (primary vs shadow)", method, path, upstream, diff.detail)
+			if h.metrics != nil {
+				h.metrics.RecordShadowDiff(upstream, diff.field)
+			}
+		}
+	}()
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/metrics"
+)
+
+func TestHandlerMirrorSendsCopy(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("primary"))
+	}))
+	defer primary.Close()
+
+	mirrored := make(chan string, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored <- r.Method + " " + r.URL.Path + " " + string(body)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:     "api",
+			Backends: []config.Backend{{URL: primary.URL, Weight: 1}},
+			Mirror:   &config.MirrorConfig{Enabled: true, URL: shadow.URL},
+		}},
+	}, metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/items/1", strings.NewReader("payload")))
+
+	if w.Code != http.StatusOK || w.Body.String() != "primary" {
+		t.Errorf("Expected the primary's 200 response, got %d %q", w.Code, w.Body.String())
+	}
+
+	select {
+	case got := <-mirrored:
+		if got != "PUT /items/1 payload" {
+			t.Errorf("Expected the shadow to get a copy of the request, got %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the request to be mirrored")
+	}
+}
+
+func TestHandlerShadowCompareRecordsStatusDiff(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer primary.Close()
+
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer shadow.Close()
+
+	collector := metrics.NewCollector(config.MetricsConfig{Enabled: true})
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:     "api",
+			Backends: []config.Backend{{URL: primary.URL, Weight: 1}},
+			Mirror: &config.MirrorConfig{
+				Enabled: true,
+				URL:     shadow.URL,
+				Compare: &config.ShadowCompareConfig{Enabled: true, Status: true},
+			},
+		}},
+	}, collector)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from the primary, got %d", w.Code)
+	}
+
+	expected := `isame_lb_shadow_diffs_total{field="status",upstream="api"} 1`
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mw := httptest.NewRecorder()
+		collector.Handler().ServeHTTP(mw, httptest.NewRequest("GET", "/metrics", nil))
+		if strings.Contains(mw.Body.String(), expected) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s in metrics:\n%s", expected, mw.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDiffShadow(t *testing.T) {
+	compare := &config.ShadowCompareConfig{Status: true, Headers: []string{"Content-Type"}, Body: true, MaxBodyBytes: 4}
+
+	record := func(status int, contentType, body string) shadowResponse {
+		w := httptest.NewRecorder()
+		sr := newShadowRecorder(w, compare)
+		sr.Header().Set("Content-Type", contentType)
+		sr.WriteHeader(status)
+		sr.Write([]byte(body))
+		return sr.response()
+	}
+
+	primary := record(http.StatusOK, "text/plain", "same-prefix")
+
+	tests := []struct {
+		name   string
+		shadow shadowResponse
+		fields []string
+	}{
+		{name: "identical", shadow: record(http.StatusOK, "text/plain", "same-prefix")},
+		{name: "differs past the body limit", shadow: record(http.StatusOK, "text/plain", "same-other")},
+		{name: "status", shadow: record(http.StatusNotFound, "text/plain", "same-prefix"), fields: []string{"status"}},
+		{name: "header", shadow: record(http.StatusOK, "application/json", "same-prefix"), fields: []string{"header"}},
+		{name: "body", shadow: record(http.StatusOK, "text/plain", "diff"), fields: []string{"body"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs := diffShadow(primary, tt.shadow, compare)
+			if len(diffs) != len(tt.fields) {
+				t.Fatalf("Expected diffs in %v, got %v", tt.fields, diffs)
+			}
+			for i, diff := range diffs {
+				if diff.field != tt.fields[i] {
+					t.Errorf("Expected diff in %s, got %s", tt.fields[i], diff.field)
+				}
+			}
+		})
+	}
+}
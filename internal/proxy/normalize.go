@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// normalizePath returns r with its path rewritten as configured, before it
+// is routed; false when .. segments climb above the root, which is rejected
+// rather than clamped so a crafted path can't land on a different backend path
+func normalizePath(r *http.Request, cfg config.PathNormalizationConfig) (*http.Request, bool) {
+	if !cfg.Enabled {
+		return r, true
+	}
+
+	// checked on the decoded path first, so %2e%2e and %2f can't smuggle
+	// a climb above the root past the encoded-path exemption below
+	cleaned, ok := cleanPath(r.URL.Path)
+	if !ok {
+		return r, false
+	}
+
+	// with encoded slashes the decoded path no longer tells segments apart,
+	// and rewriting it would change what the backend receives
+	if r.URL.RawPath != "" {
+		return r, true
+	}
+
+	switch cfg.TrailingSlash {
+	case config.TrailingSlashAdd:
+		if !strings.HasSuffix(cleaned, "/") {
+			cleaned += "/"
+		}
+	case config.TrailingSlashStrip:
+		if cleaned != "/" {
+			cleaned = strings.TrimSuffix(cleaned, "/")
+		}
+	}
+
+	if cleaned == r.URL.Path {
+		return r, true
+	}
+
+	normalized := r.Clone(r.Context())
+	normalized.URL.Path = cleaned
+	return normalized, true
+}
+
+// collapses empty segments and resolves . and .., keeping a trailing slash
+// the path already had; false when .. would leave the root
+func cleanPath(p string) (string, bool) {
+	var segments []string
+	directory := false
+	for _, segment := range strings.Split(p, "/") {
+		directory = false
+		switch segment {
+		case "", ".":
+			directory = true
+		case "..":
+			if len(segments) == 0 {
+				return "", false
+			}
+			segments = segments[:len(segments)-1]
+			directory = true
+		default:
+			segments = append(segments, segment)
+		}
+	}
+
+	cleaned := "/" + strings.Join(segments, "/")
+	if directory && len(segments) > 0 {
+		cleaned += "/"
+	}
+	return cleaned, true
+}
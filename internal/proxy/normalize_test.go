@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/health"
+	"github.com/sanchxt/isame-lb/internal/metrics"
+)
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		ok       bool
+	}{
+		{path: "/", expected: "/", ok: true},
+		{path: "//api///users", expected: "/api/users", ok: true},
+		{path: "/api//users/", expected: "/api/users/", ok: true},
+		{path: "/api/./users", expected: "/api/users", ok: true},
+		{path: "/api/v1/../users", expected: "/api/users", ok: true},
+		{path: "/api/users/..", expected: "/api/", ok: true},
+		{path: "/..", ok: false},
+		{path: "/api/../../etc/passwd", ok: false},
+		{path: "//..//etc", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			cleaned, ok := cleanPath(tt.path)
+			if ok != tt.ok {
+				t.Fatalf("cleanPath(%q) ok = %v, want %v", tt.path, ok, tt.ok)
+			}
+			if ok && cleaned != tt.expected {
+				t.Errorf("cleanPath(%q) = %q, want %q", tt.path, cleaned, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalizePathTrailingSlash(t *testing.T) {
+	tests := []struct {
+		name          string
+		trailingSlash string
+		path          string
+		expected      string
+	}{
+		{name: "kept", path: "/docs/", expected: "/docs/"},
+		{name: "added", trailingSlash: config.TrailingSlashAdd, path: "/docs", expected: "/docs/"},
+		{name: "stripped", trailingSlash: config.TrailingSlashStrip, path: "/docs//", expected: "/docs"},
+		{name: "root never stripped", trailingSlash: config.TrailingSlashStrip, path: "/", expected: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.PathNormalizationConfig{Enabled: true, TrailingSlash: tt.trailingSlash}
+			r, ok := normalizePath(httptest.NewRequest("GET", tt.path, nil), cfg)
+			if !ok {
+				t.Fatalf("normalizePath(%q) rejected the path", tt.path)
+			}
+			if r.URL.Path != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, r.URL.Path)
+			}
+		})
+	}
+}
+
+func TestNormalizePathRejectsEncodedTraversal(t *testing.T) {
+	cfg := config.PathNormalizationConfig{Enabled: true}
+
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{path: "/api/%2e%2e/%2e%2e/secret", ok: false},
+		{path: "/api/%2E%2E/%2e%2E/secret", ok: false},
+		{path: "/api%2f..%2f..%2fsecret", ok: false},
+		{path: "/api/..%2f..%2fsecret", ok: false},
+		{path: "/api/files%2freport.pdf", ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			r, ok := normalizePath(req, cfg)
+			if ok != tt.ok {
+				t.Fatalf("normalizePath(%q) ok = %v, want %v (decoded path %q)", tt.path, ok, tt.ok, req.URL.Path)
+			}
+			if ok && r.URL.RawPath != req.URL.RawPath {
+				t.Errorf("Encoded path should be forwarded untouched, got %q", r.URL.RawPath)
+			}
+		})
+	}
+}
+
+func TestHandlerPathNormalization(t *testing.T) {
+	var receivedPaths []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedPaths = append(receivedPaths, r.URL.EscapedPath())
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Server:  config.ServerConfig{PathNormalization: config.PathNormalizationConfig{Enabled: true}},
+		Upstreams: []config.Upstream{
+			{
+				Name:      "api",
+				Algorithm: "round_robin",
+				Match:     &config.MatchConfig{PathPrefix: "/api"},
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		received string
+	}{
+		{name: "duplicate slashes collapse before routing", path: "//api///users", status: http.StatusOK, received: "/api/users"},
+		{name: "dot segments resolve", path: "/static/../api/./users", status: http.StatusOK, received: "/api/users"},
+		{name: "traversal above root rejected", path: "/api/../../etc/passwd", status: http.StatusBadRequest},
+		{name: "encoded slashes left alone", path: "/api/files/a%2F..%2Fb", status: http.StatusOK, received: "/api/files/a%2F..%2Fb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receivedPaths = nil
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if tt.received == "" {
+				if len(receivedPaths) != 0 {
+					t.Errorf("Rejected request should not reach the backend, got %v", receivedPaths)
+				}
+				return
+			}
+			if len(receivedPaths) != 1 || receivedPaths[0] != tt.received {
+				t.Errorf("Expected the backend to receive %q, got %v", tt.received, receivedPaths)
+			}
+		})
+	}
+}
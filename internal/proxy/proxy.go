@@ -1,161 +1,841 @@
 package proxy
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
+	"net/netip"
 	"net/url"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/sanchxt/isame-lb/internal/balancer"
+	"github.com/sanchxt/isame-lb/internal/canary"
 	"github.com/sanchxt/isame-lb/internal/circuitbreaker"
 	"github.com/sanchxt/isame-lb/internal/config"
 	"github.com/sanchxt/isame-lb/internal/health"
 	"github.com/sanchxt/isame-lb/internal/metrics"
 	"github.com/sanchxt/isame-lb/internal/ratelimit"
 	"github.com/sanchxt/isame-lb/internal/retry"
+	"github.com/sanchxt/isame-lb/internal/transport"
 )
 
 type Handler struct {
-	config         *config.Config
-	loadBalancers  map[string]balancer.LoadBalancer
+	routing        atomic.Pointer[routing] // swapped whole on reload
 	healthChecker  *health.Checker
 	metrics        *metrics.Collector
 	circuitBreaker *circuitbreaker.CircuitBreaker
-	retrier        *retry.Retrier
-	rateLimiters   map[string]*ratelimit.RateLimiter // per-upstream rate limiters
+	accessLog      *AccessLog // nil unless access logging is enabled
+
+	inFlight atomic.Int64 // requests being served, upgraded connections until they close
+
+	ratesMu sync.RWMutex
+	rates   map[string]*requestRate // rolling requests per second by upstream
+
+	drainedMu sync.RWMutex
+	drained   map[string]bool // backends taken out of rotation through the admin API
+}
+
+// everything derived from one config, so a request sees a consistent view
+// from start to finish even if a reload lands mid-flight
+type routing struct {
+	config        *config.Config
+	loadBalancers map[string]balancer.LoadBalancer
+	retrier       *retry.Retrier
+	rateLimiters  map[string]*ratelimit.RateLimiter // per-upstream rate limiters
+	tarpits       map[string]*tarpit                // per-upstream holds for rate-limited requests
+	transport     http.RoundTripper                 // shared backend transport
+	bodyRewriters map[string]*bodyRewriter          // per-upstream response body rewriters
+	caches        map[string]*responseCache         // per-upstream response caches
+	canaries      map[string]*canary.Ramp           // per-upstream automated canary ramps
+	hedgers       map[string]*hedger                // per-upstream request hedging
+	mirrors       map[string]*mirror                // per-upstream shadow backends
+
+	errorPages      map[int]*errorPage // static bodies by status code
+	maintenancePage *errorPage
+	errorTemplate   *template.Template // JSON error body for errors without a page
+
+	trustedProxies []netip.Prefix // peers whose forwarding headers are believed
+	compressor     *compressor    // gzips responses, nil when compression is off
 }
 
 func NewHandler(cfg *config.Config, healthChecker *health.Checker, metricsCollector *metrics.Collector) (*Handler, error) {
-	loadBalancers := make(map[string]balancer.LoadBalancer)
-	rateLimiters := make(map[string]*ratelimit.RateLimiter)
+	h := &Handler{
+		healthChecker:  healthChecker,
+		metrics:        metricsCollector,
+		circuitBreaker: circuitbreaker.New(cfg.CircuitBreaker),
+		rates:          make(map[string]*requestRate),
+		drained:        make(map[string]bool),
+	}
+	h.circuitBreaker.SetStateListener(h.recordBreakerState)
+
+	rt, err := h.buildRouting(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	h.routing.Store(rt)
+	rt.startCanaries(nil)
+	h.publishBreakerStates(rt)
+
+	return h, nil
+}
+
+// Reload switches to a new config; requests already in flight finish on the
+// old one. Balancers, rate limiters and caches of upstreams whose settings
+// did not change carry over with their state.
+func (h *Handler) Reload(cfg *config.Config) error {
+	previous := h.routing.Load()
+
+	rt, err := h.buildRouting(cfg, previous)
+	if err != nil {
+		return err
+	}
+
+	h.routing.Store(rt)
+	rt.startCanaries(previous)
+	previous.stopCanaries(rt)
+	h.publishBreakerStates(rt)
+	return nil
+}
+
+// Stop ends background work tied to the current config
+func (h *Handler) Stop() {
+	h.routing.Load().stopCanaries(nil)
+}
+
+func (h *Handler) buildRouting(cfg *config.Config, previous *routing) (*routing, error) {
+	previousUpstreams := make(map[string]config.Upstream)
+	if previous != nil {
+		for _, upstream := range previous.config.Upstreams {
+			previousUpstreams[upstream.Name] = upstream
+		}
+	}
+
+	rt := &routing{
+		config:        cfg,
+		loadBalancers: make(map[string]balancer.LoadBalancer),
+		retrier:       retry.New(cfg.Retry),
+		rateLimiters:  make(map[string]*ratelimit.RateLimiter),
+		tarpits:       make(map[string]*tarpit),
+		bodyRewriters: make(map[string]*bodyRewriter),
+		caches:        make(map[string]*responseCache),
+		canaries:      make(map[string]*canary.Ramp),
+		hedgers:       make(map[string]*hedger),
+		mirrors:       make(map[string]*mirror),
+	}
 
 	for _, upstream := range cfg.Upstreams {
-		lb, err := balancer.NewLoadBalancer(upstream.Algorithm)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create load balancer for upstream %s: %w", upstream.Name, err)
+		old, existed := previousUpstreams[upstream.Name]
+
+		if existed && sameBalancer(old, upstream) {
+			rt.loadBalancers[upstream.Name] = previous.loadBalancers[upstream.Name]
+		} else {
+			lb, err := balancer.NewForUpstream(upstream)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create load balancer for upstream %s: %w", upstream.Name, err)
+			}
+			if wrr, ok := lb.(*balancer.WeightedRoundRobin); ok && h.healthChecker != nil {
+				wrr.SetDegradedCheck(h.healthChecker.IsDegraded, cfg.Health.DegradedWeight)
+				wrr.SetSlowStart(h.healthChecker.HealthySince, cfg.Health.SlowStart)
+			}
+			// hashed keys would otherwise keep landing on a backend whose
+			// circuit is open, retries included
+			switch hashed := lb.(type) {
+			case *balancer.ConsistentHash:
+				hashed.SetDownCheck(h.circuitBreaker.IsOpen)
+			case *balancer.BoundedConsistentHash:
+				hashed.SetDownCheck(h.circuitBreaker.IsOpen)
+			}
+			rt.loadBalancers[upstream.Name] = lb
 		}
-		loadBalancers[upstream.Name] = lb
 
 		if upstream.RateLimit != nil {
-			rateLimiters[upstream.Name] = ratelimit.New(upstream.RateLimit)
+			if limiter, ok := previous.rateLimiter(upstream.Name); ok && reflect.DeepEqual(old.RateLimit, upstream.RateLimit) {
+				rt.rateLimiters[upstream.Name] = limiter
+			} else {
+				rt.rateLimiters[upstream.Name] = ratelimit.New(upstream.RateLimit)
+			}
+
+			if tarpitCfg := upstream.RateLimit.Tarpit; tarpitCfg != nil && tarpitCfg.Enabled {
+				// requests held by the old tarpit keep counting against its cap
+				if tp, ok := previous.tarpit(upstream.Name); ok && reflect.DeepEqual(old.RateLimit.Tarpit, tarpitCfg) {
+					rt.tarpits[upstream.Name] = tp
+				} else {
+					rt.tarpits[upstream.Name] = newTarpit(tarpitCfg)
+				}
+			}
+		}
+
+		if upstream.ResponseRewrite != nil && upstream.ResponseRewrite.Enabled {
+			rt.bodyRewriters[upstream.Name] = newBodyRewriter(upstream.ResponseRewrite)
+		}
+
+		if upstream.Cache != nil && upstream.Cache.Enabled {
+			if cache, ok := previous.cache(upstream.Name); ok && reflect.DeepEqual(old.Cache, upstream.Cache) {
+				rt.caches[upstream.Name] = cache
+			} else {
+				rt.caches[upstream.Name] = newResponseCache(upstream.Cache)
+			}
+		}
+
+		// carried over so hedges still in flight count against the cap
+		if upstream.Hedge != nil && upstream.Hedge.Enabled {
+			if hg, ok := previous.hedger(upstream.Name); ok && reflect.DeepEqual(old.Hedge, upstream.Hedge) {
+				rt.hedgers[upstream.Name] = hg
+			} else {
+				rt.hedgers[upstream.Name] = newHedger(upstream.Hedge)
+			}
+		}
+
+		if upstream.Mirror != nil && upstream.Mirror.Enabled {
+			if m, ok := previous.mirror(upstream.Name); ok && reflect.DeepEqual(old.Mirror, upstream.Mirror) {
+				rt.mirrors[upstream.Name] = m
+			} else {
+				rt.mirrors[upstream.Name] = newMirror(upstream.Mirror)
+			}
 		}
 	}
 
-	return &Handler{
-		config:         cfg,
-		loadBalancers:  loadBalancers,
-		healthChecker:  healthChecker,
-		metrics:        metricsCollector,
-		circuitBreaker: circuitbreaker.New(cfg.CircuitBreaker),
-		retrier:        retry.New(cfg.Retry),
-		rateLimiters:   rateLimiters,
-	}, nil
+	// a ramp that changed starts over once the routing is in use; Reload
+	// stops the ones left behind
+	for _, upstream := range cfg.Upstreams {
+		if upstream.Canary == nil || !upstream.Canary.Enabled {
+			continue
+		}
+
+		if ramp, ok := previous.canary(upstream.Name); ok && reflect.DeepEqual(previousUpstreams[upstream.Name].Canary, upstream.Canary) {
+			rt.canaries[upstream.Name] = ramp
+			continue
+		}
+
+		ramp := canary.New(upstream.Canary)
+		ramp.SetBreakerCheck(func() bool {
+			state := h.circuitBreaker.GetState(ramp.Backend())
+			return state == circuitbreaker.StateOpen || state == circuitbreaker.StateForcedOpen
+		})
+		rt.canaries[upstream.Name] = ramp
+	}
+
+	// a fresh transport would drop every pooled backend connection
+	if previous != nil && reflect.DeepEqual(previous.config.Transport, cfg.Transport) {
+		rt.transport = previous.transport
+	} else {
+		rt.transport = transport.New(cfg.Transport)
+	}
+
+	errorPages, maintenancePage, err := loadErrorPages(cfg.ErrorPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load error pages: %w", err)
+	}
+	rt.errorPages = errorPages
+	rt.maintenancePage = maintenancePage
+
+	rt.errorTemplate, err = newErrorTemplate(cfg.ErrorPages.JSONTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse json_template: %w", err)
+	}
+
+	rt.trustedProxies, err = ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	rt.compressor = newCompressor(cfg.Compression)
+
+	return rt, nil
+}
+
+// whether the balancer built for a can keep serving b
+func sameBalancer(a, b config.Upstream) bool {
+	return a.Algorithm == b.Algorithm &&
+		a.ConnectionDecay == b.ConnectionDecay &&
+		reflect.DeepEqual(a.AdaptiveWeight, b.AdaptiveWeight) &&
+		reflect.DeepEqual(a.ErrorWeight, b.ErrorWeight) &&
+		reflect.DeepEqual(a.ConsistentHash, b.ConsistentHash)
+}
+
+func (rt *routing) rateLimiter(upstream string) (*ratelimit.RateLimiter, bool) {
+	if rt == nil {
+		return nil, false
+	}
+	limiter, ok := rt.rateLimiters[upstream]
+	return limiter, ok
+}
+
+func (rt *routing) tarpit(upstream string) (*tarpit, bool) {
+	if rt == nil {
+		return nil, false
+	}
+	tp, ok := rt.tarpits[upstream]
+	return tp, ok
+}
+
+func (rt *routing) canary(upstream string) (*canary.Ramp, bool) {
+	if rt == nil {
+		return nil, false
+	}
+	ramp, ok := rt.canaries[upstream]
+	return ramp, ok
+}
+
+// starts the ramps not carried over from previous
+func (rt *routing) startCanaries(previous *routing) {
+	for name, ramp := range rt.canaries {
+		if kept, ok := previous.canary(name); ok && kept == ramp {
+			continue
+		}
+		ramp.Start()
+	}
+}
+
+// stops the ramps next no longer uses
+func (rt *routing) stopCanaries(next *routing) {
+	for name, ramp := range rt.canaries {
+		if kept, ok := next.canary(name); ok && kept == ramp {
+			continue
+		}
+		ramp.Stop()
+	}
+}
+
+func (rt *routing) hedger(upstream string) (*hedger, bool) {
+	if rt == nil {
+		return nil, false
+	}
+	hg, ok := rt.hedgers[upstream]
+	return hg, ok
+}
+
+func (rt *routing) mirror(upstream string) (*mirror, bool) {
+	if rt == nil {
+		return nil, false
+	}
+	m, ok := rt.mirrors[upstream]
+	return m, ok
+}
+
+func (rt *routing) cache(upstream string) (*responseCache, bool) {
+	if rt == nil {
+		return nil, false
+	}
+	cache, ok := rt.caches[upstream]
+	return cache, ok
+}
+
+// CircuitBreaker exposes the handler's breaker for operator overrides
+func (h *Handler) CircuitBreaker() *circuitbreaker.CircuitBreaker {
+	return h.circuitBreaker
+}
+
+// gives every backend a breaker state series from the start, not only once
+// its circuit first changes
+func (h *Handler) publishBreakerStates(rt *routing) {
+	if h.metrics == nil {
+		return
+	}
+	for _, upstream := range rt.config.Upstreams {
+		for _, backend := range upstream.Backends {
+			h.metrics.RecordCircuitBreakerState(upstream.Name, backend.URL, string(h.circuitBreaker.GetState(backend.URL)))
+		}
+	}
+}
+
+func (h *Handler) recordBreakerState(backendURL string, from, to circuitbreaker.State) {
+	if h.metrics == nil {
+		return
+	}
+
+	upstream := h.routing.Load().backendUpstream(backendURL)
+	h.metrics.RecordCircuitBreakerState(upstream, backendURL, string(to))
+	if to == circuitbreaker.StateOpen {
+		h.metrics.RecordCircuitBreakerTrip(upstream, backendURL)
+	}
+}
+
+// feeds an attempt's outcome to passive health checking and, when the
+// balancer weighs backends by their errors, to the balancer
+func (h *Handler) recordOutcome(lb balancer.LoadBalancer, backendURL string, success bool) {
+	if h.healthChecker != nil {
+		h.healthChecker.UpdateFromProxy(backendURL, success)
+	}
+	if wrr, ok := lb.(*balancer.WeightedRoundRobin); ok && wrr.ErrorWeights() != nil {
+		wrr.ErrorWeights().Record(backendURL, success)
+	}
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 
+	h.inFlight.Add(1)
+	defer h.inFlight.Add(-1)
+
 	if h.metrics != nil {
 		h.metrics.IncrementActiveConnections()
 		defer h.metrics.DecrementActiveConnections()
 	}
 
-	if len(h.config.Upstreams) == 0 {
-		h.writeError(w, r, "No upstreams configured", http.StatusServiceUnavailable, start)
+	rt := h.routing.Load()
+
+	route := &accessRoute{}
+	if h.accessLog != nil {
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		w = rw
+		defer h.logAccess(r, rt, rw, route, start)
+	}
+
+	lookupStart := time.Now()
+	healthStatus := h.healthSnapshot()
+	// counted toward the first attempt's selection time
+	healthLookup := time.Since(lookupStart)
+
+	// checked before routing so a flood of headers costs as little as possible
+	if tooManyHeaders(r, rt.config.Server.MaxHeaderCount, rt.config.Server.MaxCookieCount) {
+		h.writeError(w, r, rt, unmatchedUpstream, "Request header fields too large", http.StatusRequestHeaderFieldsTooLarge, start)
+		return
+	}
+
+	if !hostAllowed(r, rt.config.Server.AllowedHosts) {
+		h.writeError(w, r, rt, unmatchedUpstream, "Host not allowed", http.StatusBadRequest, start)
+		return
+	}
+
+	r, ok := normalizePath(r, rt.config.Server.PathNormalization)
+	if !ok {
+		h.writeError(w, r, rt, unmatchedUpstream, "Invalid request path", http.StatusBadRequest, start)
+		return
+	}
+
+	upstream := rt.matchUpstream(r, healthStatus)
+	name := upstreamName(upstream)
+	route.upstream = name
+
+	if rt.config.Server.Maintenance {
+		if rt.maintenancePage != nil {
+			rt.maintenancePage.write(w, http.StatusServiceUnavailable)
+			h.recordError(r, rt, name, http.StatusServiceUnavailable, start)
+			return
+		}
+		h.writeError(w, r, rt, name, "Service under maintenance", http.StatusServiceUnavailable, start)
+		return
+	}
+
+	if len(rt.config.Upstreams) == 0 {
+		h.writeError(w, r, rt, name, "No upstreams configured", http.StatusServiceUnavailable, start)
 		return
 	}
 
-	upstream := &h.config.Upstreams[0]
+	if upstream == nil {
+		h.writeError(w, r, rt, name, "No upstream matches request", http.StatusNotFound, start)
+		return
+	}
+
+	h.requestRate(upstream.Name).record(start)
+
+	if headersTooLarge(r, upstream.MaxHeaderBytes) {
+		h.writeError(w, r, rt, name, "Request header fields too large", http.StatusRequestHeaderFieldsTooLarge, start)
+		return
+	}
 
-	clientIP := getClientIP(r)
-	if rateLimiter, exists := h.rateLimiters[upstream.Name]; exists {
+	clientIP := getClientIP(r, rt.trustedProxies)
+	r = r.WithContext(balancer.WithClientIP(r.Context(), clientIP))
+	if rateLimiter, exists := rt.rateLimiters[upstream.Name]; exists {
 		if !rateLimiter.Allow(clientIP) {
-			h.writeError(w, r, "Rate limit exceeded", http.StatusTooManyRequests, start)
+			if h.metrics != nil {
+				h.metrics.RecordRateLimited(upstream.Name)
+			}
+			if tp, ok := rt.tarpit(upstream.Name); ok {
+				tp.hold(r.Context())
+			}
+			w.Header().Set("Retry-After", retryAfter(rateLimiter.NextAllowed(clientIP)))
+			h.writeError(w, r, rt, name, "Rate limit exceeded", http.StatusTooManyRequests, start)
 			return
 		}
 	}
 
-	lb := h.loadBalancers[upstream.Name]
+	if isUpgrade(r) {
+		route.backend = h.serveUpgrade(w, r, rt, upstream, healthStatus, start)
+		return
+	}
+
+	// outside the cache recorder, so cached bodies stay uncompressed
+	if gw := rt.compressor.wrap(w, r); gw != nil {
+		w = gw
+		defer gw.Close()
+	}
 
-	var healthStatus map[string]bool
-	if h.healthChecker != nil {
-		healthStatus = h.healthChecker.GetAllStatuses()
-	} else {
-		healthStatus = make(map[string]bool)
+	var cacheKey string
+	var recorder *cacheRecorder
+	cache := rt.caches[upstream.Name]
+	if cache != nil && cache.cacheable(r) {
+		cacheKey = cache.key(r)
+		if entry, hit := cache.get(cacheKey); hit {
+			route.backend = "cache"
+			entry.writeTo(w, r)
+			if h.metrics != nil {
+				h.metrics.RecordRouteRequest(upstream.Name, "cache", r.Method, strconv.Itoa(entry.statusCode), h.metrics.Route(r.URL.Path), time.Since(start))
+			}
+			return
+		}
+		// a HEAD response has no body to replay, so only GETs fill the
+		// cache; HEADs are still answered from what a GET stored
+		if r.Method == http.MethodGet {
+			recorder = newCacheRecorder(w, cache.maxBodyBytes)
+			w = recorder
+		}
+	}
+
+	lb := rt.loadBalancers[upstream.Name]
+
+	if timeout := rt.requestTimeout(upstream); timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	// once a backend has seen it, a request is only sent again when that is
+	// safe and its body can be buffered
+	replayable := false
+	if rt.config.Retry.Enabled && rt.config.Retry.MaxAttempts > 1 && retryAllowed(r, rt.config.Retry) {
+		replayable = bufferBody(r)
+	}
+
+	// 307 and 308 from the backends can only be followed with the body at hand
+	if follow := upstream.FollowBackendRedirects; follow != nil && follow.Enabled && r.GetBody == nil {
+		bufferBody(r)
+	}
+
+	hg := rt.hedgers[upstream.Name]
+	if hg != nil && !hedgeable(r) {
+		hg = nil
+	}
+
+	var shadow <-chan shadowResponse
+	var shadowRec *shadowRecorder
+	if m := rt.mirrors[upstream.Name]; m != nil {
+		shadow = m.send(r, upstream.StripPrefix, rt.transport)
+		if shadow != nil && m.compare != nil {
+			shadowRec = newShadowRecorder(w, m.compare)
+			w = shadowRec
+		}
 	}
 
 	var wrappedWriter *responseWriter
 	var lastBackendURL string
+	var streamAborted bool
+	attempts := 0
+
+	err := rt.retrier.DoContext(r.Context(), func() error {
+		attempts++
+		if attempts > 1 {
+			rewindBody(r)
+			if h.metrics != nil {
+				h.metrics.RecordRetry(upstream.Name, lastBackendURL)
+			}
+		}
+		selectionStart := time.Now()
+		ramp := rt.canaries[upstream.Name]
+		selectedBackend, err := lb.SelectBackend(r, upstream.Backends, canaryHealth(ramp, upstream.Backends, healthStatus))
+		canAttempt := err == nil && h.circuitBreaker.CanAttempt(selectedBackend.URL)
+		selection := time.Since(selectionStart)
+		if attempts == 1 {
+			selection += healthLookup
+		}
+		h.recordSelection(upstream.Name, selection)
 
-	err := h.retrier.Do(func() error {
-		selectedBackend, err := lb.SelectBackend(r, upstream.Backends, healthStatus)
+		if errors.Is(err, balancer.ErrAllBackendsSaturated) {
+			log.Printf("All backends for upstream %s are at max_conns", upstream.Name)
+			return retry.Permanent(err)
+		}
 		if err != nil {
 			return err
 		}
+		if tracker, ok := lb.(balancer.ConnectionTracker); ok {
+			defer tracker.DecrementConnections(selectedBackend.URL)
+		}
 
 		lastBackendURL = selectedBackend.URL
 
-		if !h.circuitBreaker.CanAttempt(selectedBackend.URL) {
+		if !canAttempt {
 			log.Printf("Circuit breaker open for backend %s", selectedBackend.URL)
 			return fmt.Errorf("circuit breaker open for %s", selectedBackend.URL)
 		}
 
-		if lcLB, ok := lb.(*balancer.LeastConnections); ok {
-			lcLB.IncrementConnections(selectedBackend.URL)
-			defer lcLB.DecrementConnections(selectedBackend.URL)
-		}
-
 		backendURL, err := url.Parse(selectedBackend.URL)
 		if err != nil {
 			return fmt.Errorf("invalid backend URL: %w", err)
 		}
 
-		proxy := httputil.NewSingleHostReverseProxy(backendURL)
+		sent := time.Now()
+		proxy := rt.newReverseProxy(upstream, backendURL, r)
+		modify := rt.modifyResponse(upstream, lb, selectedBackend.URL, sent)
+
+		var hedge *hedgedRequest
+		if hg != nil {
+			hedge = h.newHedgedRequest(rt, hg, upstream, r, selectedBackend.URL, backendURL, healthStatus)
+			proxy.Transport = hedge
+			modify = hedge.modifyResponse(func(backendURL string) func(*http.Response) error {
+				return rt.modifyResponse(upstream, lb, backendURL, sent)
+			})
+		}
 
-		originalDirector := proxy.Director
-		proxy.Director = func(req *http.Request) {
-			originalDirector(req)
-			h.setProxyHeaders(req, r)
+		if follow := upstream.FollowBackendRedirects; follow != nil && follow.Enabled {
+			proxy.Transport = &redirectFollower{next: proxy.Transport, maxHops: follow.MaxHops}
 		}
 
+		watch := &streamWatch{}
+		proxy.ModifyResponse = watch.modifyResponse(modify)
+
 		proxyErr := false
 		proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
-			log.Printf("Proxy error for backend %s: %v", selectedBackend.URL, err)
+			if errors.Is(err, errRetryableResponse) {
+				log.Printf("Backend %s flagged its response as retryable", selectedBackend.URL)
+			} else {
+				log.Printf("Proxy error for backend %s: %v", selectedBackend.URL, err)
+			}
 			proxyErr = true
 		}
 
 		wrappedWriter = &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
-		proxy.ServeHTTP(wrappedWriter, r)
+		streamErr := serveStream(proxy, wrappedWriter, h.traceBackendConn(r, upstream.Name, selectedBackend.URL), watch)
+
+		// with a hedge out, the outcome belongs to whichever backend answered first
+		servedBy := selectedBackend.URL
+		if hedge != nil {
+			winner, loser := hedge.served()
+			if loser != "" {
+				h.circuitBreaker.RecordAbandoned(loser)
+			}
+			servedBy = winner
+			lastBackendURL = winner
+		}
+
+		// says nothing about the backend, so it is neither a failure nor a success
+		if clientGone(r) {
+			h.circuitBreaker.RecordAbandoned(servedBy)
+			return retry.Permanent(context.Canceled)
+		}
+
+		failed := proxyErr || streamErr != nil || wrappedWriter.statusCode >= 500
+		if ramp != nil && servedBy == ramp.Backend() {
+			ramp.Record(!failed)
+		}
 
-		if proxyErr || wrappedWriter.statusCode >= 500 {
-			h.circuitBreaker.RecordFailure(selectedBackend.URL)
-			return fmt.Errorf("backend error: status %d", wrappedWriter.statusCode)
+		if streamErr != nil {
+			log.Printf("Backend %s failed mid-stream: %v", servedBy, streamErr)
+			h.circuitBreaker.RecordFailure(servedBy)
+			h.recordOutcome(lb, servedBy, false)
+			streamAborted = true
+			return retry.Permanent(errStreamAborted)
 		}
 
-		h.circuitBreaker.RecordSuccess(selectedBackend.URL)
+		if failed {
+			h.circuitBreaker.RecordFailure(servedBy)
+			h.recordOutcome(lb, servedBy, false)
+			err := fmt.Errorf("backend error: status %d", wrappedWriter.statusCode)
+			if !replayable {
+				return retry.Permanent(err)
+			}
+			return err
+		}
+
+		h.circuitBreaker.RecordSuccess(servedBy)
+		h.recordOutcome(lb, servedBy, true)
 		return nil
 	})
 
+	route.backend = lastBackendURL
+	rt.logSlowRequest(r, upstream.Name, lastBackendURL, attempts, time.Since(start))
+
+	// nobody is left to read an error response
+	if err != nil && clientGone(r) {
+		if h.metrics != nil {
+			h.metrics.RecordClientDisconnect(upstream.Name)
+		}
+		return
+	}
+
+	if streamAborted {
+		h.recordError(r, rt, name, http.StatusBadGateway, start)
+		abortStream(r)
+		return
+	}
+
 	if err != nil {
 		if wrappedWriter == nil || wrappedWriter.statusCode == http.StatusOK {
-			h.writeError(w, r, "Service temporarily unavailable", http.StatusServiceUnavailable, start)
+			if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+				h.writeError(w, r, rt, name, "Gateway timeout", http.StatusGatewayTimeout, start)
+				return
+			}
+			h.writeError(w, r, rt, name, "Service temporarily unavailable", http.StatusServiceUnavailable, start)
 		}
 		return
 	}
 
+	if recorder != nil {
+		cache.store(cacheKey, recorder)
+	}
+
+	if shadowRec != nil {
+		h.compareShadow(upstream.Name, rt.mirrors[upstream.Name], r, shadowRec.response(), shadow)
+	}
+
 	if h.metrics != nil && wrappedWriter != nil {
 		duration := time.Since(start)
 		status := strconv.Itoa(wrappedWriter.statusCode)
-		h.metrics.RecordRequest(upstream.Name, lastBackendURL, r.Method, status, duration)
+		h.metrics.RecordRouteRequest(upstream.Name, lastBackendURL, r.Method, status, h.metrics.Route(r.URL.Path), duration)
 	}
 }
 
-func (h *Handler) setProxyHeaders(proxyReq *http.Request, originalReq *http.Request) {
-	if clientIP := getClientIP(originalReq); clientIP != "" {
-		proxyReq.Header.Set("X-Forwarded-For", clientIP)
+// a reverse proxy to target over the shared transport that sets the
+// forwarding headers for r and the upstream's request headers
+func (rt *routing) newReverseProxy(upstream *config.Upstream, target *url.URL, r *http.Request) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = rt.transport
+
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		stripPrefix(req.URL, upstream.StripPrefix)
+		originalDirector(req)
+		rt.setProxyHeaders(req, r)
+		applyHeaderRules(req.Header, upstream.RequestHeaders)
+	}
+
+	return proxy
+}
+
+// per-upstream timeout, falling back to the server wide default
+func (rt *routing) requestTimeout(upstream *config.Upstream) time.Duration {
+	if upstream.Timeout > 0 {
+		return upstream.Timeout
+	}
+	return rt.config.Server.RequestTimeout
+}
+
+// selections slower than this are logged, they point at lock contention in
+// the balancer or health checker rather than at the backends
+const slowSelectionThreshold = 10 * time.Millisecond
+
+func (h *Handler) recordSelection(upstream string, duration time.Duration) {
+	if duration >= slowSelectionThreshold {
+		log.Printf("Warning: slow backend selection upstream=%s duration=%s", upstream, duration)
+	}
+	if h.metrics != nil {
+		h.metrics.RecordSelection(upstream, duration)
+	}
+}
+
+// chains the per-upstream response hooks, nil when none apply
+func (rt *routing) modifyResponse(upstream *config.Upstream, lb balancer.LoadBalancer, backendURL string, sent time.Time) func(*http.Response) error {
+	var adaptive *balancer.AdaptiveWeights
+	if wrr, ok := lb.(*balancer.WeightedRoundRobin); ok {
+		adaptive = wrr.AdaptiveWeights()
+	}
+	timer, _ := lb.(balancer.ResponseTimeRecorder)
+	rewriter := rt.bodyRewriters[upstream.Name]
+	onHeader := rt.config.Retry.OnHeader
+	if !rt.config.Retry.Enabled {
+		onHeader = nil
+	}
+
+	headers := upstream.ResponseHeaders
+
+	if adaptive == nil && timer == nil && rewriter == nil && onHeader == nil && len(headers) == 0 {
+		return nil
+	}
+
+	return func(resp *http.Response) error {
+		// time to response headers, so long streamed bodies don't count
+		if timer != nil {
+			timer.RecordResponseTime(backendURL, time.Since(sent))
+		}
+
+		// failing here hands the response to the error handler before
+		// anything reaches the client, so the request can be retried
+		if onHeader != nil && retryableResponse(resp, onHeader) {
+			return errRetryableResponse
+		}
+
+		if adaptive != nil {
+			if value := resp.Header.Get(upstream.AdaptiveWeight.Header); value != "" {
+				if load, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					adaptive.Record(backendURL, load)
+				}
+			}
+		}
+
+		if rewriter != nil {
+			if err := rewriter.ModifyResponse(resp); err != nil {
+				return err
+			}
+		}
+
+		// last, so the configured headers are what the client sees
+		applyHeaderRules(resp.Header, headers)
+		return nil
+	}
+}
+
+// sets or, for config.RemoveHeader, deletes each header in rules
+func applyHeaderRules(header http.Header, rules map[string]string) {
+	for name, value := range rules {
+		if value == config.RemoveHeader {
+			header.Del(name)
+		} else {
+			header.Set(name, value)
+		}
+	}
+}
+
+// tracks connection reuse so backends closing connections are visible in metrics
+func (h *Handler) traceBackendConn(r *http.Request, upstream, backend string) *http.Request {
+	if h.metrics == nil {
+		return r
+	}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			h.metrics.RecordBackendConnection(upstream, backend, info.Reused)
+		},
+	}
+
+	return r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+}
+
+func (rt *routing) logSlowRequest(r *http.Request, upstream, backend string, attempts int, duration time.Duration) {
+	threshold := rt.config.Logging.SlowRequestThreshold
+	if threshold <= 0 || duration < threshold {
+		return
+	}
+
+	retries := attempts - 1
+	if retries < 0 {
+		retries = 0
+	}
+
+	log.Printf("Warning: slow request method=%s path=%q upstream=%s backend=%s duration=%s retries=%d",
+		r.Method, r.URL.Path, upstream, backend, duration, retries)
+}
+
+func (rt *routing) setProxyHeaders(proxyReq *http.Request, originalReq *http.Request) {
+	// keep the inbound chain from a trusted proxy; the reverse proxy appends
+	// the immediate peer (RemoteAddr host) after the director runs, so the
+	// chain grows per hop. Anyone else's chain is dropped, backends would
+	// take hops the client wrote for ones a proxy saw.
+	if chain := forwardedChain(originalReq); chain != "" && fromTrustedProxy(originalReq, rt.trustedProxies) {
+		proxyReq.Header.Set("X-Forwarded-For", chain)
+	} else {
+		proxyReq.Header.Del("X-Forwarded-For")
 	}
 
 	scheme := "http"
@@ -166,41 +846,93 @@ func (h *Handler) setProxyHeaders(proxyReq *http.Request, originalReq *http.Requ
 
 	proxyReq.Header.Set("X-Forwarded-Host", originalReq.Host)
 
-	proxyReq.Header.Set("X-Load-Balancer", h.config.Service)
-}
+	proxyReq.Header.Set("X-Load-Balancer", rt.config.Service)
 
-func getClientIP(r *http.Request) string {
-	if xForwardedFor := r.Header.Get("X-Forwarded-For"); xForwardedFor != "" {
-		return xForwardedFor
+	removeClientCertHeaders(proxyReq)
+	if rt.config.TLS.ClientCertHeaders {
+		setClientCertHeaders(proxyReq, originalReq)
 	}
+}
 
-	if xRealIP := r.Header.Get("X-Real-IP"); xRealIP != "" {
-		return xRealIP
+// folds every inbound X-Forwarded-For header into a single comma separated chain
+func forwardedChain(r *http.Request) string {
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
 	}
 
-	return r.RemoteAddr
+	return strings.Join(hops, ", ")
 }
 
-func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, message string, statusCode int, start time.Time) {
+// Retry-After in whole seconds, rounded up so clients don't come back early
+func retryAfter(next time.Time) string {
+	seconds := int(math.Ceil(time.Until(next).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, rt *routing, upstream, message string, statusCode int, start time.Time) {
+	if page, exists := rt.errorPages[statusCode]; exists {
+		page.write(w, statusCode)
+		h.recordError(r, rt, upstream, statusCode, start)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 
-	errorResponse := fmt.Sprintf(`{"error":"%s","code":%d}`, message, statusCode)
-	w.Write([]byte(errorResponse))
+	w.Write(renderError(rt.errorTemplate, r, message, statusCode))
 
-	if h.metrics != nil && len(h.config.Upstreams) > 0 {
+	h.recordError(r, rt, upstream, statusCode, start)
+}
+
+func (h *Handler) recordError(r *http.Request, rt *routing, upstream string, statusCode int, start time.Time) {
+	if h.metrics != nil && len(rt.config.Upstreams) > 0 {
 		duration := time.Since(start)
 		status := strconv.Itoa(statusCode)
-		h.metrics.RecordRequest(h.config.Upstreams[0].Name, "error", r.Method, status, duration)
+		h.metrics.RecordRouteRequest(upstream, "error", r.Method, status, h.metrics.Route(r.URL.Path), duration)
 	}
 }
 
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64 // body bytes written
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// lets http.ResponseController reach Flush on the underlying writer, which
+// streaming responses depend on
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func (rw *responseWriter) Flush() {
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// hands the client connection over for an upgraded protocol; the reverse
+// proxy writes the 101 response on the raw connection itself
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
@@ -1,17 +1,47 @@
 package proxy
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"runtime"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/sanchxt/isame-lb/internal/config"
 	"github.com/sanchxt/isame-lb/internal/health"
 	"github.com/sanchxt/isame-lb/internal/metrics"
 )
 
+// validates cfg, on port 8080 unless it sets one, and builds a handler over
+// it with health checks off; a nil collector leaves metrics off too
+func newTestHandler(t *testing.T, cfg *config.Config, collector *metrics.Collector) *Handler {
+	t.Helper()
+
+	if cfg.Server.Port == 0 {
+		cfg.Server.Port = 8080
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if collector == nil {
+		collector = metrics.NewCollector(config.MetricsConfig{Enabled: false})
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), collector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	return handler
+}
+
 func TestNewHandler(t *testing.T) {
 	cfg := &config.Config{
 		Service: "test-lb",
@@ -42,11 +72,11 @@ func TestNewHandler(t *testing.T) {
 		return
 	}
 
-	if len(handler.loadBalancers) != 1 {
-		t.Errorf("Expected 1 load balancer, got %d", len(handler.loadBalancers))
+	if len(handler.routing.Load().loadBalancers) != 1 {
+		t.Errorf("Expected 1 load balancer, got %d", len(handler.routing.Load().loadBalancers))
 	}
 
-	if _, exists := handler.loadBalancers["test-upstream"]; !exists {
+	if _, exists := handler.routing.Load().loadBalancers["test-upstream"]; !exists {
 		t.Error("Load balancer for test-upstream should exist")
 	}
 }
@@ -276,7 +306,159 @@ func TestHandlerProxyHeaders(t *testing.T) {
 	}
 }
 
+func TestHandlerUpstreamHeaderRules(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Authorization-Echo", r.Header.Get("Authorization"))
+		w.Header().Set("X-Debug-Echo", r.Header.Get("X-Debug"))
+		w.Header().Set("X-Forwarded-Host-Echo", r.Header.Get("X-Forwarded-Host"))
+		w.Header().Set("Server", "backend/1.0")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{Port: 8080},
+		Upstreams: []config.Upstream{{
+			Name:     "api",
+			Backends: []config.Backend{{URL: backend.URL, Weight: 1}},
+			RequestHeaders: map[string]string{
+				"authorization": "Bearer backend-token",
+				"X-Debug":       config.RemoveHeader,
+			},
+			ResponseHeaders: map[string]string{
+				"server":   config.RemoveHeader,
+				"X-Served": "isame-lb",
+			},
+		}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Host = "example.com"
+	req.Header.Set("Authorization", "Bearer client-token")
+	req.Header.Set("X-Debug", "1")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	if got := resp.Header.Get("Authorization-Echo"); got != "Bearer backend-token" {
+		t.Errorf("Expected the backend to get the injected Authorization, got %q", got)
+	}
+	if got := resp.Header.Get("X-Debug-Echo"); got != "" {
+		t.Errorf("Expected X-Debug to be stripped before the backend, got %q", got)
+	}
+	if got := resp.Header.Get("X-Forwarded-Host-Echo"); got != "example.com" {
+		t.Errorf("Expected X-Forwarded-Host to still be set, got %q", got)
+	}
+	if got := resp.Header.Get("Server"); got != "" {
+		t.Errorf("Expected Server to be stripped from the response, got %q", got)
+	}
+	if got := resp.Header.Get("X-Served"); got != "isame-lb" {
+		t.Errorf("Expected X-Served to be added to the response, got %q", got)
+	}
+}
+
+func TestHandlerForwardedForChain(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Forwarded-For-Echo", r.Header.Get("X-Forwarded-For"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+			},
+		},
+		Server:         config.ServerConfig{TrustedProxies: []string{"192.168.1.0/24"}},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: false})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		remote   string
+		xff      []string
+		expected string
+	}{
+		{
+			name:     "no existing chain",
+			expected: "192.168.1.100",
+		},
+		{
+			name:     "single hop chain",
+			xff:      []string{"203.0.113.1"},
+			expected: "203.0.113.1, 192.168.1.100",
+		},
+		{
+			name:     "multi hop chain",
+			xff:      []string{"203.0.113.1, 198.51.100.7"},
+			expected: "203.0.113.1, 198.51.100.7, 192.168.1.100",
+		},
+		{
+			name:     "multiple headers are folded",
+			xff:      []string{"203.0.113.1", "198.51.100.7"},
+			expected: "203.0.113.1, 198.51.100.7, 192.168.1.100",
+		},
+		{
+			name:     "untrusted peer's chain is dropped",
+			remote:   "203.0.113.50:12345",
+			xff:      []string{"10.0.0.1, 198.51.100.7"},
+			expected: "203.0.113.50",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.RemoteAddr = "192.168.1.100:12345"
+			if tt.remote != "" {
+				req.RemoteAddr = tt.remote
+			}
+			for _, value := range tt.xff {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if got := w.Result().Header.Get("X-Forwarded-For-Echo"); got != tt.expected {
+				t.Errorf("Expected X-Forwarded-For %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
 func TestGetClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies() error = %v", err)
+	}
+
 	tests := []struct {
 		name       string
 		headers    map[string]string
@@ -284,13 +466,13 @@ func TestGetClientIP(t *testing.T) {
 		expectedIP string
 	}{
 		{
-			name:       "X-Forwarded-For header",
+			name:       "X-Forwarded-For from trusted proxy",
 			headers:    map[string]string{"X-Forwarded-For": "192.168.1.100"},
 			remoteAddr: "10.0.0.1:12345",
 			expectedIP: "192.168.1.100",
 		},
 		{
-			name:       "X-Real-IP header",
+			name:       "X-Real-IP from trusted proxy",
 			headers:    map[string]string{"X-Real-IP": "192.168.1.200"},
 			remoteAddr: "10.0.0.1:12345",
 			expectedIP: "192.168.1.200",
@@ -299,7 +481,7 @@ func TestGetClientIP(t *testing.T) {
 			name:       "RemoteAddr fallback",
 			headers:    map[string]string{},
 			remoteAddr: "10.0.0.1:12345",
-			expectedIP: "10.0.0.1:12345",
+			expectedIP: "10.0.0.1",
 		},
 		{
 			name: "X-Forwarded-For takes precedence over X-Real-IP",
@@ -310,6 +492,60 @@ func TestGetClientIP(t *testing.T) {
 			remoteAddr: "10.0.0.1:12345",
 			expectedIP: "192.168.1.100",
 		},
+		{
+			name:       "X-Forwarded-For from untrusted peer is ignored",
+			headers:    map[string]string{"X-Forwarded-For": "192.168.1.100"},
+			remoteAddr: "203.0.113.9:12345",
+			expectedIP: "203.0.113.9",
+		},
+		{
+			name:       "X-Real-IP from untrusted peer is ignored",
+			headers:    map[string]string{"X-Real-IP": "192.168.1.200"},
+			remoteAddr: "203.0.113.9:12345",
+			expectedIP: "203.0.113.9",
+		},
+		{
+			name:       "multi-hop chain skips trusted hops",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.7, 10.1.2.3, 192.0.2.1"},
+			remoteAddr: "10.0.0.1:12345",
+			expectedIP: "198.51.100.7",
+		},
+		{
+			name:       "spoofed leftmost hop is not believed",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.7, 10.1.2.3"},
+			remoteAddr: "10.0.0.1:12345",
+			expectedIP: "198.51.100.7",
+		},
+		{
+			name:       "all hops trusted gives the leftmost",
+			headers:    map[string]string{"X-Forwarded-For": "10.9.9.9, 10.1.2.3"},
+			remoteAddr: "10.0.0.1:12345",
+			expectedIP: "10.9.9.9",
+		},
+		{
+			name:       "garbage hop stops the walk",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.7, not-an-ip, 10.1.2.3"},
+			remoteAddr: "10.0.0.1:12345",
+			expectedIP: "10.1.2.3",
+		},
+		{
+			name:       "chain without spaces",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.7,10.1.2.3"},
+			remoteAddr: "10.0.0.1:12345",
+			expectedIP: "198.51.100.7",
+		},
+		{
+			name:       "untrusted IPv6 peer",
+			headers:    map[string]string{"X-Forwarded-For": "192.168.1.100"},
+			remoteAddr: "[2001:db8::9]:12345",
+			expectedIP: "2001:db8::9",
+		},
+		{
+			name:       "hop with port",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.7:4711"},
+			remoteAddr: "192.0.2.1:12345",
+			expectedIP: "198.51.100.7",
+		},
 	}
 
 	for _, tt := range tests {
@@ -323,7 +559,7 @@ func TestGetClientIP(t *testing.T) {
 				req.Header.Set(key, value)
 			}
 
-			clientIP := getClientIP(req)
+			clientIP := getClientIP(req, trusted)
 			if clientIP != tt.expectedIP {
 				t.Errorf("Expected client IP %s, got %s", tt.expectedIP, clientIP)
 			}
@@ -331,6 +567,15 @@ func TestGetClientIP(t *testing.T) {
 	}
 }
 
+func TestGetClientIPWithoutTrustedProxies(t *testing.T) {
+	req := &http.Request{Header: make(http.Header), RemoteAddr: "10.0.0.1:12345"}
+	req.Header.Set("X-Forwarded-For", "192.168.1.100")
+
+	if clientIP := getClientIP(req, nil); clientIP != "10.0.0.1" {
+		t.Errorf("Expected X-Forwarded-For to be ignored without trusted proxies, got %s", clientIP)
+	}
+}
+
 func TestResponseWriter(t *testing.T) {
 	rw := &responseWriter{
 		ResponseWriter: httptest.NewRecorder(),
@@ -346,3 +591,771 @@ func TestResponseWriter(t *testing.T) {
 		t.Errorf("Expected status code 404, got %d", rw.statusCode)
 	}
 }
+
+func TestHandlerDialTimeout(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				// non-routable address, connection attempts hang until the dial timeout
+				Backends: []config.Backend{{URL: "http://10.255.255.1:81", Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+		Transport:      config.TransportConfig{DialTimeout: 200 * time.Millisecond},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: false})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+	handler.ServeHTTP(w, req)
+	elapsed := time.Since(start)
+
+	if w.Result().StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Result().StatusCode)
+	}
+
+	if elapsed > 2*time.Second {
+		t.Errorf("Expected request to fail within the dial timeout, took %v", elapsed)
+	}
+}
+
+func TestHandlerRequestTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Server:  config.ServerConfig{RequestTimeout: 50 * time.Millisecond},
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: false})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+	handler.ServeHTTP(w, req)
+	elapsed := time.Since(start)
+
+	if w.Result().StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("Expected status 504, got %d", w.Result().StatusCode)
+	}
+
+	if elapsed > 500*time.Millisecond {
+		t.Errorf("Expected request to be cut off by the timeout, took %v", elapsed)
+	}
+
+	// a per-upstream timeout takes precedence over the global default
+	cfg.Upstreams[0].Timeout = 2 * time.Second
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+
+	if w.Result().StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 with the longer upstream timeout, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestHandlerStaticHostOverride(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse backend URL: %v", err)
+	}
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: "http://api.internal.test:" + backendURL.Port(), Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+		Transport: config.TransportConfig{
+			DialTimeout: time.Second,
+			Hosts:       map[string]string{"api.internal.test": backendURL.Hostname()},
+		},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: false})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 via host override, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerSlowRequestLog(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(150 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+		Logging:        config.LoggingConfig{SlowRequestThreshold: 100 * time.Millisecond},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: false})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fast", nil))
+	if strings.Contains(buf.String(), "slow request") {
+		t.Errorf("Fast request should not be logged as slow, got %q", buf.String())
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	logged := buf.String()
+	if !strings.Contains(logged, "slow request") {
+		t.Fatalf("Expected slow request log, got %q", logged)
+	}
+	for _, field := range []string{"method=GET", `path="/slow"`, "backend=" + backend.URL, "retries=0"} {
+		if !strings.Contains(logged, field) {
+			t.Errorf("Expected slow request log to contain %s, got %q", field, logged)
+		}
+	}
+}
+
+func TestHandlerBackendConnectionClose(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("closing"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, Timeout: time.Minute},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: true})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+
+		resp := w.Result()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d", i, resp.StatusCode)
+		}
+		if resp.Header.Get("Connection") != "" {
+			t.Errorf("Request %d: hop-by-hop Connection header should not be forwarded", i)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "closing" {
+			t.Errorf("Request %d: expected body %q, got %q", i, "closing", body)
+		}
+	}
+
+	if state := handler.circuitBreaker.GetState(backend.URL); state != "closed" {
+		t.Errorf("Connection: close should not count as a failure, circuit is %s", state)
+	}
+
+	w := httptest.NewRecorder()
+	metricsCollector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `isame_lb_backend_connections_total{backend="` + backend.URL + `",reused="false",upstream="test-upstream"} 3`
+	if !strings.Contains(w.Body.String(), expected) {
+		t.Errorf("Expected every closed connection to be counted as not reused, metrics:\n%s", w.Body.String())
+	}
+}
+
+func TestHandlerAdaptiveWeightFromResponseHeader(t *testing.T) {
+	busyCount, idleCount := 0, 0
+
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		busyCount++
+		w.Header().Set("X-Backend-Load", "0.9")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer busy.Close()
+
+	idle := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idleCount++
+		w.Header().Set("X-Backend-Load", "0.1")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer idle.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "weighted_round_robin",
+				Backends: []config.Backend{
+					{URL: busy.URL, Weight: 1},
+					{URL: idle.URL, Weight: 1},
+				},
+				AdaptiveWeight: &config.AdaptiveWeightConfig{
+					Enabled: true,
+					Header:  "X-Backend-Load",
+					Decay:   time.Minute,
+				},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: false})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	for i := 0; i < 200; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+	}
+
+	// factors settle at 0.1 and 0.9, so the busy backend should see far less
+	if busyCount*3 > idleCount {
+		t.Errorf("Expected busy backend to receive much less traffic, got busy=%d idle=%d", busyCount, idleCount)
+	}
+}
+
+func TestHandlerReloadKeepsUnchangedBalancers(t *testing.T) {
+	cfg := &config.Config{
+		Upstreams: []config.Upstream{
+			{Name: "api", Algorithm: "least_connections", Backends: []config.Backend{{URL: "http://api1:8080", Weight: 1}}},
+			{Name: "web", Algorithm: "round_robin", Backends: []config.Backend{{URL: "http://web1:8080", Weight: 1}}},
+		},
+	}
+
+	handler, err := NewHandler(cfg, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	before := handler.routing.Load()
+
+	next := &config.Config{
+		Upstreams: []config.Upstream{
+			// backends changed, algorithm did not: connection counts carry over
+			{Name: "api", Algorithm: "least_connections", Backends: []config.Backend{{URL: "http://api2:8080", Weight: 1}}},
+			{Name: "web", Algorithm: "weighted_round_robin", Backends: []config.Backend{{URL: "http://web1:8080", Weight: 1}}},
+		},
+	}
+	if err := handler.Reload(next); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	after := handler.routing.Load()
+
+	if after.loadBalancers["api"] != before.loadBalancers["api"] {
+		t.Error("Expected the api balancer to be reused")
+	}
+	if after.loadBalancers["web"].Algorithm() != "weighted_round_robin" {
+		t.Errorf("Expected the web balancer to be rebuilt, got %s", after.loadBalancers["web"].Algorithm())
+	}
+	if after.transport != before.transport {
+		t.Error("Expected the backend transport to be reused")
+	}
+}
+
+func TestHandlerFailedReloadStartsNoCanary(t *testing.T) {
+	upstream := func(step float64) config.Upstream {
+		return config.Upstream{
+			Name:     "api",
+			Backends: []config.Backend{{URL: "http://stable:8080", Weight: 1}, {URL: "http://canary:8080", Weight: 1}},
+			Canary: &config.CanaryConfig{
+				Enabled: true,
+				Backend: "http://canary:8080",
+				Ramp:    config.CanaryRampConfig{Start: 10, Step: step, Interval: time.Minute, Target: 100},
+			},
+		}
+	}
+
+	handler, err := NewHandler(&config.Config{Upstreams: []config.Upstream{upstream(10)}}, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	defer handler.Stop()
+	before := handler.routing.Load()
+	goroutines := runtime.NumGoroutine()
+
+	// the changed ramp is built before trusted_proxies fails to parse
+	next := &config.Config{
+		Server:    config.ServerConfig{TrustedProxies: []string{"not-an-ip"}},
+		Upstreams: []config.Upstream{upstream(20)},
+	}
+	if err := handler.Reload(next); err == nil {
+		t.Fatal("Expected Reload() to fail on invalid trusted_proxies")
+	}
+
+	if handler.routing.Load() != before {
+		t.Error("Expected the failed reload to keep the previous routing")
+	}
+	if got := runtime.NumGoroutine(); got > goroutines {
+		t.Errorf("Expected the failed reload to start no canary ramp, goroutines went from %d to %d", goroutines, got)
+	}
+}
+
+func TestHandlerAllBackendsSaturated(t *testing.T) {
+	release := make(chan struct{})
+	arrived := make(chan struct{}, 1)
+	attempts := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		arrived <- struct{}{}
+		<-release
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "least_connections",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1, MaxConns: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: true, MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: false})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	}()
+	<-arrived
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+
+	close(release)
+	<-done
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 while the backend is at max_conns, got %d", w.Code)
+	}
+	if attempts != 1 {
+		t.Errorf("Expected only the in-flight request to reach the backend, got %d", attempts)
+	}
+}
+
+func TestHandlerRetryAndCircuitBreakerMetrics(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer healthy.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends: []config.Backend{
+					{URL: failing.URL, Weight: 1},
+					{URL: healthy.URL, Weight: 1},
+				},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, Timeout: time.Minute},
+		Retry:          config.RetryConfig{Enabled: true, MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: true})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+
+	w := httptest.NewRecorder()
+	metricsCollector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	content := w.Body.String()
+
+	for _, expected := range []string{
+		`isame_lb_retries_total{backend="` + failing.URL + `",upstream="test-upstream"} 1`,
+		`isame_lb_circuit_breaker_state{backend="` + failing.URL + `",upstream="test-upstream"} 1`,
+		`isame_lb_circuit_breaker_trips_total{backend="` + failing.URL + `",upstream="test-upstream"} 1`,
+		`isame_lb_circuit_breaker_state{backend="` + healthy.URL + `",upstream="test-upstream"} 0`,
+		// one selection per attempt
+		`isame_lb_selection_duration_seconds_count{upstream="test-upstream"} 2`,
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected %s in metrics:\n%s", expected, content)
+		}
+	}
+}
+
+func TestHandlerRateLimitedRetryAfter(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+				RateLimit: &config.RateLimitConfig{Enabled: true, RequestsPerIP: 2, WindowSize: 30 * time.Second},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: true})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		w = httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		handler.ServeHTTP(w, req)
+	}
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "30" {
+		t.Errorf("Expected Retry-After 30 for the oldest request in a 30s window, got %q", retryAfter)
+	}
+
+	m := httptest.NewRecorder()
+	metricsCollector.Handler().ServeHTTP(m, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `isame_lb_rate_limited_total{upstream="test-upstream"} 1`
+	if !strings.Contains(m.Body.String(), expected) {
+		t.Errorf("Expected %s in metrics:\n%s", expected, m.Body.String())
+	}
+}
+
+func TestHandlerConsistentHashFailsOverPastOpenCircuit(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer healthy.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:           "sessions",
+				Algorithm:      "consistent_hash",
+				ConsistentHash: &config.ConsistentHashConfig{Header: "X-User"},
+				Backends:       []config.Backend{{URL: failing.URL, Weight: 1}, {URL: healthy.URL, Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, Timeout: time.Minute},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	send := func(user string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/profile", nil)
+		req.Header.Set("X-User", user)
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// find a user hashed onto the failing backend; its first request opens the circuit
+	user := ""
+	for i := 0; i < 100 && user == ""; i++ {
+		candidate := fmt.Sprintf("user-%d", i)
+		if send(candidate) == http.StatusBadGateway {
+			user = candidate
+		}
+	}
+	if user == "" {
+		t.Fatal("No key hashed onto the failing backend")
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := send(user); code != http.StatusOK {
+			t.Fatalf("Expected the key to fail over while the circuit is open, got %d", code)
+		}
+	}
+
+	// once the circuit closes the key goes back to its own backend
+	handler.CircuitBreaker().Reset(failing.URL)
+	if code := send(user); code != http.StatusBadGateway {
+		t.Errorf("Expected the key to return to its primary, got %d", code)
+	}
+}
+
+func TestHandlerPassiveHealthEjectsFailingBackend(t *testing.T) {
+	var failingHits atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failingHits.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends: []config.Backend{
+					{URL: failing.URL, Weight: 1},
+					{URL: healthy.URL, Weight: 1},
+				},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	// active checks stay off; only proxied traffic can eject the backend
+	healthChecker := health.NewChecker(config.HealthConfig{
+		Enabled: false,
+		Passive: config.PassiveHealthConfig{Enabled: true, FailureThreshold: 3, CoolDown: time.Minute},
+	})
+	defer healthChecker.Stop()
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: false})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	for i := 0; i < 6; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+	}
+	if got := failingHits.Load(); got != 3 {
+		t.Fatalf("Expected failing backend to take 3 requests before ejection, got %d", got)
+	}
+	if healthChecker.IsHealthy(failing.URL) {
+		t.Fatal("Expected failing backend to be marked unhealthy")
+	}
+
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("Request %d: expected 200 from the remaining backend, got %d", i, w.Code)
+		}
+	}
+	if got := failingHits.Load(); got != 3 {
+		t.Errorf("Expected no more requests to the ejected backend, got %d total", got)
+	}
+}
+
+func TestHandlerErrorWeightReducesFlakyShare(t *testing.T) {
+	var flakyHits atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if flakyHits.Add(1)%2 == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer flaky.Close()
+
+	steady := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer steady.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:        "test-upstream",
+				Algorithm:   "weighted_round_robin",
+				ErrorWeight: &config.ErrorWeightConfig{Enabled: true, Decay: 0.5, Recovery: 1.5, Floor: 0.1},
+				Backends: []config.Backend{
+					{URL: flaky.URL, Weight: 1},
+					{URL: steady.URL, Weight: 1},
+				},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	const requests = 200
+	for i := 0; i < requests; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+	}
+
+	share := float64(flakyHits.Load()) / requests
+	if share >= 0.4 || share == 0 {
+		t.Errorf("Expected the flaky backend to get a reduced but nonzero share, got %.1f%%", share*100)
+	}
+}
+
+func TestHandlerLeastResponseTimePrefersFasterBackend(t *testing.T) {
+	var slowHits, fastHits atomic.Int64
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowHits.Add(1)
+		time.Sleep(30 * time.Millisecond)
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fastHits.Add(1)
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{Port: 8080},
+		Upstreams: []config.Upstream{{
+			Name:      "api",
+			Algorithm: "least_response_time",
+			Backends: []config.Backend{
+				{URL: slow.URL, Weight: 1},
+				{URL: fast.URL, Weight: 1},
+			},
+		}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+	}
+
+	// each backend is measured once, after that the fast one wins
+	if slowHits.Load() != 1 || fastHits.Load() != 19 {
+		t.Errorf("Expected 1 request to the slow backend and 19 to the fast one, got %d and %d", slowHits.Load(), fastHits.Load())
+	}
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// time constant of the per-upstream request rate average
+const rateWindow = 10 * time.Second
+
+// requestRate is an exponentially weighted requests-per-second estimate:
+// each request adds 1/window and the total decays with e^(-t/window), which
+// settles on the true rate for steady traffic
+type requestRate struct {
+	mu      sync.Mutex
+	value   float64
+	updated time.Time
+}
+
+func (rr *requestRate) record(now time.Time) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.value = rr.at(now) + 1/rateWindow.Seconds()
+	rr.updated = now
+}
+
+func (rr *requestRate) perSecond(now time.Time) float64 {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	return rr.at(now)
+}
+
+// caller must hold rr.mu
+func (rr *requestRate) at(now time.Time) float64 {
+	if rr.updated.IsZero() {
+		return 0
+	}
+	return rr.value * math.Exp(-float64(now.Sub(rr.updated))/float64(rateWindow))
+}
+
+// the rate tracker for upstream, created on first use; rates outlive reloads
+func (h *Handler) requestRate(upstream string) *requestRate {
+	h.ratesMu.RLock()
+	rate, exists := h.rates[upstream]
+	h.ratesMu.RUnlock()
+	if exists {
+		return rate
+	}
+
+	h.ratesMu.Lock()
+	defer h.ratesMu.Unlock()
+	if rate, exists = h.rates[upstream]; !exists {
+		rate = &requestRate{}
+		h.rates[upstream] = rate
+	}
+	return rate
+}
+
+// RequestRates reports the current requests per second of every configured upstream
+func (h *Handler) RequestRates() map[string]float64 {
+	now := time.Now()
+	upstreams := h.routing.Load().config.Upstreams
+
+	h.ratesMu.RLock()
+	defer h.ratesMu.RUnlock()
+
+	rates := make(map[string]float64, len(upstreams))
+	for _, upstream := range upstreams {
+		if rate, exists := h.rates[upstream.Name]; exists {
+			rates[upstream.Name] = rate.perSecond(now)
+		} else {
+			rates[upstream.Name] = 0
+		}
+	}
+	return rates
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/health"
+	"github.com/sanchxt/isame-lb/internal/metrics"
+)
+
+func TestRequestRateTracksSteadyTraffic(t *testing.T) {
+	rate := &requestRate{}
+	start := time.Now()
+
+	// 50 requests per second for a minute, well past the averaging window
+	now := start
+	for i := 0; i < 50*60; i++ {
+		now = start.Add(time.Duration(i) * 20 * time.Millisecond)
+		rate.record(now)
+	}
+
+	if got := rate.perSecond(now); got < 45 || got > 55 {
+		t.Errorf("Expected about 50 requests per second, got %.2f", got)
+	}
+
+	// idle traffic decays towards zero
+	if got := rate.perSecond(now.Add(time.Minute)); got > 1 {
+		t.Errorf("Expected the rate to decay after a minute of silence, got %.2f", got)
+	}
+}
+
+func TestRequestRateStartsAtZero(t *testing.T) {
+	rate := &requestRate{}
+	if got := rate.perSecond(time.Now()); got != 0 {
+		t.Errorf("Expected 0 before any request, got %.2f", got)
+	}
+}
+
+func TestHandlerRequestRates(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{Name: "busy", Backends: []config.Backend{{URL: backend.URL, Weight: 1}}},
+			{Name: "idle", Backends: []config.Backend{{URL: backend.URL, Weight: 1}}, Match: &config.MatchConfig{Host: "idle.example.com"}},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	rates := handler.RequestRates()
+	// ten requests at once add 10/window before any decay
+	if got := rates["busy"]; got < 0.9 || got > 1.0 {
+		t.Errorf("Expected busy upstream near 1 request per second, got %.3f", got)
+	}
+	if got, exists := rates["idle"]; !exists || got != 0 {
+		t.Errorf("Expected idle upstream reported at 0, got %.3f (present %v)", got, exists)
+	}
+}
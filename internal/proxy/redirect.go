@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net/http"
+)
+
+// how much of a followed redirect's body is read so its connection can be
+// reused; larger bodies close the connection instead
+const maxRedirectDrainBytes = 64 << 10
+
+// redirectFollower is an attempt's transport when the upstream follows its
+// backends' redirects, so the client only sees where they lead. Redirects
+// to another scheme or host are passed through, as is the one past maxHops.
+type redirectFollower struct {
+	next    http.RoundTripper
+	maxHops int
+}
+
+func (rf *redirectFollower) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rf.next.RoundTrip(req)
+	for hops := 0; err == nil; hops++ {
+		next := redirectRequest(req, resp)
+		if next == nil {
+			return resp, nil
+		}
+		if hops == rf.maxHops {
+			log.Printf("Warning: backend %s redirected %s more than %d times, passing the redirect through", req.URL.Host, req.URL.Path, rf.maxHops)
+			return resp, nil
+		}
+
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxRedirectDrainBytes))
+		resp.Body.Close()
+
+		req = next
+		resp, err = rf.next.RoundTrip(req)
+	}
+	return nil, err
+}
+
+// the request following resp's redirect, nil when resp should reach the
+// client as it is. 301, 302 and 303 turn into a GET without a body like in
+// browsers; 307 and 308 repeat the request, which needs a replayable body.
+func redirectRequest(req *http.Request, resp *http.Response) *http.Request {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil
+	}
+
+	location, err := req.URL.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return nil
+	}
+	if location.Scheme != req.URL.Scheme || location.Host != req.URL.Host {
+		return nil
+	}
+
+	next := req.Clone(req.Context())
+	next.URL = location
+
+	switch resp.StatusCode {
+	case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil
+			}
+			next.Body = body
+		}
+	default:
+		if req.Method != http.MethodHead {
+			next.Method = http.MethodGet
+		}
+		next.Body = nil
+		next.GetBody = nil
+		next.ContentLength = 0
+		next.Header.Del("Content-Type")
+		next.Header.Del("Content-Length")
+	}
+
+	return next
+}
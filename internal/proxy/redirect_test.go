@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// a backend that redirects /old and /temporary to /new, /loop to itself and
+// /elsewhere off host, and echoes the method and body at /new
+func newRedirectingBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		case "/temporary":
+			http.Redirect(w, r, "/new", http.StatusTemporaryRedirect)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/elsewhere":
+			http.Redirect(w, r, "http://example.com/new", http.StatusFound)
+		case "/new":
+			body, _ := io.ReadAll(r.Body)
+			w.Write([]byte(r.Method + " " + string(body)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestHandlerBackendRedirectPassThrough(t *testing.T) {
+	backend := newRedirectingBackend(t)
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:     "api",
+			Backends: []config.Backend{{URL: backend.URL, Weight: 1}},
+		}},
+	}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/old", nil))
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected the backend's 302 to reach the client, got %d", w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "/new" {
+		t.Errorf("Expected Location /new, got %q", location)
+	}
+}
+
+func TestHandlerFollowBackendRedirects(t *testing.T) {
+	backend := newRedirectingBackend(t)
+	handler := newTestHandler(t, &config.Config{
+		Upstreams: []config.Upstream{{
+			Name:                   "api",
+			Backends:               []config.Backend{{URL: backend.URL, Weight: 1}},
+			FollowBackendRedirects: &config.FollowRedirectsConfig{Enabled: true, MaxHops: 3},
+		}},
+	}, nil)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "found", method: "GET", path: "/old", wantStatus: http.StatusOK, wantBody: "GET "},
+		{name: "found turns POST into GET", method: "POST", path: "/old", body: "payload", wantStatus: http.StatusOK, wantBody: "GET "},
+		{name: "temporary keeps method and body", method: "POST", path: "/temporary", body: "payload", wantStatus: http.StatusOK, wantBody: "POST payload"},
+		{name: "other host passed through", method: "GET", path: "/elsewhere", wantStatus: http.StatusFound},
+		{name: "past max hops passed through", method: "GET", path: "/loop", wantStatus: http.StatusFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("Expected body %q, got %q", tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
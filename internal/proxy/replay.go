@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// request bodies up to this size are buffered so a retry can resend them
+const maxReplayBodyBytes = 1 << 20 // 1MB
+
+// a backend flagged its response as a transient error via retry.on_header
+var errRetryableResponse = errors.New("backend flagged response as retryable")
+
+// bufferBody makes r's body replayable through r.GetBody; false when the
+// body is too large (or unreadable) to buffer, in which case it is left
+// readable once as before
+func bufferBody(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > maxReplayBodyBytes {
+		return false
+	}
+
+	// read one byte past the cap so oversized bodies can be detected
+	buf, err := io.ReadAll(io.LimitReader(r.Body, maxReplayBodyBytes+1))
+	if err != nil || len(buf) > maxReplayBodyBytes {
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		return false
+	}
+	r.Body.Close()
+
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return true
+}
+
+// rewinds a buffered body before another attempt
+func rewindBody(r *http.Request) {
+	if r.GetBody != nil {
+		r.Body, _ = r.GetBody()
+	}
+}
+
+func retryableResponse(resp *http.Response, onHeader *config.RetryHeaderConfig) bool {
+	return strings.EqualFold(strings.TrimSpace(resp.Header.Get(onHeader.Name)), onHeader.Value)
+}
+
+// whether a request may be sent again after a backend saw it: POST and
+// PATCH can have side effects, unless the client made them safe to repeat
+// with an Idempotency-Key
+func retryAllowed(r *http.Request, cfg config.RetryConfig) bool {
+	if !cfg.OnlyIdempotent() {
+		return true
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != ""
+}
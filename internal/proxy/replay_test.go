@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/health"
+	"github.com/sanchxt/isame-lb/internal/metrics"
+)
+
+func TestHandlerRetriesOnRetryableHeader(t *testing.T) {
+	var flaggedBodies, healthyBodies []string
+
+	flagged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		flaggedBodies = append(flaggedBodies, string(body))
+		w.Header().Set("X-Retryable", "true")
+		w.Write([]byte("try elsewhere"))
+	}))
+	defer flagged.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		healthyBodies = append(healthyBodies, string(body))
+		w.Write([]byte("ok"))
+	}))
+	defer healthy.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends: []config.Backend{
+					{URL: flagged.URL, Weight: 1},
+					{URL: healthy.URL, Weight: 1},
+				},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry: config.RetryConfig{
+			Enabled:        true,
+			MaxAttempts:    2,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+			OnHeader:       &config.RetryHeaderConfig{Name: "X-Retryable", Value: "true"},
+		},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: false})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{"id":1}`))
+	req.Header.Set("Idempotency-Key", "order-1")
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if w.Body.String() != "ok" {
+		t.Errorf("Expected the retried backend's body, got %q", w.Body.String())
+	}
+	if w.Header().Get("X-Retryable") != "" {
+		t.Error("Flagged response headers should not reach the client")
+	}
+
+	if len(flaggedBodies) != 1 || len(healthyBodies) != 1 {
+		t.Fatalf("Expected one attempt per backend, got %d and %d", len(flaggedBodies), len(healthyBodies))
+	}
+	if healthyBodies[0] != `{"id":1}` {
+		t.Errorf("Expected the request body to be resent on retry, got %q", healthyBodies[0])
+	}
+}
+
+func TestBufferBodyTooLarge(t *testing.T) {
+	payload := strings.Repeat("x", maxReplayBodyBytes+1)
+	r := httptest.NewRequest("POST", "/upload", io.NopCloser(strings.NewReader(payload)))
+	r.ContentLength = -1
+
+	if bufferBody(r) {
+		t.Error("Expected an oversized body not to be replayable")
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	if len(body) != len(payload) {
+		t.Errorf("Expected the body to stay readable once, got %d of %d bytes", len(body), len(payload))
+	}
+}
+
+func TestHandlerRetriesOnlyIdempotentMethods(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		idempotencyKey string
+		idempotentOnly *bool
+		attempts       int
+	}{
+		{name: "GET is retried", method: "GET", attempts: 3},
+		{name: "PUT is retried", method: "PUT", attempts: 3},
+		{name: "POST is attempted once", method: "POST", attempts: 1},
+		{name: "PATCH is attempted once", method: "PATCH", attempts: 1},
+		{name: "POST with idempotency key is retried", method: "POST", idempotencyKey: "abc", attempts: 3},
+		{name: "POST retried when opted in", method: "POST", idempotentOnly: new(bool), attempts: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+			defer backend.Close()
+
+			cfg := &config.Config{
+				Service: "test-lb",
+				Upstreams: []config.Upstream{
+					{
+						Name:      "test-upstream",
+						Algorithm: "round_robin",
+						Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+					},
+				},
+				CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+				Retry: config.RetryConfig{
+					Enabled:        true,
+					MaxAttempts:    3,
+					InitialBackoff: time.Millisecond,
+					MaxBackoff:     time.Millisecond,
+					IdempotentOnly: tt.idempotentOnly,
+				},
+			}
+
+			healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+			metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: false})
+
+			handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+			if err != nil {
+				t.Fatalf("Failed to create handler: %v", err)
+			}
+
+			req := httptest.NewRequest(tt.method, "/resource", strings.NewReader("payload"))
+			if tt.idempotencyKey != "" {
+				req.Header.Set("Idempotency-Key", tt.idempotencyKey)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if attempts != tt.attempts {
+				t.Errorf("Expected %d attempts, got %d", tt.attempts, attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+var errBodyTooLarge = errors.New("decompressed body exceeds max_body_bytes")
+
+// bodyRewriter applies literal find/replace rules to eligible response bodies
+type bodyRewriter struct {
+	replacer     *strings.Replacer
+	contentTypes map[string]bool
+	maxBodyBytes int64
+}
+
+func newBodyRewriter(cfg *config.ResponseRewriteConfig) *bodyRewriter {
+	pairs := make([]string, 0, len(cfg.Rules)*2)
+	for _, rule := range cfg.Rules {
+		pairs = append(pairs, rule.Find, rule.Replace)
+	}
+
+	contentTypes := make(map[string]bool, len(cfg.ContentTypes))
+	for _, ct := range cfg.ContentTypes {
+		contentTypes[strings.ToLower(ct)] = true
+	}
+
+	return &bodyRewriter{
+		replacer:     strings.NewReplacer(pairs...),
+		contentTypes: contentTypes,
+		maxBodyBytes: cfg.MaxBodyBytes,
+	}
+}
+
+// ModifyResponse rewrites the body in place, leaving it untouched when the
+// content type, encoding or size make rewriting unsafe
+func (br *bodyRewriter) ModifyResponse(resp *http.Response) error {
+	if !br.eligible(resp) {
+		return nil
+	}
+
+	if resp.ContentLength > br.maxBodyBytes {
+		return nil
+	}
+
+	gzipped := strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip")
+
+	// read one byte past the cap so oversized bodies can be detected
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, br.maxBodyBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(raw)) > br.maxBodyBytes {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(raw), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+
+	body := raw
+	if gzipped {
+		body, err = gunzip(raw, br.maxBodyBytes)
+		if err != nil {
+			// not worth failing the request over, send what the backend sent
+			resp.Body = io.NopCloser(bytes.NewReader(raw))
+			return nil
+		}
+		resp.Header.Del("Content-Encoding")
+	}
+
+	rewritten := []byte(br.replacer.Replace(string(body)))
+
+	resp.Body = io.NopCloser(bytes.NewReader(rewritten))
+	resp.ContentLength = int64(len(rewritten))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(rewritten)))
+	resp.Header.Del("ETag")
+
+	return nil
+}
+
+func (br *bodyRewriter) eligible(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !br.contentTypes[strings.ToLower(mediaType)] {
+		return false
+	}
+
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "", "identity", "gzip":
+		return true
+	default:
+		return false
+	}
+}
+
+func gunzip(data []byte, limit int64) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	body, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errBodyTooLarge
+	}
+
+	return body, nil
+}
+
+// readCloser pairs a replacement reader with the original body's Close
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
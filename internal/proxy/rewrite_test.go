@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/health"
+	"github.com/sanchxt/isame-lb/internal/metrics"
+)
+
+func newTestRewriter(maxBodyBytes int64) *bodyRewriter {
+	return newBodyRewriter(&config.ResponseRewriteConfig{
+		Enabled:      true,
+		Rules:        []config.RewriteRule{{Find: "http://internal-host", Replace: "https://example.com"}},
+		ContentTypes: []string{"text/html", "application/json"},
+		MaxBodyBytes: maxBodyBytes,
+	})
+}
+
+func newTestResponse(contentType, encoding string, body []byte) *http.Response {
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	resp.Header.Set("Content-Type", contentType)
+	if encoding != "" {
+		resp.Header.Set("Content-Encoding", encoding)
+	}
+	return resp
+}
+
+func TestBodyRewriterRewritesHTML(t *testing.T) {
+	rw := newTestRewriter(1024)
+	resp := newTestResponse("text/html; charset=utf-8", "", []byte(`<a href="http://internal-host/login">login</a>`))
+
+	if err := rw.ModifyResponse(resp); err != nil {
+		t.Fatalf("ModifyResponse() error = %v", err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	expected := `<a href="https://example.com/login">login</a>`
+	if string(body) != expected {
+		t.Errorf("Expected body %q, got %q", expected, body)
+	}
+	if resp.ContentLength != int64(len(expected)) {
+		t.Errorf("Expected content length %d, got %d", len(expected), resp.ContentLength)
+	}
+}
+
+func TestBodyRewriterSkipsBinaryContent(t *testing.T) {
+	rw := newTestRewriter(1024)
+	original := []byte("http://internal-host \x00\x01\x02")
+	resp := newTestResponse("application/octet-stream", "", original)
+
+	if err := rw.ModifyResponse(resp); err != nil {
+		t.Fatalf("ModifyResponse() error = %v", err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(body, original) {
+		t.Errorf("Binary body should be untouched, got %q", body)
+	}
+}
+
+func TestBodyRewriterDecompressesGzip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(`{"url":"http://internal-host/api"}`))
+	zw.Close()
+
+	rw := newTestRewriter(1024)
+	resp := newTestResponse("application/json", "gzip", buf.Bytes())
+
+	if err := rw.ModifyResponse(resp); err != nil {
+		t.Fatalf("ModifyResponse() error = %v", err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != `{"url":"https://example.com/api"}` {
+		t.Errorf("Unexpected rewritten body %q", body)
+	}
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Error("Content-Encoding should be removed after decompressing")
+	}
+}
+
+func TestBodyRewriterSkipsOversizedBody(t *testing.T) {
+	rw := newTestRewriter(16)
+	original := []byte("<p>http://internal-host is a long body</p>")
+	resp := newTestResponse("text/html", "", original)
+	resp.ContentLength = -1 // force the streaming size check
+
+	if err := rw.ModifyResponse(resp); err != nil {
+		t.Fatalf("ModifyResponse() error = %v", err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(body, original) {
+		t.Errorf("Oversized body should pass through untouched, got %q", body)
+	}
+}
+
+func TestHandlerResponseRewrite(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`<img src="http://internal-host/logo.png">`))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+				ResponseRewrite: &config.ResponseRewriteConfig{
+					Enabled:      true,
+					Rules:        []config.RewriteRule{{Find: "http://internal-host", Replace: "https://example.com"}},
+					ContentTypes: []string{"text/html"},
+					MaxBodyBytes: 1024,
+				},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: false})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	body, _ := io.ReadAll(w.Result().Body)
+	if !strings.Contains(string(body), "https://example.com/logo.png") {
+		t.Errorf("Expected rewritten body, got %q", body)
+	}
+}
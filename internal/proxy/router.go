@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// metrics label for requests no upstream accepted
+const unmatchedUpstream = "unmatched"
+
+// picks the upstream for a request: the first route that matches it, then
+// the first upstream whose match rules accept it, then the configured
+// default, then the first catch-all upstream; nil when none apply
+func (rt *routing) matchUpstream(r *http.Request, healthStatus map[string]bool) *config.Upstream {
+	upstream, _ := rt.explainMatch(r, healthStatus)
+	return upstream
+}
+
+// matchUpstream along with which rule picked the upstream
+func (rt *routing) explainMatch(r *http.Request, healthStatus map[string]bool) (*config.Upstream, string) {
+	upstreams := rt.config.Upstreams
+
+	for i := range rt.config.Routes {
+		if route := &rt.config.Routes[i]; matches(&route.Match, r) {
+			upstream := rt.routeUpstream(route, healthStatus)
+			if upstream != nil && upstream.Name != route.Upstreams[0] {
+				return upstream, fmt.Sprintf("routes[%d], preferred upstreams have no healthy backend", i)
+			}
+			return upstream, fmt.Sprintf("routes[%d]", i)
+		}
+	}
+
+	for i := range upstreams {
+		if match := upstreams[i].Match; match != nil && matches(match, r) {
+			return &upstreams[i], "upstream match rules"
+		}
+	}
+
+	if name := rt.config.Server.DefaultUpstream; name != "" {
+		if upstream := rt.upstream(name); upstream != nil {
+			return upstream, "server.default_upstream"
+		}
+	}
+
+	for i := range upstreams {
+		if upstreams[i].Match == nil {
+			return &upstreams[i], "first upstream without match rules"
+		}
+	}
+
+	return nil, "no upstream matches"
+}
+
+// the first of the route's upstreams with a healthy backend; when none has
+// one the preferred upstream is used so the request fails the usual way
+func (rt *routing) routeUpstream(route *config.Route, healthStatus map[string]bool) *config.Upstream {
+	var preferred *config.Upstream
+	for _, name := range route.Upstreams {
+		upstream := rt.upstream(name)
+		if upstream == nil {
+			continue
+		}
+		if preferred == nil {
+			preferred = upstream
+		}
+		if hasAvailableBackend(upstream, healthStatus) {
+			return upstream
+		}
+	}
+	return preferred
+}
+
+func (rt *routing) upstream(name string) *config.Upstream {
+	for i := range rt.config.Upstreams {
+		if rt.config.Upstreams[i].Name == name {
+			return &rt.config.Upstreams[i]
+		}
+	}
+	return nil
+}
+
+// the name of the first upstream the backend belongs to, for metric labels
+func (rt *routing) backendUpstream(backendURL string) string {
+	for _, upstream := range rt.config.Upstreams {
+		for _, backend := range upstream.Backends {
+			if backend.URL == backendURL {
+				return upstream.Name
+			}
+		}
+	}
+	return unmatchedUpstream
+}
+
+// backends without a health status yet count as healthy, like the balancers do
+func hasAvailableBackend(upstream *config.Upstream, healthStatus map[string]bool) bool {
+	for _, backend := range upstream.Backends {
+		if backend.Disabled {
+			continue
+		}
+		if healthy, exists := healthStatus[backend.URL]; !exists || healthy {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(match *config.MatchConfig, r *http.Request) bool {
+	if match.Host != "" && !strings.EqualFold(requestHost(r), match.Host) {
+		return false
+	}
+	if match.PathPrefix != "" && !hasPathPrefix(r.URL.Path, match.PathPrefix) {
+		return false
+	}
+	if match.Header != "" {
+		values := r.Header.Values(match.Header)
+		if len(values) == 0 || (match.HeaderValue != "" && !slices.Contains(values, match.HeaderValue)) {
+			return false
+		}
+	}
+	return true
+}
+
+func requestHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		return host
+	}
+	return r.Host
+}
+
+// whether the request's Host is one of allowed, see config.HostAllowed
+func hostAllowed(r *http.Request, allowed []string) bool {
+	return config.HostAllowed(requestHost(r), allowed)
+}
+
+// "/api" matches "/api" and "/api/users" but not "/apiv2"
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// removes prefix from u's path on a segment boundary, leaving "/" when
+// nothing is left; paths outside prefix are not touched
+func stripPrefix(u *url.URL, prefix string) {
+	if prefix == "" || !hasPathPrefix(u.Path, prefix) {
+		return
+	}
+
+	u.Path = ensureLeadingSlash(u.Path[len(prefix):])
+	if u.RawPath != "" {
+		// the escaped form only matches when the prefix has nothing to escape
+		if rest, ok := strings.CutPrefix(u.RawPath, prefix); ok {
+			u.RawPath = ensureLeadingSlash(rest)
+		} else {
+			u.RawPath = ""
+		}
+	}
+}
+
+func ensureLeadingSlash(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
+}
+
+func upstreamName(upstream *config.Upstream) string {
+	if upstream == nil {
+		return unmatchedUpstream
+	}
+	return upstream.Name
+}
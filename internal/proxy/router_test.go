@@ -0,0 +1,346 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/health"
+	"github.com/sanchxt/isame-lb/internal/metrics"
+)
+
+func newNamedBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestHandlerRoutesByMatch(t *testing.T) {
+	api := newNamedBackend(t, "api")
+	web := newNamedBackend(t, "web")
+	static := newNamedBackend(t, "static")
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:     "api",
+				Backends: []config.Backend{{URL: api.URL, Weight: 1}},
+				Match:    &config.MatchConfig{PathPrefix: "/api"},
+			},
+			{
+				Name:     "static",
+				Backends: []config.Backend{{URL: static.URL, Weight: 1}},
+				Match:    &config.MatchConfig{Host: "static.example.com"},
+			},
+			{
+				Name:     "web",
+				Backends: []config.Backend{{URL: web.URL, Weight: 1}},
+				Match:    &config.MatchConfig{Host: "www.example.com"},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		host       string
+		path       string
+		defaultUp  string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "path prefix", host: "www.example.com", path: "/api/users", wantStatus: http.StatusOK, wantBody: "api"},
+		{name: "exact prefix", host: "www.example.com", path: "/api", wantStatus: http.StatusOK, wantBody: "api"},
+		{name: "prefix needs segment boundary", host: "www.example.com", path: "/apiv2", wantStatus: http.StatusOK, wantBody: "web"},
+		{name: "host with port", host: "STATIC.example.com:8080", path: "/logo.png", wantStatus: http.StatusOK, wantBody: "static"},
+		{name: "no match", host: "other.example.com", path: "/", wantStatus: http.StatusNotFound},
+		{name: "no match with default", host: "other.example.com", path: "/", defaultUp: "web", wantStatus: http.StatusOK, wantBody: "web"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.Server.DefaultUpstream = tt.defaultUp
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Host = tt.host
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+
+			body, _ := io.ReadAll(w.Body)
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("Expected request routed to %q, got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
+
+func TestMatchUpstreamCatchAll(t *testing.T) {
+	rt := &routing{config: &config.Config{
+		Upstreams: []config.Upstream{
+			{Name: "api", Match: &config.MatchConfig{PathPrefix: "/api/"}},
+			{Name: "web"},
+			{Name: "other"},
+		},
+	}}
+
+	req := httptest.NewRequest("GET", "/api/users", nil)
+	if got := upstreamName(rt.matchUpstream(req, nil)); got != "api" {
+		t.Errorf("Expected api, got %s", got)
+	}
+
+	// upstreams without match rules take what the rules leave, first one wins
+	req = httptest.NewRequest("GET", "/index.html", nil)
+	if got := upstreamName(rt.matchUpstream(req, nil)); got != "web" {
+		t.Errorf("Expected web, got %s", got)
+	}
+}
+
+func TestHandlerRouteFallsBackToHealthyUpstream(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	secondary := newNamedBackend(t, "secondary")
+	standard := newNamedBackend(t, "standard")
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{Name: "standard", Backends: []config.Backend{{URL: standard.URL, Weight: 1}}},
+			{Name: "premium", Backends: []config.Backend{{URL: primary.URL, Weight: 1}}, Match: &config.MatchConfig{Host: "premium.internal"}},
+			{Name: "premium-backup", Backends: []config.Backend{{URL: secondary.URL, Weight: 1}}, Match: &config.MatchConfig{Host: "backup.internal"}},
+		},
+		Routes: []config.Route{{
+			Match:     config.MatchConfig{Header: "X-Tier", HeaderValue: "premium"},
+			Upstreams: []string{"premium", "premium-backup"},
+		}},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{
+		Enabled:            true,
+		Interval:           20 * time.Millisecond,
+		Timeout:            time.Second,
+		Path:               "/health",
+		UnhealthyThreshold: 1,
+		HealthyThreshold:   1,
+	})
+	healthChecker.Start(cfg.Upstreams)
+	defer healthChecker.Stop()
+
+	handler, err := NewHandler(cfg, healthChecker, metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for healthChecker.IsHealthy(primary.URL) {
+		if time.Now().After(deadline) {
+			t.Fatal("Primary upstream never turned unhealthy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name     string
+		tier     string
+		wantBody string
+	}{
+		{name: "falls back past the unhealthy primary", tier: "premium", wantBody: "secondary"},
+		{name: "other header values skip the route", tier: "basic", wantBody: "standard"},
+		{name: "no header skips the route", wantBody: "standard"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.tier != "" {
+				req.Header.Set("X-Tier", tt.tier)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			if body := w.Body.String(); body != tt.wantBody {
+				t.Errorf("Expected request routed to %q, got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
+
+func TestRouteUpstreamPrefersFirstHealthy(t *testing.T) {
+	rt := &routing{config: &config.Config{
+		Upstreams: []config.Upstream{
+			{Name: "a", Backends: []config.Backend{{URL: "http://a1"}, {URL: "http://a2", Disabled: true}}},
+			{Name: "b", Backends: []config.Backend{{URL: "http://b1"}}},
+		},
+		Routes: []config.Route{{Match: config.MatchConfig{Header: "X-Pool"}, Upstreams: []string{"a", "b"}}},
+	}}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Pool", "any")
+
+	tests := []struct {
+		name   string
+		health map[string]bool
+		want   string
+	}{
+		{name: "primary healthy", health: map[string]bool{"http://a1": true, "http://b1": true}, want: "a"},
+		{name: "unknown status counts as healthy", health: map[string]bool{}, want: "a"},
+		{name: "primary down", health: map[string]bool{"http://a1": false, "http://a2": true}, want: "b"},
+		{name: "all down uses the primary", health: map[string]bool{"http://a1": false, "http://b1": false}, want: "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := upstreamName(rt.matchUpstream(req, tt.health)); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHandlerAllowedHosts(t *testing.T) {
+	backend := newNamedBackend(t, "api")
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Server:  config.ServerConfig{AllowedHosts: []string{"example.com", "*.example.org", "::1"}},
+		Upstreams: []config.Upstream{
+			{Name: "api", Algorithm: "round_robin", Backends: []config.Backend{{URL: backend.URL, Weight: 1}}},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	tests := []struct {
+		host   string
+		status int
+	}{
+		{host: "example.com", status: http.StatusOK},
+		{host: "EXAMPLE.com:8080", status: http.StatusOK},
+		{host: "example.com.", status: http.StatusOK},
+		{host: "api.example.org", status: http.StatusOK},
+		{host: "a.b.example.org:443", status: http.StatusOK},
+		{host: "[::1]:8080", status: http.StatusOK},
+		{host: "example.org", status: http.StatusBadRequest},
+		{host: "evil.com", status: http.StatusBadRequest},
+		{host: "example.com.evil.com", status: http.StatusBadRequest},
+		{host: "notexample.com", status: http.StatusBadRequest},
+		{host: "", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/items", nil)
+			req.Host = tt.host
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Host %q: expected %d, got %d", tt.host, tt.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandlerAllowedHostsEmptyAllowsAll(t *testing.T) {
+	backend := newNamedBackend(t, "api")
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{Name: "api", Algorithm: "round_robin", Backends: []config.Backend{{URL: backend.URL, Weight: 1}}},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: false},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/items", nil)
+	req.Host = "anything.test"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 without allowed_hosts, got %d", w.Code)
+	}
+}
+
+func TestHandlerStripPrefix(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{Port: 8080},
+		Upstreams: []config.Upstream{{
+			Name:        "api",
+			Backends:    []config.Backend{{URL: backend.URL, Weight: 1}},
+			StripPrefix: "/api/",
+		}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/api/users?page=2", want: "/users?page=2"},
+		{path: "/api/users/", want: "/users/"},
+		{path: "/api", want: "/"},
+		{path: "/api/", want: "/"},
+		{path: "/api/a%2Fb", want: "/a%2Fb"},
+		{path: "/apiv2/users", want: "/apiv2/users"},
+		{path: "/other", want: "/other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("Expected the backend to see %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/balancer"
+)
+
+// RateLimitClients reports how many clients each rate-limited upstream is tracking
+func (h *Handler) RateLimitClients() map[string]int {
+	rt := h.routing.Load()
+
+	clients := make(map[string]int, len(rt.rateLimiters))
+	for upstream, limiter := range rt.rateLimiters {
+		clients[upstream] = limiter.Clients()
+	}
+	return clients
+}
+
+// BalancerLockWait reports how long each upstream's balancer has spent
+// waiting for its locks, for balancers that keep track
+func (h *Handler) BalancerLockWait() map[string]time.Duration {
+	rt := h.routing.Load()
+
+	waits := make(map[string]time.Duration, len(rt.loadBalancers))
+	for upstream, lb := range rt.loadBalancers {
+		if waiter, ok := lb.(balancer.LockWaiter); ok {
+			waits[upstream] = waiter.LockWait()
+		}
+	}
+	return waits
+}
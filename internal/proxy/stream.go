@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httputil"
+)
+
+// the backend failed after the response started, so there is nothing to retry
+var errStreamAborted = errors.New("backend failed mid-stream")
+
+// streamWatch notes a backend response body that breaks before EOF
+type streamWatch struct {
+	err error
+}
+
+// wraps the response body after the other response hooks have run
+func (sw *streamWatch) modifyResponse(next func(*http.Response) error) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if next != nil {
+			if err := next(resp); err != nil {
+				return err
+			}
+		}
+		resp.Body = &watchedBody{ReadCloser: resp.Body, watch: sw}
+		return nil
+	}
+}
+
+type watchedBody struct {
+	io.ReadCloser
+	watch *streamWatch
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.watch.err == nil {
+		b.watch.err = err
+	}
+	return n, err
+}
+
+// runs the proxy and returns the backend's mid-stream error, if any. The
+// reverse proxy aborts the client connection on a broken copy; that abort is
+// held back here so the failure can be recorded first, and re-raised by
+// abortStream once the request is accounted for.
+func serveStream(proxy *httputil.ReverseProxy, w http.ResponseWriter, r *http.Request, watch *streamWatch) (streamErr error) {
+	defer func() {
+		rec := recover()
+		if rec == nil {
+			return
+		}
+		if rec != http.ErrAbortHandler {
+			panic(rec)
+		}
+		// the client's connection is gone already, the caller accounts for it
+		if clientGone(r) {
+			return
+		}
+		if watch.err == nil {
+			panic(rec)
+		}
+		streamErr = watch.err
+	}()
+
+	proxy.ServeHTTP(w, r)
+
+	if clientGone(r) {
+		return nil
+	}
+	return watch.err
+}
+
+// the client disconnected; request timeouts end in DeadlineExceeded instead
+func clientGone(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.Canceled)
+}
+
+// cuts the client connection so a truncated response can't pass for a
+// complete one; outside a real server there is no connection to cut
+func abortStream(r *http.Request) {
+	if r.Context().Value(http.ServerContextKey) != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
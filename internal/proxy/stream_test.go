@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/health"
+	"github.com/sanchxt/isame-lb/internal/metrics"
+)
+
+func TestHandlerBackendFailsMidStream(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+
+		// drop the connection without finishing the chunked body
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Failed to hijack backend connection: %v", err)
+			return
+		}
+		conn.Close()
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "stream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 5, Timeout: time.Minute},
+		Retry:          config.RetryConfig{Enabled: true, MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: false})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	front := httptest.NewServer(handler)
+	defer front.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(front.URL + "/events")
+	if err != nil {
+		t.Fatalf("Request failed before the stream started: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err == nil {
+		t.Errorf("Expected the client connection to be cut, read complete body %q", body)
+	}
+	if string(body) != "data: first\n\n" {
+		t.Errorf("Expected the part sent before the failure, got %q", body)
+	}
+
+	if failures := handler.circuitBreaker.GetFailures(backend.URL); failures != 1 {
+		t.Errorf("Expected one recorded failure and no retry, got %d", failures)
+	}
+}
+
+func TestHandlerClientDisconnectNotPenalized(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "slow",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, Timeout: time.Minute},
+		Retry:          config.RetryConfig{Enabled: true, MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+
+	healthChecker := health.NewChecker(config.HealthConfig{Enabled: false})
+	metricsCollector := metrics.NewCollector(config.MetricsConfig{Enabled: true})
+
+	handler, err := NewHandler(cfg, healthChecker, metricsCollector)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	front := httptest.NewServer(handler)
+	defer front.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", front.URL+"/slow", nil)
+	if _, err := http.DefaultClient.Do(req); err == nil {
+		t.Fatal("Expected the client request to be cancelled")
+	}
+
+	expected := `isame_lb_client_disconnects_total{upstream="slow"} 1`
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		w := httptest.NewRecorder()
+		metricsCollector.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		if strings.Contains(w.Body.String(), expected) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the disconnect to be counted, metrics:\n%s", w.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if failures := handler.circuitBreaker.GetFailures(backend.URL); failures != 0 {
+		t.Errorf("Client disconnect should not count against the backend, got %d failures", failures)
+	}
+	if state := handler.circuitBreaker.GetState(backend.URL); state != "closed" {
+		t.Errorf("Expected the circuit to stay closed, got %s", state)
+	}
+}
@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// tarpit holds rejected requests for delay before they are answered, which
+// slows scanners down, with at most cap(slots) held at once
+type tarpit struct {
+	delay time.Duration
+	slots chan struct{}
+}
+
+func newTarpit(cfg *config.TarpitConfig) *tarpit {
+	return &tarpit{
+		delay: cfg.Delay,
+		slots: make(chan struct{}, cfg.MaxConcurrent),
+	}
+}
+
+// hold waits out the delay, or until ctx is done, and reports whether the
+// request was held; false without waiting when the tarpit is full
+func (tp *tarpit) hold(ctx context.Context) bool {
+	select {
+	case tp.slots <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-tp.slots }()
+
+	timer := time.NewTimer(tp.delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/health"
+	"github.com/sanchxt/isame-lb/internal/metrics"
+)
+
+func TestTarpitHoldCap(t *testing.T) {
+	tp := newTarpit(&config.TarpitConfig{Delay: 100 * time.Millisecond, MaxConcurrent: 1})
+
+	held := make(chan time.Duration, 1)
+	go func() {
+		start := time.Now()
+		tp.hold(context.Background())
+		held <- time.Since(start)
+	}()
+
+	// wait for the first request to take the only slot
+	deadline := time.Now().Add(time.Second)
+	for len(tp.slots) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the first request to be held")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	if tp.hold(context.Background()) {
+		t.Error("Expected a request past max_concurrent not to be held")
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected a request past max_concurrent to return at once, took %s", elapsed)
+	}
+
+	if elapsed := <-held; elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the held request to wait out the delay, took %s", elapsed)
+	}
+	if !tp.hold(cancelledContext(t)) {
+		t.Error("Expected the slot to be free again")
+	}
+}
+
+// a context that is already cancelled, so hold returns right away
+func cancelledContext(t *testing.T) context.Context {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestHandlerRateLimitTarpit(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{Port: 8080},
+		Upstreams: []config.Upstream{{
+			Name:     "api",
+			Backends: []config.Backend{{URL: backend.URL, Weight: 1}},
+			RateLimit: &config.RateLimitConfig{
+				Enabled:       true,
+				RequestsPerIP: 1,
+				WindowSize:    30 * time.Second,
+				Tarpit:        &config.TarpitConfig{Enabled: true, Delay: 100 * time.Millisecond, MaxConcurrent: 1},
+			},
+		}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	send := func() (int, time.Duration) {
+		start := time.Now()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+		return w.Code, time.Since(start)
+	}
+
+	if code, _ := send(); code != http.StatusOK {
+		t.Fatalf("Expected the first request through, got %d", code)
+	}
+
+	type result struct {
+		code    int
+		elapsed time.Duration
+	}
+	results := make(chan result, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			code, elapsed := send()
+			results <- result{code, elapsed}
+		}()
+	}
+
+	var delayed, immediate int
+	for i := 0; i < 2; i++ {
+		res := <-results
+		if res.code != http.StatusTooManyRequests {
+			t.Errorf("Expected 429, got %d", res.code)
+		}
+		if res.elapsed >= 100*time.Millisecond {
+			delayed++
+		} else {
+			immediate++
+		}
+	}
+
+	// one slot: one request is held, the other is rejected right away
+	if delayed != 1 || immediate != 1 {
+		t.Errorf("Expected one tarpitted and one immediate rejection, got %d and %d", delayed, immediate)
+	}
+}
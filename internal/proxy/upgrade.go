@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/balancer"
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// requests asking to switch protocols, such as websocket handshakes
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgraded connections skip the cache, the request timeout and the retry
+// loop: they live as long as both sides keep them open, and once the backend
+// has switched protocols there is nothing left to retry. Returns the backend
+// the request went to, empty when none was picked.
+func (h *Handler) serveUpgrade(w http.ResponseWriter, r *http.Request, rt *routing, upstream *config.Upstream, healthStatus map[string]bool, start time.Time) string {
+	lb := rt.loadBalancers[upstream.Name]
+
+	selectedBackend, err := lb.SelectBackend(r, upstream.Backends, canaryHealth(rt.canaries[upstream.Name], upstream.Backends, healthStatus))
+	if err != nil {
+		h.writeError(w, r, rt, upstream.Name, "Service temporarily unavailable", http.StatusServiceUnavailable, start)
+		return ""
+	}
+	// an open websocket counts as a connection for as long as it lasts
+	if tracker, ok := lb.(balancer.ConnectionTracker); ok {
+		defer tracker.DecrementConnections(selectedBackend.URL)
+	}
+
+	if !h.circuitBreaker.CanAttempt(selectedBackend.URL) {
+		log.Printf("Circuit breaker open for backend %s", selectedBackend.URL)
+		h.writeError(w, r, rt, upstream.Name, "Service temporarily unavailable", http.StatusServiceUnavailable, start)
+		return selectedBackend.URL
+	}
+
+	backendURL, err := url.Parse(selectedBackend.URL)
+	if err != nil {
+		h.writeError(w, r, rt, upstream.Name, "Service temporarily unavailable", http.StatusServiceUnavailable, start)
+		return selectedBackend.URL
+	}
+
+	proxy := rt.newReverseProxy(upstream, backendURL, r)
+	if headers := upstream.ResponseHeaders; len(headers) > 0 {
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			applyHeaderRules(resp.Header, headers)
+			return nil
+		}
+	}
+
+	proxyErr := false
+	proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
+		log.Printf("Proxy error for upgrade to backend %s: %v", selectedBackend.URL, err)
+		proxyErr = true
+	}
+
+	wrappedWriter := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	proxy.ServeHTTP(wrappedWriter, r)
+
+	// says nothing about the backend, so it is neither a failure nor a success
+	if proxyErr && clientGone(r) {
+		h.circuitBreaker.RecordAbandoned(selectedBackend.URL)
+		if h.metrics != nil {
+			h.metrics.RecordClientDisconnect(upstream.Name)
+		}
+		return selectedBackend.URL
+	}
+
+	if proxyErr {
+		h.circuitBreaker.RecordFailure(selectedBackend.URL)
+		h.recordOutcome(lb, selectedBackend.URL, false)
+		if wrappedWriter.statusCode != http.StatusSwitchingProtocols {
+			h.writeError(w, r, rt, upstream.Name, "Bad gateway", http.StatusBadGateway, start)
+		}
+		return selectedBackend.URL
+	}
+
+	failed := wrappedWriter.statusCode >= 500
+	if failed {
+		h.circuitBreaker.RecordFailure(selectedBackend.URL)
+	} else {
+		h.circuitBreaker.RecordSuccess(selectedBackend.URL)
+	}
+	h.recordOutcome(lb, selectedBackend.URL, !failed)
+
+	if h.metrics != nil {
+		status := strconv.Itoa(wrappedWriter.statusCode)
+		h.metrics.RecordRouteRequest(upstream.Name, selectedBackend.URL, r.Method, status, h.metrics.Route(r.URL.Path), time.Since(start))
+	}
+	return selectedBackend.URL
+}
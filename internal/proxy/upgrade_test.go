@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/balancer"
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/health"
+	"github.com/sanchxt/isame-lb/internal/metrics"
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// writes a single unfragmented text frame, masked as clients must
+func writeFrame(w io.Writer, payload []byte, masked bool) error {
+	header := []byte{0x81, byte(len(payload))}
+	if !masked {
+		_, err := w.Write(append(header, payload...))
+		return err
+	}
+
+	mask := []byte{1, 2, 3, 4}
+	header[1] |= 0x80
+	frame := append(header, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+// reads a short (under 126 bytes) frame and returns its unmasked payload
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := int(header[1] & 0x7f)
+	var mask []byte
+	if header[1]&0x80 != 0 {
+		mask = make([]byte, 4)
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		if mask != nil {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return payload, nil
+}
+
+func newWebsocketEchoServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			http.Error(w, "expected websocket upgrade", http.StatusBadRequest)
+			return
+		}
+
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Backend failed to hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			websocketAccept(r.Header.Get("Sec-WebSocket-Key")))
+		brw.Flush()
+
+		for {
+			payload, err := readFrame(brw)
+			if err != nil {
+				return
+			}
+			if err := writeFrame(conn, append([]byte("echo: "), payload...), false); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestHandlerWebsocketPassthrough(t *testing.T) {
+	backend := newWebsocketEchoServer(t)
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Server:  config.ServerConfig{RequestTimeout: 50 * time.Millisecond},
+		Upstreams: []config.Upstream{
+			{
+				Name:      "ws",
+				Algorithm: "least_connections",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, Timeout: time.Minute},
+		Retry:          config.RetryConfig{Enabled: true, MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	front := httptest.NewServer(handler)
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(front.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	key := base64.StdEncoding.EncodeToString([]byte("isame-lb-ws-key!"))
+	fmt.Fprintf(conn, "GET /chat HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", key)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, got %d", resp.StatusCode)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != websocketAccept(key) {
+		t.Errorf("Expected Sec-WebSocket-Accept %q, got %q", websocketAccept(key), accept)
+	}
+
+	// outlives request_timeout: upgraded connections are not cut by it
+	time.Sleep(100 * time.Millisecond)
+
+	for _, message := range []string{"hello", "second frame"} {
+		if err := writeFrame(conn, []byte(message), true); err != nil {
+			t.Fatalf("Failed to send %q: %v", message, err)
+		}
+		payload, err := readFrame(reader)
+		if err != nil {
+			t.Fatalf("Failed to read echo of %q: %v", message, err)
+		}
+		if string(payload) != "echo: "+message {
+			t.Errorf("Expected %q, got %q", "echo: "+message, payload)
+		}
+	}
+
+	lc := handler.routing.Load().loadBalancers["ws"].(*balancer.LeastConnections)
+	if got := lc.GetConnections(backend.URL); got != 1 {
+		t.Errorf("Expected the open websocket to count as 1 connection, got %d", got)
+	}
+}
+
+func TestIsUpgrade(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection string
+		upgrade    string
+		want       bool
+	}{
+		{name: "websocket", connection: "Upgrade", upgrade: "websocket", want: true},
+		{name: "token list", connection: "keep-alive, upgrade", upgrade: "websocket", want: true},
+		{name: "no upgrade header", connection: "Upgrade", want: false},
+		{name: "plain keep-alive", connection: "keep-alive", upgrade: "websocket", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Connection", tt.connection)
+			if tt.upgrade != "" {
+				req.Header.Set("Upgrade", tt.upgrade)
+			}
+			if got := isUpgrade(req); got != tt.want {
+				t.Errorf("isUpgrade() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerUpgradeClientGoneIsAbandoned(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Upstreams: []config.Upstream{
+			{
+				Name:      "ws",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+			},
+		},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 3, Timeout: time.Minute},
+		Retry:          config.RetryConfig{Enabled: false},
+	}
+
+	handler, err := NewHandler(cfg, health.NewChecker(config.HealthConfig{Enabled: false}), metrics.NewCollector(config.MetricsConfig{Enabled: false}))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	handler.circuitBreaker.RecordFailure(backend.URL)
+	handler.circuitBreaker.RecordFailure(backend.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	req := httptest.NewRequest("GET", "/chat", nil).WithContext(ctx)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// a success would have reset the count, a failure tripped the circuit
+	if got := handler.circuitBreaker.GetFailures(backend.URL); got != 2 {
+		t.Errorf("Expected the abandoned upgrade to leave 2 failures, got %d", got)
+	}
+}
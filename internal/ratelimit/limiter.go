@@ -18,7 +18,8 @@ type clientLimiter struct {
 
 type RateLimiter struct {
 	config  *config.RateLimitConfig
-	clients map[string]*clientLimiter
+	clients map[string]*clientLimiter // sliding window
+	buckets map[string]*tokenBucket   // token bucket
 	mu      sync.RWMutex
 }
 
@@ -26,13 +27,21 @@ func New(cfg *config.RateLimitConfig) *RateLimiter {
 	return &RateLimiter{
 		config:  cfg,
 		clients: make(map[string]*clientLimiter),
+		buckets: make(map[string]*tokenBucket),
 	}
 }
 
+func (rl *RateLimiter) tokenBucket() bool {
+	return rl.config.Strategy == config.RateLimitTokenBucket
+}
+
 func (rl *RateLimiter) Allow(clientIP string) bool {
 	if rl.config == nil || !rl.config.Enabled {
 		return true
 	}
+	if rl.tokenBucket() {
+		return rl.allowToken(clientIP)
+	}
 
 	rl.mu.Lock()
 	client, exists := rl.clients[clientIP]
@@ -73,6 +82,9 @@ func (rl *RateLimiter) GetUsage(clientIP string) int {
 	if rl.config == nil || !rl.config.Enabled {
 		return 0
 	}
+	if rl.tokenBucket() {
+		return rl.tokensUsed(clientIP)
+	}
 
 	rl.mu.RLock()
 	client, exists := rl.clients[clientIP]
@@ -98,6 +110,53 @@ func (rl *RateLimiter) GetUsage(clientIP string) int {
 	return count
 }
 
+// NextAllowed reports the earliest time clientIP may make another request:
+// now when it is under the limit, otherwise when enough of its requests
+// have left the sliding window
+func (rl *RateLimiter) NextAllowed(clientIP string) time.Time {
+	now := time.Now()
+	if rl.config == nil || !rl.config.Enabled {
+		return now
+	}
+	if rl.tokenBucket() {
+		return rl.nextToken(clientIP)
+	}
+
+	rl.mu.RLock()
+	client, exists := rl.clients[clientIP]
+	rl.mu.RUnlock()
+
+	if !exists {
+		return now
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	windowStart := now.Add(-rl.config.WindowSize)
+
+	// requests are recorded in order, so the oldest ones expire first
+	var valid []requestRecord
+	for _, req := range client.requests {
+		if req.timestamp.After(windowStart) {
+			valid = append(valid, req)
+		}
+	}
+
+	if len(valid) < rl.config.RequestsPerIP {
+		return now
+	}
+
+	return valid[len(valid)-rl.config.RequestsPerIP].timestamp.Add(rl.config.WindowSize)
+}
+
+// Clients is the number of clients currently tracked, until Cleanup drops them
+func (rl *RateLimiter) Clients() int {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return len(rl.clients) + len(rl.buckets)
+}
+
 func (rl *RateLimiter) Cleanup() {
 	if rl.config == nil || !rl.config.Enabled {
 		return
@@ -124,4 +183,16 @@ func (rl *RateLimiter) Cleanup() {
 			delete(rl.clients, clientIP)
 		}
 	}
+
+	// a full bucket is no different from a fresh one
+	for clientIP, b := range rl.buckets {
+		b.mu.Lock()
+		b.refill(now, rl.config.Rate, rl.config.Burst)
+		full := b.tokens >= float64(rl.config.Burst)
+		b.mu.Unlock()
+
+		if full {
+			delete(rl.buckets, clientIP)
+		}
+	}
 }
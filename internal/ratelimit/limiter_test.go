@@ -209,3 +209,36 @@ func TestRateLimiterPartialWindow(t *testing.T) {
 		}
 	}
 }
+
+func TestRateLimiterNextAllowed(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		Enabled:       true,
+		RequestsPerIP: 2,
+		WindowSize:    time.Second,
+	}
+
+	rl := New(cfg)
+	clientIP := "192.168.1.1"
+
+	if next := rl.NextAllowed(clientIP); time.Until(next) > 0 {
+		t.Errorf("Unknown client should be allowed now, got %s from now", time.Until(next))
+	}
+
+	first := time.Now()
+	rl.Allow(clientIP)
+	time.Sleep(100 * time.Millisecond)
+	rl.Allow(clientIP)
+
+	// the first request leaving the window frees a slot
+	next := rl.NextAllowed(clientIP)
+	if next.Before(first.Add(cfg.WindowSize)) || next.After(first.Add(cfg.WindowSize+50*time.Millisecond)) {
+		t.Errorf("Expected the next slot when the first request expires, got %s after it", next.Sub(first))
+	}
+
+	if rl.Allow(clientIP) {
+		t.Fatal("Third request should be denied")
+	}
+	if again := rl.NextAllowed(clientIP); !again.Equal(next) {
+		t.Errorf("A denied request should not push the next slot back, got %s then %s", next, again)
+	}
+}
@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// a client's bucket: refilled at the configured rate up to burst, one token
+// per request. Constant memory per client, unlike the sliding window's
+// timestamp list, and a full bucket lets a burst through at once.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	mu      sync.Mutex
+}
+
+// caller must hold b.mu
+func (b *tokenBucket) refill(now time.Time, rate float64, burst int) {
+	elapsed := now.Sub(b.updated).Seconds()
+	b.tokens = math.Min(float64(burst), b.tokens+elapsed*rate)
+	b.updated = now
+}
+
+func (rl *RateLimiter) bucket(clientIP string, create bool) *tokenBucket {
+	if !create {
+		rl.mu.RLock()
+		defer rl.mu.RUnlock()
+		return rl.buckets[clientIP]
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	b, exists := rl.buckets[clientIP]
+	if !exists {
+		b = &tokenBucket{tokens: float64(rl.config.Burst), updated: time.Now()}
+		rl.buckets[clientIP] = b
+	}
+	return b
+}
+
+func (rl *RateLimiter) allowToken(clientIP string) bool {
+	b := rl.bucket(clientIP, true)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now(), rl.config.Rate, rl.config.Burst)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// when the bucket next holds a whole token
+func (rl *RateLimiter) nextToken(clientIP string) time.Time {
+	now := time.Now()
+	b := rl.bucket(clientIP, false)
+	if b == nil {
+		return now
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now, rl.config.Rate, rl.config.Burst)
+	if b.tokens >= 1 {
+		return now
+	}
+	wait := (1 - b.tokens) / rl.config.Rate
+	return now.Add(time.Duration(wait * float64(time.Second)))
+}
+
+// tokens spent and not yet refilled, the bucket's counterpart to requests in the window
+func (rl *RateLimiter) tokensUsed(clientIP string) int {
+	b := rl.bucket(clientIP, false)
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now(), rl.config.Rate, rl.config.Burst)
+	return int(math.Ceil(float64(rl.config.Burst) - b.tokens))
+}
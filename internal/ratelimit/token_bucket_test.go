@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestTokenBucketBurstThenRefill(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		Enabled:  true,
+		Strategy: config.RateLimitTokenBucket,
+		Rate:     20, // one token every 50ms
+		Burst:    5,
+	}
+
+	rl := New(cfg)
+	clientIP := "192.168.1.1"
+
+	for i := 0; i < 5; i++ {
+		if !rl.Allow(clientIP) {
+			t.Fatalf("Request %d of the burst should be allowed", i+1)
+		}
+	}
+	if rl.Allow(clientIP) {
+		t.Fatal("Request beyond the burst should be denied")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if !rl.Allow(clientIP) {
+		t.Error("A refilled token should allow one more request")
+	}
+	if rl.Allow(clientIP) {
+		t.Error("Only one token should have been refilled")
+	}
+}
+
+// both allow 5 requests a second, but only the bucket takes them all at once
+// and then spaces out the rest; the window blocks until the first expires
+func TestTokenBucketDiffersFromSlidingWindow(t *testing.T) {
+	window := New(&config.RateLimitConfig{
+		Enabled:       true,
+		Strategy:      config.RateLimitSlidingWindow,
+		RequestsPerIP: 5,
+		WindowSize:    time.Second,
+	})
+	bucket := New(&config.RateLimitConfig{
+		Enabled:  true,
+		Strategy: config.RateLimitTokenBucket,
+		Rate:     5,
+		Burst:    5,
+	})
+	clientIP := "192.168.1.1"
+
+	for i := 0; i < 5; i++ {
+		window.Allow(clientIP)
+		bucket.Allow(clientIP)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+
+	if window.Allow(clientIP) {
+		t.Error("Sliding window should deny until the first request leaves the window")
+	}
+	if !bucket.Allow(clientIP) {
+		t.Error("Token bucket should have refilled a token after 200ms")
+	}
+
+	if next := time.Until(window.NextAllowed(clientIP)); next < 600*time.Millisecond {
+		t.Errorf("Expected the window to stay closed for most of a second, got %s", next)
+	}
+	if next := time.Until(bucket.NextAllowed(clientIP)); next > 250*time.Millisecond {
+		t.Errorf("Expected the bucket's next token within 200ms, got %s", next)
+	}
+}
+
+func TestTokenBucketCleanup(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		Enabled:  true,
+		Strategy: config.RateLimitTokenBucket,
+		Rate:     100,
+		Burst:    2,
+	}
+
+	rl := New(cfg)
+	rl.Allow("192.168.1.1")
+	if usage := rl.GetUsage("192.168.1.1"); usage != 1 {
+		t.Errorf("Expected one token in use, got %d", usage)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	rl.Cleanup()
+
+	if _, exists := rl.buckets["192.168.1.1"]; exists {
+		t.Error("Refilled bucket should be removed by cleanup")
+	}
+}
@@ -1,6 +1,8 @@
 package retry
 
 import (
+	"context"
+	"errors"
 	"math"
 	"math/rand"
 	"time"
@@ -8,6 +10,20 @@ import (
 	"github.com/sanchxt/isame-lb/internal/config"
 )
 
+// wraps errors that another attempt cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying, e.g. once part of the response
+// has already reached the client
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
 type Retrier struct {
 	config config.RetryConfig
 	rand   *rand.Rand
@@ -35,8 +51,11 @@ func (r *Retrier) Do(fn func() error) error {
 		}
 
 		lastErr = err
+		if !r.ShouldRetry(err) {
+			return err
+		}
 
-		if attempt < maxAttempts && r.ShouldRetry(err) {
+		if attempt < maxAttempts {
 			backoff := r.calculateBackoff(attempt)
 			time.Sleep(backoff)
 		}
@@ -45,8 +64,51 @@ func (r *Retrier) Do(fn func() error) error {
 	return lastErr
 }
 
+// DoContext is like Do but gives up as soon as ctx is done, including
+// while waiting out a backoff
+func (r *Retrier) DoContext(ctx context.Context, fn func() error) error {
+	var lastErr error
+
+	maxAttempts := r.config.MaxAttempts
+	if !r.config.Enabled {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			if lastErr == nil {
+				lastErr = err
+			}
+			return lastErr
+		}
+
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		lastErr = err
+		if !r.ShouldRetry(err) {
+			return err
+		}
+
+		if attempt < maxAttempts {
+			timer := time.NewTimer(r.calculateBackoff(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return lastErr
+			case <-timer.C:
+			}
+		}
+	}
+
+	return lastErr
+}
+
 func (r *Retrier) ShouldRetry(err error) bool {
-	return err != nil
+	var permanent *permanentError
+	return err != nil && !errors.As(err, &permanent)
 }
 
 func (r *Retrier) calculateBackoff(attempt int) time.Duration {
@@ -1,6 +1,7 @@
 package retry
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -229,3 +230,65 @@ func TestRetrierShouldRetry(t *testing.T) {
 		}
 	}
 }
+
+func TestRetrierDoContextStopsOnCancel(t *testing.T) {
+	cfg := config.RetryConfig{
+		Enabled:        true,
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Second,
+	}
+
+	r := New(cfg)
+	attempts := 0
+	expectedErr := errors.New("persistent failure")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := r.DoContext(ctx, func() error {
+		attempts++
+		return expectedErr
+	})
+
+	if !errors.Is(err, expectedErr) {
+		t.Errorf("Expected error %v, got %v", expectedErr, err)
+	}
+
+	if attempts != 1 {
+		t.Errorf("Expected 1 attempt before the context expired, got %d", attempts)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected backoff to be cut short by the context, took %v", elapsed)
+	}
+}
+
+func TestRetrierPermanentError(t *testing.T) {
+	cfg := config.RetryConfig{
+		Enabled:        true,
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}
+
+	r := New(cfg)
+	cause := errors.New("response already started")
+
+	attempts := 0
+	err := r.DoContext(context.Background(), func() error {
+		attempts++
+		return Permanent(cause)
+	})
+
+	if attempts != 1 {
+		t.Errorf("Expected a permanent error to stop retries, got %d attempts", attempts)
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("Expected the original error to be returned, got %v", err)
+	}
+	if r.ShouldRetry(err) {
+		t.Error("Permanent errors should not be retryable")
+	}
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// circuit breaker state of a single backend as reported by the admin API
+type breakerStatus struct {
+	Upstream string `json:"upstream"`
+	Backend  string `json:"backend"`
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+}
+
+// body of POST /admin/circuit-breakers/force
+type forceRequest struct {
+	Backend string `json:"backend"`
+	State   string `json:"state"` // "open", "closed" or "auto" to clear the override
+}
+
+func (s *LoadBalancerServer) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.statusHandler) // also here so isame-ctl needs only the admin address
+	mux.HandleFunc("/admin/circuit-breakers", s.breakersHandler)
+	mux.HandleFunc("/admin/circuit-breakers/force", s.forceBreakerHandler)
+	mux.HandleFunc("/admin/drain", s.drainHandler)
+	mux.HandleFunc("/admin/undrain", s.undrainHandler)
+	mux.HandleFunc("/admin/explain", s.explainHandler)
+	mux.HandleFunc("/admin/backends", s.backendsHandler)
+	mux.HandleFunc("/admin/backends/{backend}/drain", s.drainBackendHandler)
+	mux.HandleFunc("/admin/backends/{backend}/enable", s.enableBackendHandler)
+	return mux
+}
+
+func (s *LoadBalancerServer) startAdmin() {
+	cfg := s.currentConfig()
+
+	addr := net.JoinHostPort(cfg.Admin.Address, strconv.Itoa(cfg.Admin.Port))
+	s.adminServer = &http.Server{
+		Addr:    addr,
+		Handler: s.adminHandler(),
+	}
+
+	log.Printf("Admin API starting on %s", addr)
+	go func() {
+		if err := s.adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Admin server error: %v", err)
+		}
+	}()
+}
+
+func (s *LoadBalancerServer) breakersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	breaker := s.proxy.CircuitBreaker()
+
+	statuses := []breakerStatus{}
+	for _, upstream := range s.currentConfig().Upstreams {
+		for _, backend := range upstream.Backends {
+			statuses = append(statuses, breakerStatus{
+				Upstream: upstream.Name,
+				Backend:  backend.URL,
+				State:    string(breaker.GetState(backend.URL)),
+				Failures: breaker.GetFailures(backend.URL),
+			})
+		}
+	}
+
+	writeAdminJSON(w, http.StatusOK, statuses)
+}
+
+func (s *LoadBalancerServer) forceBreakerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req forceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAdminError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	upstream, ok := s.backendUpstream(req.Backend)
+	if !ok {
+		writeAdminError(w, fmt.Sprintf("unknown backend %q", req.Backend), http.StatusNotFound)
+		return
+	}
+
+	breaker := s.proxy.CircuitBreaker()
+
+	switch req.State {
+	case "open":
+		breaker.ForceOpen(req.Backend)
+	case "closed":
+		breaker.ForceClosed(req.Backend)
+	case "auto":
+		breaker.ClearForce(req.Backend)
+	default:
+		writeAdminError(w, fmt.Sprintf("invalid state %q (supported: open, closed, auto)", req.State), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Admin: circuit breaker for %s set to %s", req.Backend, req.State)
+
+	writeAdminJSON(w, http.StatusOK, breakerStatus{
+		Upstream: upstream,
+		Backend:  req.Backend,
+		State:    string(breaker.GetState(req.Backend)),
+		Failures: breaker.GetFailures(req.Backend),
+	})
+}
+
+// drain state as reported by the admin API
+type drainStatus struct {
+	Draining   bool   `json:"draining"`
+	ShutdownIn string `json:"shutdown_in,omitempty"` // empty when waiting for a signal
+}
+
+func (s *LoadBalancerServer) drainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.beginDrain()
+
+	status := drainStatus{Draining: s.isDraining()}
+	if delay := s.currentConfig().Admin.DrainDelay; delay > 0 {
+		status.ShutdownIn = delay.String()
+	}
+	writeAdminJSON(w, http.StatusOK, status)
+}
+
+func (s *LoadBalancerServer) undrainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.cancelDrain(); err != nil {
+		writeAdminError(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, drainStatus{Draining: false})
+}
+
+// live state of a single backend as reported by the admin API
+type backendStatus struct {
+	Upstream    string `json:"upstream"`
+	Backend     string `json:"backend"`
+	Healthy     bool   `json:"healthy"`
+	Degraded    bool   `json:"degraded"`
+	Disabled    bool   `json:"disabled"` // weight 0 in the config
+	Drained     bool   `json:"drained"`  // taken out of rotation through the admin API
+	Connections int64  `json:"connections"`
+	Breaker     string `json:"breaker"`
+}
+
+func (s *LoadBalancerServer) backendStatus(upstream string, backend config.Backend) backendStatus {
+	return backendStatus{
+		Upstream:    upstream,
+		Backend:     backend.URL,
+		Healthy:     s.healthChecker.IsHealthy(backend.URL),
+		Degraded:    s.healthChecker.IsDegraded(backend.URL),
+		Disabled:    backend.Disabled,
+		Drained:     s.proxy.IsDrained(backend.URL),
+		Connections: s.proxy.Connections(backend.URL),
+		Breaker:     string(s.proxy.CircuitBreaker().GetState(backend.URL)),
+	}
+}
+
+func (s *LoadBalancerServer) backendsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statuses := []backendStatus{}
+	for _, upstream := range s.currentConfig().Upstreams {
+		for _, backend := range upstream.Backends {
+			statuses = append(statuses, s.backendStatus(upstream.Name, backend))
+		}
+	}
+
+	writeAdminJSON(w, http.StatusOK, statuses)
+}
+
+// stops sending new requests to the backend named by the escaped URL in the
+// path; requests already in flight finish
+func (s *LoadBalancerServer) drainBackendHandler(w http.ResponseWriter, r *http.Request) {
+	s.setBackendDrained(w, r, true)
+}
+
+func (s *LoadBalancerServer) enableBackendHandler(w http.ResponseWriter, r *http.Request) {
+	s.setBackendDrained(w, r, false)
+}
+
+func (s *LoadBalancerServer) setBackendDrained(w http.ResponseWriter, r *http.Request, drained bool) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	url := r.PathValue("backend")
+	upstream, backend, ok := s.findBackend(url)
+	if !ok {
+		writeAdminError(w, fmt.Sprintf("unknown backend %q", url), http.StatusNotFound)
+		return
+	}
+
+	if drained {
+		s.proxy.DrainBackend(url)
+	} else {
+		s.proxy.EnableBackend(url)
+	}
+
+	writeAdminJSON(w, http.StatusOK, s.backendStatus(upstream, backend))
+}
+
+// body of POST /admin/explain: the request to route
+type explainQuery struct {
+	Method   string            `json:"method"` // defaults to GET
+	Path     string            `json:"path"`   // may include a query string
+	Host     string            `json:"host"`
+	Headers  map[string]string `json:"headers"`
+	ClientIP string            `json:"client_ip"` // used by ip_hash
+}
+
+func (q explainQuery) request() (*http.Request, error) {
+	method := q.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := q.Path
+	if path == "" {
+		path = "/"
+	}
+
+	r, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Host = q.Host
+	for name, value := range q.Headers {
+		r.Header.Set(name, value)
+	}
+	if q.ClientIP != "" {
+		r.RemoteAddr = q.ClientIP
+	}
+	return r, nil
+}
+
+// routes a described request without proxying it and reports the decision
+func (s *LoadBalancerServer) explainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var query explainQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		writeAdminError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req, err := query.request()
+	if err != nil {
+		writeAdminError(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, s.proxy.Explain(req))
+}
+
+// returns the name of the upstream that owns the backend URL
+func (s *LoadBalancerServer) backendUpstream(url string) (string, bool) {
+	upstream, _, ok := s.findBackend(url)
+	return upstream, ok
+}
+
+// returns the backend with the URL and the name of its upstream
+func (s *LoadBalancerServer) findBackend(url string) (string, config.Backend, bool) {
+	for _, upstream := range s.currentConfig().Upstreams {
+		for _, backend := range upstream.Backends {
+			if backend.URL == url {
+				return upstream.Name, backend, true
+			}
+		}
+	}
+	return "", config.Backend{}, false
+}
+
+func writeAdminJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, message string, statusCode int) {
+	writeAdminJSON(w, statusCode, map[string]string{"error": message})
+}
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/circuitbreaker"
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/proxy"
+)
+
+func newAdminTestServer(t *testing.T) *LoadBalancerServer {
+	t.Helper()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Version: "1.0.0",
+		Server:  config.ServerConfig{Port: 8080},
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends: []config.Backend{
+					{URL: "http://backend1.com", Weight: 1},
+					{URL: "http://backend2.com", Weight: 1},
+				},
+			},
+		},
+		Health:  config.HealthConfig{Enabled: false},
+		Metrics: config.MetricsConfig{Enabled: false},
+		CircuitBreaker: config.CircuitBreakerConfig{
+			Enabled:          true,
+			FailureThreshold: 2,
+			Timeout:          time.Minute,
+		},
+		Admin: config.AdminConfig{Enabled: true, Address: "127.0.0.1", Port: 9091},
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return srv
+}
+
+func TestAdminForceBreakerOpen(t *testing.T) {
+	srv := newAdminTestServer(t)
+	handler := srv.adminHandler()
+
+	body := `{"backend":"http://backend1.com","state":"open"}`
+	req := httptest.NewRequest("POST", "/admin/circuit-breakers/force", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("force returned status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	breaker := srv.proxy.CircuitBreaker()
+	if breaker.CanAttempt("http://backend1.com") {
+		t.Error("Forced-open backend should reject requests")
+	}
+	if !breaker.CanAttempt("http://backend2.com") {
+		t.Error("Other backends should be unaffected")
+	}
+
+	req = httptest.NewRequest("GET", "/admin/circuit-breakers", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var statuses []breakerStatus
+	if err := json.NewDecoder(rr.Body).Decode(&statuses); err != nil {
+		t.Fatalf("Failed to decode breaker list: %v", err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("Expected 2 backends, got %d", len(statuses))
+	}
+	if statuses[0].State != string(circuitbreaker.StateForcedOpen) {
+		t.Errorf("Expected %s, got %s", circuitbreaker.StateForcedOpen, statuses[0].State)
+	}
+
+	body = `{"backend":"http://backend1.com","state":"auto"}`
+	req = httptest.NewRequest("POST", "/admin/circuit-breakers/force", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if !breaker.CanAttempt("http://backend1.com") {
+		t.Error("Backend should accept requests after the override is cleared")
+	}
+}
+
+func TestAdminForceBreakerErrors(t *testing.T) {
+	srv := newAdminTestServer(t)
+	handler := srv.adminHandler()
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{name: "unknown backend", method: "POST", body: `{"backend":"http://other.com","state":"open"}`, status: http.StatusNotFound},
+		{name: "invalid state", method: "POST", body: `{"backend":"http://backend1.com","state":"half"}`, status: http.StatusBadRequest},
+		{name: "malformed body", method: "POST", body: `{`, status: http.StatusBadRequest},
+		{name: "wrong method", method: "GET", body: "", status: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/admin/circuit-breakers/force", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rr.Code)
+			}
+		})
+	}
+}
+
+func TestAdminExplain(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb",
+		Version: "1.0.0",
+		Server:  config.ServerConfig{Port: 8080, PathNormalization: config.PathNormalizationConfig{Enabled: true}},
+		Upstreams: []config.Upstream{
+			{
+				Name:      "api",
+				Algorithm: "round_robin",
+				Match:     &config.MatchConfig{PathPrefix: "/api"},
+				Backends: []config.Backend{
+					{URL: "http://api-old.com", Disabled: true},
+					{URL: "http://api.com", Weight: 1},
+				},
+			},
+			{Name: "premium", Backends: []config.Backend{{URL: "http://premium.com", Weight: 1}}},
+			{Name: "web", Backends: []config.Backend{{URL: "http://web.com", Weight: 1}}},
+		},
+		Routes: []config.Route{
+			{Match: config.MatchConfig{Header: "X-Tier", HeaderValue: "premium"}, Upstreams: []string{"premium", "web"}},
+		},
+		Health:  config.HealthConfig{Enabled: false},
+		Metrics: config.MetricsConfig{Enabled: false},
+		Admin:   config.AdminConfig{Enabled: true, Address: "127.0.0.1", Port: 9091},
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	handler := srv.adminHandler()
+
+	tests := []struct {
+		name     string
+		body     string
+		upstream string
+		reason   string
+		eligible string
+	}{
+		{
+			name:     "route by header",
+			body:     `{"method":"GET","path":"/api/users","headers":{"X-Tier":"premium"}}`,
+			upstream: "premium",
+			reason:   "routes[0]",
+			eligible: "http://premium.com",
+		},
+		{
+			name:     "path prefix",
+			body:     `{"method":"POST","path":"/api/users?page=2"}`,
+			upstream: "api",
+			reason:   "upstream match rules",
+			eligible: "http://api.com",
+		},
+		{
+			name:     "normalized path",
+			body:     `{"path":"/static/../api/users"}`,
+			upstream: "api",
+			reason:   "upstream match rules",
+			eligible: "http://api.com",
+		},
+		{
+			name:     "catch-all",
+			body:     `{"path":"/index.html"}`,
+			upstream: "premium",
+			reason:   "first upstream without match rules",
+			eligible: "http://premium.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/explain", strings.NewReader(tt.body)))
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("explain returned status %d: %s", rr.Code, rr.Body.String())
+			}
+
+			var explanation proxy.Explanation
+			if err := json.NewDecoder(rr.Body).Decode(&explanation); err != nil {
+				t.Fatalf("Failed to decode explanation: %v", err)
+			}
+
+			if explanation.Upstream != tt.upstream || explanation.Reason != tt.reason {
+				t.Errorf("Expected upstream %s via %q, got %s via %q", tt.upstream, tt.reason, explanation.Upstream, explanation.Reason)
+			}
+			var eligible []string
+			for _, candidate := range explanation.Backends {
+				if candidate.Eligible {
+					eligible = append(eligible, candidate.URL)
+				}
+			}
+			if len(eligible) != 1 || eligible[0] != tt.eligible {
+				t.Errorf("Expected only %s eligible, got %v (error %q)", tt.eligible, eligible, explanation.Error)
+			}
+		})
+	}
+
+	// the disabled backend is listed but never eligible
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/explain", strings.NewReader(`{"path":"/api"}`)))
+	var explanation proxy.Explanation
+	if err := json.NewDecoder(rr.Body).Decode(&explanation); err != nil {
+		t.Fatalf("Failed to decode explanation: %v", err)
+	}
+	if len(explanation.Backends) != 2 || !explanation.Backends[0].Disabled || explanation.Backends[1].Disabled {
+		t.Errorf("Expected both api backends with the first disabled, got %+v", explanation.Backends)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/explain", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be rejected with 405, got %d", rr.Code)
+	}
+}
+
+func TestAdminBackendsDrainAndEnable(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.Write([]byte("one"))
+	}))
+	defer slow.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("two"))
+	}))
+	defer other.Close()
+
+	srv, err := New(&config.Config{
+		Server: config.ServerConfig{Port: 8080},
+		Upstreams: []config.Upstream{{
+			Name:      "web",
+			Algorithm: "least_connections",
+			Backends: []config.Backend{
+				{URL: slow.URL, Weight: 1},
+				{URL: other.URL, Weight: 1},
+			},
+		}},
+		Admin: config.AdminConfig{Enabled: true, Address: "127.0.0.1", Port: 9091},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	handler := srv.adminHandler()
+
+	listBackends := func() map[string]backendStatus {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/backends", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET /admin/backends returned %d: %s", rr.Code, rr.Body.String())
+		}
+		var statuses []backendStatus
+		if err := json.NewDecoder(rr.Body).Decode(&statuses); err != nil {
+			t.Fatalf("Failed to decode backend list: %v", err)
+		}
+		byURL := make(map[string]backendStatus)
+		for _, status := range statuses {
+			byURL[status.Backend] = status
+		}
+		return byURL
+	}
+
+	// a request in flight to the backend when it is drained
+	inFlight := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rr := httptest.NewRecorder()
+		srv.proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/slow", nil))
+		inFlight <- rr
+	}()
+	<-started
+
+	status := listBackends()[slow.URL]
+	if status.Upstream != "web" || !status.Healthy || status.Drained || status.Connections != 1 || status.Breaker != "closed" {
+		t.Errorf("Unexpected status before drain: %+v", status)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/backends/"+url.PathEscape(slow.URL)+"/drain", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("drain returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if !listBackends()[slow.URL].Drained {
+		t.Error("Expected the backend to be reported drained")
+	}
+
+	for i := 0; i < 4; i++ {
+		if body := proxiedBody(t, srv); body != "two" {
+			t.Fatalf("Expected no new requests to the drained backend, got %q", body)
+		}
+	}
+
+	close(release)
+	if rr := <-inFlight; rr.Code != http.StatusOK || rr.Body.String() != "one" {
+		t.Errorf("Expected the in-flight request to finish, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/backends/"+url.PathEscape(slow.URL)+"/enable", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("enable returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		seen[proxiedBody(t, srv)] = true
+	}
+	if !seen["one"] {
+		t.Error("Expected the enabled backend back in rotation")
+	}
+}
+
+func TestAdminBackendsErrors(t *testing.T) {
+	handler := newAdminTestServer(t).adminHandler()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{"unknown backend", "POST", "/admin/backends/" + url.PathEscape("http://nope.com") + "/drain", http.StatusNotFound},
+		{"drain with GET", "GET", "/admin/backends/" + url.PathEscape("http://backend1.com") + "/drain", http.StatusMethodNotAllowed},
+		{"list with POST", "POST", "/admin/backends", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+			if rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminServesStatus(t *testing.T) {
+	handler := newAdminTestServer(t).adminHandler()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/status", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"service": "test-lb"`) {
+		t.Errorf("Expected /status on the admin API, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
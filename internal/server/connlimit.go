@@ -0,0 +1,112 @@
+package server
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+
+	"github.com/sanchxt/isame-lb/internal/proxy"
+)
+
+// connLimiter caps concurrent connections per client IP across the HTTP and
+// HTTPS listeners. It is the servers' ConnState hook: a connection over the
+// limit is closed before any request on it is read. Trusted proxies carry many
+// clients on their connections and are not limited.
+type connLimiter struct {
+	limit   int
+	trusted []netip.Prefix
+
+	mu  sync.Mutex
+	ips map[string]*ipConns
+}
+
+type ipConns struct {
+	open   int
+	warned bool // rejection logged, reset once the IP has no connections left
+}
+
+func newConnLimiter(limit int, trusted []netip.Prefix) *connLimiter {
+	return &connLimiter{limit: limit, trusted: trusted, ips: make(map[string]*ipConns)}
+}
+
+func (cl *connLimiter) connState(conn net.Conn, state http.ConnState) {
+	if cl.isTrusted(connIP(conn)) {
+		return
+	}
+	switch state {
+	case http.StateNew:
+		if !cl.acquire(connIP(conn)) {
+			conn.Close()
+		}
+	case http.StateClosed, http.StateHijacked:
+		cl.release(connIP(conn))
+	}
+}
+
+// counts a new connection from ip, false when it takes ip over the limit;
+// it is counted either way and released once closed
+func (cl *connLimiter) acquire(ip string) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	entry, ok := cl.ips[ip]
+	if !ok {
+		entry = &ipConns{}
+		cl.ips[ip] = entry
+	}
+	entry.open++
+
+	if entry.open <= cl.limit {
+		return true
+	}
+	if !entry.warned {
+		entry.warned = true
+		log.Printf("Warning: client %s is over max_conns_per_ip (%d), closing its new connections", ip, cl.limit)
+	}
+	return false
+}
+
+func (cl *connLimiter) release(ip string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	entry, ok := cl.ips[ip]
+	if !ok {
+		return
+	}
+	entry.open--
+	if entry.open <= 0 {
+		delete(cl.ips, ip)
+	}
+}
+
+// open connections from ip
+func (cl *connLimiter) count(ip string) int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if entry, ok := cl.ips[ip]; ok {
+		return entry.open
+	}
+	return 0
+}
+
+func (cl *connLimiter) isTrusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return proxy.IsTrustedProxy(addr.Unmap(), cl.trusted)
+}
+
+// the peer address, or the client's from the PROXY header when
+// proxy_protocol is on, since proxyConn reports it as the remote address
+func connIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestMaxConnsPerIP(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb",
+		Version: "1.0.0",
+		Server:  config.ServerConfig{Port: 8080, MaxConnsPerIP: 2},
+		Upstreams: []config.Upstream{
+			{Name: "test-upstream", Algorithm: "round_robin", Backends: []config.Backend{{URL: "http://backend1.com", Weight: 1}}},
+		},
+		Health:  config.HealthConfig{Enabled: false},
+		Metrics: config.MetricsConfig{Enabled: false},
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+
+	ln, err := srv.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen() returned error: %v", err)
+	}
+	ts := srv.newHTTPServer(ln.Addr().String(), http.HandlerFunc(srv.healthHandler))
+	go ts.Serve(ln)
+	defer ts.Close()
+
+	// sends a request over conn, which stays open for the next one
+	get := func(conn net.Conn) error {
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Write([]byte("GET /health HTTP/1.1\r\nHost: lb\r\n\r\n")); err != nil {
+			return err
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	first, second := dial(), dial()
+	for i, conn := range []net.Conn{first, second} {
+		if err := get(conn); err != nil {
+			t.Fatalf("Expected connection %d within the limit to be served, got %v", i+1, err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := get(dial()); err == nil {
+			t.Fatal("Expected a connection over the limit to be closed")
+		}
+	}
+
+	// a closed connection frees its slot
+	first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.connLimiter.count("127.0.0.1") > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the closed connection to be released, %d still counted", srv.connLimiter.count("127.0.0.1"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := get(dial()); err != nil {
+		t.Errorf("Expected a connection to be accepted once under the limit again, got %v", err)
+	}
+	if err := get(second); err != nil {
+		t.Errorf("Expected the existing connection to keep working, got %v", err)
+	}
+}
+
+func TestMaxConnsPerIPTrustedProxy(t *testing.T) {
+	tests := []struct {
+		name          string
+		proxyProtocol bool
+		headers       []string // PROXY header per connection, empty without proxy_protocol
+		wantServed    []bool
+	}{
+		{
+			name:       "trusted peer is not limited",
+			headers:    []string{"", "", ""},
+			wantServed: []bool{true, true, true},
+		},
+		{
+			name:          "proxy protocol source is limited",
+			proxyProtocol: true,
+			headers: []string{
+				"PROXY TCP4 203.0.113.7 10.0.0.1 51234 8080\r\n",
+				"PROXY TCP4 203.0.113.7 10.0.0.1 51235 8080\r\n",
+				"PROXY TCP4 203.0.113.8 10.0.0.1 51236 8080\r\n",
+			},
+			wantServed: []bool{true, false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Service: "test-lb",
+				Version: "1.0.0",
+				Server: config.ServerConfig{
+					Port:           8080,
+					MaxConnsPerIP:  1,
+					TrustedProxies: []string{"127.0.0.1"},
+					ProxyProtocol:  tt.proxyProtocol,
+				},
+				Upstreams: []config.Upstream{
+					{Name: "test-upstream", Algorithm: "round_robin", Backends: []config.Backend{{URL: "http://backend1.com", Weight: 1}}},
+				},
+				Health:  config.HealthConfig{Enabled: false},
+				Metrics: config.MetricsConfig{Enabled: false},
+			}
+
+			srv, err := New(cfg)
+			if err != nil {
+				t.Fatalf("New() returned error: %v", err)
+			}
+
+			ln, err := srv.listen("127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("listen() returned error: %v", err)
+			}
+			ts := srv.newHTTPServer(ln.Addr().String(), http.HandlerFunc(srv.healthHandler))
+			go ts.Serve(ln)
+			defer ts.Close()
+
+			// connections stay open so each one counts against the next
+			for i, header := range tt.headers {
+				conn, err := net.Dial("tcp", ln.Addr().String())
+				if err != nil {
+					t.Fatalf("Dial() error = %v", err)
+				}
+				defer conn.Close()
+
+				conn.SetDeadline(time.Now().Add(2 * time.Second))
+				_, err = conn.Write([]byte(header + "GET /health HTTP/1.1\r\nHost: lb\r\n\r\n"))
+				if err == nil {
+					var resp *http.Response
+					resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+					if err == nil {
+						resp.Body.Close()
+					}
+				}
+				if served := err == nil; served != tt.wantServed[i] {
+					t.Errorf("connection %d served = %v, want %v (err %v)", i+1, served, tt.wantServed[i], err)
+				}
+			}
+		})
+	}
+}
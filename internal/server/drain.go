@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+var errShutdownStarted = errors.New("shutdown already started")
+
+// beginDrain fails /health so orchestrators stop routing here, closes client
+// connections after their current response and, when a delay is configured,
+// starts shutdown once it elapses
+func (s *LoadBalancerServer) beginDrain() {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	if s.draining || s.shutdownStarted {
+		return
+	}
+
+	s.draining = true
+	s.drain()
+
+	if delay := s.currentConfig().Admin.DrainDelay; delay > 0 {
+		s.drainTimer = time.AfterFunc(delay, s.triggerShutdown)
+		log.Printf("Draining, shutdown in %s", delay)
+	} else {
+		log.Println("Draining, waiting for shutdown signal")
+	}
+}
+
+// cancelDrain undoes beginDrain unless shutdown is already underway
+func (s *LoadBalancerServer) cancelDrain() error {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	if s.shutdownStarted {
+		return errShutdownStarted
+	}
+	if !s.draining {
+		return nil
+	}
+
+	if s.drainTimer != nil {
+		s.drainTimer.Stop()
+		s.drainTimer = nil
+	}
+	s.draining = false
+
+	for _, srv := range []*http.Server{s.httpServer, s.httpsServer} {
+		if srv != nil {
+			srv.SetKeepAlivesEnabled(!s.listener.DisableKeepAlives)
+		}
+	}
+
+	log.Println("Drain cancelled")
+	return nil
+}
+
+func (s *LoadBalancerServer) triggerShutdown() {
+	s.drainMu.Lock()
+	if !s.draining || s.shutdownStarted {
+		s.drainMu.Unlock()
+		return
+	}
+	s.shutdownStarted = true
+	s.drainMu.Unlock()
+
+	select {
+	case s.shutdownCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *LoadBalancerServer) isDraining() bool {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	return s.draining
+}
+
+// how often waitForConnections checks the in-flight count
+const connectionPollInterval = 50 * time.Millisecond
+
+// waitForConnections blocks until no request is in flight, grace has passed
+// or ctx is done. http.Server.Shutdown already waits for plain requests but
+// not for hijacked ones such as WebSockets, so this gives those a chance to
+// finish too.
+func (s *LoadBalancerServer) waitForConnections(ctx context.Context, grace time.Duration) {
+	active := s.proxy.ActiveConnections()
+	if active == 0 {
+		return
+	}
+	log.Printf("Waiting up to %s for %d in-flight requests", grace, active)
+
+	timeout := time.NewTimer(grace)
+	defer timeout.Stop()
+	ticker := time.NewTicker(connectionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if s.proxy.ActiveConnections() == 0 {
+				log.Println("All in-flight requests finished")
+				return
+			}
+		case <-timeout.C:
+			log.Printf("Drain grace elapsed with %d requests still in flight", s.proxy.ActiveConnections())
+			return
+		case <-ctx.Done():
+			log.Printf("Shutdown timed out with %d requests still in flight", s.proxy.ActiveConnections())
+			return
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestAdminDrainAndUndrain(t *testing.T) {
+	srv := newAdminTestServer(t)
+	admin := srv.adminHandler()
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.newHTTPServer("", http.HandlerFunc(srv.healthHandler))
+	ts.Start()
+	defer ts.Close()
+	srv.httpServer = ts.Config
+
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/drain", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("drain returned status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	resp, err := http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatalf("Request during drain failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected /health to return 503 while draining, got %d", resp.StatusCode)
+	}
+	if !resp.Close {
+		t.Error("Responses during drain should carry Connection: close")
+	}
+
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/undrain", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("undrain returned status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	resp, err = http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatalf("Request after undrain failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected /health to return 200 after undrain, got %d", resp.StatusCode)
+	}
+	if resp.Close {
+		t.Error("Keep-alives should be re-enabled after undrain")
+	}
+}
+
+func TestDrainDelayStartsShutdown(t *testing.T) {
+	srv := newAdminTestServer(t)
+	srv.config.Admin.DrainDelay = 20 * time.Millisecond
+
+	srv.beginDrain()
+
+	select {
+	case <-srv.shutdownCh:
+	case <-time.After(time.Second):
+		t.Fatal("Expected shutdown to be triggered after the drain delay")
+	}
+
+	rr := httptest.NewRecorder()
+	srv.adminHandler().ServeHTTP(rr, httptest.NewRequest("POST", "/admin/undrain", nil))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected undrain after shutdown started to return 409, got %d", rr.Code)
+	}
+}
+
+func TestUndrainCancelsPendingShutdown(t *testing.T) {
+	srv := newAdminTestServer(t)
+	srv.config.Admin.DrainDelay = 50 * time.Millisecond
+
+	srv.beginDrain()
+	if err := srv.cancelDrain(); err != nil {
+		t.Fatalf("cancelDrain() error = %v", err)
+	}
+
+	select {
+	case <-srv.shutdownCh:
+		t.Fatal("Shutdown should not start after the drain was cancelled")
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	if srv.isDraining() {
+		t.Error("Server should not be draining after cancel")
+	}
+}
+
+// a server whose only backend holds each request until release is closed
+func newDrainGraceServer(t *testing.T, grace time.Duration, algorithm string) (*LoadBalancerServer, chan struct{}, chan struct{}) {
+	t.Helper()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("done"))
+	}))
+	t.Cleanup(backend.Close)
+
+	srv, err := New(&config.Config{
+		Server: config.ServerConfig{Port: 8080, DrainGrace: grace},
+		Upstreams: []config.Upstream{{
+			Name:      "web",
+			Algorithm: algorithm,
+			Backends:  []config.Backend{{URL: backend.URL, Weight: 1}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return srv, started, release
+}
+
+func TestShutdownWaitsForInFlightRequests(t *testing.T) {
+	// round_robin keeps no connection counts of its own
+	for _, algorithm := range []string{"least_connections", "round_robin"} {
+		t.Run(algorithm, func(t *testing.T) {
+			srv, started, release := newDrainGraceServer(t, 5*time.Second, algorithm)
+
+			// served outside the HTTP server, like a hijacked connection that
+			// http.Server.Shutdown does not wait for
+			finished := make(chan int, 1)
+			go func() {
+				rr := httptest.NewRecorder()
+				srv.proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+				finished <- rr.Code
+			}()
+			<-started
+
+			time.AfterFunc(100*time.Millisecond, func() { close(release) })
+
+			begin := time.Now()
+			srv.Shutdown(context.Background())
+
+			select {
+			case code := <-finished:
+				if code != http.StatusOK {
+					t.Errorf("Expected the in-flight request to succeed, got %d", code)
+				}
+			default:
+				t.Fatal("Expected Shutdown to wait for the in-flight request")
+			}
+			if elapsed := time.Since(begin); elapsed > 2*time.Second {
+				t.Errorf("Expected Shutdown to return once the request finished, took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestShutdownDrainGraceExpires(t *testing.T) {
+	srv, started, release := newDrainGraceServer(t, 100*time.Millisecond, "least_connections")
+	defer close(release)
+
+	go srv.proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	begin := time.Now()
+	srv.Shutdown(context.Background())
+	if elapsed := time.Since(begin); elapsed < 100*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected Shutdown to give up after the drain grace, took %s", elapsed)
+	}
+	if active := srv.proxy.ActiveConnections(); active != 1 {
+		t.Errorf("Expected the request to still be in flight, got %d", active)
+	}
+}
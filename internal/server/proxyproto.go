@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long a new connection gets to send its PROXY header before it is closed
+const proxyHeaderTimeout = 5 * time.Second
+
+// longest v1 header the spec allows, CRLF included
+const proxyV1MaxLen = 107
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener reads the PROXY protocol header an L4 load balancer puts in
+// front of every connection, so the connection's RemoteAddr is the client
+// rather than the balancer. Headers are read off the accept loop, one
+// goroutine per connection, so a slow sender can't hold up the others;
+// connections without a valid header are closed.
+type proxyListener struct {
+	net.Listener
+	timeout time.Duration
+
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newProxyListener(inner net.Listener, timeout time.Duration) *proxyListener {
+	l := &proxyListener{
+		Listener: inner,
+		timeout:  timeout,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *proxyListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.handshake(conn)
+	}
+}
+
+func (l *proxyListener) handshake(conn net.Conn) {
+	pc, err := readProxyHeader(conn, l.timeout)
+	if err != nil {
+		log.Printf("Warning: closing connection from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	select {
+	case l.conns <- pc:
+	case <-l.done:
+		pc.Close()
+	}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *proxyListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// proxyConn is a connection past its PROXY header: reads continue from
+// whatever was buffered behind the header and RemoteAddr is the client
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *proxyConn) RemoteAddr() net.Addr { return c.remote }
+
+// reads a v1 or v2 header off conn within timeout; LOCAL and UNKNOWN
+// headers, as health checks from the balancer send, keep conn's own address
+func readProxyHeader(conn net.Conn, timeout time.Duration) (*proxyConn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PROXY header: %w", err)
+	}
+
+	var remote net.Addr
+	switch {
+	case bytes.Equal(sig, proxyV2Signature):
+		remote, err = readProxyV2(r)
+	case bytes.HasPrefix(sig, []byte("PROXY ")):
+		remote, err = readProxyV1(r)
+	default:
+		err = errors.New("missing PROXY protocol header")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		remote = conn.RemoteAddr()
+	}
+	return &proxyConn{Conn: conn, r: r, remote: remote}, nil
+}
+
+// "PROXY TCP4 <src> <dst> <src port> <dst port>\r\n" or "PROXY UNKNOWN ...\r\n"
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == proxyV1MaxLen {
+			return nil, errors.New("PROXY v1 header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PROXY header: %w", err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", line)
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("invalid PROXY v1 source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY v1 source port %q", fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// 12-byte signature, version/command, family/protocol, 2-byte length, then
+// the addresses and any TLVs, which are skipped
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read PROXY header: %w", err)
+	}
+	verCmd, family := header[12], header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("failed to read PROXY header: %w", err)
+	}
+
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", verCmd>>4)
+	}
+	switch verCmd & 0x0f {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", verCmd&0x0f)
+	}
+
+	switch family >> 4 {
+	case 0x1: // AF_INET: src, dst, src port, dst port
+		if len(payload) < 12 {
+			return nil, errors.New("short PROXY v2 IPv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, errors.New("short PROXY v2 IPv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	default: // unspecified or unix sockets carry no usable client address
+		return nil, nil
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestProxyProtocolV1SetsClientAddress(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb",
+		Version: "1.0.0",
+		Server:  config.ServerConfig{Port: 8080, ProxyProtocol: true},
+		Upstreams: []config.Upstream{
+			{Name: "test-upstream", Algorithm: "round_robin", Backends: []config.Backend{{URL: "http://backend1.com", Weight: 1}}},
+		},
+		Health:  config.HealthConfig{Enabled: false},
+		Metrics: config.MetricsConfig{Enabled: false},
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+
+	ln, err := srv.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen() returned error: %v", err)
+	}
+	ts := srv.newHTTPServer(ln.Addr().String(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	}))
+	go ts.Serve(ln)
+	defer ts.Close()
+
+	send := func(preamble string) (string, error) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+		if _, err := io.WriteString(conn, preamble+"GET / HTTP/1.1\r\nHost: lb\r\n\r\n"); err != nil {
+			return "", err
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	got, err := send("PROXY TCP4 203.0.113.7 10.0.0.1 51234 8080\r\n")
+	if err != nil {
+		t.Fatalf("request with PROXY header failed: %v", err)
+	}
+	if got != "203.0.113.7:51234" {
+		t.Errorf("RemoteAddr = %q, want the PROXY header's source 203.0.113.7:51234", got)
+	}
+
+	got, err = send("PROXY TCP6 2001:db8::1 2001:db8::2 40000 443\r\n")
+	if err != nil {
+		t.Fatalf("request with TCP6 PROXY header failed: %v", err)
+	}
+	if got != "[2001:db8::1]:40000" {
+		t.Errorf("RemoteAddr = %q, want [2001:db8::1]:40000", got)
+	}
+
+	if _, err := send(""); err == nil {
+		t.Error("connection without a PROXY header was served, want it closed")
+	}
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(cmd, family byte, payload []byte) string {
+		header := append([]byte{}, proxyV2Signature...)
+		header = append(header, 0x20|cmd, family, 0, 0)
+		binary.BigEndian.PutUint16(header[14:], uint16(len(payload)))
+		return string(append(header, payload...))
+	}
+	ipv4 := []byte{198, 51, 100, 9, 10, 0, 0, 1, 0xc3, 0x50, 0x1f, 0x90} // 198.51.100.9:50000 -> 10.0.0.1:8080
+	ipv4WithTLV := append(append([]byte{}, ipv4...), 0x04, 0x00, 0x01, 0x00)
+
+	tests := []struct {
+		name   string
+		header string
+		want   string // client address, "local" for the connection's own
+		hasErr bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.0.2.10 10.0.0.1 1234 80\r\n", "192.0.2.10:1234", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "local", false},
+		{"v1 family mismatch", "PROXY TCP4 2001:db8::1 10.0.0.1 1234 80\r\n", "", true},
+		{"v1 bad port", "PROXY TCP4 192.0.2.10 10.0.0.1 70000 80\r\n", "", true},
+		{"v1 missing fields", "PROXY TCP4 192.0.2.10\r\n", "", true},
+		{"v2 ipv4", v2(0x1, 0x11, ipv4), "198.51.100.9:50000", false},
+		{"v2 ipv4 with tlv", v2(0x1, 0x11, ipv4WithTLV), "198.51.100.9:50000", false},
+		{"v2 local", v2(0x0, 0x00, nil), "local", false},
+		{"v2 short address block", v2(0x1, 0x11, ipv4[:6]), "", true},
+		{"no header", "GET / HTTP/1.1\r\nHost: lb\r\n\r\n", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go func() {
+				io.WriteString(client, tt.header+"after")
+			}()
+
+			pc, err := readProxyHeader(server, time.Second)
+			if (err != nil) != tt.hasErr {
+				t.Fatalf("readProxyHeader() error = %v, hasErr %v", err, tt.hasErr)
+			}
+			if err != nil {
+				return
+			}
+
+			want := tt.want
+			if want == "local" {
+				want = server.RemoteAddr().String()
+			}
+			if got := pc.RemoteAddr().String(); got != want {
+				t.Errorf("RemoteAddr() = %q, want %q", got, want)
+			}
+
+			rest := make([]byte, len("after"))
+			if _, err := io.ReadFull(pc, rest); err != nil || string(rest) != "after" {
+				t.Errorf("data after the header = %q, %v; want %q", rest, err, "after")
+			}
+		})
+	}
+}
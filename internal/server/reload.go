@@ -0,0 +1,134 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+	"github.com/sanchxt/isame-lb/internal/metrics"
+)
+
+// SetConfigPath enables SIGHUP reloads from the given file
+func (s *LoadBalancerServer) SetConfigPath(path string) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	s.configPath = path
+}
+
+func (s *LoadBalancerServer) currentConfig() *config.Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.config
+}
+
+// reload re-reads the config file and swaps in its upstreams; on any error
+// the running config stays in place
+func (s *LoadBalancerServer) reload() (err error) {
+	defer func() { s.recordReload(err) }()
+
+	s.configMu.RLock()
+	path := s.configPath
+	s.configMu.RUnlock()
+
+	if path == "" {
+		log.Println("Warning: received SIGHUP but no config file to reload")
+		return errors.New("no config file to reload from")
+	}
+
+	log.Printf("Reloading configuration from %s", path)
+
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		log.Printf("Config reload failed, keeping current config: %v", err)
+		return err
+	}
+
+	if err := s.applyConfig(cfg); err != nil {
+		log.Printf("Config reload failed, keeping current config: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// keeps the outcome of the latest reload for /status and the internal metrics
+func (s *LoadBalancerServer) recordReload(err error) {
+	outcome := &metrics.ReloadOutcome{At: time.Now(), Success: err == nil}
+	if err != nil {
+		outcome.Error = err.Error()
+	}
+
+	s.reloadMu.Lock()
+	s.lastReload = outcome
+	s.reloadMu.Unlock()
+}
+
+// applyConfig switches the proxy and health checks to cfg without touching
+// the listeners; in-flight requests finish on the config they started with
+func (s *LoadBalancerServer) applyConfig(cfg *config.Config) error {
+	previous := s.currentConfig()
+
+	if err := s.proxy.Reload(cfg); err != nil {
+		return fmt.Errorf("failed to apply config: %w", err)
+	}
+
+	s.healthChecker.Update(cfg.Upstreams)
+
+	s.configMu.Lock()
+	s.config = cfg
+	s.configMu.Unlock()
+
+	for _, section := range restartOnlyChanges(previous, cfg) {
+		log.Printf("Warning: %s changed, restart to apply it", section)
+	}
+
+	log.Printf("Configuration reloaded: %d upstreams", len(cfg.Upstreams))
+	return nil
+}
+
+// sections read once at startup, which a reload cannot change
+func restartOnlyChanges(previous, next *config.Config) []string {
+	var changed []string
+
+	if previous.Server.Port != next.Server.Port || previous.Server.HTTPSPort != next.Server.HTTPSPort {
+		changed = append(changed, "server ports")
+	}
+
+	sections := []struct {
+		name     string
+		old, new any
+	}{
+		{"tls", previous.TLS, next.TLS},
+		{"health", previous.Health, next.Health},
+		{"metrics", previous.Metrics, next.Metrics},
+		{"circuit_breaker", previous.CircuitBreaker, next.CircuitBreaker},
+		{"admin", previous.Admin, next.Admin},
+		{"logging.capture", previous.Logging.Capture, next.Logging.Capture},
+		{"logging.access_log", previous.Logging.AccessLog, next.Logging.AccessLog},
+		{"server timeouts", serverTimeouts(previous), serverTimeouts(next)},
+		{"server.max_header_bytes", previous.Server.MaxHeaderBytes, next.Server.MaxHeaderBytes},
+		{"server.disable_keep_alives", previous.Server.DisableKeepAlives, next.Server.DisableKeepAlives},
+		{"server.tcp_keep_alive", previous.Server.TCPKeepAlive, next.Server.TCPKeepAlive},
+		{"server.proxy_protocol", previous.Server.ProxyProtocol, next.Server.ProxyProtocol},
+		{"server.max_conns_per_ip", previous.Server.MaxConnsPerIP, next.Server.MaxConnsPerIP},
+	}
+	for _, section := range sections {
+		if !reflect.DeepEqual(section.old, section.new) {
+			changed = append(changed, section.name)
+		}
+	}
+
+	// forwarded headers follow a reload, the connection limit's exemptions do not
+	if next.Server.MaxConnsPerIP > 0 && !reflect.DeepEqual(previous.Server.TrustedProxies, next.Server.TrustedProxies) {
+		changed = append(changed, "server.trusted_proxies for max_conns_per_ip")
+	}
+
+	return changed
+}
+
+func serverTimeouts(cfg *config.Config) [3]time.Duration {
+	return [3]time.Duration{cfg.Server.ReadTimeout, cfg.Server.WriteTimeout, cfg.Server.IdleTimeout}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func writeReloadConfig(t *testing.T, path, backendURL string) {
+	t.Helper()
+
+	content := fmt.Sprintf(`server:
+  port: 8080
+upstreams:
+  - name: "web"
+    backends:
+      - url: %q
+health:
+  enabled: false
+metrics:
+  enabled: false
+`, backendURL)
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+}
+
+func newReloadTestServer(t *testing.T, path string) *LoadBalancerServer {
+	t.Helper()
+
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	srv.SetConfigPath(path)
+	return srv
+}
+
+func proxiedBody(t *testing.T, srv *LoadBalancerServer) string {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	srv.proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	body, _ := io.ReadAll(rr.Body)
+	return string(body)
+}
+
+func TestReloadSwapsUpstreams(t *testing.T) {
+	oldBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("old"))
+	}))
+	defer oldBackend.Close()
+	newBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	}))
+	defer newBackend.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeReloadConfig(t, path, oldBackend.URL)
+	srv := newReloadTestServer(t, path)
+
+	if body := proxiedBody(t, srv); body != "old" {
+		t.Fatalf("Expected old backend before reload, got %q", body)
+	}
+
+	writeReloadConfig(t, path, newBackend.URL)
+	if err := srv.reload(); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+
+	if body := proxiedBody(t, srv); body != "new" {
+		t.Errorf("Expected new backend after reload, got %q", body)
+	}
+	if got := srv.currentConfig().Upstreams[0].Backends[0].URL; got != newBackend.URL {
+		t.Errorf("Expected server config to be swapped, got backend %s", got)
+	}
+}
+
+func TestReloadKeepsConfigOnError(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("old"))
+	}))
+	defer backend.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeReloadConfig(t, path, backend.URL)
+	srv := newReloadTestServer(t, path)
+	previous := srv.currentConfig()
+
+	// an unsupported scheme fails validation
+	writeReloadConfig(t, path, "ftp://backend")
+	if err := srv.reload(); err == nil {
+		t.Fatal("Expected reload of an invalid config to fail")
+	}
+
+	if srv.currentConfig() != previous {
+		t.Error("Expected the previous config to stay in place")
+	}
+	if body := proxiedBody(t, srv); body != "old" {
+		t.Errorf("Expected proxy to keep serving the old backend, got %q", body)
+	}
+}
+
+func TestRestartOnlyChanges(t *testing.T) {
+	previous := &config.Config{Server: config.ServerConfig{Port: 8080}}
+	next := &config.Config{
+		Server:  config.ServerConfig{Port: 8081, RequestTimeout: 1},
+		Metrics: config.MetricsConfig{Enabled: true},
+	}
+
+	changed := restartOnlyChanges(previous, next)
+	if len(changed) != 2 || changed[0] != "server ports" || changed[1] != "metrics" {
+		t.Errorf("Expected server ports and metrics to be reported, got %v", changed)
+	}
+
+	next = &config.Config{
+		Server: config.ServerConfig{
+			Port:           8080,
+			IdleTimeout:    time.Minute,
+			TCPKeepAlive:   time.Second,
+			ProxyProtocol:  true,
+			MaxConnsPerIP:  10,
+			TrustedProxies: []string{"10.0.0.0/8"},
+		},
+		Logging: config.LoggingConfig{AccessLog: config.AccessLogConfig{Enabled: true}},
+	}
+
+	changed = restartOnlyChanges(previous, next)
+	expected := []string{
+		"logging.access_log",
+		"server timeouts",
+		"server.tcp_keep_alive",
+		"server.proxy_protocol",
+		"server.max_conns_per_ip",
+		"server.trusted_proxies for max_conns_per_ip",
+	}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("restartOnlyChanges() = %v, want %v", changed, expected)
+	}
+}
+
+func TestInternalStatsTrackReloads(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeReloadConfig(t, path, backend.URL)
+	srv := newReloadTestServer(t, path)
+
+	scrape := func() string {
+		rr := httptest.NewRecorder()
+		srv.metrics.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return rr.Body.String()
+	}
+
+	content := scrape()
+	for _, expected := range []string{
+		"isame_lb_internal_goroutines ",
+		"isame_lb_internal_health_check_goroutines 0",
+		"isame_lb_internal_last_reload_timestamp_seconds 0",
+		"isame_lb_internal_last_reload_success 0",
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected %q in metrics before any reload:\n%s", expected, content)
+		}
+	}
+
+	writeReloadConfig(t, path, "ftp://backend")
+	if err := srv.reload(); err == nil {
+		t.Fatal("Expected reload of an invalid config to fail")
+	}
+	stats := srv.internalStats()
+	if stats.LastReload == nil || stats.LastReload.Success || stats.LastReload.Error == "" {
+		t.Errorf("Expected a failed reload with its error, got %+v", stats.LastReload)
+	}
+
+	writeReloadConfig(t, path, backend.URL)
+	if err := srv.reload(); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+
+	content = scrape()
+	if !strings.Contains(content, "isame_lb_internal_last_reload_success 1") {
+		t.Errorf("Expected the successful reload in metrics:\n%s", content)
+	}
+	if strings.Contains(content, "isame_lb_internal_last_reload_timestamp_seconds 0\n") {
+		t.Errorf("Expected the reload time in metrics:\n%s", content)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.statusHandler(rr, httptest.NewRequest("GET", "/status", nil))
+	var status struct {
+		Internal struct {
+			Goroutines int `json:"goroutines"`
+			LastReload *struct {
+				Success bool `json:"success"`
+			} `json:"last_reload"`
+		} `json:"internal"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to parse /status: %v\n%s", err, rr.Body.String())
+	}
+	if status.Internal.Goroutines == 0 || status.Internal.LastReload == nil || !status.Internal.LastReload.Success {
+		t.Errorf("Expected an internal section with the last reload in /status, got %s", rr.Body.String())
+	}
+}
@@ -2,11 +2,20 @@ package server
 
 import (
 	"context"
+	cryptotls "crypto/tls"
+	"encoding/json"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -15,35 +24,83 @@ import (
 	"github.com/sanchxt/isame-lb/internal/metrics"
 	"github.com/sanchxt/isame-lb/internal/proxy"
 	"github.com/sanchxt/isame-lb/internal/tls"
+	"github.com/sanchxt/isame-lb/internal/transport"
 )
 
 type LoadBalancerServer struct {
-	config        *config.Config
+	configMu      sync.RWMutex
+	config        *config.Config      // replaced on reload, read through currentConfig
+	listener      config.ServerConfig // server settings the listeners use, fixed until restart
+	configPath    string              // file reloaded on SIGHUP, empty disables reloads
 	httpServer    *http.Server
 	httpsServer   *http.Server
+	adminServer   *http.Server
 	healthChecker *health.Checker
 	metrics       *metrics.Collector
 	proxy         *proxy.Handler
+	capture       *proxy.Capture   // nil unless debug capture is enabled
+	accessLog     *proxy.AccessLog // nil unless access logging is enabled
 	tlsManager    *tls.Manager
+	connLimiter   *connLimiter // nil unless max_conns_per_ip is set
+
+	reloadMu   sync.Mutex
+	lastReload *metrics.ReloadOutcome // nil until the first reload
+
+	// admin triggered drain; shutdownCh starts shutdown without a signal
+	drainMu         sync.Mutex
+	draining        bool
+	drainTimer      *time.Timer
+	shutdownStarted bool
+	shutdownCh      chan struct{}
 }
 
 func New(cfg *config.Config) (*LoadBalancerServer, error) {
 	healthChecker := health.NewChecker(cfg.Health)
+	healthChecker.SetTransport(transport.New(cfg.Transport))
 
 	metricsCollector := metrics.NewCollector(cfg.Metrics)
+	healthChecker.SetMetrics(metricsCollector)
 
 	proxyHandler, err := proxy.NewHandler(cfg, healthChecker, metricsCollector)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create proxy handler: %w", err)
 	}
+	metricsCollector.SetRequestRates(proxyHandler.RequestRates)
+
+	var accessLog *proxy.AccessLog
+	if cfg.Logging.AccessLog.Enabled {
+		accessLog, err = proxy.NewAccessLog(cfg.Logging.AccessLog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up access log: %w", err)
+		}
+		proxyHandler.SetAccessLog(accessLog)
+	}
+
+	var capture *proxy.Capture
+	if cfg.Logging.Capture.Enabled {
+		capture, err = proxy.NewCapture(cfg.Logging.Capture, proxyHandler)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up request capture: %w", err)
+		}
+		log.Printf("Warning: request capture enabled, writing to %s", cfg.Logging.Capture.File)
+	}
 
 	var tlsMgr *tls.Manager
 	if cfg.TLS.Enabled {
 		tlsMgr, err = tls.NewManager(tls.Config{
 			CertPath:     cfg.TLS.CertFile,
 			KeyPath:      cfg.TLS.KeyFile,
+			CertPEM:      []byte(cfg.TLS.CertPEM),
+			KeyPEM:       []byte(cfg.TLS.KeyPEM),
 			MinVersion:   cfg.TLS.MinVersion,
 			CipherSuites: cfg.TLS.CipherSuites,
+			OCSPStapling: cfg.TLS.OCSPStapling,
+
+			DisableSessionTickets: cfg.TLS.DisableSessionTickets,
+			SessionTicketRotation: cfg.TLS.SessionTicketRotation,
+
+			ClientAuth:   cfg.TLS.ClientAuth,
+			ClientCAPath: cfg.TLS.ClientCAFile,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to initialize TLS: %w", err)
@@ -54,67 +111,102 @@ func New(cfg *config.Config) (*LoadBalancerServer, error) {
 		}
 	}
 
-	return &LoadBalancerServer{
+	var limiter *connLimiter
+	if cfg.Server.MaxConnsPerIP > 0 {
+		trusted, err := proxy.ParseTrustedProxies(cfg.Server.TrustedProxies)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trusted proxies: %w", err)
+		}
+		limiter = newConnLimiter(cfg.Server.MaxConnsPerIP, trusted)
+	}
+
+	s := &LoadBalancerServer{
 		config:        cfg,
+		listener:      cfg.Server,
 		healthChecker: healthChecker,
 		metrics:       metricsCollector,
 		proxy:         proxyHandler,
+		capture:       capture,
+		accessLog:     accessLog,
 		tlsManager:    tlsMgr,
-	}, nil
+		connLimiter:   limiter,
+		shutdownCh:    make(chan struct{}, 1),
+	}
+	metricsCollector.SetInternalStats(s.internalStats)
+
+	return s, nil
 }
 
 func (s *LoadBalancerServer) Start() error {
-	log.Printf("Starting %s v%s", s.config.Service, s.config.Version)
+	cfg := s.currentConfig()
+
+	log.Printf("Starting %s v%s", cfg.Service, cfg.Version)
+	s.logStartupSummary()
+
+	if cfg.Server.RequireBackendsOnStart {
+		ctx, cancel := context.WithTimeout(context.Background(), startupResolveTimeout)
+		err := s.checkBackendsResolve(ctx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("startup check failed: %w", err)
+		}
+	}
 
 	if err := s.metrics.Start(); err != nil {
 		return fmt.Errorf("failed to start metrics server: %w", err)
 	}
 
-	s.healthChecker.Start(s.config.Upstreams)
+	s.healthChecker.Start(cfg.Upstreams)
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", s.healthHandler)
-	mux.HandleFunc("/status", s.statusHandler)
-	mux.Handle("/", s.proxy)
+	if cfg.Admin.Enabled {
+		s.startAdmin()
+	}
+
+	var handler http.Handler = s.proxy
+	if s.capture != nil {
+		handler = s.capture
+	}
+	mux := s.newMux(handler)
 
-	httpAddr := fmt.Sprintf(":%d", s.config.Server.Port)
-	s.httpServer = &http.Server{
-		Addr:           httpAddr,
-		Handler:        mux,
-		ReadTimeout:    s.config.Server.ReadTimeout,
-		WriteTimeout:   s.config.Server.WriteTimeout,
-		IdleTimeout:    s.config.Server.IdleTimeout,
-		MaxHeaderBytes: s.config.Server.MaxHeaderBytes,
+	httpMux := mux
+	if cfg.TLS.Enabled && cfg.TLS.RedirectHTTP {
+		httpMux = s.newMux(http.HandlerFunc(s.redirectToHTTPS))
+	}
+
+	httpAddr := fmt.Sprintf(":%d", cfg.Server.Port)
+	s.httpServer = s.newHTTPServer(httpAddr, httpMux)
+
+	httpListener, err := s.listen(httpAddr)
+	if err != nil {
+		return err
 	}
 
 	log.Printf("HTTP server starting on %s", httpAddr)
 	go func() {
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := s.httpServer.Serve(httpListener); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("HTTP server error: %v", err)
 		}
 	}()
 
-	if s.config.TLS.Enabled && s.tlsManager != nil {
-		httpsAddr := fmt.Sprintf(":%d", s.config.Server.HTTPSPort)
+	if cfg.TLS.Enabled && s.tlsManager != nil {
+		httpsAddr := fmt.Sprintf(":%d", cfg.Server.HTTPSPort)
 
 		tlsConfig, err := s.tlsManager.GetTLSConfig()
 		if err != nil {
 			return fmt.Errorf("failed to get TLS config: %w", err)
 		}
 
-		s.httpsServer = &http.Server{
-			Addr:           httpsAddr,
-			Handler:        mux,
-			TLSConfig:      tlsConfig,
-			ReadTimeout:    s.config.Server.ReadTimeout,
-			WriteTimeout:   s.config.Server.WriteTimeout,
-			IdleTimeout:    s.config.Server.IdleTimeout,
-			MaxHeaderBytes: s.config.Server.MaxHeaderBytes,
+		s.httpsServer = s.newHTTPServer(httpsAddr, mux)
+		s.httpsServer.TLSConfig = tlsConfig
+
+		httpsListener, err := s.listen(httpsAddr)
+		if err != nil {
+			return err
 		}
 
 		log.Printf("HTTPS server starting on %s", httpsAddr)
 		go func() {
-			if err := s.httpsServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			if err := serveTLS(s.httpsServer, httpsListener); err != nil && err != http.ErrServerClosed {
 				log.Fatalf("HTTPS server error: %v", err)
 			}
 		}()
@@ -125,9 +217,97 @@ func (s *LoadBalancerServer) Start() error {
 	return nil
 }
 
+// the listener's routes: health and status endpoints, everything else to handler
+func (s *LoadBalancerServer) newMux(handler http.Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.healthHandler)
+	mux.HandleFunc("/status", s.statusHandler)
+	mux.HandleFunc("/ready", s.readyHandler)
+	mux.Handle("/", handler)
+	return mux
+}
+
+// sends plain HTTP requests to the same host, path and query over HTTPS
+func (s *LoadBalancerServer) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := strings.Trim(r.Host, "[]")
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	if port := s.listener.HTTPSPort; port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+	http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+}
+
+// how inbound listeners are opened: client connections get TCP keep-alive
+// probes every server.tcp_keep_alive, so idle ones survive NATs and
+// firewalls and dead peers are noticed
+func (s *LoadBalancerServer) listenConfig() net.ListenConfig {
+	return net.ListenConfig{KeepAlive: s.listener.TCPKeepAlive}
+}
+
+func (s *LoadBalancerServer) listen(addr string) (net.Listener, error) {
+	lc := s.listenConfig()
+	ln, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	if s.listener.ProxyProtocol {
+		return newProxyListener(ln, proxyHeaderTimeout), nil
+	}
+	return ln, nil
+}
+
+// serves srv over TLS with srv.TLSConfig itself. ServeTLS would serve a
+// clone, which never sees the session ticket keys the manager rotates.
+func serveTLS(srv *http.Server, ln net.Listener) error {
+	return srv.Serve(cryptotls.NewListener(ln, srv.TLSConfig))
+}
+
+// builds an inbound server with the configured timeouts and keep-alive setting
+func (s *LoadBalancerServer) newHTTPServer(addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:           addr,
+		Handler:        handler,
+		ReadTimeout:    s.listener.ReadTimeout,
+		WriteTimeout:   s.listener.WriteTimeout,
+		IdleTimeout:    s.listener.IdleTimeout,
+		MaxHeaderBytes: s.listener.MaxHeaderBytes,
+	}
+	srv.SetKeepAlivesEnabled(!s.listener.DisableKeepAlives)
+	if s.connLimiter != nil {
+		srv.ConnState = s.connLimiter.connState
+	}
+
+	return srv
+}
+
+// drain tells clients to reconnect elsewhere: responses still in flight go
+// out with Connection: close and no further requests reuse the connection
+func (s *LoadBalancerServer) drain() {
+	for _, srv := range []*http.Server{s.httpServer, s.httpsServer} {
+		if srv != nil {
+			srv.SetKeepAlivesEnabled(false)
+		}
+	}
+}
+
 func (s *LoadBalancerServer) Shutdown(ctx context.Context) error {
 	log.Println("Shutting down load balancer...")
 
+	s.drainMu.Lock()
+	s.shutdownStarted = true
+	if s.drainTimer != nil {
+		s.drainTimer.Stop()
+	}
+	s.drainMu.Unlock()
+
+	s.drain()
+
 	if s.httpServer != nil {
 		log.Println("Shutting down HTTP server...")
 		if err := s.httpServer.Shutdown(ctx); err != nil {
@@ -142,7 +322,46 @@ func (s *LoadBalancerServer) Shutdown(ctx context.Context) error {
 		}
 	}
 
+	// listeners are closed, so the count can only go down from here
+	if grace := s.currentConfig().Server.DrainGrace; grace > 0 {
+		s.waitForConnections(ctx, grace)
+	}
+
+	if s.adminServer != nil {
+		log.Println("Shutting down admin server...")
+		if err := s.adminServer.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down admin server: %v", err)
+		}
+	}
+
+	if s.capture != nil {
+		if err := s.capture.Close(); err != nil {
+			log.Printf("Error closing capture file: %v", err)
+		}
+	}
+
+	if s.accessLog != nil {
+		if err := s.accessLog.Close(); err != nil {
+			log.Printf("Error closing access log: %v", err)
+		}
+	}
+
 	s.healthChecker.Stop()
+	s.proxy.Stop()
+
+	if s.tlsManager != nil {
+		s.tlsManager.Stop()
+	}
+
+	// metrics go last so a final scrape can still see the shutdown counters
+	if cfg := s.currentConfig(); cfg.Metrics.ShutdownGrace > 0 && cfg.Metrics.Enabled {
+		grace := cfg.Metrics.ShutdownGrace
+		log.Printf("Keeping metrics server up for %s", grace)
+		select {
+		case <-time.After(grace):
+		case <-ctx.Done():
+		}
+	}
 
 	if err := s.metrics.Stop(); err != nil {
 		log.Printf("Error stopping metrics server: %v", err)
@@ -154,10 +373,23 @@ func (s *LoadBalancerServer) Shutdown(ctx context.Context) error {
 
 func (s *LoadBalancerServer) waitForShutdown() {
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-	<-sigCh
-	log.Println("Received shutdown signal")
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+loop:
+	for {
+		select {
+		case sig := <-sigCh:
+			if sig == syscall.SIGHUP {
+				s.reload()
+				continue
+			}
+			log.Println("Received shutdown signal")
+			break loop
+		case <-s.shutdownCh:
+			log.Println("Drain delay elapsed, shutting down")
+			break loop
+		}
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -166,26 +398,99 @@ func (s *LoadBalancerServer) waitForShutdown() {
 }
 
 func (s *LoadBalancerServer) healthHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := s.currentConfig()
+
 	w.Header().Set("Content-Type", "application/json")
+	if s.isDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"draining","service":"` + cfg.Service + `"}`))
+		return
+	}
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"ok","service":"` + s.config.Service + `"}`))
+	w.Write([]byte(`{"status":"ok","service":"` + cfg.Service + `"}`))
+}
+
+// healthy and total backends of one upstream, for /ready
+type upstreamReadiness struct {
+	Healthy int `json:"healthy"`
+	Total   int `json:"total"`
+}
+
+// readyHandler is the readiness probe: 200 while at least one enabled
+// backend is healthy and the server is not draining, 503 otherwise. Unlike
+// /health it says whether requests can actually be served.
+func (s *LoadBalancerServer) readyHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := s.currentConfig()
+
+	upstreams := make(map[string]upstreamReadiness, len(cfg.Upstreams))
+	healthy := 0
+	for _, upstream := range cfg.Upstreams {
+		var counts upstreamReadiness
+		for _, backend := range upstream.Backends {
+			if backend.Disabled {
+				continue
+			}
+			counts.Total++
+			if s.healthChecker.IsHealthy(backend.URL) {
+				counts.Healthy++
+			}
+		}
+		upstreams[upstream.Name] = counts
+		healthy += counts.Healthy
+	}
+
+	status, code := "ready", http.StatusOK
+	switch {
+	case s.isDraining():
+		status, code = "draining", http.StatusServiceUnavailable
+	case healthy == 0:
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+
+	body, _ := json.Marshal(struct {
+		Status    string                       `json:"status"`
+		Service   string                       `json:"service"`
+		Upstreams map[string]upstreamReadiness `json:"upstreams"`
+	}{status, cfg.Service, upstreams})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
 }
 
 func (s *LoadBalancerServer) statusHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := s.currentConfig()
+
 	statuses := s.healthChecker.GetAllStatuses()
 
 	healthyCount := 0
+	degradedCount := 0
+	disabledCount := 0
 	totalCount := 0
 
-	for _, upstream := range s.config.Upstreams {
+	for _, upstream := range cfg.Upstreams {
 		for _, backend := range upstream.Backends {
 			totalCount++
+			if backend.Disabled {
+				disabledCount++
+			}
 			if healthy, exists := statuses[backend.URL]; exists && healthy {
 				healthyCount++
+				if s.healthChecker.IsDegraded(backend.URL) {
+					degradedCount++
+				}
 			}
 		}
 	}
 
+	// two decimals is plenty for a glance at the load
+	rates := s.proxy.RequestRates()
+	for upstream, rate := range rates {
+		rates[upstream] = math.Round(rate*100) / 100
+	}
+	ratesJSON, _ := json.Marshal(rates)
+	internalJSON, _ := json.Marshal(s.internalStats())
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
@@ -196,20 +501,52 @@ func (s *LoadBalancerServer) statusHandler(w http.ResponseWriter, r *http.Reques
 		"backends": {
 			"total": %d,
 			"healthy": %d,
-			"unhealthy": %d
+			"degraded": %d,
+			"unhealthy": %d,
+			"disabled": %d
 		},
+		"requests_per_second": %s,
 		"health_checks_enabled": %t,
-		"metrics_enabled": %t
+		"metrics_enabled": %t,
+		"internal": %s
 	}`,
-		s.config.Service,
-		s.config.Version,
-		len(s.config.Upstreams),
+		cfg.Service,
+		cfg.Version,
+		len(cfg.Upstreams),
 		totalCount,
 		healthyCount,
+		degradedCount,
 		totalCount-healthyCount,
-		s.config.Health.Enabled,
-		s.config.Metrics.Enabled,
+		disabledCount,
+		ratesJSON,
+		cfg.Health.Enabled,
+		cfg.Metrics.Enabled,
+		internalJSON,
 	)
 
 	w.Write([]byte(status))
 }
+
+// the load balancer's own state, for /status and the internal metrics
+func (s *LoadBalancerServer) internalStats() metrics.InternalStats {
+	lockWait := make(map[string]float64)
+	for upstream, wait := range s.proxy.BalancerLockWait() {
+		lockWait[upstream] = wait.Seconds()
+	}
+
+	s.reloadMu.Lock()
+	var lastReload *metrics.ReloadOutcome
+	if s.lastReload != nil {
+		outcome := *s.lastReload
+		lastReload = &outcome
+	}
+	s.reloadMu.Unlock()
+
+	return metrics.InternalStats{
+		Goroutines:            runtime.NumGoroutine(),
+		HealthCheckGoroutines: s.healthChecker.ActiveChecks(),
+		RateLimitClients:      s.proxy.RateLimitClients(),
+		BalancerLockWait:      lockWait,
+		LastReload:            lastReload,
+	}
+}
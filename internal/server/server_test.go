@@ -1,10 +1,19 @@
 package server
 
 import (
+	"context"
+	cryptotls "crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/sanchxt/isame-lb/internal/config"
 )
@@ -167,7 +176,10 @@ func TestLoadBalancerServer_statusHandler(t *testing.T) {
 		`"upstreams": 1`,
 		`"total": 2`,
 		`"healthy": 0`,
+		`"degraded": 0`,
 		`"unhealthy": 2`,
+		`"disabled": 0`,
+		`"requests_per_second": {"test-upstream":0}`,
 		`"health_checks_enabled": false`,
 		`"metrics_enabled": false`,
 	}
@@ -179,6 +191,176 @@ func TestLoadBalancerServer_statusHandler(t *testing.T) {
 	}
 }
 
+func newReadyTestServer(t *testing.T) *LoadBalancerServer {
+	t.Helper()
+
+	cfg := &config.Config{
+		Service: "test-lb",
+		Version: "1.0.0",
+		Server:  config.ServerConfig{Port: 8080},
+		Upstreams: []config.Upstream{
+			{
+				Name:      "api",
+				Algorithm: "round_robin",
+				Backends: []config.Backend{
+					{URL: "http://backend1.com", Weight: 1},
+					{URL: "http://backend2.com", Weight: 1},
+					{URL: "http://backend3.com", Disabled: true},
+				},
+			},
+			{
+				Name:      "web",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: "http://backend4.com", Weight: 1}},
+			},
+		},
+		// passive ejection lets the tests mark backends unhealthy without probes
+		Health: config.HealthConfig{
+			Enabled: false,
+			Passive: config.PassiveHealthConfig{Enabled: true, FailureThreshold: 1, CoolDown: time.Minute},
+		},
+		Metrics: config.MetricsConfig{Enabled: false},
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return srv
+}
+
+func TestLoadBalancerServer_readyHandler(t *testing.T) {
+	srv := newReadyTestServer(t)
+	srv.healthChecker.UpdateFromProxy("http://backend1.com", false)
+
+	rr := httptest.NewRecorder()
+	srv.readyHandler(rr, httptest.NewRequest("GET", "/ready", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("readyHandler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("readyHandler returned wrong content type: got %v want %v", contentType, "application/json")
+	}
+
+	var body struct {
+		Status    string                       `json:"status"`
+		Upstreams map[string]upstreamReadiness `json:"upstreams"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response %q: %v", rr.Body.String(), err)
+	}
+
+	if body.Status != "ready" {
+		t.Errorf("Expected status ready, got %q", body.Status)
+	}
+	if got := body.Upstreams["api"]; got != (upstreamReadiness{Healthy: 1, Total: 2}) {
+		t.Errorf("Expected api to have 1 of 2 enabled backends healthy, got %+v", got)
+	}
+	if got := body.Upstreams["web"]; got != (upstreamReadiness{Healthy: 1, Total: 1}) {
+		t.Errorf("Expected web to have 1 of 1 backends healthy, got %+v", got)
+	}
+}
+
+func TestLoadBalancerServer_readyHandlerNotReady(t *testing.T) {
+	srv := newReadyTestServer(t)
+	for _, backend := range []string{"http://backend1.com", "http://backend2.com", "http://backend4.com"} {
+		srv.healthChecker.UpdateFromProxy(backend, false)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.readyHandler(rr, httptest.NewRequest("GET", "/ready", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyHandler returned wrong status code: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	for _, field := range []string{`"status":"not_ready"`, `"api":{"healthy":0,"total":2}`, `"web":{"healthy":0,"total":1}`} {
+		if !strings.Contains(rr.Body.String(), field) {
+			t.Errorf("readyHandler response should contain %s: got %v", field, rr.Body.String())
+		}
+	}
+
+	// liveness does not depend on the backends
+	rr = httptest.NewRecorder()
+	srv.healthHandler(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("healthHandler should stay up without healthy backends, got %v", rr.Code)
+	}
+}
+
+func TestDisabledBackendGetsNoTraffic(t *testing.T) {
+	hits := make(map[string]int)
+	var mu sync.Mutex
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hits[name]++
+			mu.Unlock()
+		}))
+	}
+	active := newBackend("active")
+	defer active.Close()
+	disabled := newBackend("disabled")
+	defer disabled.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := fmt.Sprintf(`server:
+  port: 8080
+upstreams:
+  - name: "web"
+    algorithm: "round_robin"
+    backends:
+      - url: %q
+      - url: %q
+        weight: 0
+health:
+  enabled: true
+  interval: "1m"
+metrics:
+  enabled: false
+`, active.URL, disabled.URL)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if backends := cfg.Upstreams[0].Backends; backends[0].Weight != 1 || !backends[1].Disabled {
+		t.Fatalf("Expected unset weight to default to 1 and weight 0 to disable, got %+v", backends)
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	srv.healthChecker.Start(cfg.Upstreams)
+	defer srv.healthChecker.Stop()
+
+	for i := 0; i < 10; i++ {
+		rr := httptest.NewRecorder()
+		srv.proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rr.Code)
+		}
+	}
+
+	if hits["disabled"] != 0 || hits["active"] != 10 {
+		t.Errorf("Expected all traffic on the active backend, got %v", hits)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.statusHandler(rr, httptest.NewRequest("GET", "/status", nil))
+	body := rr.Body.String()
+
+	for _, field := range []string{`"healthy": 2`, `"disabled": 1`} {
+		if !strings.Contains(body, field) {
+			t.Errorf("Expected status to contain %s, got %s", field, body)
+		}
+	}
+}
+
 func TestNewWithTLS(t *testing.T) {
 	cfg := &config.Config{
 		Service: "test-lb-tls",
@@ -344,3 +526,336 @@ func TestNewWithTLSCustomMinVersion(t *testing.T) {
 		t.Error("New() with TLS should initialize TLS manager")
 	}
 }
+
+func TestShutdownKeepsMetricsUpAfterProxy(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb",
+		Version: "1.0.0",
+		Server:  config.ServerConfig{Port: 8080},
+		Upstreams: []config.Upstream{
+			{
+				Name:      "test-upstream",
+				Algorithm: "round_robin",
+				Backends:  []config.Backend{{URL: "http://backend1.com", Weight: 1}},
+			},
+		},
+		Health: config.HealthConfig{Enabled: false},
+		Metrics: config.MetricsConfig{
+			Enabled:       true,
+			Port:          9095,
+			Path:          "/metrics",
+			ShutdownGrace: 500 * time.Millisecond,
+		},
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	if err := srv.metrics.Start(); err != nil {
+		t.Fatalf("Failed to start metrics: %v", err)
+	}
+
+	proxyServer := httptest.NewServer(http.HandlerFunc(srv.healthHandler))
+	srv.httpServer = proxyServer.Config
+	defer proxyServer.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		srv.Shutdown(context.Background())
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := http.Get(proxyServer.URL + "/health"); err == nil {
+		t.Error("Proxy should no longer accept requests once shutdown has begun")
+	}
+
+	resp, err := http.Get("http://localhost:9095/metrics")
+	if err != nil {
+		t.Fatalf("Metrics should still be scrapable during the grace period: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected metrics status 200, got %d", resp.StatusCode)
+	}
+
+	<-done
+
+	if _, err := http.Get("http://localhost:9095/metrics"); err == nil {
+		t.Error("Metrics server should be stopped once shutdown completes")
+	}
+}
+
+func TestDrainDisablesKeepAlives(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.Metrics.Enabled = false
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.newHTTPServer("", http.HandlerFunc(srv.healthHandler))
+	ts.Start()
+	defer ts.Close()
+	srv.httpServer = ts.Config
+
+	resp, err := http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Close {
+		t.Error("Keep-alive should be enabled before draining")
+	}
+
+	srv.drain()
+
+	resp, err = http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatalf("Request during drain failed: %v", err)
+	}
+	resp.Body.Close()
+	if !resp.Close {
+		t.Error("Responses during drain should carry Connection: close")
+	}
+}
+
+func TestDisableKeepAlivesConfig(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.Metrics.Enabled = false
+	cfg.Server.DisableKeepAlives = true
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.newHTTPServer("", http.HandlerFunc(srv.healthHandler))
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if !resp.Close {
+		t.Error("Expected Connection: close when keep-alives are disabled")
+	}
+}
+
+func TestRedirectHTTPToHTTPS(t *testing.T) {
+	tests := []struct {
+		name      string
+		httpsPort int
+		target    string
+		host      string
+		want      string
+	}{
+		{name: "custom port", httpsPort: 8443, target: "/api/items?page=2&sort=name", host: "example.com:8080", want: "https://example.com:8443/api/items?page=2&sort=name"},
+		{name: "default port", httpsPort: 443, target: "/login", host: "example.com", want: "https://example.com/login"},
+		{name: "ipv6 host", httpsPort: 8443, target: "/", host: "[::1]:8080", want: "https://[::1]:8443/"},
+		{name: "encoded path", httpsPort: 443, target: "/files/a%2Fb", host: "example.com", want: "https://example.com/files/a%2Fb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &LoadBalancerServer{
+				config: &config.Config{
+					Server: config.ServerConfig{HTTPSPort: tt.httpsPort},
+					TLS:    config.TLSConfig{Enabled: true, RedirectHTTP: true},
+				},
+				listener: config.ServerConfig{HTTPSPort: tt.httpsPort},
+			}
+
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.Host = tt.host
+			rr := httptest.NewRecorder()
+			srv.newMux(http.HandlerFunc(srv.redirectToHTTPS)).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusMovedPermanently {
+				t.Errorf("Expected status 301, got %d", rr.Code)
+			}
+			if location := rr.Header().Get("Location"); location != tt.want {
+				t.Errorf("Expected Location %q, got %q", tt.want, location)
+			}
+		})
+	}
+}
+
+func TestRedirectHTTPKeepsStartupPort(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb",
+		Version: "1.0.0",
+		Server:  config.ServerConfig{Port: 8080, HTTPSPort: 8443},
+		Upstreams: []config.Upstream{
+			{Name: "test-upstream", Algorithm: "round_robin", Backends: []config.Backend{{URL: "http://backend1.com", Weight: 1}}},
+		},
+		Health:  config.HealthConfig{Enabled: false},
+		Metrics: config.MetricsConfig{Enabled: false},
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+
+	// the HTTPS listener stays on 8443 until a restart
+	next := *cfg
+	next.Server.HTTPSPort = 9443
+	if err := srv.applyConfig(&next); err != nil {
+		t.Fatalf("applyConfig() error = %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/login", nil)
+	req.Host = "example.com"
+	rr := httptest.NewRecorder()
+	srv.redirectToHTTPS(rr, req)
+	if location := rr.Header().Get("Location"); location != "https://example.com:8443/login" {
+		t.Errorf("Expected the redirect to keep the listening port, got %q", location)
+	}
+}
+
+func TestRedirectHTTPExemptsHealth(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb",
+		Version: "1.0.0",
+		Server:  config.ServerConfig{Port: 8080, HTTPSPort: 8443},
+		Upstreams: []config.Upstream{
+			{Name: "test-upstream", Algorithm: "round_robin", Backends: []config.Backend{{URL: "http://backend1.com", Weight: 1}}},
+		},
+		Health:  config.HealthConfig{Enabled: false},
+		Metrics: config.MetricsConfig{Enabled: false},
+		TLS: config.TLSConfig{
+			Enabled:      true,
+			CertFile:     "../tls/testdata/server.crt",
+			KeyFile:      "../tls/testdata/server.key",
+			RedirectHTTP: true,
+		},
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+
+	mux := srv.newMux(http.HandlerFunc(srv.redirectToHTTPS))
+	for _, path := range []string{"/health", "/status", "/ready"} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected %s to be served over HTTP, got %d", path, rr.Code)
+		}
+	}
+}
+
+func TestListenerKeepAlive(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb",
+		Version: "1.0.0",
+		Server:  config.ServerConfig{Port: 8080, TCPKeepAlive: 45 * time.Second},
+		Upstreams: []config.Upstream{
+			{Name: "test-upstream", Algorithm: "round_robin", Backends: []config.Backend{{URL: "http://backend1.com", Weight: 1}}},
+		},
+		Health:  config.HealthConfig{Enabled: false},
+		Metrics: config.MetricsConfig{Enabled: false},
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+
+	if lc := srv.listenConfig(); lc.KeepAlive != 45*time.Second {
+		t.Errorf("Expected listener keep-alive period 45s, got %s", lc.KeepAlive)
+	}
+
+	ln, err := srv.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen() returned error: %v", err)
+	}
+	defer ln.Close()
+
+	ts := &http.Server{Handler: http.HandlerFunc(srv.healthHandler)}
+	go ts.Serve(ln)
+	defer ts.Close()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/health")
+	if err != nil {
+		t.Fatalf("Request through keep-alive listener failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 through keep-alive listener, got %d", resp.StatusCode)
+	}
+}
+
+func TestServeTLSRotatesSessionTickets(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb-tls",
+		Version: "1.0.0",
+		Server:  config.ServerConfig{Port: 8080, HTTPSPort: 8443},
+		Upstreams: []config.Upstream{
+			{Name: "test-upstream", Algorithm: "round_robin", Backends: []config.Backend{{URL: "http://backend1.com", Weight: 1}}},
+		},
+		Health:  config.HealthConfig{Enabled: false},
+		Metrics: config.MetricsConfig{Enabled: false},
+		TLS: config.TLSConfig{
+			Enabled:               true,
+			CertFile:              "../tls/testdata/server.crt",
+			KeyFile:               "../tls/testdata/server.key",
+			SessionTicketRotation: 200 * time.Millisecond,
+		},
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer srv.tlsManager.Stop()
+
+	tlsConfig, err := srv.tlsManager.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	httpsServer := srv.newHTTPServer(ln.Addr().String(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	httpsServer.TLSConfig = tlsConfig
+	go serveTLS(httpsServer, ln)
+	defer httpsServer.Close()
+
+	cache := cryptotls.NewLRUClientSessionCache(1)
+	resumed := func() bool {
+		t.Helper()
+		conn, err := cryptotls.Dial("tcp", ln.Addr().String(), &cryptotls.Config{
+			InsecureSkipVerify: true,
+			ClientSessionCache: cache,
+			MaxVersion:         cryptotls.VersionTLS12,
+		})
+		if err != nil {
+			t.Fatalf("tls.Dial() error = %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().DidResume
+	}
+
+	resumed()
+	if !resumed() {
+		t.Fatal("Second handshake should resume with the first one's ticket")
+	}
+
+	// the listener has to see the rotated keys, so the old ticket ages out
+	time.Sleep(time.Second)
+	if resumed() {
+		t.Error("Ticket should not resume once its key has been rotated out")
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/transport"
+)
+
+// max time spent resolving backend hosts before startup gives up
+const startupResolveTimeout = 5 * time.Second
+
+var errNoBackendsResolve = errors.New("no backend host resolves")
+
+// one line per section, logged before the listeners start
+func (s *LoadBalancerServer) startupSummary() []string {
+	cfg := s.currentConfig()
+
+	https := "disabled"
+	if cfg.TLS.Enabled {
+		https = fmt.Sprintf(":%d", cfg.Server.HTTPSPort)
+	}
+
+	lines := []string{
+		fmt.Sprintf("server: http :%d, https %s, request_timeout %s", cfg.Server.Port, https, cfg.Server.RequestTimeout),
+	}
+
+	for _, upstream := range cfg.Upstreams {
+		line := fmt.Sprintf("upstream %q: algorithm %s, %d backends", upstream.Name, upstream.Algorithm, len(upstream.Backends))
+		if upstream.Match != nil {
+			line += fmt.Sprintf(", match host=%q path_prefix=%q", upstream.Match.Host, upstream.Match.PathPrefix)
+		}
+		if upstream.Name == cfg.Server.DefaultUpstream {
+			line += ", default"
+		}
+		lines = append(lines, line)
+	}
+
+	if cfg.Health.Enabled {
+		lines = append(lines, fmt.Sprintf("health checks: %s %s every %s, timeout %s", cfg.Health.Method, cfg.Health.Path, cfg.Health.Interval, cfg.Health.Timeout))
+	} else {
+		lines = append(lines, "health checks: disabled")
+	}
+
+	if cfg.Metrics.Enabled {
+		lines = append(lines, fmt.Sprintf("metrics: :%d%s", cfg.Metrics.Port, cfg.Metrics.Path))
+	} else {
+		lines = append(lines, "metrics: disabled")
+	}
+
+	if cfg.Admin.Enabled {
+		lines = append(lines, fmt.Sprintf("admin: %s", net.JoinHostPort(cfg.Admin.Address, strconv.Itoa(cfg.Admin.Port))))
+	}
+
+	if defaults := cfg.AppliedDefaults(); len(defaults) > 0 {
+		lines = append(lines, "defaults applied: "+strings.Join(defaults, ", "))
+	}
+
+	return lines
+}
+
+func (s *LoadBalancerServer) logStartupSummary() {
+	log.Println("Startup summary:")
+	for _, line := range s.startupSummary() {
+		log.Printf("  %s", line)
+	}
+}
+
+// checkBackendsResolve fails when not a single backend host resolves, which
+// almost always means a typo or a broken DNS setup rather than an outage
+func (s *LoadBalancerServer) checkBackendsResolve(ctx context.Context) error {
+	cfg := s.currentConfig()
+
+	resolver := transport.Resolver(cfg.Transport)
+
+	total := 0
+	for _, upstream := range cfg.Upstreams {
+		for _, backend := range upstream.Backends {
+			total++
+
+			parsed, err := url.Parse(backend.URL)
+			if err != nil {
+				continue
+			}
+
+			host := parsed.Hostname()
+			if _, exists := cfg.Transport.Hosts[host]; exists || net.ParseIP(host) != nil {
+				return nil
+			}
+
+			if _, err = resolver.LookupHost(ctx, host); err == nil {
+				return nil
+			}
+			log.Printf("Warning: backend %s does not resolve: %v", backend.URL, err)
+		}
+	}
+
+	if total == 0 {
+		return nil
+	}
+	return errNoBackendsResolve
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestStartupSummary(t *testing.T) {
+	cfg := &config.Config{
+		Service: "test-lb",
+		Server:  config.ServerConfig{Port: 8080, DefaultUpstream: "web"},
+		Upstreams: []config.Upstream{
+			{
+				Name: "web",
+				Backends: []config.Backend{
+					{URL: "http://localhost:3000"},
+					{URL: "http://localhost:3001"},
+				},
+			},
+			{
+				Name:      "api",
+				Algorithm: "least_connections",
+				Backends:  []config.Backend{{URL: "http://localhost:4000", Weight: 1}},
+				Match:     &config.MatchConfig{PathPrefix: "/api"},
+			},
+		},
+		Health:  config.HealthConfig{Enabled: true},
+		Metrics: config.MetricsConfig{Enabled: false},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	summary := strings.Join(srv.startupSummary(), "\n")
+
+	expected := []string{
+		"server: http :8080, https disabled",
+		`upstream "web": algorithm round_robin, 2 backends, default`,
+		`upstream "api": algorithm least_connections, 1 backends, match host="" path_prefix="/api"`,
+		"health checks: GET /health every 30s, timeout 5s",
+		"metrics: disabled",
+		"upstreams[web].algorithm=round_robin",
+		"server.read_timeout=15s",
+		"health.path=/health",
+	}
+	for _, want := range expected {
+		if !strings.Contains(summary, want) {
+			t.Errorf("Expected summary to contain %q, got:\n%s", want, summary)
+		}
+	}
+
+	if strings.Contains(summary, "upstreams[api].algorithm") {
+		t.Errorf("Explicit algorithm should not be reported as a default:\n%s", summary)
+	}
+}
+
+func TestCheckBackendsResolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		backends []config.Backend
+		hosts    map[string]string
+		hasErr   bool
+	}{
+		{name: "ip literal", backends: []config.Backend{{URL: "http://127.0.0.1:3000"}}},
+		{name: "static host override", backends: []config.Backend{{URL: "http://api.internal:3000"}}, hosts: map[string]string{"api.internal": "10.0.0.1"}},
+		{name: "one of several resolves", backends: []config.Backend{{URL: "http://nothing.invalid:3000"}, {URL: "http://127.0.0.1:3001"}}},
+		{name: "none resolve", backends: []config.Backend{{URL: "http://nothing.invalid:3000"}}, hasErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &LoadBalancerServer{config: &config.Config{
+				Upstreams: []config.Upstream{{Name: "test", Backends: tt.backends}},
+				Transport: config.TransportConfig{Hosts: tt.hosts},
+			}}
+
+			err := srv.checkBackendsResolve(context.Background())
+			if (err != nil) != tt.hasErr {
+				t.Errorf("checkBackendsResolve() error = %v, hasErr %v", err, tt.hasErr)
+			}
+		})
+	}
+}
+
+func TestCheckBackendsResolveLogsLookupError(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	srv := &LoadBalancerServer{config: &config.Config{
+		Upstreams: []config.Upstream{{Name: "test", Backends: []config.Backend{{URL: "http://nothing.invalid:3000"}}}},
+	}}
+	srv.checkBackendsResolve(context.Background())
+
+	if out := buf.String(); !strings.Contains(out, "does not resolve") || strings.Contains(out, "<nil>") {
+		t.Errorf("Expected the warning to carry the lookup error, got %q", out)
+	}
+}
@@ -1,35 +1,67 @@
 package tls
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
 )
 
 // Manager handles TLS certificate loading and configuration
 type Manager struct {
 	certPath     string
 	keyPath      string
+	certPEM      []byte
+	keyPEM       []byte
 	minVersion   uint16
 	cipherSuites []uint16
+	ocspStapling bool
+
+	clientAuth   tls.ClientAuthType
+	clientCAPath string
+
+	ocspMu      sync.Mutex
+	ocspLeaf    []byte       // leaf the stapler was set up for, nil before the first
+	ocspStapler *ocspStapler // nil when that leaf can't be stapled
+
+	disableSessionTickets bool
+	tickets               *ticketRotator // nil unless ticket keys are rotated by the manager
+
+	mu          sync.RWMutex
+	cert        *tls.Certificate // last certificate loaded, served on handshakes
+	certModTime time.Time
+	keyModTime  time.Time
 }
 
 // Config holds TLS manager configuration
 type Config struct {
 	CertPath     string
 	KeyPath      string
+	CertPEM      []byte   // Inline certificate, used instead of CertPath when set
+	KeyPEM       []byte   // Inline private key, used instead of KeyPath when set
 	MinVersion   string   // "1.2", "1.3"
 	CipherSuites []string // Optional custom cipher suites
+	OCSPStapling bool     // Fetch and staple OCSP responses for the certificate
+
+	DisableSessionTickets bool          // Turn off TLS session ticket resumption
+	SessionTicketRotation time.Duration // Rotate in-memory ticket keys on this interval; 0 keeps Go's default keys
+
+	ClientAuth   string // "none", "request", "require", "verify_if_given", "require_and_verify"
+	ClientCAPath string // CAs client certificates are verified against
 }
 
 // NewManager creates a new TLS manager with the given configuration
 func NewManager(cfg Config) (*Manager, error) {
-	if cfg.CertPath == "" {
-		return nil, errors.New("cert path is required")
+	if cfg.CertPath == "" && len(cfg.CertPEM) == 0 {
+		return nil, errors.New("cert path or PEM is required")
 	}
-	if cfg.KeyPath == "" {
-		return nil, errors.New("key path is required")
+	if cfg.KeyPath == "" && len(cfg.KeyPEM) == 0 {
+		return nil, errors.New("key path or PEM is required")
 	}
 
 	minVersion, err := parseMinVersion(cfg.MinVersion)
@@ -42,17 +74,48 @@ func NewManager(cfg Config) (*Manager, error) {
 		return nil, fmt.Errorf("invalid cipher suites: %w", err)
 	}
 
+	clientAuth, err := parseClientAuth(cfg.ClientAuth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client auth: %w", err)
+	}
+
+	var tickets *ticketRotator
+	if !cfg.DisableSessionTickets && cfg.SessionTicketRotation > 0 {
+		tickets, err = newTicketRotator(cfg.SessionTicketRotation)
+		if err != nil {
+			return nil, err
+		}
+		tickets.Start()
+	}
+
 	return &Manager{
-		certPath:     cfg.CertPath,
-		keyPath:      cfg.KeyPath,
-		minVersion:   minVersion,
-		cipherSuites: cipherSuites,
+		certPath:              cfg.CertPath,
+		keyPath:               cfg.KeyPath,
+		certPEM:               cfg.CertPEM,
+		keyPEM:                cfg.KeyPEM,
+		minVersion:            minVersion,
+		cipherSuites:          cipherSuites,
+		ocspStapling:          cfg.OCSPStapling,
+		clientAuth:            clientAuth,
+		clientCAPath:          cfg.ClientCAPath,
+		disableSessionTickets: cfg.DisableSessionTickets,
+		tickets:               tickets,
 	}, nil
 }
 
 // LoadCertificate loads the TLS certificate and private key
 func (m *Manager) LoadCertificate() (tls.Certificate, error) {
-	cert, err := tls.LoadX509KeyPair(m.certPath, m.keyPath)
+	certPEM, err := readPEM(m.certPath, m.certPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	keyPEM, err := readPEM(m.keyPath, m.keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
 	if err != nil {
 		return tls.Certificate{}, fmt.Errorf("failed to load certificate: %w", err)
 	}
@@ -60,22 +123,173 @@ func (m *Manager) LoadCertificate() (tls.Certificate, error) {
 	return cert, nil
 }
 
+// readPEM returns the inline PEM data if present, otherwise the file contents
+func readPEM(path string, inline []byte) ([]byte, error) {
+	if len(inline) > 0 {
+		return inline, nil
+	}
+
+	return os.ReadFile(path)
+}
+
+// Reload reads the certificate and key again and serves them on the next
+// handshake. On failure the previously loaded certificate is kept.
+func (m *Manager) Reload() error {
+	certModTime, keyModTime := m.modTimes()
+
+	cert, err := m.LoadCertificate()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cert = &cert
+	m.certModTime = certModTime
+	m.keyModTime = keyModTime
+	return nil
+}
+
+// modTimes returns the mtimes of the cert and key files, zero for inline PEM
+// or files that can't be stat'ed
+func (m *Manager) modTimes() (cert, key time.Time) {
+	if len(m.certPEM) == 0 {
+		if info, err := os.Stat(m.certPath); err == nil {
+			cert = info.ModTime()
+		}
+	}
+	if len(m.keyPEM) == 0 {
+		if info, err := os.Stat(m.keyPath); err == nil {
+			key = info.ModTime()
+		}
+	}
+	return cert, key
+}
+
+// certificate returns the cached certificate, reloading it first when the
+// cert or key file has changed on disk since it was loaded
+func (m *Manager) certificate() (*tls.Certificate, error) {
+	certModTime, keyModTime := m.modTimes()
+
+	m.mu.RLock()
+	cert := m.cert
+	changed := !certModTime.Equal(m.certModTime) || !keyModTime.Equal(m.keyModTime)
+	m.mu.RUnlock()
+
+	if cert != nil && !changed {
+		return cert, nil
+	}
+
+	if err := m.Reload(); err != nil {
+		if cert == nil {
+			return nil, err
+		}
+		// a half-written renewal shouldn't take the listener down
+		log.Printf("TLS certificate reload failed, keeping the current one: %v", err)
+		return cert, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert, nil
+}
+
 // GetTLSConfig returns a configured tls.Config
 func (m *Manager) GetTLSConfig() (*tls.Config, error) {
-	cert, err := m.LoadCertificate()
+	cert, err := m.certificate()
 	if err != nil {
 		return nil, err
 	}
 
+	// the server serves this config through tls.NewListener, which unlike
+	// ServeTLS doesn't add the HTTP/2 protocols itself
 	config := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		MinVersion:   m.minVersion,
-		CipherSuites: m.cipherSuites,
+		MinVersion:             m.minVersion,
+		CipherSuites:           m.cipherSuites,
+		SessionTicketsDisabled: m.disableSessionTickets,
+		NextProtos:             []string{"h2", "http/1.1"},
+	}
+
+	if m.tickets != nil {
+		m.tickets.Register(config)
+	}
+
+	if m.clientAuth != tls.NoClientCert {
+		config.ClientAuth = m.clientAuth
+	}
+	if m.clientCAPath != "" {
+		pool, err := loadCertPool(m.clientCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client CAs: %w", err)
+		}
+		config.ClientCAs = pool
+	}
+
+	m.ocspStaplerFor(cert)
+
+	// pick up renewed certificates on every handshake, stapling the latest
+	// cached response fetched for the certificate being served
+	config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		current, err := m.certificate()
+		if err != nil {
+			return nil, err
+		}
+		stapler := m.ocspStaplerFor(current)
+		if stapler == nil {
+			return current, nil
+		}
+
+		stapled := *current
+		stapled.OCSPStaple = stapler.Staple()
+		return &stapled, nil
 	}
 
 	return config, nil
 }
 
+// ocspStaplerFor returns the background OCSP fetcher for cert, replacing the
+// one for a previous certificate after a reload. It returns nil when stapling
+// is disabled or the certificate does not support it.
+func (m *Manager) ocspStaplerFor(cert *tls.Certificate) *ocspStapler {
+	if !m.ocspStapling || len(cert.Certificate) == 0 {
+		return nil
+	}
+
+	m.ocspMu.Lock()
+	defer m.ocspMu.Unlock()
+
+	if bytes.Equal(m.ocspLeaf, cert.Certificate[0]) {
+		return m.ocspStapler
+	}
+
+	if m.ocspStapler != nil {
+		m.ocspStapler.Stop()
+		m.ocspStapler = nil
+	}
+	m.ocspLeaf = cert.Certificate[0]
+
+	stapler, err := newOCSPStapler(cert.Certificate)
+	if err != nil {
+		log.Printf("OCSP stapling disabled: %v", err)
+		return nil
+	}
+	stapler.Start()
+	m.ocspStapler = stapler
+	return stapler
+}
+
+// Stop halts any background work started by the manager
+func (m *Manager) Stop() {
+	m.ocspMu.Lock()
+	if m.ocspStapler != nil {
+		m.ocspStapler.Stop()
+	}
+	m.ocspMu.Unlock()
+	if m.tickets != nil {
+		m.tickets.Stop()
+	}
+}
+
 // ValidateCertificate validates the certificate and key pair
 func (m *Manager) ValidateCertificate() error {
 	cert, err := m.LoadCertificate()
@@ -118,6 +332,38 @@ func parseMinVersion(version string) (uint16, error) {
 	}
 }
 
+// parseClientAuth converts a client auth mode to tls.ClientAuthType
+func parseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require":
+		return tls.RequireAnyClientCert, nil
+	case "verify_if_given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require_and_verify":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("unsupported client auth %q (supported: none, request, require, verify_if_given, require_and_verify)", mode)
+	}
+}
+
+// loadCertPool reads PEM encoded CA certificates into a pool
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
 // parseCipherSuites converts cipher suite names to IDs
 func parseCipherSuites(ciphers []string) ([]uint16, error) {
 	if len(ciphers) == 0 {
@@ -155,11 +401,17 @@ func parseCipherSuites(ciphers []string) ([]uint16, error) {
 	}
 
 	result := make([]uint16, 0, len(ciphers))
+	seen := make(map[uint16]bool, len(ciphers))
 	for _, name := range ciphers {
 		id, ok := cipherMap[name]
 		if !ok {
 			return nil, fmt.Errorf("unknown cipher suite: %s", name)
 		}
+		// duplicates are harmless, keep the first occurrence's preference order
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
 		result = append(result, id)
 	}
 
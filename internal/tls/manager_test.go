@@ -1,10 +1,15 @@
 package tls
 
 import (
+	"bytes"
 	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestNewManager_Success(t *testing.T) {
@@ -117,6 +122,67 @@ func TestLoadCertificate_Success(t *testing.T) {
 	}
 }
 
+func TestLoadCertificate_InlinePEM(t *testing.T) {
+	certPEM, err := os.ReadFile("testdata/server.crt")
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	keyPEM, err := os.ReadFile("testdata/server.key")
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{
+			name: "inline cert and key",
+			cfg:  Config{CertPEM: certPEM, KeyPEM: keyPEM},
+		},
+		{
+			name: "inline cert with key file",
+			cfg:  Config{CertPEM: certPEM, KeyPath: "testdata/server.key"},
+		},
+		{
+			name: "cert file with inline key",
+			cfg:  Config{CertPath: "testdata/server.crt", KeyPEM: keyPEM},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr, err := NewManager(tt.cfg)
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+
+			cert, err := mgr.LoadCertificate()
+			if err != nil {
+				t.Fatalf("LoadCertificate() error = %v, want nil", err)
+			}
+
+			if len(cert.Certificate) == 0 {
+				t.Error("LoadCertificate() returned empty certificate")
+			}
+		})
+	}
+}
+
+func TestLoadCertificate_InvalidInlinePEM(t *testing.T) {
+	mgr, err := NewManager(Config{
+		CertPEM: []byte("not a certificate"),
+		KeyPEM:  []byte("not a key"),
+	})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	if _, err := mgr.LoadCertificate(); err == nil {
+		t.Error("LoadCertificate() error = nil, want error for invalid inline PEM")
+	}
+}
+
 func TestLoadCertificate_FileNotFound(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -206,8 +272,9 @@ func TestGetTLSConfig_Success(t *testing.T) {
 		t.Fatal("GetTLSConfig() returned nil config")
 	}
 
-	if len(tlsConfig.Certificates) != 1 {
-		t.Errorf("GetTLSConfig() certificates count = %d, want 1", len(tlsConfig.Certificates))
+	cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil || cert == nil {
+		t.Errorf("GetCertificate() = %v, %v, want the loaded certificate", cert, err)
 	}
 
 	if tlsConfig.MinVersion != tls.VersionTLS12 {
@@ -419,6 +486,16 @@ func TestParseCipherSuites(t *testing.T) {
 			wantLen: 2,
 			wantErr: false,
 		},
+		{
+			name: "duplicate ciphers are collapsed",
+			ciphers: []string{
+				"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+				"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+				"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+			},
+			wantLen: 2,
+			wantErr: false,
+		},
 		{
 			name: "invalid cipher suite",
 			ciphers: []string{
@@ -555,3 +632,246 @@ func TestManager_RelativeAndAbsolutePaths(t *testing.T) {
 		}
 	})
 }
+
+// copyFile copies src to dst, keeping tests from touching testdata
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if err := os.WriteFile(dst, data, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+// handshakeLeaf connects to addr and returns the leaf certificate it served
+func handshakeLeaf(t *testing.T, addr string) []byte {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("tls.Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0].Raw
+}
+
+func TestManager_ReloadsChangedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "server.crt")
+	keyPath := filepath.Join(dir, "server.key")
+	copyFile(t, "testdata/server.crt", certPath)
+	copyFile(t, "testdata/server.key", keyPath)
+
+	mgr, err := NewManager(Config{CertPath: certPath, KeyPath: keyPath})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	tlsConfig, err := mgr.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatalf("tls.Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}(conn)
+		}
+	}()
+
+	original, err := mgr.LoadCertificate()
+	if err != nil {
+		t.Fatalf("LoadCertificate() error = %v", err)
+	}
+	if leaf := handshakeLeaf(t, listener.Addr().String()); !bytes.Equal(leaf, original.Certificate[0]) {
+		t.Fatal("First handshake should serve the certificate loaded at startup")
+	}
+
+	// swap in a renewed certificate, as cert-manager would
+	certPEM, keyPEM, renewed, _, _ := testChain(t, "http://ocsp.invalid")
+	if err := os.WriteFile(certPath, certPEM, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	// coarse filesystem timestamps could leave the mtime unchanged
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certPath, later, later)
+	os.Chtimes(keyPath, later, later)
+
+	if leaf := handshakeLeaf(t, listener.Addr().String()); !bytes.Equal(leaf, renewed.Raw) {
+		t.Error("Next handshake should serve the renewed certificate")
+	}
+}
+
+func TestManager_ReloadKeepsCertificateOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "server.crt")
+	keyPath := filepath.Join(dir, "server.key")
+	copyFile(t, "testdata/server.crt", certPath)
+	copyFile(t, "testdata/server.key", keyPath)
+
+	mgr, err := NewManager(Config{CertPath: certPath, KeyPath: keyPath})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	tlsConfig, err := mgr.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+	before, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+
+	// a renewal caught half-written: the new cert doesn't match the old key
+	copyFile(t, "testdata/mismatched.crt", certPath)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certPath, later, later)
+
+	if err := mgr.Reload(); err == nil {
+		t.Error("Reload() should fail for a mismatched key pair")
+	}
+
+	after, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	if !bytes.Equal(after.Certificate[0], before.Certificate[0]) {
+		t.Error("A failed reload should keep serving the previous certificate")
+	}
+}
+
+func TestParseClientAuth(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    tls.ClientAuthType
+		wantErr bool
+	}{
+		{mode: "", want: tls.NoClientCert},
+		{mode: "none", want: tls.NoClientCert},
+		{mode: "request", want: tls.RequestClientCert},
+		{mode: "require", want: tls.RequireAnyClientCert},
+		{mode: "verify_if_given", want: tls.VerifyClientCertIfGiven},
+		{mode: "require_and_verify", want: tls.RequireAndVerifyClientCert},
+		{mode: "always", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			got, err := parseClientAuth(tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseClientAuth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseClientAuth() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTLSConfig_InvalidClientCA(t *testing.T) {
+	mgr, err := NewManager(Config{
+		CertPath:     "testdata/server.crt",
+		KeyPath:      "testdata/server.key",
+		ClientAuth:   "require_and_verify",
+		ClientCAPath: "testdata/invalid.crt",
+	})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	if _, err := mgr.GetTLSConfig(); err == nil {
+		t.Error("GetTLSConfig() should fail when the client CA file holds no certificates")
+	}
+}
+
+func TestGetTLSConfig_MutualTLS(t *testing.T) {
+	mgr, err := NewManager(Config{
+		CertPath:     "testdata/server.crt",
+		KeyPath:      "testdata/server.key",
+		ClientAuth:   "require_and_verify",
+		ClientCAPath: "testdata/client-ca.crt",
+	})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	tlsConfig, err := mgr.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert || tlsConfig.ClientCAs == nil {
+		t.Fatalf("Expected client certs to be required and verified, got %v", tlsConfig.ClientAuth)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	clientCert, err := tls.LoadX509KeyPair("testdata/client.crt", "testdata/client.key")
+	if err != nil {
+		t.Fatalf("LoadX509KeyPair() error = %v", err)
+	}
+
+	newClient := func(certs []tls.Certificate) *http.Client {
+		// the test server cert has no SANs; only the client side is under test
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       certs,
+		}}}
+	}
+
+	t.Run("valid client certificate", func(t *testing.T) {
+		resp, err := newClient([]tls.Certificate{clientCert}).Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request with a client certificate failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		if body.String() != "test-client" {
+			t.Errorf("Expected the handler to see the client certificate, got %q", body.String())
+		}
+	})
+
+	t.Run("no client certificate", func(t *testing.T) {
+		if resp, err := newClient(nil).Get(server.URL); err == nil {
+			resp.Body.Close()
+			t.Error("Request without a client certificate should be rejected")
+		}
+	})
+
+	t.Run("certificate from another CA", func(t *testing.T) {
+		untrusted, err := tls.LoadX509KeyPair("testdata/server.crt", "testdata/server.key")
+		if err != nil {
+			t.Fatalf("LoadX509KeyPair() error = %v", err)
+		}
+		if resp, err := newClient([]tls.Certificate{untrusted}).Get(server.URL); err == nil {
+			resp.Body.Close()
+			t.Error("Request with a certificate the client CA didn't sign should be rejected")
+		}
+	})
+}
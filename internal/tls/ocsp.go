@@ -0,0 +1,177 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// ocspRetryInterval is how long to wait before retrying a failed fetch
+	ocspRetryInterval = time.Minute
+	// ocspDefaultRefresh is used when the responder omits NextUpdate
+	ocspDefaultRefresh = time.Hour
+	// ocspMaxResponseSize caps how much of a responder reply is read
+	ocspMaxResponseSize = 1 << 20
+)
+
+// ocspStapler fetches and caches the OCSP response for a leaf certificate
+type ocspStapler struct {
+	leaf          *x509.Certificate
+	issuer        *x509.Certificate
+	responderURL  string
+	client        *http.Client
+	retryInterval time.Duration
+
+	mu         sync.RWMutex
+	staple     []byte
+	nextUpdate time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newOCSPStapler creates a stapler for the given certificate chain
+func newOCSPStapler(chain [][]byte) (*ocspStapler, error) {
+	if len(chain) < 2 {
+		return nil, errors.New("certificate chain has no issuer certificate")
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse leaf certificate: %w", err)
+	}
+
+	issuer, err := x509.ParseCertificate(chain[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issuer certificate: %w", err)
+	}
+
+	if len(leaf.OCSPServer) == 0 {
+		return nil, errors.New("certificate has no OCSP responder URL")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &ocspStapler{
+		leaf:          leaf,
+		issuer:        issuer,
+		responderURL:  leaf.OCSPServer[0],
+		client:        &http.Client{Timeout: 10 * time.Second},
+		retryInterval: ocspRetryInterval,
+		ctx:           ctx,
+		cancel:        cancel,
+	}, nil
+}
+
+// Staple returns the cached OCSP response, or nil if none is valid
+func (s *ocspStapler) Staple() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.nextUpdate.IsZero() && time.Now().After(s.nextUpdate) {
+		return nil
+	}
+	return s.staple
+}
+
+// Start fetches the first response and keeps it refreshed in the background
+func (s *ocspStapler) Start() {
+	s.wg.Add(1)
+	go s.run()
+}
+
+// Stop halts background refreshes
+func (s *ocspStapler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *ocspStapler) run() {
+	defer s.wg.Done()
+
+	for {
+		wait := s.retryInterval
+		if err := s.refresh(); err != nil {
+			log.Printf("OCSP stapling: fetch from %s failed, serving without staple: %v", s.responderURL, err)
+		} else {
+			wait = s.refreshDelay()
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// refreshDelay schedules the next fetch halfway to the response's expiry
+func (s *ocspStapler) refreshDelay() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.nextUpdate.IsZero() {
+		return ocspDefaultRefresh
+	}
+
+	delay := time.Until(s.nextUpdate) / 2
+	if delay < s.retryInterval {
+		delay = s.retryInterval
+	}
+	return delay
+}
+
+func (s *ocspStapler) refresh() error {
+	reqBytes, err := ocsp.CreateRequest(s.leaf, s.issuer, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create OCSP request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.responderURL, bytes.NewReader(reqBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("responder returned status %d", resp.StatusCode)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, ocspMaxResponseSize))
+	if err != nil {
+		return fmt.Errorf("failed to read OCSP response: %w", err)
+	}
+
+	parsed, err := ocsp.ParseResponseForCert(raw, s.leaf, s.issuer)
+	if err != nil {
+		return fmt.Errorf("failed to parse OCSP response: %w", err)
+	}
+
+	if parsed.Status != ocsp.Good {
+		return fmt.Errorf("certificate OCSP status is not good (status %d)", parsed.Status)
+	}
+
+	s.mu.Lock()
+	s.staple = raw
+	s.nextUpdate = parsed.NextUpdate
+	s.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testChain builds a CA-signed leaf whose OCSP responder is responderURL
+func testChain(t *testing.T, responderURL string) (certPEM, keyPEM []byte, leaf, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey) {
+	t.Helper()
+
+	issuerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	issuerTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	issuerDER, err := x509.CreateCertificate(rand.Reader, issuerTemplate, issuerTemplate, &issuerKey.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	issuer, _ = x509.ParseCertificate(issuerDER)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{responderURL},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, issuer, &leafKey.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	leaf, _ = x509.ParseCertificate(leafDER)
+
+	keyDER, err := x509.MarshalECPrivateKey(leafKey)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error = %v", err)
+	}
+
+	certPEM = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuerDER})...)
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, leaf, issuer, issuerKey
+}
+
+func TestOCSPStapling_StaplesResponse(t *testing.T) {
+	var leaf, issuer *x509.Certificate
+	var issuerKey *ecdsa.PrivateKey
+
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if _, err := ocsp.ParseRequest(body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		resp, err := ocsp.CreateResponse(issuer, issuer, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: leaf.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, issuerKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+	defer responder.Close()
+
+	certPEM, keyPEM, l, i, k := testChain(t, responder.URL)
+	leaf, issuer, issuerKey = l, i, k
+
+	mgr, err := NewManager(Config{CertPEM: certPEM, KeyPEM: keyPEM, OCSPStapling: true})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer mgr.Stop()
+
+	tlsConfig, err := mgr.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+
+	if tlsConfig.GetCertificate == nil {
+		t.Fatal("GetTLSConfig() should use GetCertificate when OCSP stapling is enabled")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("GetCertificate() error = %v", err)
+		}
+		if len(cert.OCSPStaple) > 0 {
+			parsed, err := ocsp.ParseResponseForCert(cert.OCSPStaple, leaf, issuer)
+			if err != nil {
+				t.Fatalf("stapled response failed to parse: %v", err)
+			}
+			if parsed.Status != ocsp.Good {
+				t.Errorf("stapled status = %d, want Good", parsed.Status)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("OCSP response was not stapled in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOCSPStapling_FetchFailureServesWithoutStaple(t *testing.T) {
+	requests := make(chan struct{}, 10)
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer responder.Close()
+
+	certPEM, keyPEM, _, _, _ := testChain(t, responder.URL)
+
+	mgr, err := NewManager(Config{CertPEM: certPEM, KeyPEM: keyPEM, OCSPStapling: true})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer mgr.Stop()
+
+	tlsConfig, err := mgr.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+
+	select {
+	case <-requests:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OCSP responder was never queried")
+	}
+
+	cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	if len(cert.OCSPStaple) != 0 {
+		t.Error("Expected no staple after a failed fetch")
+	}
+}
+
+func TestOCSPStapling_NoIssuerDisablesStapling(t *testing.T) {
+	mgr, err := NewManager(Config{
+		CertPath:     "testdata/server.crt",
+		KeyPath:      "testdata/server.key",
+		OCSPStapling: true,
+	})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer mgr.Stop()
+
+	tlsConfig, err := mgr.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+
+	cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	if len(cert.OCSPStaple) != 0 {
+		t.Error("GetTLSConfig() should serve the certificate without a staple when there is no issuer")
+	}
+}
+
+func TestOCSPStapling_RestaplesReloadedCertificate(t *testing.T) {
+	var mu sync.Mutex
+	var leaf, issuer *x509.Certificate
+	var issuerKey *ecdsa.PrivateKey
+
+	// answers for whichever chain is current
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		resp, err := ocsp.CreateResponse(issuer, issuer, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: leaf.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, issuerKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+	defer responder.Close()
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "server.crt")
+	keyPath := filepath.Join(dir, "server.key")
+
+	// writes a new chain to disk and has the responder answer for it
+	install := func(modTime time.Time) (*x509.Certificate, *x509.Certificate) {
+		certPEM, keyPEM, l, i, k := testChain(t, responder.URL)
+		mu.Lock()
+		leaf, issuer, issuerKey = l, i, k
+		mu.Unlock()
+
+		if err := os.WriteFile(certPath, certPEM, 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		os.Chtimes(certPath, modTime, modTime)
+		os.Chtimes(keyPath, modTime, modTime)
+		return l, i
+	}
+
+	firstLeaf, firstIssuer := install(time.Now())
+
+	mgr, err := NewManager(Config{CertPath: certPath, KeyPath: keyPath, OCSPStapling: true})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer mgr.Stop()
+
+	tlsConfig, err := mgr.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+
+	// waits for a staple that verifies against the given chain
+	waitForStaple := func(leaf, issuer *x509.Certificate) {
+		t.Helper()
+
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+			if err != nil {
+				t.Fatalf("GetCertificate() error = %v", err)
+			}
+			if len(cert.OCSPStaple) > 0 && bytes.Equal(cert.Certificate[0], leaf.Raw) {
+				if _, err := ocsp.ParseResponseForCert(cert.OCSPStaple, leaf, issuer); err != nil {
+					t.Fatalf("stapled response does not match the served certificate: %v", err)
+				}
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("OCSP response was not stapled in time")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitForStaple(firstLeaf, firstIssuer)
+
+	// a renewed certificate gets its own staple
+	waitForStaple(install(time.Now().Add(time.Minute)))
+}
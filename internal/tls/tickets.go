@@ -0,0 +1,102 @@
+package tls
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ticketKeyHistory is how many keys are kept for resuming sessions, the
+// newest encrypts new tickets and the rest only decrypt
+const ticketKeyHistory = 3
+
+// ticketRotator generates session ticket keys in memory and rotates them
+// on every TLS config it manages
+type ticketRotator struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	keys    [][32]byte
+	configs []*tls.Config
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func newTicketRotator(interval time.Duration) (*ticketRotator, error) {
+	r := &ticketRotator{
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+
+	if err := r.rotate(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Register applies the current keys to config and keeps it updated
+func (r *ticketRotator) Register(config *tls.Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	config.SetSessionTicketKeys(r.keys)
+	r.configs = append(r.configs, config)
+}
+
+// Start rotates keys in the background every interval
+func (r *ticketRotator) Start() {
+	r.wg.Add(1)
+	go r.run()
+}
+
+// Stop halts background rotation
+func (r *ticketRotator) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+	r.wg.Wait()
+}
+
+func (r *ticketRotator) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if err := r.rotate(); err != nil {
+				log.Printf("Session ticket key rotation failed, keeping current keys: %v", err)
+			}
+		}
+	}
+}
+
+func (r *ticketRotator) rotate() error {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return fmt.Errorf("failed to generate session ticket key: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := append([][32]byte{key}, r.keys...)
+	if len(keys) > ticketKeyHistory {
+		keys = keys[:ticketKeyHistory]
+	}
+	r.keys = keys
+
+	for _, config := range r.configs {
+		config.SetSessionTicketKeys(keys)
+	}
+
+	return nil
+}
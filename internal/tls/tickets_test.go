@@ -0,0 +1,130 @@
+package tls
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSessionTicketKeysRotate(t *testing.T) {
+	mgr, err := NewManager(Config{
+		CertPath:              "testdata/server.crt",
+		KeyPath:               "testdata/server.key",
+		SessionTicketRotation: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer mgr.Stop()
+
+	tlsConfig, err := mgr.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatalf("tls.Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}(conn)
+		}
+	}()
+
+	// TLS 1.2 hands out the ticket during the handshake itself
+	cache := tls.NewLRUClientSessionCache(1)
+	resumed := func() bool {
+		t.Helper()
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+			ClientSessionCache: cache,
+			MaxVersion:         tls.VersionTLS12,
+		})
+		if err != nil {
+			t.Fatalf("tls.Dial() error = %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().DidResume
+	}
+
+	if resumed() {
+		t.Fatal("First handshake has no ticket to resume")
+	}
+	if !resumed() {
+		t.Fatal("Second handshake should resume with the first one's ticket")
+	}
+
+	// a ticket outlives one rotation, its key stays around to decrypt
+	if err := mgr.tickets.rotate(); err != nil {
+		t.Fatalf("rotate() error = %v", err)
+	}
+	if !resumed() {
+		t.Error("Ticket should still resume after one rotation")
+	}
+
+	// and stops working once its key has aged out of the history
+	for i := 0; i < ticketKeyHistory; i++ {
+		if err := mgr.tickets.rotate(); err != nil {
+			t.Fatalf("rotate() error = %v", err)
+		}
+	}
+	if resumed() {
+		t.Error("Ticket should not resume once its key has been rotated out")
+	}
+}
+
+func TestSessionTicketsDisabled(t *testing.T) {
+	mgr, err := NewManager(Config{
+		CertPath:              "testdata/server.crt",
+		KeyPath:               "testdata/server.key",
+		DisableSessionTickets: true,
+		SessionTicketRotation: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer mgr.Stop()
+
+	tlsConfig, err := mgr.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+
+	if !tlsConfig.SessionTicketsDisabled {
+		t.Error("Expected session tickets to be disabled")
+	}
+	if mgr.tickets != nil {
+		t.Error("Expected no key rotation when session tickets are disabled")
+	}
+}
+
+func TestSessionTicketsDefault(t *testing.T) {
+	mgr, err := NewManager(Config{
+		CertPath: "testdata/server.crt",
+		KeyPath:  "testdata/server.key",
+	})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer mgr.Stop()
+
+	tlsConfig, err := mgr.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+
+	if tlsConfig.SessionTicketsDisabled || mgr.tickets != nil {
+		t.Error("Expected Go's default session ticket handling when nothing is configured")
+	}
+}
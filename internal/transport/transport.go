@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+// New builds the HTTP transport used to reach backends
+func New(cfg config.TransportConfig) *http.Transport {
+	dialer := newDialer(cfg)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, overrideHost(cfg.Hosts, addr))
+	}
+
+	return transport
+}
+
+// backend dials send TCP keep-alive probes every transport.keep_alive
+func newDialer(cfg config.TransportConfig) *net.Dialer {
+	return &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.KeepAlive,
+		Resolver:  Resolver(cfg),
+	}
+}
+
+// Resolver returns the DNS resolver backends are looked up with
+func Resolver(cfg config.TransportConfig) *net.Resolver {
+	if cfg.Resolver == "" {
+		return net.DefaultResolver
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: cfg.DialTimeout}
+			return d.DialContext(ctx, network, cfg.Resolver)
+		},
+	}
+}
+
+// rewrites host:port using the static hosts map, keeping the port
+func overrideHost(hosts map[string]string, addr string) string {
+	if len(hosts) == 0 {
+		return addr
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	if mapped, exists := hosts[host]; exists {
+		return net.JoinHostPort(mapped, port)
+	}
+
+	return addr
+}
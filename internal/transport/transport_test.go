@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/sanchxt/isame-lb/internal/config"
+)
+
+func TestOverrideHost(t *testing.T) {
+	hosts := map[string]string{"api.internal": "127.0.0.1"}
+
+	tests := []struct {
+		addr     string
+		expected string
+	}{
+		{addr: "api.internal:8080", expected: "127.0.0.1:8080"},
+		{addr: "other.internal:8080", expected: "other.internal:8080"},
+		{addr: "no-port", expected: "no-port"},
+	}
+
+	for _, tt := range tests {
+		if got := overrideHost(hosts, tt.addr); got != tt.expected {
+			t.Errorf("overrideHost(%q) = %q, want %q", tt.addr, got, tt.expected)
+		}
+	}
+}
+
+func TestNewDialsOverriddenHost(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse backend URL: %v", err)
+	}
+
+	client := &http.Client{
+		Transport: New(config.TransportConfig{
+			DialTimeout: time.Second,
+			Hosts:       map[string]string{"api.internal.test": backendURL.Hostname()},
+		}),
+	}
+
+	resp, err := client.Get("http://api.internal.test:" + backendURL.Port() + "/")
+	if err != nil {
+		t.Fatalf("Request via host override failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestNewDialerKeepAlive(t *testing.T) {
+	dialer := newDialer(config.TransportConfig{DialTimeout: time.Second, KeepAlive: 45 * time.Second})
+	if dialer.KeepAlive != 45*time.Second {
+		t.Errorf("Expected keep-alive period 45s, got %s", dialer.KeepAlive)
+	}
+
+	dialer = newDialer(config.TransportConfig{DialTimeout: time.Second, KeepAlive: -1})
+	if dialer.KeepAlive >= 0 {
+		t.Errorf("Expected a negative keep_alive to disable probes, got %s", dialer.KeepAlive)
+	}
+}